@@ -1,17 +1,23 @@
 package Dingo
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/dinever/dingo/app/handler"
 	"github.com/dinever/dingo/app/model"
 	"github.com/dinever/dingo/app/utils"
 	"github.com/dinever/golf"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var (
-	App *golf.Application
+	App       *golf.Application
+	Scheduler *utils.Scheduler
 )
 
 func fileExists(filename string) bool {
@@ -19,19 +25,26 @@ func fileExists(filename string) bool {
 	return err == nil
 }
 
-func Init(dbPath string) {
-	if err := model.Initialize(dbPath, fileExists(dbPath)); err != nil {
+// Init sets up the application with the database of the data source name,
+// which is either the path of a SQLite database or a PostgreSQL or MySQL URL.
+func Init(dsn string) {
+	if err := model.Initialize(dsn, fileExists(dsn)); err != nil {
 		err = fmt.Errorf("failed to intialize db: %v", err)
 		panic(err)
 	}
-	fmt.Printf("Database is used at %s\n", dbPath)
+	fmt.Printf("Database is used at %s\n", model.RedactDSN(dsn))
+	initApp()
+}
 
+// initApp sets up the application once the database is open.
+func initApp() {
 	App = golf.New()
 
 	App.Config.Set("app/static_dir", "static")
 	App.Config.Set("app.log_dir", "tmp/log")
 	App.Config.Set("app/upload_dir", "upload")
 	upload_dir, _ := App.Config.GetString("app/upload_dir", "upload")
+	handler.Backups.UploadDir = upload_dir
 	registerMiddlewares()
 	registerFuncMap()
 	handler.RegisterFunctions(App)
@@ -46,6 +59,252 @@ func Init(dbPath string) {
 
 	App.SessionManager = golf.NewMemorySessionManager()
 	App.Error(404, handler.NotFoundHandler)
+
+	// Mails are kept in a maildir until a mail server is set up
+	SetMailer(utils.NewMaildirMailer("mail", "dingo@localhost"))
+	SetSpamChecker(model.NewBayesSpamChecker())
+
+	Scheduler = utils.NewScheduler()
+	registerJobs()
+}
+
+// Migrate applies the pending database migrations without starting the
+// application. With dryRun, the pending migrations are only listed.
+func Migrate(dsn string, dryRun bool) error {
+	applied, err := model.OpenAndMigrate(dsn, dryRun)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("Database %s is up to date at version %d\n", model.RedactDSN(dsn), model.LatestSchemaVersion())
+		return nil
+	}
+	for _, m := range applied {
+		if dryRun {
+			fmt.Printf("Pending migration %d: %s\n", m.Version, m.Name)
+		} else {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// Export writes the content of the site as JSON to path, or to the standard
+// output if path is empty. The password hashes of the users are only
+// included with withPasswords. With ghost, the content is written in the
+// format which Ghost imports.
+func Export(dsn, path string, withPasswords, ghost bool) error {
+	if err := model.Initialize(dsn, fileExists(dsn)); err != nil {
+		return err
+	}
+	e, err := model.ExportSite(withPasswords)
+	if err != nil {
+		return err
+	}
+	var export interface{} = e
+	if ghost {
+		if export, err = model.ExportGhost(); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	model.NewMessage("backup", "[1]"+filepath.Base(path)).Save()
+	fmt.Fprintf(os.Stderr, "Exported %d posts, %d pages, %d comments and %d users to %s\n", len(e.Posts), len(e.Pages), len(e.Comments), len(e.Users), path)
+	return nil
+}
+
+// Import imports the JSON export of Dingo or of Ghost at path into the site.
+// Content which already exists is skipped. A new site is created without the welcome data.
+func Import(dsn, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	e, err := model.ReadExport(data)
+	if err != nil {
+		return err
+	}
+	if err := model.Initialize(dsn, true); err != nil {
+		return err
+	}
+	r, err := model.ImportSite(e)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d users, %d categories, %d posts, %d pages, %d comments and %d settings, skipped %d\n",
+		r.Users, r.Categories, r.Posts, r.Pages, r.Comments, r.Settings, r.Skipped)
+	return nil
+}
+
+// ImportWordPress imports the WordPress export at path into the site. The
+// attachments are copied from uploadsSource, a copy of the wp-content/uploads
+// directory, or downloaded from the WordPress site if it is empty. With
+// dryRun, the site is left as it is and only the report is printed.
+func ImportWordPress(dsn, path, uploadsSource string, dryRun bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := model.Initialize(dsn, true); err != nil {
+		return err
+	}
+	w := &model.WordPressImport{UploadDir: "upload", UploadsSource: uploadsSource, DryRun: dryRun}
+	report, err := w.Run(f)
+	if report != nil {
+		if dryRun {
+			fmt.Println("Dry run, nothing is imported:")
+		}
+		fmt.Print(report)
+	}
+	return err
+}
+
+// ImportMarkdown imports the Markdown files with front matter found in dir
+// as posts. The new posts are written by the user with the given email, or
+// by the owner of the site if it is empty.
+func ImportMarkdown(dsn, dir, email string) error {
+	if err := model.Initialize(dsn, true); err != nil {
+		return err
+	}
+	var author *model.User
+	if email != "" {
+		u, err := model.GetUserByEmail(email)
+		if err != nil {
+			return fmt.Errorf("No user with the email %s", email)
+		}
+		author = u
+	} else {
+		users, err := model.GetAllUsers()
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if u.Role == model.RoleOwner {
+				author = u
+			}
+		}
+		if author == nil {
+			return fmt.Errorf("The site has no owner, give the author with -author")
+		}
+	}
+	m := &model.MarkdownImport{Author: author}
+	report, err := m.ImportDir(dir)
+	if report != nil {
+		fmt.Print(report)
+	}
+	return err
+}
+
+// ExportStatic renders the published content of the site with the theme
+// into plain files in out, along with the assets of the theme and the
+// uploaded files, so that it can be hosted without Dingo.
+func ExportStatic(dsn, out string) error {
+	// A missing database is not created, it would be exported with the
+	// welcome post
+	if err := model.OpenExisting(dsn); err != nil {
+		return err
+	}
+	initApp()
+	handler.RegisterHomeHandler(App)
+	if model.GetSettingValue("site_url") == "" {
+		log.Printf("[Warning]: The site URL is not set, the links of the feeds and of the sitemap have no host")
+	}
+	upload_dir, _ := App.Config.GetString("app/upload_dir", "upload")
+	s := &handler.StaticExport{
+		App:       App,
+		Out:       out,
+		AssetDir:  filepath.Join("view", model.GetSettingValue("theme"), "assets"),
+		UploadDir: upload_dir,
+	}
+	paths, err := s.Run()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d pages to %s\n", len(paths), out)
+	return nil
+}
+
+// ScheduleBackups takes a backup into dir whenever the newest one is older
+// than interval, keeping the keep newest backups. It must be called before
+// Run.
+func ScheduleBackups(dir string, interval time.Duration, keep int) {
+	handler.Backups.Dir = dir
+	handler.Backups.Keep = keep
+	if interval <= 0 {
+		return
+	}
+	if !model.CanSnapshotDatabase() {
+		log.Printf("[Warning]: Scheduled backups are disabled, only SQLite databases can be backed up")
+		return
+	}
+	check := interval
+	if check > 10*time.Minute {
+		check = 10 * time.Minute
+	}
+	Scheduler.Every(check, "backup", func() error {
+		return handler.Backups.RunIfOlder(interval)
+	})
+}
+
+// Backup takes a backup into dir, keeping the keep newest backups.
+func Backup(dsn, dir string, keep int) error {
+	if err := model.Initialize(dsn, fileExists(dsn)); err != nil {
+		return err
+	}
+	b := &model.Backups{Dir: dir, UploadDir: "upload", Keep: keep}
+	path, err := b.Run()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up the site at %s\n", path)
+	return nil
+}
+
+// Restore replaces the database and the uploaded files with the backup at
+// path.
+func Restore(dsn, path string) error {
+	if err := model.RestoreBackup(path, dsn, "upload"); err != nil {
+		return err
+	}
+	fmt.Printf("Restored the site from %s\n", path)
+	return nil
+}
+
+// SetMailer sets how the mails of the application are delivered.
+func SetMailer(m utils.Mailer) {
+	handler.Mailer = m
+}
+
+// SetSpamChecker sets how the comments of the visitors are checked for spam,
+// nil leaves them all to the moderators.
+func SetSpamChecker(c model.SpamChecker) {
+	handler.SpamChecker = c
+}
+
+// SetWebmentionClient sets the HTTP client which fetches the sources of the
+// received webmentions and sends the webmentions of the posts. The default
+// client does not reach private addresses.
+func SetWebmentionClient(c *http.Client) {
+	model.WebmentionClient = c
+}
+
+func registerJobs() {
+	Scheduler.Every(time.Minute, "publish", model.PublishScheduledPosts)
+	Scheduler.Every(time.Minute, "webmentions", model.SendWebmentions)
+	Scheduler.Every(time.Hour, "tokens", model.DeleteExpiredTokens)
+	Scheduler.Every(time.Hour, "password_resets", model.DeleteExpiredPasswordResets)
+	Scheduler.Every(time.Hour, "login_challenges", model.DeleteExpiredLoginChallenges)
 }
 
 func registerFuncMap() {
@@ -75,69 +334,11 @@ func CreateSampleData() {
 	model.NewSetting("sub_title", "Another blog created by Dingo", "blog").Save()
 }
 
-func registerAdminURLHandlers() {
-	authChain := golf.NewChain(handler.AuthMiddleware)
-	App.Get("/login/", handler.AuthLoginPageHandler)
-	App.Post("/login/", handler.AuthLoginHandler)
-
-	App.Get("/signup/", handler.AuthSignUpPageHandler)
-	App.Post("/signup/", handler.AuthSignUpHandler)
-
-	App.Get("/logout/", handler.AuthLogoutHandler)
-
-	App.Get("/admin/", authChain.Final(handler.AdminHandler))
-
-	App.Get("/admin/profile/", authChain.Final(handler.ProfileHandler))
-	App.Post("/admin/profile/", authChain.Final(handler.ProfileChangeHandler))
-
-	App.Get("/admin/editor/post/", authChain.Final(handler.PostCreateHandler))
-	App.Post("/admin/editor/post/", authChain.Final(handler.PostSaveHandler))
-
-	App.Get("/admin/editor/page/", authChain.Final(handler.PageCreateHandler))
-	App.Post("/admin/editor/page/", authChain.Final(handler.PageSaveHandler))
-
-	App.Get("/admin/posts/", authChain.Final(handler.AdminPostHandler))
-	App.Get("/admin/editor/:id/", authChain.Final(handler.PostEditHandler))
-	App.Post("/admin/editor/:id/", authChain.Final(handler.PostSaveHandler))
-	App.Delete("/admin/editor/:id/", authChain.Final(handler.PostRemoveHandler))
-
-	App.Get("/admin/pages/", authChain.Final(handler.AdminPageHandler))
-
-	App.Get("/admin/comments/", authChain.Final(handler.CommentViewHandler))
-	App.Post("/admin/comments/", authChain.Final(handler.CommentAddHandler))
-	App.Put("/admin/comments/", authChain.Final(handler.CommentUpdateHandler))
-	App.Delete("/admin/comments/", authChain.Final(handler.CommentRemoveHandler))
-
-	App.Get("/admin/setting/", authChain.Final(handler.SettingViewHandler))
-	App.Post("/admin/setting/", authChain.Final(handler.SettingUpdateHandler))
-	App.Post("/admin/setting/custom/", authChain.Final(handler.SettingCustomHandler))
-	App.Post("/admin/setting/nav/", authChain.Final(handler.SettingNavHandler))
-	//
-	App.Get("/admin/files/", authChain.Final(handler.FileViewHandler))
-	App.Delete("/admin/files/", authChain.Final(handler.FileRemoveHandler))
-	App.Post("/admin/files/upload/", authChain.Final(handler.FileUploadHandler))
-
-	App.Get("/admin/password/", authChain.Final(handler.AdminPasswordPage))
-	App.Post("/admin/password/", authChain.Final(handler.AdminPasswordChange))
-
-	App.Get("/admin/monitor/", authChain.Final(handler.AdminMonitorPage))
-}
-
-func registerHomeHandler() {
-	statsChain := golf.NewChain()
-	App.Get("/", statsChain.Final(handler.HomeHandler))
-	App.Get("/page/:page/", handler.HomeHandler)
-	App.Post("/comment/:id/", handler.CommentHandler)
-	App.Get("/tag/:tag/", handler.TagHandler)
-	App.Get("/tag/:tag/page/:page/", handler.TagHandler)
-	App.Get("/feed/", handler.RssHandler)
-	App.Get("/sitemap.xml", handler.SiteMapHandler)
-	App.Get("/:slug/", statsChain.Final(handler.ContentHandler))
-}
-
 func Run(portNumber string) {
-	registerAdminURLHandlers()
-	registerHomeHandler()
+	handler.RegisterAdminURLHandlers(App)
+	handler.RegisterAPIHandlers(App)
+	handler.RegisterHomeHandler(App)
+	Scheduler.Start()
 	fmt.Printf("Application Started on port %s\n", portNumber)
 	App.Run(":" + portNumber)
 }
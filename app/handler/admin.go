@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"database/sql"
+	"fmt"
 	"github.com/dinever/dingo/app/model"
 	"github.com/dinever/dingo/app/utils"
 	"github.com/dinever/golf"
 	"github.com/twinj/uuid"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,9 +27,171 @@ func AdminHandler(ctx *golf.Context) {
 func ProfileHandler(ctx *golf.Context) {
 	userObj, _ := ctx.Session.Get("user")
 	u := userObj.(*model.User)
+	tokenObj, _ := ctx.Session.Get("token")
+	sessions, err := model.GetTokensByUserId(u.Id)
+	if err != nil {
+		panic(err)
+	}
+	keys, err := model.GetApiKeysByUserId(u.Id)
+	if err != nil {
+		panic(err)
+	}
 	ctx.Loader("admin").Render("profile.html", map[string]interface{}{
-		"Title": "Profile",
-		"User":  u,
+		"Title":             "Profile",
+		"User":              u,
+		"Sessions":          sessions,
+		"Current":           tokenObj,
+		"ApiKeys":           keys,
+		"TwoFactor":         u.TwoFactorEnabled(),
+		"TwoFactorRequired": model.TwoFactorRequired(),
+	})
+}
+
+// TwoFactorSetupHandler gives a new TOTP secret to add to an authenticator
+// app. It is only saved once confirmed with TwoFactorEnableHandler.
+func TwoFactorSetupHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	secret := utils.NewTOTPSecret()
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"secret": secret,
+		"uri":    utils.TOTPProvisioningURI(model.GetSettingValue("title"), u.Email, secret),
+	})
+}
+
+// TwoFactorEnableHandler enables two-factor authentication with a new
+// secret. Replacing the secret of a user who already has one takes the
+// password and a current code, like disabling it.
+func TwoFactorEnableHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	if u.TwoFactorEnabled() {
+		if !u.CheckPassword(ctx.Request.FormValue("password")) {
+			ctx.SendStatus(400)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "Incorrect password.",
+			})
+			return
+		}
+		if !u.CheckTwoFactor(ctx.Request.FormValue("current_code")) {
+			ctx.SendStatus(400)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "Invalid code.",
+			})
+			return
+		}
+	}
+	codes, err := u.EnableTwoFactor(ctx.Request.FormValue("secret"), ctx.Request.FormValue("code"))
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"codes":  codes,
+	})
+}
+
+func TwoFactorDisableHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	if model.TwoFactorRequired() {
+		ctx.SendStatus(403)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Two-factor authentication is required on this blog.",
+		})
+		return
+	}
+	if !u.CheckPassword(ctx.Request.FormValue("password")) {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Incorrect password.",
+		})
+		return
+	}
+	if err := u.DisableTwoFactor(); err != nil {
+		panic(err)
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+// ApiKeyCreateHandler issues a new API key, the key is only shown once.
+func ApiKeyCreateHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	if model.TwoFactorRequired() && !u.TwoFactorEnabled() {
+		ctx.SendStatus(403)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Two-factor authentication is required on this blog.",
+		})
+		return
+	}
+	key := model.NewApiKey(u, ctx.Request.FormValue("name"))
+	if err := key.Save(); err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"key":    key.Key,
+	})
+}
+
+func ApiKeyRemoveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	if err := model.DeleteApiKeyById(int64(id), u.Id); err != nil {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+// SessionRemoveHandler revokes the session given by id, or all the other
+// sessions of the user when no id is given.
+func SessionRemoveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	tokenObj, _ := ctx.Session.Get("token")
+	current := tokenObj.(*model.Token)
+	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	var err error
+	if id > 0 {
+		err = model.DeleteTokenById(int64(id), u.Id)
+	} else {
+		err = model.DeleteTokensByUserId(u.Id, current.Id)
+	}
+	if err != nil {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
 	})
 }
 
@@ -58,9 +223,10 @@ func PostCreateHandler(ctx *golf.Context) {
 	u := userObj.(*model.User)
 	p := model.NewPost()
 	ctx.Loader("admin").Render("edit_post.html", map[string]interface{}{
-		"Title": "New Post",
-		"Post":  p,
-		"User":  u,
+		"Title":      "New Post",
+		"Post":       p,
+		"User":       u,
+		"Categories": getCategoryTree(),
 	})
 }
 
@@ -71,16 +237,21 @@ func PostSaveHandler(ctx *golf.Context) {
 	id := ctx.Param("id")
 	idInt, _ := strconv.Atoi(id)
 	p.Id = int64(idInt)
+	if p.Id != 0 && editablePost(ctx, u, p.Id) == nil {
+		return
+	}
 	p.Title = ctx.Request.FormValue("title")
 	p.Slug = ctx.Request.FormValue("slug")
 	p.Markdown = ctx.Request.FormValue("content")
 	p.Html = utils.Markdown2Html(p.Markdown)
 	p.Tags = model.GenerateTagsFromCommaString(ctx.Request.FormValue("tag"))
 	p.AllowComment = ctx.Request.FormValue("comment") == "on"
-	p.Category = ctx.Request.FormValue("category")
+	p.Category = getCategoryFromForm(ctx.Request.FormValue("category"))
 	p.CreatedBy = u.Id
 	p.UpdatedBy = u.Id
 	p.IsPublished = ctx.Request.FormValue("status") == "on"
+	p.PublishedAt = getTimeFromForm(ctx.Request.FormValue("published_at"))
+	p.UnpublishAt = getTimeFromForm(ctx.Request.FormValue("unpublish_at"))
 	p.IsPage = false
 	p.Author = u
 	p.Hits = 1
@@ -100,19 +271,59 @@ func PostSaveHandler(ctx *golf.Context) {
 	})
 }
 
+// editablePost gets the post of the id, it answers with an error and returns
+// nil if the post can not be found or can not be edited by the user.
+func editablePost(ctx *golf.Context, u *model.User, id int64) *model.Post {
+	p, err := model.GetPostById(id)
+	if err != nil {
+		status, msg := 500, err.Error()
+		if err == sql.ErrNoRows {
+			status, msg = 404, "Post not found"
+		}
+		ctx.SendStatus(status)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    msg,
+		})
+		return nil
+	}
+	if !u.CanEditPost(p) {
+		forbidden(ctx)
+		return nil
+	}
+	return p
+}
+
 func AdminPostHandler(ctx *golf.Context) {
 	userObj, _ := ctx.Session.Get("user")
 	u := userObj.(*model.User)
 	i, _ := strconv.Atoi(ctx.Request.FormValue("page"))
-	posts, pager, err := model.GetPostList(int64(i), 10, false, false, "created_at DESC")
+	q := strings.TrimSpace(ctx.Request.FormValue("q"))
+	var (
+		posts []*model.Post
+		pager *utils.Pager
+		err   error
+	)
+	if q != "" && model.SearchAvailable() {
+		// Drafts and scheduled posts are searched too
+		var results []*model.SearchResult
+		results, pager, err = model.SearchPosts(q, &model.PostFilter{}, int64(i), 10)
+		for _, r := range results {
+			posts = append(posts, r.Post)
+		}
+	} else {
+		posts, pager, err = model.GetPostList(int64(i), 10, false, false, "created_at DESC")
+	}
 	if err != nil {
 		panic(err)
 	}
 	ctx.Loader("admin").Render("posts.html", map[string]interface{}{
-		"Title": "Posts",
-		"Posts": posts,
-		"User":  u,
-		"Pager": pager,
+		"Title":  "Posts",
+		"Posts":  posts,
+		"User":   u,
+		"Pager":  pager,
+		"Query":  q,
+		"Search": model.SearchAvailable(),
 	})
 }
 
@@ -126,16 +337,114 @@ func PostEditHandler(ctx *golf.Context) {
 		ctx.Redirect("/admin/posts/")
 		return
 	}
+	if !u.CanEditPost(p) {
+		forbidden(ctx)
+		return
+	}
 	ctx.Loader("admin").Render("edit_post.html", map[string]interface{}{
-		"Title": "Edit Post",
-		"Post":  p,
-		"User":  u,
+		"Title":      "Edit Post",
+		"Post":       p,
+		"User":       u,
+		"Categories": getCategoryTree(),
+	})
+}
+
+func PostRevisionsHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := ctx.Param("id")
+	postId, _ := strconv.Atoi(id)
+	p, err := model.GetPostById(int64(postId))
+	if p == nil || err != nil {
+		ctx.Redirect("/admin/posts/")
+		return
+	}
+	if !u.CanEditPost(p) {
+		forbidden(ctx)
+		return
+	}
+	revisions, _ := model.GetPostRevisionsByPostId(p.Id)
+	// Compare the two latest revisions unless asked otherwise
+	var from, to *model.PostRevision
+	if len(revisions) > 1 {
+		from, to = revisions[1], revisions[0]
+	}
+	if fromId, err := strconv.Atoi(ctx.Request.FormValue("from")); err == nil {
+		if r, err := model.GetPostRevisionById(int64(fromId)); err == nil && r.PostId == p.Id {
+			from = r
+		}
+	}
+	if toId, err := strconv.Atoi(ctx.Request.FormValue("to")); err == nil {
+		if r, err := model.GetPostRevisionById(int64(toId)); err == nil && r.PostId == p.Id {
+			to = r
+		}
+	}
+	data := map[string]interface{}{
+		"Title":     "Revisions",
+		"Post":      p,
+		"User":      u,
+		"Revisions": revisions,
+		"From":      from,
+		"To":        to,
+	}
+	if from != nil && to != nil {
+		data["Diff"] = from.Diff(to)
+	}
+	ctx.Loader("admin").Render("revisions.html", data)
+}
+
+func PostRevisionRestoreHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id := ctx.Param("id")
+	postId, _ := strconv.Atoi(id)
+	rid := ctx.Param("rid")
+	revisionId, _ := strconv.Atoi(rid)
+	p, err := model.GetPostById(int64(postId))
+	if err != nil {
+		ctx.SendStatus(404)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Post not found",
+		})
+		return
+	}
+	if !u.CanEditPost(p) {
+		forbidden(ctx)
+		return
+	}
+	r, err := model.GetPostRevisionById(int64(revisionId))
+	if err != nil || r.PostId != p.Id {
+		ctx.SendStatus(404)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Revision not found",
+		})
+		return
+	}
+	p.CreatedBy = u.Id
+	if err := p.RestoreRevision(r); err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":  "success",
+		"content": p,
 	})
 }
 
 func PostRemoveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
 	id := ctx.Param("id")
 	postId, _ := strconv.Atoi(id)
+	if editablePost(ctx, u, int64(postId)) == nil {
+		return
+	}
 	err := model.DeletePostById(int64(postId))
 	if err != nil {
 		ctx.JSON(map[string]interface{}{
@@ -153,9 +462,10 @@ func PageCreateHandler(ctx *golf.Context) {
 	u := userObj.(*model.User)
 	p := model.NewPost()
 	ctx.Loader("admin").Render("edit_post.html", map[string]interface{}{
-		"Title": "New Page",
-		"Post":  p,
-		"User":  u,
+		"Title":      "New Page",
+		"Post":       p,
+		"User":       u,
+		"Categories": getCategoryTree(),
 	})
 }
 
@@ -192,10 +502,12 @@ func PageSaveHandler(ctx *golf.Context) {
 	p.Html = utils.Markdown2Html(p.Markdown)
 	p.Tags = model.GenerateTagsFromCommaString(ctx.Request.FormValue("tag"))
 	p.AllowComment = ctx.Request.FormValue("comment") == "on"
-	p.Category = ctx.Request.FormValue("category")
+	p.Category = getCategoryFromForm(ctx.Request.FormValue("category"))
 	p.CreatedBy = u.Id
 	p.UpdatedBy = u.Id
 	p.IsPublished = ctx.Request.FormValue("status") == "on"
+	p.PublishedAt = getTimeFromForm(ctx.Request.FormValue("published_at"))
+	p.UnpublishAt = getTimeFromForm(ctx.Request.FormValue("unpublish_at"))
 	p.IsPage = true
 	p.Author = u
 	p.Hits = 1
@@ -214,16 +526,29 @@ func PageSaveHandler(ctx *golf.Context) {
 	})
 }
 
+// CommentViewHandler shows the moderation queue, the comments of one state
+// at a time, the pending ones by default.
 func CommentViewHandler(ctx *golf.Context) {
 	i, _ := strconv.Atoi(ctx.Request.FormValue("page"))
 	user, _ := ctx.Session.Get("user")
-	comments, pager, err := model.GetCommentList(int64(i), 10)
+	status := ctx.Request.FormValue("status")
+	if !model.IsCommentStatus(status) {
+		status = model.CommentPending
+	}
+	comments, pager, err := model.GetFilteredCommentList(&model.CommentFilter{Status: status}, int64(i), 10)
+	if err != nil {
+		panic(err)
+	}
+	counts, err := model.GetCommentCounts()
 	if err != nil {
 		panic(err)
 	}
 	ctx.Loader("admin").Render("comments.html", map[string]interface{}{
 		"Title":    "Comments",
 		"Comments": comments,
+		"Status":   status,
+		"Statuses": model.CommentStatuses,
+		"Counts":   counts,
 		"User":     user,
 		"Pager":    pager,
 	})
@@ -238,8 +563,7 @@ func CommentAddHandler(ctx *golf.Context) {
 		panic(err)
 	}
 	if !parent.Approved {
-		parent.Approved = true
-		parent.Save()
+		moderateComment(parent, model.CommentApproved)
 	}
 	c := model.NewComment()
 	c.Author = u.Name
@@ -249,7 +573,7 @@ func CommentAddHandler(ctx *golf.Context) {
 	c.Avatar = utils.Gravatar(c.Email, "50")
 	c.Parent = parent.Id
 	c.PostId = parent.PostId
-	c.Ip = ctx.Request.RemoteAddr
+	c.Ip = clientIp(ctx.Request)
 	c.UserAgent = ctx.Request.UserAgent()
 	c.UserId = u.Id
 	c.Approved = true
@@ -260,22 +584,36 @@ func CommentAddHandler(ctx *golf.Context) {
 		"status":  "success",
 		"comment": c.ToJson(),
 	})
+	notifyReply(c)
 	if err := model.NewMessage("comment", c).Save(); err != nil {
 		panic(err)
 	}
 }
 
+// CommentUpdateHandler moves a comment to the moderation state of the
+// status parameter, it approves the comment by default.
 func CommentUpdateHandler(ctx *golf.Context) {
 	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	status := ctx.Request.FormValue("status")
+	if status == "" {
+		status = model.CommentApproved
+	}
+	if !model.IsCommentStatus(status) {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Unknown comment status: " + status,
+		})
+		return
+	}
 	c, err := model.GetCommentById(int64(id))
 	if err != nil {
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
 			"msg":    err.Error(),
 		})
+		return
 	}
-	c.Approved = true
-	if err := c.Save(); err != nil {
+	if err := moderateComment(c, status); err != nil {
 		panic(err)
 	}
 	ctx.JSON(map[string]interface{}{
@@ -283,6 +621,41 @@ func CommentUpdateHandler(ctx *golf.Context) {
 	})
 }
 
+// CommentBulkHandler applies an action to the comments of the id
+// parameters: a moderation state, or delete to delete them for good.
+func CommentBulkHandler(ctx *golf.Context) {
+	ctx.Request.ParseForm()
+	action := ctx.Request.FormValue("action")
+	if action != "delete" && !model.IsCommentStatus(action) {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Unknown action: " + action,
+		})
+		return
+	}
+	count := 0
+	for _, v := range ctx.Request.Form["id"] {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		c, err := model.GetCommentById(id)
+		if err != nil {
+			continue
+		}
+		if action == "delete" {
+			err = model.DeleteComment(c.Id)
+		} else {
+			err = moderateComment(c, action)
+		}
+		if err != nil {
+			panic(err)
+		}
+		count++
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"count":  count,
+	})
+}
+
 func CommentRemoveHandler(ctx *golf.Context) {
 	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
 	err := model.DeleteComment(int64(id))
@@ -297,6 +670,95 @@ func CommentRemoveHandler(ctx *golf.Context) {
 	})
 }
 
+func CategoryViewHandler(ctx *golf.Context) {
+	user, _ := ctx.Session.Get("user")
+	categories, err := model.GetCategoryTree()
+	if err != nil {
+		panic(err)
+	}
+	ctx.Loader("admin").Render("categories.html", map[string]interface{}{
+		"Title":      "Categories",
+		"Categories": categories,
+		"User":       user,
+	})
+}
+
+func CategorySaveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	var (
+		c   *model.Category
+		err error
+	)
+	if id > 0 {
+		c, err = model.GetCategoryById(int64(id))
+		if err != nil {
+			ctx.SendStatus(404)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "Category not found.",
+			})
+			return
+		}
+	} else {
+		c = model.NewCategory("", "")
+		c.CreatedBy = u.Id
+	}
+	c.Name = strings.TrimSpace(ctx.Request.FormValue("name"))
+	if c.Name == "" {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Name can not be empty.",
+		})
+		return
+	}
+	if slug := ctx.Request.FormValue("slug"); slug != "" {
+		c.Slug = slug
+	} else if c.Id == 0 {
+		c.Slug = model.GenerateSlug(c.Name, "categories")
+	}
+	parentId, _ := strconv.Atoi(ctx.Request.FormValue("parent"))
+	c.ParentId = int64(parentId)
+	c.Description = ctx.Request.FormValue("description")
+	// The meta fields are kept when the form does not have them
+	if _, ok := ctx.Request.Form["meta_title"]; ok {
+		c.MetaTitle = ctx.Request.FormValue("meta_title")
+	}
+	if _, ok := ctx.Request.Form["meta_description"]; ok {
+		c.MetaDescription = ctx.Request.FormValue("meta_description")
+	}
+	c.UpdatedBy = u.Id
+	if err = c.Save(); err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":   "success",
+		"category": c,
+	})
+}
+
+func CategoryRemoveHandler(ctx *golf.Context) {
+	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	err := model.DeleteCategoryById(int64(id))
+	if err != nil {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
 func SettingViewHandler(ctx *golf.Context) {
 	user, _ := ctx.Session.Get("user")
 	ctx.Loader("admin").Render("setting.html", map[string]interface{}{
@@ -304,6 +766,7 @@ func SettingViewHandler(ctx *golf.Context) {
 		"User":       user,
 		"Custom":     model.GetCustomSettings(),
 		"Navigators": model.GetNavigators(),
+		"Backups":    Backups.List(),
 	})
 }
 
@@ -395,3 +858,173 @@ func AdminMonitorPage(ctx *golf.Context) {
 		"Monitor": utils.ReadMemStats(),
 	})
 }
+
+func UserViewHandler(ctx *golf.Context) {
+	user, _ := ctx.Session.Get("user")
+	users, err := model.GetAllUsers()
+	if err != nil {
+		panic(err)
+	}
+	invitations, err := model.GetPendingInvitations()
+	if err != nil {
+		panic(err)
+	}
+	ctx.Loader("admin").Render("users.html", map[string]interface{}{
+		"Title":       "Users",
+		"Users":       users,
+		"Invitations": invitations,
+		"User":        user,
+	})
+}
+
+func UserInviteHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	email := strings.TrimSpace(ctx.Request.FormValue("email"))
+	if !rxEmail.MatchString(email) {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Invalid email address.",
+		})
+		return
+	}
+	if model.UserEmailExist(email) {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "A user with this email address already exists.",
+		})
+		return
+	}
+	if _, err := mailUrl("/"); err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	role, _ := strconv.Atoi(ctx.Request.FormValue("role"))
+	invitation := model.NewInvitation(email, role, u.Id)
+	if err := invitation.Save(); err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	link, _ := mailUrl(invitation.Url())
+	sendMail(utils.NewMail(email, "You have been invited to "+model.GetSettingValue("title"), fmt.Sprintf(invitationMail,
+		u.Name, model.GetSettingValue("title"), invitation.RoleName(), link, model.InvitationExpiration)))
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"url":    invitation.Url(),
+	})
+}
+
+const invitationMail = `Hi,
+
+%s invited you to join %s as %s. To create your account, open the
+following link:
+
+%s
+
+The link can only be used once and expires in %v.
+`
+
+// getManagedUser finds the user given in the form, the owner and the current
+// user can not be managed.
+func getManagedUser(ctx *golf.Context) *model.User {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	target, err := model.GetUserById(int64(id))
+	if err != nil {
+		ctx.SendStatus(404)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "User not found.",
+		})
+		return nil
+	}
+	if target.Id == u.Id || target.Role == model.RoleOwner {
+		forbidden(ctx)
+		return nil
+	}
+	return target
+}
+
+func UserUpdateHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	target := getManagedUser(ctx)
+	if target == nil {
+		return
+	}
+	if role, err := strconv.Atoi(ctx.Request.FormValue("role")); err == nil {
+		if role < model.RoleAdministrator || role > model.RoleAuthor {
+			ctx.SendStatus(400)
+			ctx.JSON(map[string]interface{}{
+				"status": "error",
+				"msg":    "Invalid role.",
+			})
+			return
+		}
+		if err := target.SetRole(role); err != nil {
+			panic(err)
+		}
+	}
+	switch status := ctx.Request.FormValue("status"); status {
+	case "":
+	case model.UserActive, model.UserSuspended:
+		if err := target.SetStatus(status, u.Id); err != nil {
+			panic(err)
+		}
+	default:
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Invalid status.",
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+func UserRemoveHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	target := getManagedUser(ctx)
+	if target == nil {
+		return
+	}
+	// The posts of the deleted user are given to the current user
+	if err := model.DeleteUserById(target.Id, u.Id); err != nil {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+func InvitationRemoveHandler(ctx *golf.Context) {
+	id, _ := strconv.Atoi(ctx.Request.FormValue("id"))
+	if err := model.DeleteInvitationById(int64(id)); err != nil {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
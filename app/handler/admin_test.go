@@ -1,15 +1,21 @@
 package handler
 
 import (
+	"archive/zip"
+	"bytes"
 	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
 	"github.com/dinever/golf"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func authenticatedContext(form url.Values, method, path string) *golf.Context {
@@ -25,6 +31,23 @@ func authenticatedContext(form url.Values, method, path string) *golf.Context {
 	return golf.NewContext(req, w, app)
 }
 
+// authorContext is like authenticatedContext, for an author who does not own
+// the site.
+func authorContext(form url.Values, method, path string) *golf.Context {
+	_ = model.NewUser(email, name).Create(password)
+	_ = model.NewUser("author@example.com", "Author").Create(password)
+	login := url.Values{}
+	login.Add("email", "author@example.com")
+	login.Add("password", password)
+	ctx := mockContext(login, "POST", "/login/")
+	ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+	rec := ctx.Response.(*httptest.ResponseRecorder)
+	req := makeTestHTTPRequest(strings.NewReader(form.Encode()), method, path)
+	req.Header = http.Header{"Cookie": rec.HeaderMap["Set-Cookie"]}
+	req.PostForm = form
+	return golf.NewContext(req, httptest.NewRecorder(), InitTestApp())
+}
+
 func TestViewHandler(t *testing.T) {
 	Convey("Initialize database", t, func() {
 		model.Initialize("test.db", true)
@@ -79,6 +102,26 @@ func TestViewHandler(t *testing.T) {
 			})
 		})
 
+		Convey("Post search view", func() {
+			ctx := authenticatedContext(nil, "GET", "/admin/posts/?q=welcome")
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			Convey("Should return HTTP response 200 OK", func() {
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("Search view", func() {
+			ctx := mockContext(nil, "GET", "/search/?q=welcome")
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			Convey("Should return HTTP response 200 OK", func() {
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			})
+		})
+
 		Convey("Page view", func() {
 			ctx := authenticatedContext(nil, "GET", "/admin/pages/")
 			app := ctx.App
@@ -163,6 +206,16 @@ func TestViewHandler(t *testing.T) {
 			})
 		})
 
+		Convey("Users view", func() {
+			ctx := authenticatedContext(nil, "GET", "/admin/users/")
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			Convey("Should return HTTP response 200 OK", func() {
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			})
+		})
+
 	})
 }
 
@@ -186,6 +239,49 @@ func TestProfileChangeHandler(t *testing.T) {
 	})
 }
 
+func TestTwoFactorHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		cookies := authenticatedContext(nil, "GET", "/admin/").Request.Header
+		u, _ := model.GetUserByEmail(email)
+		old := utils.NewTOTPSecret()
+		code, _ := utils.TOTPCode(old, utils.TOTPStep(time.Now()))
+		recovery, err := u.EnableTwoFactor(old, code)
+		So(err, ShouldBeNil)
+
+		Convey("Replace the secret with the password and a current code", func() {
+			enable := func(form url.Values) int {
+				req := makeTestHTTPRequest(strings.NewReader(form.Encode()), "POST", "/admin/profile/2fa/")
+				req.Header = cookies
+				req.PostForm = form
+				ctx := golf.NewContext(req, httptest.NewRecorder(), InitTestApp())
+				ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+				return ctx.Response.(*httptest.ResponseRecorder).Code
+			}
+			secret := utils.NewTOTPSecret()
+			code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+			form := url.Values{}
+			form.Add("secret", secret)
+			form.Add("code", code)
+			So(enable(form), ShouldEqual, 400)
+
+			form.Set("password", password)
+			form.Set("current_code", "000000")
+			So(enable(form), ShouldEqual, 400)
+			So(u.CheckTwoFactor(recovery[1]), ShouldBeTrue)
+
+			form.Set("current_code", recovery[0])
+			So(enable(form), ShouldEqual, 200)
+			So(u.RecoveryCodesLeft(), ShouldEqual, model.RecoveryCodeCount)
+			So(u.CheckTwoFactor(recovery[2]), ShouldBeFalse)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
+
 func TestPostHandler(t *testing.T) {
 	Convey("Initialize database", t, func() {
 		model.Initialize("test.db", true)
@@ -375,6 +471,20 @@ func TestPostHandler(t *testing.T) {
 	})
 }
 
+// stubSpamChecker takes the comments whose content is "spam" for spam.
+type stubSpamChecker struct {
+	learned map[int64]bool
+}
+
+func (s *stubSpamChecker) IsSpam(c *model.Comment) (bool, error) {
+	return c.Content == "spam", nil
+}
+
+func (s *stubSpamChecker) Learn(c *model.Comment, spam bool) error {
+	s.learned[c.Id] = spam
+	return nil
+}
+
 func TestCommentHandler(t *testing.T) {
 	Convey("Initialize database", t, func() {
 		model.Initialize("test.db", true)
@@ -398,6 +508,7 @@ func TestCommentHandler(t *testing.T) {
 				form.Add("email", "ken@gmail.com")
 				form.Add("website", "https://en.wikipedia.org/wiki/Ken_Thompson")
 				form.Add("comment", "Nice post!")
+				form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
 				ctx := mockContext(form, "POST", "/comment/1/")
 				app.ServeHTTP(ctx.Response, ctx.Request)
 
@@ -446,6 +557,89 @@ func TestCommentHandler(t *testing.T) {
 					})
 				})
 
+				Convey("Mark the comment as spam", func() {
+					checker := &stubSpamChecker{learned: make(map[int64]bool)}
+					SpamChecker = checker
+					defer func() { SpamChecker = nil }()
+					form := url.Values{}
+					form.Add("id", "1")
+					form.Add("status", "spam")
+					ctx := authenticatedContext(form, "PUT", "/admin/comments/")
+					app.ServeHTTP(ctx.Response, ctx.Request)
+
+					So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "success")
+					c, err := model.GetCommentById(1)
+					So(err, ShouldBeNil)
+					So(c.Status, ShouldEqual, model.CommentSpam)
+					So(checker.learned, ShouldResemble, map[int64]bool{1: true})
+
+					Convey("Catch the next spam", func() {
+						form := url.Values{}
+						form.Add("author", "Spammer")
+						form.Add("email", "spam@example.com")
+						form.Add("comment", "spam")
+						form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+						ctx := mockContext(form, "POST", "/comment/1/")
+						app.ServeHTTP(ctx.Response, ctx.Request)
+
+						c, err := model.GetCommentById(2)
+						So(err, ShouldBeNil)
+						So(c.Status, ShouldEqual, model.CommentSpam)
+						counts, _ := model.GetCommentCounts()
+						So(counts[model.CommentSpam], ShouldEqual, 2)
+					})
+				})
+
+				Convey("Moderate comments in bulk", func() {
+					So(model.NewComment().Save(), ShouldBeNil)
+					form := url.Values{}
+					form.Add("action", "trash")
+					form.Add("id", "1")
+					form.Add("id", "2")
+					ctx := authenticatedContext(form, "POST", "/admin/comments/bulk/")
+					app.ServeHTTP(ctx.Response, ctx.Request)
+
+					So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `"count":2`)
+					counts, _ := model.GetCommentCounts()
+					So(counts[model.CommentTrash], ShouldEqual, 2)
+
+					form.Set("action", "delete")
+					ctx = authenticatedContext(form, "POST", "/admin/comments/bulk/")
+					app.ServeHTTP(ctx.Response, ctx.Request)
+					_, err := model.GetCommentById(1)
+					So(err, ShouldNotBeNil)
+
+					form.Set("action", "archive")
+					ctx = authenticatedContext(form, "POST", "/admin/comments/bulk/")
+					app.ServeHTTP(ctx.Response, ctx.Request)
+					So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "Unknown action")
+				})
+
+				Convey("Get the comment tree", func() {
+					c, _ := model.GetCommentById(1)
+					reply := model.NewComment()
+					reply.Author, reply.Email, reply.Content = "Dennis Ritchie", "dmr@example.com", "Indeed."
+					reply.PostId, reply.Parent = c.PostId, c.Id
+					reply.Approved = true
+					So(reply.Save(), ShouldBeNil)
+
+					rec := getFeed("/comment/1/")
+					So(rec.Code, ShouldEqual, 200)
+					body := rec.Body.String()
+					// The pending comment only holds its reply
+					So(body, ShouldContainSubstring, `"deleted":true`)
+					So(body, ShouldNotContainSubstring, "ken@gmail.com")
+					So(body, ShouldContainSubstring, `"author":"Dennis Ritchie"`)
+					So(body, ShouldNotContainSubstring, "dmr@example.com")
+					So(getFeed("/comment/99/").Code, ShouldEqual, 404)
+				})
+
+				Convey("View the spam queue", func() {
+					ctx := authenticatedContext(nil, "GET", "/admin/comments/?status=spam")
+					app.ServeHTTP(ctx.Response, ctx.Request)
+					So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+				})
+
 				Convey("Delete the comment", func() {
 					form := url.Values{}
 					form.Add("id", "1")
@@ -471,6 +665,86 @@ func TestCommentHandler(t *testing.T) {
 	})
 }
 
+func TestAuthorPermission(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		form := url.Values{}
+		form.Add("title", "Hello World")
+		form.Add("slug", "hello-world")
+		form.Add("content", "Sample content")
+		ctx := authenticatedContext(form, "POST", "/admin/editor/post/")
+		ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+
+		Convey("Authors can not save over the posts of others", func() {
+			form.Set("title", "Hijacked")
+			ctx := authorContext(form, "POST", "/admin/editor/1/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 403)
+			post, _ := model.GetPostById(1)
+			So(post.Title, ShouldEqual, "Hello World")
+		})
+
+		Convey("Posts which can not be found are not saved", func() {
+			ctx := authorContext(form, "POST", "/admin/editor/42/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 404)
+			_, err := model.GetPostById(42)
+			So(err, ShouldNotBeNil)
+
+			ctx = authorContext(nil, "DELETE", "/admin/editor/42/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 404)
+		})
+
+		Convey("Authors can not upload files", func() {
+			ctx := authorContext(nil, "POST", "/admin/files/upload/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 403)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
+
+func TestCategoryHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		c := model.NewCategory("Go", "go")
+		c.MetaTitle = "All about Go"
+		c.MetaDescription = "Posts about Go."
+		So(c.Save(), ShouldBeNil)
+
+		Convey("Keep the meta fields which are not sent", func() {
+			form := url.Values{}
+			form.Add("id", "1")
+			form.Add("name", "Golang")
+			ctx := authenticatedContext(form, "POST", "/admin/categories/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+
+			category, err := model.GetCategoryById(1)
+			So(err, ShouldBeNil)
+			So(category.Name, ShouldEqual, "Golang")
+			So(category.MetaTitle, ShouldEqual, "All about Go")
+			So(category.MetaDescription, ShouldEqual, "Posts about Go.")
+
+			form.Add("meta_title", "")
+			form.Add("meta_description", "Go posts.")
+			ctx = authenticatedContext(form, "POST", "/admin/categories/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			category, _ = model.GetCategoryById(1)
+			So(category.MetaTitle, ShouldEqual, "")
+			So(category.MetaDescription, ShouldEqual, "Go posts.")
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
+
 func TestSettingHandler(t *testing.T) {
 	Convey("Initialize database", t, func() {
 		model.Initialize("test.db", true)
@@ -591,3 +865,140 @@ func TestPasswordHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestExportHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+
+		Convey("Export the site", func() {
+			ctx := authenticatedContext(nil, "GET", "/admin/setting/export/")
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			rec := ctx.Response.(*httptest.ResponseRecorder)
+
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Header().Get("Content-Disposition"), ShouldStartWith, "attachment;")
+			So(rec.Body.String(), ShouldContainSubstring, `"version": 1`)
+			So(rec.Body.String(), ShouldNotContainSubstring, `"password"`)
+
+			Convey("Import the export again", func() {
+				var body bytes.Buffer
+				w := multipart.NewWriter(&body)
+				part, _ := w.CreateFormFile("file", "dingo.json")
+				part.Write(rec.Body.Bytes())
+				w.Close()
+				ctx := authenticatedContext(nil, "POST", "/admin/setting/import/")
+				ctx.Request.Body = ioutil.NopCloser(&body)
+				ctx.Request.Header.Set("Content-Type", w.FormDataContentType())
+				app := ctx.App
+				app.ServeHTTP(ctx.Response, ctx.Request)
+
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+				So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "success")
+			})
+
+			Convey("Export the site for Ghost", func() {
+				ctx := authenticatedContext(nil, "GET", "/admin/setting/export/?format=ghost")
+				app := ctx.App
+				app.ServeHTTP(ctx.Response, ctx.Request)
+				rec := ctx.Response.(*httptest.ResponseRecorder)
+
+				So(rec.Code, ShouldEqual, 200)
+				So(rec.Header().Get("Content-Disposition"), ShouldContainSubstring, "dingo-ghost-")
+				So(model.IsGhostExport(rec.Body.Bytes()), ShouldBeTrue)
+			})
+
+			Convey("Import a file which is not an export", func() {
+				var body bytes.Buffer
+				w := multipart.NewWriter(&body)
+				part, _ := w.CreateFormFile("file", "dingo.json")
+				part.Write([]byte(`{"posts": []}`))
+				w.Close()
+				ctx := authenticatedContext(nil, "POST", "/admin/setting/import/")
+				ctx.Request.Body = ioutil.NopCloser(&body)
+				ctx.Request.Header.Set("Content-Type", w.FormDataContentType())
+				app := ctx.App
+				app.ServeHTTP(ctx.Response, ctx.Request)
+
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 400)
+				So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "error")
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
+
+func TestMarkdownImportHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+
+		Convey("Import a zip of Markdown files", func() {
+			var archive bytes.Buffer
+			z := zip.NewWriter(&archive)
+			f, _ := z.Create("hello.md")
+			f.Write([]byte("---\ntitle: Hello Markdown\ntags: [Go]\n---\nHello.\n"))
+			z.Close()
+			var body bytes.Buffer
+			w := multipart.NewWriter(&body)
+			part, _ := w.CreateFormFile("file", "posts.zip")
+			part.Write(archive.Bytes())
+			w.Close()
+			ctx := authenticatedContext(nil, "POST", "/admin/setting/import/markdown/")
+			ctx.Request.Body = ioutil.NopCloser(&body)
+			ctx.Request.Header.Set("Content-Type", w.FormDataContentType())
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `"created":["hello.md"]`)
+			p, err := model.GetPostBySlug("hello")
+			So(err, ShouldBeNil)
+			So(p.Author.Email, ShouldEqual, email)
+		})
+
+		Convey("Import a file which is not a zip", func() {
+			var body bytes.Buffer
+			w := multipart.NewWriter(&body)
+			part, _ := w.CreateFormFile("file", "posts.zip")
+			part.Write([]byte("not a zip"))
+			w.Close()
+			ctx := authenticatedContext(nil, "POST", "/admin/setting/import/markdown/")
+			ctx.Request.Body = ioutil.NopCloser(&body)
+			ctx.Request.Header.Set("Content-Type", w.FormDataContentType())
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 400)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "error")
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
+
+func TestBackupHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		Backups = &model.Backups{Dir: "test-backup", UploadDir: "test-upload", Keep: 1}
+
+		Convey("Back up the site", func() {
+			ctx := authenticatedContext(nil, "POST", "/admin/setting/backup/")
+			app := ctx.App
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "success")
+			So(Backups.List(), ShouldHaveLength, 1)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+			os.RemoveAll("test-backup")
+		})
+	})
+}
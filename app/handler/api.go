@@ -0,0 +1,590 @@
+package handler
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	"github.com/dinever/golf"
+)
+
+// The JSON API answers with an envelope: {"status": "success", "data": ...}
+// with a "meta" object for lists, or {"status": "error", "msg": ...} with an
+// "errors" object mapping the invalid fields to their problem.
+
+const (
+	apiDefaultPageSize = 10
+	apiMaxPageSize     = 100
+)
+
+// APIAuthMiddleware authenticates the API requests with the key given in the
+// header "Authorization: Bearer <key>".
+func APIAuthMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
+	fn := func(ctx *golf.Context) {
+		header := ctx.Request.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			apiError(ctx, 401, "An API key is required")
+			return
+		}
+		key, err := model.GetApiKeyByKey(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if err != nil {
+			apiError(ctx, 401, "Invalid API key")
+			return
+		}
+		user, err := model.GetUserById(key.UserId)
+		if err != nil || !user.IsActive() {
+			apiError(ctx, 401, "Invalid API key")
+			return
+		}
+		if model.TwoFactorRequired() && !user.TwoFactorEnabled() {
+			apiError(ctx, 403, "Two-factor authentication is required on this blog")
+			return
+		}
+		if err := key.Touch(); err != nil {
+			panic(err)
+		}
+		ctx.Session.Set("user", user)
+		next(ctx)
+	}
+	return fn
+}
+
+func apiSuccess(ctx *golf.Context, data interface{}) {
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+func apiList(ctx *golf.Context, data interface{}, pager *utils.Pager) {
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+		"meta": map[string]interface{}{
+			"pagination": map[string]interface{}{
+				"page":  pager.Current,
+				"size":  pager.Size,
+				"total": pager.Total,
+				"pages": pager.Pages,
+				"prev":  pager.IsPrev,
+				"next":  pager.IsNext,
+			},
+		},
+	})
+}
+
+func apiError(ctx *golf.Context, code int, msg string) {
+	ctx.SendStatus(code)
+	ctx.JSON(map[string]interface{}{
+		"status": "error",
+		"msg":    msg,
+	})
+}
+
+func apiValidationError(ctx *golf.Context, errors map[string]string) {
+	ctx.SendStatus(422)
+	ctx.JSON(map[string]interface{}{
+		"status": "error",
+		"msg":    "Validation failed",
+		"errors": errors,
+	})
+}
+
+// apiPage reads the page and size query parameters of lists.
+func apiPage(ctx *golf.Context, errors map[string]string) (int64, int64) {
+	page, size := int64(1), int64(apiDefaultPageSize)
+	if v := ctx.Request.FormValue("page"); v != "" {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || i < 1 {
+			errors["page"] = "must be a positive integer"
+		}
+		page = i
+	}
+	if v := ctx.Request.FormValue("size"); v != "" {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || i < 1 || i > apiMaxPageSize {
+			errors["size"] = "must be between 1 and " + strconv.Itoa(apiMaxPageSize)
+		}
+		size = i
+	}
+	return page, size
+}
+
+func apiId(ctx *golf.Context) int64 {
+	id, _ := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	return id
+}
+
+func isValidSlug(slug string) bool {
+	for _, r := range slug {
+		if r != '-' && r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return slug != ""
+}
+
+func apiPostData(p *model.Post) map[string]interface{} {
+	tags := make([]string, len(p.Tags))
+	for i, t := range p.Tags {
+		tags[i] = t.Name
+	}
+	var category interface{}
+	if p.Category != nil {
+		category = map[string]interface{}{
+			"id":   p.Category.Id,
+			"name": p.Category.Name,
+			"slug": p.Category.Slug,
+		}
+	}
+	return map[string]interface{}{
+		"id":            p.Id,
+		"uuid":          p.UUID,
+		"title":         p.Title,
+		"slug":          p.Slug,
+		"url":           p.Url(),
+		"markdown":      p.Markdown,
+		"html":          p.Html,
+		"image":         p.Image,
+		"status":        p.Status(),
+		"page":          p.IsPage,
+		"featured":      p.IsFeatured,
+		"allow_comment": p.AllowComment,
+		"tags":          tags,
+		"category":      category,
+		"author": map[string]interface{}{
+			"id":   p.Author.Id,
+			"name": p.Author.Name,
+			"slug": p.Author.Slug,
+		},
+		"created_at":   p.CreatedAt,
+		"updated_at":   p.UpdatedAt,
+		"published_at": p.PublishedAt,
+		"unpublish_at": p.UnpublishAt,
+	}
+}
+
+func APIPostListHandler(ctx *golf.Context) {
+	apiPostList(ctx, false)
+}
+
+func APIPageListHandler(ctx *golf.Context) {
+	apiPostList(ctx, true)
+}
+
+// apiPostList lists the posts or the pages, they can be filtered by status,
+// tag slug and author slug.
+func apiPostList(ctx *golf.Context, isPage bool) {
+	errors := make(map[string]string)
+	page, size := apiPage(ctx, errors)
+	filter := &model.PostFilter{IsPage: isPage}
+	switch status := ctx.Request.FormValue("status"); status {
+	case "", "draft", "scheduled", "published":
+		filter.Status = status
+	default:
+		errors["status"] = "must be draft, scheduled or published"
+	}
+	if slug := ctx.Request.FormValue("tag"); slug != "" {
+		if tag, err := model.GetTagBySlug(slug); err != nil {
+			errors["tag"] = "tag not found"
+		} else {
+			filter.TagId = tag.Id
+		}
+	}
+	if slug := ctx.Request.FormValue("author"); slug != "" {
+		if author, err := model.GetUserBySlug(slug); err != nil {
+			errors["author"] = "user not found"
+		} else {
+			filter.AuthorId = author.Id
+		}
+	}
+	if len(errors) > 0 {
+		apiValidationError(ctx, errors)
+		return
+	}
+	posts, pager, err := model.GetFilteredPostList(filter, page, size, "created_at DESC")
+	if err != nil {
+		panic(err)
+	}
+	data := make([]map[string]interface{}, len(posts))
+	for i, p := range posts {
+		data[i] = apiPostData(p)
+	}
+	apiList(ctx, data, pager)
+}
+
+// getApiPost finds the post or page of the URL, it answers with an error and
+// returns nil if the post is missing or can not be edited by the user.
+func getApiPost(ctx *golf.Context, isPage bool, edit bool) *model.Post {
+	p, err := model.GetPostById(apiId(ctx))
+	if err != nil || p.IsPage != isPage {
+		apiError(ctx, 404, "Not found")
+		return nil
+	}
+	if edit {
+		userObj, _ := ctx.Session.Get("user")
+		if !userObj.(*model.User).CanEditPost(p) {
+			forbidden(ctx)
+			return nil
+		}
+	}
+	return p
+}
+
+func APIPostHandler(ctx *golf.Context) {
+	if p := getApiPost(ctx, false, false); p != nil {
+		apiSuccess(ctx, apiPostData(p))
+	}
+}
+
+func APIPageHandler(ctx *golf.Context) {
+	if p := getApiPost(ctx, true, false); p != nil {
+		apiSuccess(ctx, apiPostData(p))
+	}
+}
+
+// apiPostInput is the body of the requests creating and updating posts. The
+// fields which are left out are not changed.
+type apiPostInput struct {
+	Title        *string    `json:"title"`
+	Slug         *string    `json:"slug"`
+	Markdown     *string    `json:"markdown"`
+	Image        *string    `json:"image"`
+	Status       *string    `json:"status"`
+	Featured     *bool      `json:"featured"`
+	AllowComment *bool      `json:"allow_comment"`
+	Tags         []string   `json:"tags"`
+	Category     *int64     `json:"category"`
+	PublishedAt  *time.Time `json:"published_at"`
+	UnpublishAt  *time.Time `json:"unpublish_at"`
+}
+
+// apply validates the input and copies it to the post.
+func (in *apiPostInput) apply(p *model.Post) map[string]string {
+	errors := make(map[string]string)
+	if in.Title != nil {
+		p.Title = strings.TrimSpace(*in.Title)
+	}
+	if p.Title == "" {
+		errors["title"] = "can not be empty"
+	}
+	// The slugs of imported posts may not follow the rules, they are kept
+	// as long as they do not change.
+	slug := p.Slug
+	if in.Slug != nil {
+		p.Slug = strings.Trim(*in.Slug, "/")
+	}
+	if p.Slug == "" && p.Id == 0 {
+		p.Slug = model.GenerateSlug(p.Title, "posts")
+	}
+	if (p.Id == 0 || p.Slug != slug) && !isValidSlug(p.Slug) {
+		errors["slug"] = "must only contain letters, digits, dashes and underscores"
+	}
+	if in.Markdown != nil {
+		p.Markdown = *in.Markdown
+		p.Html = utils.Markdown2Html(p.Markdown)
+	}
+	if in.Image != nil {
+		p.Image = *in.Image
+	}
+	if in.Status != nil {
+		switch *in.Status {
+		case "draft":
+			p.IsPublished, p.IsScheduled = false, false
+		case "published":
+			// Posts published in the future are scheduled
+			p.IsPublished = true
+		default:
+			errors["status"] = "must be draft or published"
+		}
+	}
+	if in.Featured != nil {
+		p.IsFeatured = *in.Featured
+	}
+	if in.AllowComment != nil {
+		p.AllowComment = *in.AllowComment
+	}
+	if in.Tags != nil {
+		p.Tags = model.GenerateTagsFromCommaString(strings.Join(in.Tags, ","))
+	}
+	if in.Category != nil {
+		if *in.Category == 0 {
+			p.Category = nil
+		} else if c, err := model.GetCategoryById(*in.Category); err != nil {
+			errors["category"] = "category not found"
+		} else {
+			p.Category = c
+		}
+	}
+	if in.PublishedAt != nil {
+		p.PublishedAt = in.PublishedAt
+	}
+	if in.UnpublishAt != nil {
+		p.UnpublishAt = in.UnpublishAt
+	}
+	if p.PublishedAt != nil && p.UnpublishAt != nil && !p.UnpublishAt.After(*p.PublishedAt) {
+		errors["unpublish_at"] = "must be after published_at"
+	}
+	return errors
+}
+
+func APIPostCreateHandler(ctx *golf.Context) {
+	apiPostSave(ctx, model.NewPost(), false)
+}
+
+func APIPageCreateHandler(ctx *golf.Context) {
+	p := model.NewPost()
+	p.IsPage = true
+	apiPostSave(ctx, p, false)
+}
+
+func APIPostUpdateHandler(ctx *golf.Context) {
+	if p := getApiPost(ctx, false, true); p != nil {
+		apiPostSave(ctx, p, true)
+	}
+}
+
+func APIPageUpdateHandler(ctx *golf.Context) {
+	if p := getApiPost(ctx, true, true); p != nil {
+		apiPostSave(ctx, p, true)
+	}
+}
+
+func apiPostSave(ctx *golf.Context, p *model.Post, update bool) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	input := new(apiPostInput)
+	if err := json.NewDecoder(ctx.Request.Body).Decode(input); err != nil {
+		apiError(ctx, 400, "Invalid JSON body")
+		return
+	}
+	if !update {
+		p.AllowComment = true
+		p.Author = u
+		p.Hits = 1
+	}
+	if errors := input.apply(p); len(errors) > 0 {
+		apiValidationError(ctx, errors)
+		return
+	}
+	p.CreatedBy = u.Id
+	p.UpdatedBy = u.Id
+	if err := p.Save(); err != nil {
+		apiError(ctx, 400, err.Error())
+		return
+	}
+	// Read the post back to answer with what has been saved
+	saved, err := model.GetPostById(p.Id)
+	if err != nil {
+		panic(err)
+	}
+	if !update {
+		ctx.SendStatus(201)
+	}
+	apiSuccess(ctx, apiPostData(saved))
+}
+
+func APIPostRemoveHandler(ctx *golf.Context) {
+	apiPostRemove(ctx, false)
+}
+
+func APIPageRemoveHandler(ctx *golf.Context) {
+	apiPostRemove(ctx, true)
+}
+
+func apiPostRemove(ctx *golf.Context, isPage bool) {
+	p := getApiPost(ctx, isPage, true)
+	if p == nil {
+		return
+	}
+	if err := model.DeletePostById(p.Id); err != nil {
+		panic(err)
+	}
+	apiSuccess(ctx, nil)
+}
+
+func APITagListHandler(ctx *golf.Context) {
+	errors := make(map[string]string)
+	page, size := apiPage(ctx, errors)
+	if len(errors) > 0 {
+		apiValidationError(ctx, errors)
+		return
+	}
+	tags, err := model.GetAllTags()
+	if err != nil {
+		panic(err)
+	}
+	// Tags are few, they are paged in memory
+	pager := utils.NewPager(page, size, int64(len(tags)))
+	data := make([]map[string]interface{}, 0)
+	for i := pager.Begin - 1; i >= 0 && i < pager.End; i++ {
+		data = append(data, map[string]interface{}{
+			"id":   tags[i].Id,
+			"name": tags[i].Name,
+			"slug": tags[i].Slug,
+			"url":  tags[i].Url(),
+		})
+	}
+	apiList(ctx, data, pager)
+}
+
+func apiCommentData(c *model.Comment) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         c.Id,
+		"post_id":    c.PostId,
+		"parent":     c.Parent,
+		"author":     c.Author,
+		"email":      c.Email,
+		"website":    c.Website,
+		"avatar":     c.Avatar,
+		"content":    c.Content,
+		"approved":   c.Approved,
+		"status":     c.Status,
+		"user_agent": c.UserAgent,
+		"created_at": c.CreatedAt,
+	}
+}
+
+// APICommentListHandler lists the comments, they can be filtered by post id
+// and by moderation status.
+func APICommentListHandler(ctx *golf.Context) {
+	errors := make(map[string]string)
+	page, size := apiPage(ctx, errors)
+	filter := new(model.CommentFilter)
+	if v := ctx.Request.FormValue("post"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errors["post"] = "must be a post id"
+		}
+		filter.PostId = id
+	}
+	if status := ctx.Request.FormValue("status"); status == "" || model.IsCommentStatus(status) {
+		filter.Status = status
+	} else {
+		errors["status"] = "must be " + strings.Join(model.CommentStatuses, ", ")
+	}
+	if len(errors) > 0 {
+		apiValidationError(ctx, errors)
+		return
+	}
+	comments, pager, err := model.GetFilteredCommentList(filter, page, size)
+	if err != nil {
+		panic(err)
+	}
+	data := make([]map[string]interface{}, len(comments))
+	for i, c := range comments {
+		data[i] = apiCommentData(c)
+	}
+	apiList(ctx, data, pager)
+}
+
+func getApiComment(ctx *golf.Context) *model.Comment {
+	c, err := model.GetCommentById(apiId(ctx))
+	if err != nil {
+		apiError(ctx, 404, "Not found")
+		return nil
+	}
+	return c
+}
+
+func APICommentHandler(ctx *golf.Context) {
+	if c := getApiComment(ctx); c != nil {
+		apiSuccess(ctx, apiCommentData(c))
+	}
+}
+
+// APICommentUpdateHandler moderates a comment with a body like
+// {"status": "spam"}, or approves and unapproves it with {"approved": true}.
+func APICommentUpdateHandler(ctx *golf.Context) {
+	c := getApiComment(ctx)
+	if c == nil {
+		return
+	}
+	var input struct {
+		Approved *bool  `json:"approved"`
+		Status   string `json:"status"`
+	}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&input); err != nil {
+		apiError(ctx, 400, "Invalid JSON body")
+		return
+	}
+	status := input.Status
+	switch {
+	case status != "":
+		if !model.IsCommentStatus(status) {
+			apiValidationError(ctx, map[string]string{"status": "must be " + strings.Join(model.CommentStatuses, ", ")})
+			return
+		}
+	case input.Approved == nil:
+		apiValidationError(ctx, map[string]string{"approved": "is required"})
+		return
+	case *input.Approved:
+		status = model.CommentApproved
+	default:
+		status = model.CommentPending
+	}
+	if err := moderateComment(c, status); err != nil {
+		panic(err)
+	}
+	apiSuccess(ctx, apiCommentData(c))
+}
+
+func APICommentRemoveHandler(ctx *golf.Context) {
+	c := getApiComment(ctx)
+	if c == nil {
+		return
+	}
+	if err := model.DeleteComment(c.Id); err != nil {
+		panic(err)
+	}
+	apiSuccess(ctx, nil)
+}
+
+func apiSettingsData() map[string]string {
+	data := make(map[string]string)
+	for _, s := range model.GetAllSettings() {
+		data[s.Key] = s.Value
+	}
+	return data
+}
+
+func APISettingListHandler(ctx *golf.Context) {
+	apiSuccess(ctx, apiSettingsData())
+}
+
+// APISettingUpdateHandler sets the settings given as a JSON object of keys
+// and values. The type of existing settings is kept.
+func APISettingUpdateHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	var input map[string]string
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&input); err != nil {
+		apiError(ctx, 400, "Invalid JSON body, an object of strings is expected")
+		return
+	}
+	errors := make(map[string]string)
+	for k := range input {
+		if strings.TrimSpace(k) == "" {
+			errors[k] = "key can not be empty"
+		}
+	}
+	if len(errors) > 0 {
+		apiValidationError(ctx, errors)
+		return
+	}
+	for k, v := range input {
+		s := model.NewSetting(k, v, "")
+		if old, err := model.GetSetting(k); err == nil {
+			s.Type = old.Type
+		}
+		s.CreatedBy = u.Id
+		if err := s.Save(); err != nil {
+			panic(err)
+		}
+	}
+	apiSuccess(ctx, apiSettingsData())
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	"github.com/dinever/golf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func apiContext(key, method, path, body string) *golf.Context {
+	w := httptest.NewRecorder()
+	app := InitTestApp()
+	req := makeTestHTTPRequest(strings.NewReader(body), method, path)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return golf.NewContext(req, w, app)
+}
+
+// serveAPI handles the request and decodes the JSON envelope of the response.
+func serveAPI(ctx *golf.Context) (int, map[string]interface{}) {
+	ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+	rec := ctx.Response.(*httptest.ResponseRecorder)
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body
+}
+
+func TestAPIHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		user := model.NewUser(email, name)
+		So(user.Create(password), ShouldBeNil)
+		key := model.NewApiKey(user, "Test")
+		So(key.Save(), ShouldBeNil)
+
+		Convey("Request without API key", func() {
+			code, body := serveAPI(apiContext("", "GET", "/api/v1/posts/", ""))
+			So(code, ShouldEqual, 401)
+			So(body["status"], ShouldEqual, "error")
+
+			code, _ = serveAPI(apiContext("invalid", "GET", "/api/v1/posts/", ""))
+			So(code, ShouldEqual, 401)
+		})
+
+		Convey("Create a post", func() {
+			code, body := serveAPI(apiContext(key.Key, "POST", "/api/v1/posts/",
+				`{"title": "Hello API", "markdown": "**Hi**", "tags": ["Go", "API"], "status": "published"}`))
+			So(code, ShouldEqual, 201)
+			post := body["data"].(map[string]interface{})
+			So(post["slug"], ShouldEqual, "hello-api")
+			So(post["status"], ShouldEqual, "published")
+			So(post["html"], ShouldContainSubstring, "<strong>Hi</strong>")
+			So(post["tags"], ShouldHaveLength, 2)
+
+			Convey("List posts", func() {
+				serveAPI(apiContext(key.Key, "POST", "/api/v1/posts/", `{"title": "Draft", "tags": ["Go"]}`))
+
+				code, body := serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/?size=1", ""))
+				So(code, ShouldEqual, 200)
+				So(body["data"], ShouldHaveLength, 1)
+				pagination := body["meta"].(map[string]interface{})["pagination"].(map[string]interface{})
+				So(pagination["total"], ShouldEqual, 2)
+				So(pagination["pages"], ShouldEqual, 2)
+				So(pagination["next"], ShouldBeTrue)
+
+				_, body = serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/?status=draft", ""))
+				So(body["data"], ShouldHaveLength, 1)
+				_, body = serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/?tag=api", ""))
+				So(body["data"], ShouldHaveLength, 1)
+				_, body = serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/?author="+user.Slug, ""))
+				So(body["data"], ShouldHaveLength, 2)
+				_, body = serveAPI(apiContext(key.Key, "GET", "/api/v1/pages/", ""))
+				So(body["data"], ShouldHaveLength, 0)
+
+				code, body = serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/?status=unknown&size=1000", ""))
+				So(code, ShouldEqual, 422)
+				errors := body["errors"].(map[string]interface{})
+				So(errors, ShouldContainKey, "status")
+				So(errors, ShouldContainKey, "size")
+			})
+
+			Convey("Update the post", func() {
+				path := "/api/v1/posts/" + formatId(post["id"]) + "/"
+				code, body := serveAPI(apiContext(key.Key, "PUT", path, `{"title": "Hello again", "status": "draft"}`))
+				So(code, ShouldEqual, 200)
+				updated := body["data"].(map[string]interface{})
+				So(updated["title"], ShouldEqual, "Hello again")
+				So(updated["status"], ShouldEqual, "draft")
+				// Fields which are not given are kept
+				So(updated["markdown"], ShouldEqual, "**Hi**")
+
+				code, _ = serveAPI(apiContext(key.Key, "GET", "/api/v1/pages/"+formatId(post["id"])+"/", ""))
+				So(code, ShouldEqual, 404)
+			})
+
+			Convey("Keep a legacy slug", func() {
+				legacy := model.NewPost()
+				legacy.Title = "Legacy"
+				legacy.Slug = "legacy.html"
+				legacy.Author = user
+				So(legacy.Save(), ShouldBeNil)
+				path := "/api/v1/posts/" + strconv.FormatInt(legacy.Id, 10) + "/"
+				code, body := serveAPI(apiContext(key.Key, "PUT", path, `{"title": "Legacy again", "slug": "legacy.html"}`))
+				So(code, ShouldEqual, 200)
+				So(body["data"].(map[string]interface{})["slug"], ShouldEqual, "legacy.html")
+				code, _ = serveAPI(apiContext(key.Key, "PUT", path, `{"slug": "legacy.htm"}`))
+				So(code, ShouldEqual, 422)
+			})
+
+			Convey("Delete the post", func() {
+				path := "/api/v1/posts/" + formatId(post["id"]) + "/"
+				code, _ := serveAPI(apiContext(key.Key, "DELETE", path, ""))
+				So(code, ShouldEqual, 200)
+				code, _ = serveAPI(apiContext(key.Key, "GET", path, ""))
+				So(code, ShouldEqual, 404)
+			})
+		})
+
+		Convey("Create an invalid post", func() {
+			code, body := serveAPI(apiContext(key.Key, "POST", "/api/v1/posts/", `{"title": " ", "slug": "a/b", "status": "hidden"}`))
+			So(code, ShouldEqual, 422)
+			errors := body["errors"].(map[string]interface{})
+			So(errors, ShouldContainKey, "title")
+			So(errors, ShouldContainKey, "slug")
+			So(errors, ShouldContainKey, "status")
+
+			code, _ = serveAPI(apiContext(key.Key, "POST", "/api/v1/posts/", `not json`))
+			So(code, ShouldEqual, 400)
+		})
+
+		Convey("Update settings", func() {
+			code, body := serveAPI(apiContext(key.Key, "PUT", "/api/v1/settings/", `{"title": "Scripted Blog"}`))
+			So(code, ShouldEqual, 200)
+			So(body["data"].(map[string]interface{})["title"], ShouldEqual, "Scripted Blog")
+			So(model.GetSettingValue("title"), ShouldEqual, "Scripted Blog")
+		})
+
+		Convey("The signing keys are not settings", func() {
+			token := model.UnsubscribeToken(email)
+			now := time.Now()
+			form := commentToken(1, now)
+			code, _ := serveAPI(apiContext(key.Key, "PUT", "/api/v1/settings/", `{"mail_secret": "forged", "site_secret": "forged"}`))
+			So(code, ShouldEqual, 200)
+			So(model.CheckUnsubscribeToken(email, token), ShouldBeTrue)
+			So(commentToken(1, now), ShouldEqual, form)
+		})
+
+		Convey("Refuse the keys of users without the required two-factor authentication", func() {
+			So(model.NewSetting("require_2fa", "true", "").Save(), ShouldBeNil)
+			code, _ := serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/", ""))
+			So(code, ShouldEqual, 403)
+			ctx := authenticatedContext(url.Values{"name": {"Other"}}, "POST", "/admin/profile/keys/")
+			code, _ = serveAPI(ctx)
+			So(code, ShouldEqual, 403)
+
+			secret := utils.NewTOTPSecret()
+			totp, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+			_, err := user.EnableTwoFactor(secret, totp)
+			So(err, ShouldBeNil)
+			code, _ = serveAPI(apiContext(key.Key, "GET", "/api/v1/posts/", ""))
+			So(code, ShouldEqual, 200)
+		})
+
+		Convey("Authors can not manage settings", func() {
+			author := model.NewUser("author@example.com", "Author")
+			So(author.Create(password), ShouldBeNil)
+			authorKey := model.NewApiKey(author, "Test")
+			So(authorKey.Save(), ShouldBeNil)
+
+			code, _ := serveAPI(apiContext(authorKey.Key, "GET", "/api/v1/settings/", ""))
+			So(code, ShouldEqual, 403)
+			code, _ = serveAPI(apiContext(authorKey.Key, "GET", "/api/v1/comments/", ""))
+			So(code, ShouldEqual, 403)
+			code, _ = serveAPI(apiContext(authorKey.Key, "GET", "/api/v1/tags/", ""))
+			So(code, ShouldEqual, 200)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
+
+func formatId(id interface{}) string {
+	return strconv.FormatInt(int64(id.(float64)), 10)
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"fmt"
 	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
 	"github.com/dinever/golf"
 	"regexp"
 	"strconv"
@@ -37,116 +39,299 @@ func AuthSignUpHandler(ctx *golf.Context) {
 	}
 
 	email := ctx.Request.FormValue("email")
-	if !rxEmail.MatchString(email) {
+	name := ctx.Request.FormValue("name")
+	password := ctx.Request.FormValue("password")
+	if msg := validateSignUpForm(email, name, password, ctx.Request.FormValue("re-password")); msg != "" {
 		ctx.SendStatus(400)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    "Invalid email address.",
+			"msg":    msg,
 		})
 		return
 	}
-	name := ctx.Request.FormValue("name")
+	err = model.NewUser(email, name).Create(password)
+	if err != nil {
+		ctx.Abort(500)
+		return
+	}
+	user, err := model.GetUserByEmail(email)
+	if err != nil {
+		ctx.Abort(500)
+		return
+	}
+	if err = logIn(ctx, user); err != nil {
+		ctx.Abort(500)
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+// validateSignUpForm returns the error message of the first invalid field of
+// the sign up form, or an empty string if the form is valid.
+func validateSignUpForm(email, name, password, rePassword string) string {
+	if !rxEmail.MatchString(email) {
+		return "Invalid email address."
+	}
 	if len(name) < 3 {
-		ctx.SendStatus(400)
+		return "Name is too short."
+	}
+	return validatePassword(password, rePassword)
+}
+
+// validatePassword returns an error message if the password is invalid or
+// was not repeated correctly.
+func validatePassword(password, rePassword string) string {
+	if len(password) < 5 {
+		return "Password is too short."
+	}
+	if len(password) > 20 {
+		return "Password is too long."
+	}
+	if password != rePassword {
+		return "Password does not match."
+	}
+	return ""
+}
+
+// logIn creates a token for the user and sets the authentication cookies.
+func logIn(ctx *golf.Context, user *model.User) error {
+	var (
+		exp int
+		t   *model.Token
+	)
+	if ctx.Request.FormValue("remember-me") == "on" {
+		exp = 3600 * 24 * 3
+		t = model.NewToken(user, ctx, int64(exp))
+	} else {
+		exp = 0
+		t = model.NewToken(user, ctx, 3600)
+	}
+	if err := t.Save(); err != nil {
+		return err
+	}
+	ctx.SetCookie("token-user", strconv.Itoa(int(t.UserId)), exp)
+	ctx.SetCookie("token-value", t.Value, exp)
+	return nil
+}
+
+func InvitationPageHandler(ctx *golf.Context) {
+	invitation, err := model.GetInvitationByToken(ctx.Param("token"))
+	if err != nil {
+		ctx.Abort(404)
+		return
+	}
+	ctx.Loader("admin").Render("signup.html", map[string]interface{}{
+		"Invitation": invitation,
+	})
+}
+
+func InvitationAcceptHandler(ctx *golf.Context) {
+	invitation, err := model.GetInvitationByToken(ctx.Param("token"))
+	if err != nil {
+		ctx.SendStatus(404)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    "Name is too short.",
+			"msg":    "The invitation is invalid or has expired.",
 		})
 		return
 	}
+	email := invitation.Email
+	name := ctx.Request.FormValue("name")
 	password := ctx.Request.FormValue("password")
-	if len(password) < 5 {
+	msg := validateSignUpForm(email, name, password, ctx.Request.FormValue("re-password"))
+	if msg == "" && model.UserEmailExist(email) {
+		msg = "A user with this email address already exists."
+	}
+	if msg != "" {
 		ctx.SendStatus(400)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    "Password is too short.",
+			"msg":    msg,
 		})
 		return
 	}
-	if len(password) > 20 {
+	user := model.NewUser(email, name)
+	if err = invitation.AcceptAs(user, password); err != nil {
 		ctx.SendStatus(400)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    "Password is too long.",
+			"msg":    err.Error(),
 		})
 		return
 	}
-	rePassword := ctx.Request.FormValue("re-password")
-	if password != rePassword {
+	if err = logIn(ctx, user); err != nil {
+		ctx.Abort(500)
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+func AuthLoginHandler(ctx *golf.Context) {
+	email := ctx.Request.FormValue("email")
+	password := ctx.Request.FormValue("password")
+	user, err := model.GetUserByEmail(email)
+	if user == nil || err != nil {
+		ctx.JSON(map[string]interface{}{"status": "error"})
+		return
+	}
+	if !user.CheckPassword(password) {
+		ctx.JSON(map[string]interface{}{"status": "error"})
+		return
+	}
+	if !user.IsActive() {
+		ctx.JSON(map[string]interface{}{"status": "error", "message": "This account has been suspended."})
+		return
+	}
+	if user.TwoFactorEnabled() {
+		// The token is only issued once the code is checked
+		c := model.NewLoginChallenge(user)
+		if err = c.Save(); err != nil {
+			panic(err)
+		}
+		ctx.JSON(map[string]interface{}{"status": "2fa", "challenge": c.Token})
+		return
+	}
+	if err = logIn(ctx, user); err != nil {
+		ctx.JSON(map[string]interface{}{"status": "error", "message": "Can not create token."})
+		panic(err)
+	}
+	ctx.JSON(map[string]interface{}{"status": "success"})
+}
+
+func ForgotPasswordPageHandler(ctx *golf.Context) {
+	ctx.Loader("admin").Render("forgot.html", make(map[string]interface{}))
+}
+
+// ForgotPasswordHandler mails a password reset link. It succeeds even if
+// there is no such user, so that it can not be used to find out who has an
+// account.
+func ForgotPasswordHandler(ctx *golf.Context) {
+	email := ctx.Request.FormValue("email")
+	if !rxEmail.MatchString(email) {
 		ctx.SendStatus(400)
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
-			"msg":    "Password does not match.",
+			"msg":    "Invalid email address.",
 		})
 		return
 	}
-	err = model.NewUser(email, name).Create(password)
+	if _, err := mailUrl("/"); err != nil {
+		ctx.SendStatus(503)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	if user, err := model.GetUserByEmail(email); err == nil && user.IsActive() {
+		r := model.NewPasswordReset(user)
+		if err := r.Save(); err != nil {
+			panic(err)
+		}
+		link, _ := mailUrl(r.Url())
+		sendMail(utils.NewMail(user.Email, "Reset your password", fmt.Sprintf(passwordResetMail,
+			user.Name, model.GetSettingValue("title"), link, model.PasswordResetExpiration)))
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+const passwordResetMail = `Hi %s,
+
+Someone asked to reset the password of your account on %s. To choose a new
+password, open the following link:
+
+%s
+
+The link can only be used once and expires in %v. If you did not ask for a
+new password, you can ignore this mail.
+`
+
+func ResetPasswordPageHandler(ctx *golf.Context) {
+	r, err := model.GetPasswordResetByToken(ctx.Param("token"))
 	if err != nil {
-		ctx.Abort(500)
+		ctx.Abort(404)
 		return
 	}
-	user, err := model.GetUserByEmail(email)
+	ctx.Loader("admin").Render("reset.html", map[string]interface{}{
+		"Reset": r,
+	})
+}
+
+func ResetPasswordHandler(ctx *golf.Context) {
+	r, err := model.GetPasswordResetByToken(ctx.Param("token"))
 	if err != nil {
-		ctx.Abort(500)
+		ctx.SendStatus(404)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "The link is invalid or has expired.",
+		})
 		return
 	}
-	rememberMe := ctx.Request.FormValue("remember-me")
-	var (
-		exp int
-		s   *model.Token
-	)
-	if rememberMe == "on" {
-		exp = 3600 * 24 * 3
-		s = model.NewToken(user, ctx, int64(exp))
-	} else {
-		exp = 0
-		s = model.NewToken(user, ctx, 3600)
+	password := ctx.Request.FormValue("password")
+	if msg := validatePassword(password, ctx.Request.FormValue("re-password")); msg != "" {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    msg,
+		})
+		return
 	}
-	if err = s.Save(); err != nil {
-		ctx.Abort(500)
+	if err := r.Use(password); err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
 		return
 	}
-	ctx.SetCookie("token-user", strconv.Itoa(int(s.UserId)), exp)
-	ctx.SetCookie("token-value", s.Value, exp)
 	ctx.JSON(map[string]interface{}{
 		"status": "success",
 	})
 }
 
-func AuthLoginHandler(ctx *golf.Context) {
-	email := ctx.Request.FormValue("email")
-	password := ctx.Request.FormValue("password")
-	rememberMe := ctx.Request.FormValue("remember-me")
-	user, err := model.GetUserByEmail(email)
-	if user == nil || err != nil {
-		ctx.JSON(map[string]interface{}{"status": "error"})
+// TwoFactorLoginHandler is the second step of the login of users with
+// two-factor authentication enabled.
+func TwoFactorLoginHandler(ctx *golf.Context) {
+	c, err := model.GetLoginChallengeByToken(ctx.Request.FormValue("challenge"))
+	if err != nil {
+		ctx.SendStatus(403)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Login has expired, please log in again.",
+		})
 		return
 	}
-	if !user.CheckPassword(password) {
-		ctx.JSON(map[string]interface{}{"status": "error"})
+	user, err := c.Verify(ctx.Request.FormValue("code"))
+	if err != nil {
+		ctx.SendStatus(403)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
 		return
 	}
-	var (
-		exp int
-		t   *model.Token
-	)
-	if rememberMe == "on" {
-		exp = 3600 * 24 * 3
-		t = model.NewToken(user, ctx, int64(exp))
-	} else {
-		exp = 0
-		t = model.NewToken(user, ctx, 3600)
+	if !user.IsActive() {
+		forbidden(ctx)
+		return
 	}
-	if err = t.Save(); err != nil {
-		ctx.JSON(map[string]interface{}{"status": "error", "message": "Can not create token."})
+	if err = logIn(ctx, user); err != nil {
 		panic(err)
 	}
-	ctx.SetCookie("token-user", strconv.Itoa(int(t.UserId)), exp)
-	ctx.SetCookie("token-value", t.Value, exp)
 	ctx.JSON(map[string]interface{}{"status": "success"})
 }
 
 func AuthLogoutHandler(ctx *golf.Context) {
+	if tokenStr, err := ctx.Request.Cookie("token-value"); err == nil {
+		if t, err := model.GetTokenByValue(tokenStr.Value); err == nil {
+			model.DeleteTokenById(t.Id, t.UserId)
+		}
+	}
 	ctx.SetCookie("token-user", "", -3600)
 	ctx.SetCookie("token-value", "", -3600)
 	ctx.Redirect("/login/")
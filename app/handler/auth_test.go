@@ -2,11 +2,14 @@ package handler
 
 import (
 	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
 	"github.com/dinever/golf"
 	. "github.com/smartystreets/goconvey/convey"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
 	"testing"
 )
 
@@ -186,3 +189,97 @@ func TestUserWithoutAuthentication(t *testing.T) {
 		})
 	})
 }
+
+func TestPasswordReset(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", false)
+		So(model.NewSetting("site_url", "http://example.com", "blog").Save(), ShouldBeNil)
+		user := model.NewUser(email, name)
+		user.Create(password)
+		mailer := &stubMailer{sent: make(chan *utils.Mail, 10)}
+		Mailer = mailer
+
+		Convey("Ask for a password reset", func() {
+			form := url.Values{}
+			form.Add("email", "nobody@example.com")
+			ctx := mockContext(form, "POST", "/forgot/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+
+			Convey("Unknown emails should not be disclosed", func() {
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			})
+		})
+
+		Convey("Link to the Site URL and not to the host of the request", func() {
+			form := url.Values{}
+			form.Add("email", email)
+			ctx := mockContext(form, "POST", "/forgot/")
+			ctx.Request.Host = "evil.example"
+			ctx.Request.Header.Set("X-Forwarded-Proto", "https")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			m := mailer.next()
+			So(m, ShouldNotBeNil)
+			So(m.Body, ShouldContainSubstring, "http://example.com/reset/")
+			So(m.Body, ShouldNotContainSubstring, "evil.example")
+
+			form = url.Values{}
+			form.Add("email", "editor@example.com")
+			form.Add("role", strconv.Itoa(model.RoleEditor))
+			ctx = authenticatedContext(form, "POST", "/admin/users/")
+			ctx.Request.Host = "evil.example"
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			m = mailer.next()
+			So(m, ShouldNotBeNil)
+			So(m.Body, ShouldContainSubstring, "http://example.com/invite/")
+			So(m.Body, ShouldNotContainSubstring, "evil.example")
+		})
+
+		Convey("Send no links while the Site URL is not set", func() {
+			So(model.NewSetting("site_url", "", "blog").Save(), ShouldBeNil)
+			form := url.Values{}
+			form.Add("email", email)
+			ctx := mockContext(form, "POST", "/forgot/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 503)
+
+			form = url.Values{}
+			form.Add("email", "editor@example.com")
+			form.Add("role", strconv.Itoa(model.RoleEditor))
+			ctx = authenticatedContext(form, "POST", "/admin/users/")
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 400)
+			So(mailer.next(), ShouldBeNil)
+			invitations, _ := model.GetPendingInvitations()
+			So(invitations, ShouldBeEmpty)
+		})
+
+		Convey("Reset the password", func() {
+			r := model.NewPasswordReset(user)
+			So(r.Save(), ShouldBeNil)
+
+			form := url.Values{}
+			form.Add("password", "newpassword")
+			form.Add("re-password", "newpassword")
+			ctx := mockContext(form, "POST", r.Url())
+			ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+
+			Convey("The password should be changed", func() {
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+				So(user.CheckPassword("newpassword"), ShouldBeTrue)
+			})
+
+			Convey("The link can not be used twice", func() {
+				ctx := mockContext(form, "POST", r.Url())
+				ctx.App.ServeHTTP(ctx.Response, ctx.Request)
+				So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 404)
+			})
+		})
+
+		Reset(func() {
+			Mailer = nil
+			os.Remove("test.db")
+		})
+	})
+}
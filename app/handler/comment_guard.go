@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+const (
+	// commentRateWindow is how long the comments of an IP address or of an
+	// email address count against their limit.
+	commentRateWindow = 10 * time.Minute
+	commentRateIp     = 10
+	commentRateEmail  = 5
+
+	// The comment form must be sent between commentMinDelay and
+	// commentMaxDelay after the page was rendered, bots are faster.
+	commentMinDelay = 3 * time.Second
+	commentMaxDelay = 24 * time.Hour
+
+	// commentHoneypot is a field of the comment form hidden from the
+	// visitors, only bots fill it in.
+	commentHoneypot = "homepage"
+
+	// commentMaxLength is the length in bytes of the longest comment taken.
+	commentMaxLength = 10000
+)
+
+// commentToken signs the post and the time a comment form is rendered, so
+// the form can not be sent without loading the page first, nor too fast.
+func commentToken(postId int64, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return ts + "-" + model.CommentFormToken(postId, ts)
+}
+
+func getCommentToken(p *model.Post) string {
+	return commentToken(p.Id, time.Now())
+}
+
+// checkCommentForm returns why a comment form sent for post is refused, or
+// an empty string if it is not.
+func checkCommentForm(ctx *golf.Context, post *model.Post) string {
+	if ctx.Request.FormValue(commentHoneypot) != "" {
+		return "Can not comment on this post."
+	}
+	parts := strings.SplitN(ctx.Request.FormValue("token"), "-", 2)
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if len(parts) != 2 || err != nil || !model.CheckCommentFormToken(post.Id, parts[0], parts[1]) {
+		return "Please reload the page and try again."
+	}
+	elapsed := time.Since(time.Unix(ts, 0))
+	if elapsed < commentMinDelay {
+		return "You are commenting too fast, please take your time."
+	}
+	if elapsed > commentMaxDelay {
+		return "The page is too old, please reload it and try again."
+	}
+	return ""
+}
+
+// checkComment returns why a valid comment is refused, or an empty string
+// if it is not: too long, too many comments from its addresses, the same
+// content on the post, or an entry of the blocklist.
+func checkComment(c *model.Comment) string {
+	if len(c.Content) > commentMaxLength {
+		return "The comment is too long."
+	}
+	byIp, byEmail, err := model.CountRecentComments(c.Ip, c.Email, time.Now().Add(-commentRateWindow))
+	if err != nil {
+		log.Printf("[Error]: Can not count the recent comments of %s: %v", c.Email, err)
+	} else if byIp >= commentRateIp || byEmail >= commentRateEmail {
+		return "You are commenting too often, please wait a few minutes."
+	}
+	if duplicate, err := model.IsDuplicateComment(c); err != nil {
+		log.Printf("[Error]: Can not check comment for duplicates: %v", err)
+	} else if duplicate {
+		return "This comment was already posted."
+	}
+	if model.IsCommentBlocked(c) {
+		return "Can not comment on this post."
+	}
+	return ""
+}
+
+// clientIp is the IP address of the client of a request, without the port.
+// Behind the proxies listed in the trusted_proxies setting, it is the first
+// address of X-Forwarded-For, from the right, which is not one of them:
+// the addresses on its left are written by the client and can not be
+// trusted.
+func clientIp(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	trusted := trustedProxies()
+	if len(trusted) == 0 || !isTrustedProxy(trusted, ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(trusted, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxies parses the trusted_proxies setting, one IP address or
+// network per line.
+func trustedProxies() []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+	for _, line := range strings.Split(model.GetSettingValue("trusted_proxies"), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		} else {
+			log.Printf("[Error]: Invalid trusted proxy %q", line)
+		}
+	}
+	return networks
+}
+
+func isTrustedProxy(trusted []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, network := range trusted {
+		if parsed != nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentGuard(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		form := url.Values{}
+		form.Add("title", "Hello World")
+		form.Add("slug", "hello-world")
+		form.Add("content", "Sample content")
+		form.Add("comment", "on")
+		form.Add("status", "on")
+		ctx := authenticatedContext(form, "POST", "/admin/editor/post/")
+		app := ctx.App
+		app.ServeHTTP(ctx.Response, ctx.Request)
+
+		comment := func(n int) url.Values {
+			form := url.Values{}
+			form.Add("author", "Ken Thompson")
+			form.Add("email", "ken@gmail.com")
+			form.Add("comment", "Nice post #"+strconv.Itoa(n))
+			form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+			return form
+		}
+		post := func(form url.Values, path string) string {
+			ctx := mockContext(form, "POST", path)
+			ctx.Request.RemoteAddr = "192.0.2.1:4321"
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			return ctx.Response.(*httptest.ResponseRecorder).Body.String()
+		}
+
+		Convey("Accept a comment", func() {
+			So(post(comment(1), "/comment/1/"), ShouldContainSubstring, `"res":true`)
+			c, err := model.GetCommentById(1)
+			So(err, ShouldBeNil)
+			So(c.Ip, ShouldEqual, "192.0.2.1")
+		})
+
+		Convey("Refuse a comment on a missing post", func() {
+			So(post(comment(1), "/comment/99/"), ShouldContainSubstring, "Post not found.")
+		})
+
+		Convey("Refuse the bots", func() {
+			form := comment(1)
+			form.Set(commentHoneypot, "http://spam.example.com/")
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "Can not comment on this post.")
+
+			form = comment(1)
+			form.Del("token")
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "Please reload the page")
+			form.Set("token", commentToken(2, time.Now().Add(-time.Minute)))
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "Please reload the page")
+			form.Set("token", commentToken(1, time.Now()))
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "too fast")
+			form.Set("token", commentToken(1, time.Now().Add(-48*time.Hour)))
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "too old")
+			_, err := model.GetCommentById(1)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Refuse a comment which is too long", func() {
+			form := comment(1)
+			form.Set("comment", strings.Repeat("a", commentMaxLength+1))
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "too long")
+			_, err := model.GetCommentById(1)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Refuse a duplicate comment", func() {
+			So(post(comment(1), "/comment/1/"), ShouldContainSubstring, `"res":true`)
+			form := comment(1)
+			form.Set("email", "rob@gmail.com")
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "already posted")
+		})
+
+		Convey("Limit the comments of an email and of an IP address", func() {
+			for i := 0; i < commentRateEmail; i++ {
+				So(post(comment(i), "/comment/1/"), ShouldContainSubstring, `"res":true`)
+			}
+			So(post(comment(commentRateEmail), "/comment/1/"), ShouldContainSubstring, "too often")
+
+			for i := commentRateEmail; i < commentRateIp; i++ {
+				form := comment(i)
+				form.Set("email", "reader"+strconv.Itoa(i)+"@gmail.com")
+				So(post(form, "/comment/1/"), ShouldContainSubstring, `"res":true`)
+			}
+			form := comment(commentRateIp)
+			form.Set("email", "rob@gmail.com")
+			So(post(form, "/comment/1/"), ShouldContainSubstring, "too often")
+		})
+
+		Convey("Refuse the comments of the blocklist", func() {
+			So(model.NewSetting("comment_blocklist", "192.0.2.0/24", "").Save(), ShouldBeNil)
+			So(post(comment(1), "/comment/1/"), ShouldContainSubstring, "Can not comment on this post.")
+		})
+
+		Convey("Read the address of the client behind a trusted proxy", func() {
+			r := makeTestHTTPRequest(nil, "POST", "/comment/1/")
+			r.RemoteAddr = "10.0.0.2:4321"
+			r.Header.Add("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+			r.Header.Add("X-Forwarded-For", "10.0.0.1")
+			// X-Forwarded-For is ignored unless the proxy is trusted
+			So(clientIp(r), ShouldEqual, "10.0.0.2")
+
+			So(model.NewSetting("trusted_proxies", "10.0.0.0/8\n192.0.2.1", "").Save(), ShouldBeNil)
+			So(clientIp(r), ShouldEqual, "198.51.100.7")
+			r.Header.Set("X-Forwarded-For", "10.0.0.1")
+			So(clientIp(r), ShouldEqual, "10.0.0.1")
+			r.Header.Set("X-Forwarded-For", "198.51.100.7, not-an-ip, 10.0.0.1")
+			So(clientIp(r), ShouldEqual, "10.0.0.1")
+			r.RemoteAddr = "203.0.113.1:4321"
+			r.Header.Set("X-Forwarded-For", "198.51.100.7")
+			So(clientIp(r), ShouldEqual, "203.0.113.1")
+
+			// The commenters behind the proxy have their own limits
+			for i := 0; i < commentRateIp; i++ {
+				form := comment(i)
+				form.Set("email", "reader"+strconv.Itoa(i)+"@gmail.com")
+				ctx := mockContext(form, "POST", "/comment/1/")
+				ctx.Request.RemoteAddr = "192.0.2.1:4321"
+				ctx.Request.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+				app.ServeHTTP(ctx.Response, ctx.Request)
+				So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `"res":true`)
+			}
+			So(post(comment(commentRateIp), "/comment/1/"), ShouldContainSubstring, `"res":true`)
+			c, _ := model.GetCommentById(1)
+			So(c.Ip, ShouldEqual, "198.51.100.0")
+		})
+
+		Convey("Refuse the comments on closed posts", func() {
+			p, _ := model.GetPostById(1)
+			p.AllowComment = false
+			So(p.Save(), ShouldBeNil)
+			So(post(comment(1), "/comment/1/"), ShouldContainSubstring, "Comments are closed.")
+
+			p.AllowComment = true
+			So(p.Save(), ShouldBeNil)
+			published := time.Now().Add(-72 * time.Hour)
+			p.PublishedAt = &published
+			So(p.Save(), ShouldBeNil)
+			So(model.NewSetting("comment_close_days", "2", "").Save(), ShouldBeNil)
+			So(post(comment(1), "/comment/1/"), ShouldContainSubstring, "Comments are closed.")
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
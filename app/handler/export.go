@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// Backups takes the backups of the site, it is set up by the application.
+var Backups = &model.Backups{Dir: "backup", UploadDir: "upload", Keep: 7}
+
+// ExportHandler downloads the content of the site as a JSON document, or
+// as an export of Ghost with ?format=ghost. The password hashes of the
+// users are included with ?passwords=1.
+func ExportHandler(ctx *golf.Context) {
+	var export interface{}
+	var err error
+	name := "dingo-"
+	if ctx.Request.FormValue("format") == "ghost" {
+		export, err = model.ExportGhost()
+		name += "ghost-"
+	} else {
+		export, err = model.ExportSite(ctx.Request.FormValue("passwords") == "1")
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(export, "", "  ")
+	}
+	if err != nil {
+		model.NewMessage("backup", "[0]"+err.Error()).Save()
+		ctx.SendStatus(500)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	name += time.Now().Format("20060102-150405") + ".json"
+	model.NewMessage("backup", "[1]"+name).Save()
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Content-Disposition", `attachment; filename="`+name+`"`)
+	ctx.Send(data)
+}
+
+// ImportHandler imports an uploaded export of Dingo or of Ghost into the
+// site.
+func ImportHandler(ctx *golf.Context) {
+	ctx.Request.ParseMultipartForm(32 << 20)
+	f, _, err := ctx.Request.FormFile("file")
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	var e *model.Export
+	if err == nil {
+		e, err = model.ReadExport(data)
+	}
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	result, err := model.ImportSite(e)
+	if err != nil {
+		ctx.SendStatus(500)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+			"result": result,
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"result": result,
+	})
+}
+
+// MarkdownImportHandler imports the Markdown files with front matter of an
+// uploaded zip archive as posts of the current user.
+func MarkdownImportHandler(ctx *golf.Context) {
+	userObj, _ := ctx.Session.Get("user")
+	u := userObj.(*model.User)
+	ctx.Request.ParseMultipartForm(32 << 20)
+	f, header, err := ctx.Request.FormFile("file")
+	if err != nil {
+		ctx.SendStatus(400)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	defer f.Close()
+	m := &model.MarkdownImport{Author: u}
+	report, err := m.ImportZip(f, header.Size)
+	if err != nil {
+		status := 500
+		if report == nil {
+			status = 400
+		}
+		ctx.SendStatus(status)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+			"result": report,
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"result": report,
+	})
+}
+
+// BackupHandler takes a backup of the site on demand.
+func BackupHandler(ctx *golf.Context) {
+	path, err := Backups.Run()
+	if err != nil {
+		ctx.SendStatus(500)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    err.Error(),
+		})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+		"file":   filepath.Base(path),
+	})
+}
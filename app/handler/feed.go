@@ -0,0 +1,368 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// feedSize is the number of posts in a feed.
+const feedSize = 20
+
+// The root-relative links in the content of a post, which are made absolute
+// as the feed readers do not know the site.
+var feedLinkPattern = regexp.MustCompile(`((?:href|src)\s*=\s*["'])/([^/])`)
+
+// feed is what the RSS, Atom and JSON feeds are written from.
+type feed struct {
+	Title       string
+	Description string
+	// Link is the page the feed is about, Self is the feed without its
+	// format.
+	Link    string
+	Self    string
+	Updated time.Time
+	Items   []*feedItem
+}
+
+type feedItem struct {
+	Id      string
+	Title   string
+	Link    string
+	Author  string
+	Image   string
+	Tags    []string
+	Summary string
+	// Content is the HTML of the post, it is empty when the feeds only
+	// have the excerpts.
+	Content   string
+	Published time.Time
+	Updated   time.Time
+}
+
+// FeedHandler serves the feed of the latest posts as RSS, or as Atom or JSON
+// Feed with the format parameter.
+func FeedHandler(ctx *golf.Context) {
+	title := model.GetSettingValue("title")
+	f := newFeed(ctx, title, model.GetSettingValue("description"), "/", "/feed/", &model.PostFilter{Status: "published"})
+	writeFeed(ctx, f)
+}
+
+// TagFeedHandler serves the feed of the latest posts with a tag.
+func TagFeedHandler(ctx *golf.Context) {
+	slug, _ := url.QueryUnescape(ctx.Param("tag"))
+	tag, err := model.GetTagBySlug(slug)
+	if err != nil {
+		ctx.Abort(404)
+		return
+	}
+	title := model.GetSettingValue("title") + " - " + tag.Name
+	f := newFeed(ctx, title, model.GetSettingValue("description"), tag.Url()+"/", tag.Url()+"/feed/", &model.PostFilter{Status: "published", TagId: tag.Id})
+	writeFeed(ctx, f)
+}
+
+// AuthorFeedHandler serves the feed of the latest posts of a user.
+func AuthorFeedHandler(ctx *golf.Context) {
+	slug, _ := url.QueryUnescape(ctx.Param("author"))
+	u, err := model.GetUserBySlug(slug)
+	if err != nil {
+		ctx.Abort(404)
+		return
+	}
+	title := model.GetSettingValue("title") + " - " + u.Name
+	f := newFeed(ctx, title, u.Bio, "/author/"+u.Slug+"/", "/author/"+u.Slug+"/feed/", &model.PostFilter{Status: "published", AuthorId: u.Id})
+	writeFeed(ctx, f)
+}
+
+// siteURL is the URL of the site without the trailing slash, as set in the
+// settings or else as requested.
+func siteURL(ctx *golf.Context) string {
+	if u := strings.TrimRight(model.GetSettingValue("site_url"), "/"); u != "" {
+		return u
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host
+}
+
+func newFeed(ctx *golf.Context, title, description, link, self string, filter *model.PostFilter) *feed {
+	base := siteURL(ctx)
+	posts, _, err := model.GetFilteredPostList(filter, 1, feedSize, "published_at DESC")
+	if err != nil {
+		panic(err)
+	}
+	full := model.GetSettingValue("feed_content") == "full"
+	f := &feed{
+		Title:       title,
+		Description: description,
+		Link:        base + link,
+		Self:        base + self,
+		Items:       make([]*feedItem, 0, len(posts)),
+	}
+	for _, p := range posts {
+		item := &feedItem{
+			Id:        "urn:uuid:" + p.UUID,
+			Title:     p.Title,
+			Link:      base + p.Url() + "/",
+			Author:    p.Author.Name,
+			Summary:   p.Excerpt(),
+			Published: *p.PublishedAt,
+			Updated:   *p.PublishedAt,
+			Tags:      make([]string, 0, len(p.Tags)),
+		}
+		if p.UpdatedAt != nil && p.UpdatedAt.After(item.Updated) {
+			item.Updated = *p.UpdatedAt
+		}
+		if strings.HasPrefix(p.Image, "/") && !strings.HasPrefix(p.Image, "//") {
+			item.Image = base + p.Image
+		} else {
+			item.Image = p.Image
+		}
+		if full {
+			item.Content = feedLinkPattern.ReplaceAllString(p.Html, "${1}"+base+"/$2")
+		}
+		for _, t := range p.Tags {
+			item.Tags = append(item.Tags, t.Name)
+		}
+		if item.Updated.After(f.Updated) {
+			f.Updated = item.Updated
+		}
+		f.Items = append(f.Items, item)
+	}
+	if f.Updated.IsZero() {
+		f.Updated = time.Now()
+	}
+	return f
+}
+
+// writeFeed writes the feed in the format given by the format parameter,
+// RSS by default.
+func writeFeed(ctx *golf.Context, f *feed) {
+	var data []byte
+	var err error
+	switch ctx.Param("format") {
+	case "":
+		ctx.SetHeader("Content-Type", "application/rss+xml; charset=utf-8")
+		data, err = xml.MarshalIndent(f.rss(), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	case "atom":
+		ctx.SetHeader("Content-Type", "application/atom+xml; charset=utf-8")
+		data, err = xml.MarshalIndent(f.atom(), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	case "json":
+		ctx.SetHeader("Content-Type", "application/feed+json; charset=utf-8")
+		data, err = json.MarshalIndent(f.jsonFeed(), "", "  ")
+	default:
+		ctx.Abort(404)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	ctx.Send(data)
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	DCNS      string     `xml:"xmlns:dc,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded,omitempty"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f *feed) rss() *rssFeed {
+	r := &rssFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Description,
+			Self:          atomLink{Href: f.Self, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: f.Updated.Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(f.Items)),
+		},
+	}
+	for _, item := range f.Items {
+		r.Channel.Items = append(r.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Guid:        rssGuid{Value: item.Id},
+			PubDate:     item.Published.Format(time.RFC1123Z),
+			Creator:     item.Author,
+			Categories:  item.Tags,
+			Description: item.Summary,
+			Content:     item.Content,
+		})
+	}
+	return r
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Id       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (f *feed) atom() *atomFeed {
+	a := &atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Id:       f.Self,
+		Updated:  f.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Link, Rel: "alternate", Type: "text/html"},
+			{Href: f.Self + "atom/", Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: make([]atomEntry, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		e := atomEntry{
+			Title:      item.Title,
+			Id:         item.Id,
+			Link:       atomLink{Href: item.Link, Rel: "alternate", Type: "text/html"},
+			Published:  item.Published.Format(time.RFC3339),
+			Updated:    item.Updated.Format(time.RFC3339),
+			Author:     atomPerson{Name: item.Author},
+			Categories: make([]atomCategory, 0, len(item.Tags)),
+			Summary:    &atomText{Type: "text", Body: item.Summary},
+		}
+		if item.Content != "" {
+			e.Content = &atomText{Type: "html", Body: item.Content}
+		}
+		for _, t := range item.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: t})
+		}
+		a.Entries = append(a.Entries, e)
+	}
+	return a
+}
+
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url"`
+	FeedURL     string          `json:"feed_url"`
+	Description string          `json:"description,omitempty"`
+	Items       []*jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	Id            string            `json:"id"`
+	URL           string            `json:"url"`
+	Title         string            `json:"title"`
+	ContentHtml   string            `json:"content_html,omitempty"`
+	ContentText   string            `json:"content_text,omitempty"`
+	Summary       string            `json:"summary,omitempty"`
+	Image         string            `json:"image,omitempty"`
+	DatePublished string            `json:"date_published"`
+	DateModified  string            `json:"date_modified"`
+	Authors       []*jsonFeedAuthor `json:"authors"`
+	Tags          []string          `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+func (f *feed) jsonFeed() *jsonFeed {
+	j := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.Self + "json/",
+		Description: f.Description,
+		Items:       make([]*jsonFeedItem, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		ji := &jsonFeedItem{
+			Id:            item.Id,
+			URL:           item.Link,
+			Title:         item.Title,
+			Image:         item.Image,
+			DatePublished: item.Published.Format(time.RFC3339),
+			DateModified:  item.Updated.Format(time.RFC3339),
+			Authors:       []*jsonFeedAuthor{{Name: item.Author}},
+			Tags:          item.Tags,
+		}
+		if item.Content != "" {
+			ji.ContentHtml = item.Content
+			ji.Summary = item.Summary
+		} else {
+			ji.ContentText = item.Summary
+		}
+		j.Items = append(j.Items, ji)
+	}
+	return j
+}
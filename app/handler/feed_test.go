@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func getFeed(path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	InitTestApp().ServeHTTP(rec, makeTestHTTPRequest(nil, "GET", path))
+	return rec
+}
+
+func TestFeedHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		So(model.NewSetting("site_url", "http://example.com/", "blog").Save(), ShouldBeNil)
+		So(model.NewSetting("title", "Feed Blog", "blog").Save(), ShouldBeNil)
+		u := model.NewUser(email, name)
+		So(u.Create(password), ShouldBeNil)
+		p := model.NewPost()
+		p.Title = "Feed & Post"
+		p.Slug = "feed-post"
+		p.Markdown = "Hello ![Gopher](/upload/gopher.png)"
+		p.Html = utils.Markdown2Html(p.Markdown)
+		p.Tags = model.GenerateTagsFromCommaString("Go")
+		p.CreatedBy = u.Id
+		p.IsPublished = true
+		So(p.Save(), ShouldBeNil)
+		p, _ = model.GetPostBySlug("feed-post")
+
+		Convey("Get the RSS feed", func() {
+			rec := getFeed("/feed/")
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/rss+xml")
+			var r rssFeed
+			So(xml.Unmarshal(rec.Body.Bytes(), &r), ShouldBeNil)
+			So(r.Channel.Title, ShouldEqual, "Feed Blog")
+			So(r.Channel.Items, ShouldHaveLength, 1)
+			item := r.Channel.Items[0]
+			So(item.Title, ShouldEqual, "Feed & Post")
+			So(item.Link, ShouldEqual, "http://example.com/feed-post/")
+			So(item.PubDate, ShouldEqual, p.PublishedAt.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+			So(item.Content, ShouldBeEmpty)
+		})
+
+		Convey("Get the Atom feed with the full content", func() {
+			So(model.NewSetting("feed_content", "full", "").Save(), ShouldBeNil)
+			rec := getFeed("/feed/atom/")
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/atom+xml")
+			var a atomFeed
+			So(xml.Unmarshal(rec.Body.Bytes(), &a), ShouldBeNil)
+			So(a.Links[1].Href, ShouldEqual, "http://example.com/feed/atom/")
+			So(a.Entries, ShouldHaveLength, 1)
+			So(a.Entries[0].Id, ShouldEqual, "urn:uuid:"+p.UUID)
+			So(a.Entries[0].Author.Name, ShouldEqual, name)
+			So(a.Entries[0].Content.Body, ShouldContainSubstring, `src="http://example.com/upload/gopher.png"`)
+		})
+
+		Convey("Get the JSON feed of a tag", func() {
+			rec := getFeed("/tag/go/feed/json/")
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/feed+json")
+			var j jsonFeed
+			So(json.Unmarshal(rec.Body.Bytes(), &j), ShouldBeNil)
+			So(j.Version, ShouldEqual, "https://jsonfeed.org/version/1.1")
+			So(j.Title, ShouldEqual, "Feed Blog - Go")
+			So(j.FeedURL, ShouldEqual, "http://example.com/tag/go/feed/json/")
+			So(j.Items, ShouldHaveLength, 1)
+			So(j.Items[0].Tags, ShouldResemble, []string{"Go"})
+			So(j.Items[0].ContentText, ShouldNotBeEmpty)
+		})
+
+		Convey("Get the feed of an author", func() {
+			rec := getFeed("/author/" + u.Slug + "/feed/atom/")
+			So(rec.Code, ShouldEqual, 200)
+			var a atomFeed
+			So(xml.Unmarshal(rec.Body.Bytes(), &a), ShouldBeNil)
+			So(a.Entries, ShouldHaveLength, 1)
+
+			So(getFeed("/author/nobody/feed/").Code, ShouldEqual, 404)
+			So(getFeed("/feed/unknown/").Code, ShouldEqual, 404)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -1,9 +1,17 @@
 package handler
 
 import (
+	"log"
+	"strconv"
+	"time"
+
 	"github.com/dinever/dingo/app/model"
 )
 
+// defaultCommentDepth is the deepest nesting of the comments until the
+// comment_depth setting is set.
+const defaultCommentDepth = 3
+
 func getAllPosts() []*model.Post {
 	posts, _ := model.GetAllPostList(false, true, "published_at DESC")
 	return posts
@@ -18,3 +26,56 @@ func getRecentPosts() []*model.Post {
 	posts, _, _ := model.GetPostList(1, 5, false, true, "published_at DESC")
 	return posts
 }
+
+func getCategoryTree() []*model.Category {
+	categories, _ := model.GetCategoryTree()
+	return categories
+}
+
+// commentDepth is the deepest nesting of the comments, from the
+// comment_depth setting.
+func commentDepth() int {
+	depth, err := strconv.Atoi(model.GetSettingValue("comment_depth"))
+	if err != nil || depth < 0 {
+		return defaultCommentDepth
+	}
+	return depth
+}
+
+// getCommentTree returns the comments of a post depth-first, each with its
+// Depth, for the themes to indent the replies.
+func getCommentTree(post *model.Post) []*model.CommentNode {
+	nodes, err := model.GetCommentTree(post.Id, commentDepth())
+	if err != nil {
+		log.Printf("[Error]: Can not get the comments of post %d: %v", post.Id, err)
+		return nil
+	}
+	return model.FlattenCommentTree(nodes)
+}
+
+// getCategoryFromForm returns the category selected in the post editor, or nil
+// if the post is uncategorized.
+func getCategoryFromForm(value string) *model.Category {
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return nil
+	}
+	c, err := model.GetCategoryById(int64(id))
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// editorTimeLayout is the format of the datetime-local inputs in the editor.
+const editorTimeLayout = "2006-01-02T15:04"
+
+// getTimeFromForm parses a datetime-local input of the editor, it returns nil
+// if the input is empty or malformed.
+func getTimeFromForm(value string) *time.Time {
+	t, err := time.ParseInLocation(editorTimeLayout, value, time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
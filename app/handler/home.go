@@ -9,18 +9,24 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"time"
 )
 
+// postsPerPage is the number of posts on a page of the home, tag and
+// category listings.
+const postsPerPage = 5
+
 func RegisterFunctions(app *golf.Application) {
 	app.View.FuncMap["Tags"] = getAllTags
 	app.View.FuncMap["RecentArticles"] = getRecentPosts
+	app.View.FuncMap["Categories"] = getCategoryTree
+	app.View.FuncMap["CommentTree"] = getCommentTree
+	app.View.FuncMap["CommentToken"] = getCommentToken
 }
 
 func HomeHandler(ctx *golf.Context) {
 	p := ctx.Param("page")
 	page, _ := strconv.Atoi(p)
-	articles, pager, err := model.GetPostList(int64(page), 5, false, true, "published_at DESC")
+	articles, pager, err := model.GetPostList(int64(page), postsPerPage, false, true, "published_at DESC")
 	if err != nil {
 		panic(err)
 	}
@@ -47,6 +53,7 @@ func ContentHandler(ctx *golf.Context) {
 		return
 	}
 	post.Hits++
+	ctx.SetHeader("Link", webmentionLink(ctx))
 	data := map[string]interface{}{
 		"Title":    post.Title,
 		"Article":  post,
@@ -60,6 +67,10 @@ func ContentHandler(ctx *golf.Context) {
 	}
 }
 
+// SpamChecker classifies the comments of the visitors, the comments it
+// takes for spam go to the spam queue. It is set up by the application.
+var SpamChecker model.SpamChecker
+
 func CommentHandler(ctx *golf.Context) {
 	id := ctx.Param("id")
 	cid, _ := strconv.Atoi(id)
@@ -67,7 +78,23 @@ func CommentHandler(ctx *golf.Context) {
 	if cid < 1 || err != nil {
 		ctx.JSON(map[string]interface{}{
 			"status": "error",
+			"msg":    "Post not found.",
+		})
+		return
+	}
+	if !post.CommentsOpen() {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Comments are closed.",
 		})
+		return
+	}
+	if msg := checkCommentForm(ctx, post); msg != "" {
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    msg,
+		})
+		return
 	}
 	c := model.NewComment()
 	c.Author = ctx.Request.FormValue("author")
@@ -78,26 +105,58 @@ func CommentHandler(ctx *golf.Context) {
 	c.PostId = post.Id
 	pid, _ := strconv.Atoi(ctx.Request.FormValue("pid"))
 	c.Parent = int64(pid)
-	c.Ip = ctx.Request.RemoteAddr
+	c.Ip = clientIp(ctx.Request)
 	c.UserAgent = ctx.Request.UserAgent()
 	c.UserId = 0
+	c.Notify = ctx.Request.FormValue("notify") != ""
 	msg := c.ValidateComment()
+	if msg == "" && c.Parent != 0 {
+		if parent, err := c.ParentComment(); err != nil || parent == nil {
+			msg = "Can not reply to this comment."
+		}
+	}
+	if msg == "" {
+		msg = checkComment(c)
+	}
 	if msg == "" {
+		if SpamChecker != nil {
+			spam, err := SpamChecker.IsSpam(c)
+			if err != nil {
+				log.Printf("[Error]: Can not check comment for spam: %v", err)
+			}
+			if spam {
+				c.SetStatus(model.CommentSpam)
+			}
+		}
 		if err := c.Save(); err != nil {
 			ctx.JSON(map[string]interface{}{
 				"status": "error",
 				"msg":    "Can not comment on this post.",
 			})
+			return
 		}
-		post.CommentNum++
-		err = post.Save()
-		if err != nil {
+		// Spam is answered like any comment, but nobody is told about it
+		if c.Status == model.CommentSpam {
+			ctx.JSON(map[string]interface{}{
+				"res":     true,
+				"comment": c.ToJson(),
+			})
+			return
+		}
+		if err := model.IncrementCommentNum(post.Id); err != nil {
 			log.Printf("[Error]: Can not increase comment count for post %v: %v", post.Id, err.Error())
 		}
 		ctx.JSON(map[string]interface{}{
 			"res":     true,
 			"comment": c.ToJson(),
 		})
+		if c.Notify {
+			confirmSubscription(c.Email)
+		}
+		notifyNewComment(post, c)
+		if c.Approved {
+			notifyReply(c)
+		}
 		if err = model.NewMessage("comment", c).Save(); err != nil {
 			panic(err)
 		}
@@ -109,6 +168,52 @@ func CommentHandler(ctx *golf.Context) {
 	}
 }
 
+// CommentTreeHandler answers the approved comments of a published post as a
+// tree, without the emails and addresses of their authors.
+func CommentTreeHandler(ctx *golf.Context) {
+	id, _ := strconv.Atoi(ctx.Param("id"))
+	post, err := model.GetPostById(int64(id))
+	if err != nil || !post.IsPublished {
+		ctx.SendStatus(404)
+		ctx.JSON(map[string]interface{}{
+			"status": "error",
+			"msg":    "Post not found.",
+		})
+		return
+	}
+	nodes, err := model.GetCommentTree(post.Id, commentDepth())
+	if err != nil {
+		panic(err)
+	}
+	ctx.JSON(map[string]interface{}{
+		"status":   "success",
+		"comments": commentTreeJson(nodes),
+	})
+}
+
+func commentTreeJson(nodes []*model.CommentNode) []map[string]interface{} {
+	list := make([]map[string]interface{}, len(nodes))
+	for i, n := range nodes {
+		m := map[string]interface{}{
+			"id":          n.Id,
+			"pid":         n.Parent,
+			"depth":       n.Depth,
+			"deleted":     n.Deleted,
+			"type":        n.Type,
+			"create_time": n.CreatedAt.Unix(),
+			"replies":     commentTreeJson(n.Replies),
+		}
+		if !n.Deleted {
+			m["author"] = n.Author
+			m["website"] = n.Website
+			m["avatar"] = n.Avatar
+			m["content"] = n.Content
+		}
+		list[i] = m
+	}
+	return list
+}
+
 func TagHandler(ctx *golf.Context) {
 	p := ctx.Param("page")
 	page, _ := strconv.Atoi(p)
@@ -119,7 +224,7 @@ func TagHandler(ctx *golf.Context) {
 		NotFoundHandler(ctx)
 		return
 	}
-	posts, pager, err := model.GetPostsByTag(tag.Id, int64(page), 5, true, "published_at DESC")
+	posts, pager, err := model.GetPostsByTag(tag.Id, int64(page), postsPerPage, true, "published_at DESC")
 	data := map[string]interface{}{
 		"Articles": posts,
 		"Pager":    pager,
@@ -129,66 +234,66 @@ func TagHandler(ctx *golf.Context) {
 	ctx.Loader("theme").Render("tag.html", data)
 }
 
-func SiteMapHandler(ctx *golf.Context) {
-	baseUrl := model.GetSettingValue("site_url")
-	articles, _, _ := model.GetPostList(1, 50, false, true, "published_at DESC")
-	navigators := model.GetNavigators()
-	now := utils.Now().Format(time.RFC3339)
-
-	articleMap := make([]map[string]string, len(articles))
-	for i, a := range articles {
-		m := make(map[string]string)
-		m["Link"] = strings.Replace(baseUrl+a.Url(), baseUrl+"/", baseUrl, -1)
-		m["Created"] = a.PublishedAt.Format(time.RFC3339)
-		articleMap[i] = m
+func AuthorHandler(ctx *golf.Context) {
+	p := ctx.Param("page")
+	page, _ := strconv.Atoi(p)
+	authorSlug, _ := url.QueryUnescape(ctx.Param("author"))
+	author, err := model.GetUserBySlug(authorSlug)
+	if err != nil {
+		ctx.Abort(404)
+		return
 	}
+	posts, pager, err := model.GetFilteredPostList(&model.PostFilter{Status: "published", AuthorId: author.Id}, int64(page), postsPerPage, "published_at DESC")
+	if err != nil {
+		panic(err)
+	}
+	data := map[string]interface{}{
+		"Articles": posts,
+		"Pager":    pager,
+		"Author":   author,
+		"Title":    author.Name,
+	}
+	ctx.Loader("theme").Render("author.html", data)
+}
 
-	navMap := make([]map[string]string, 0)
-	for _, n := range navigators {
-		m := make(map[string]string)
-		if n.Url == "/" {
-			continue
-		}
-		if strings.HasPrefix(n.Url, "/") {
-			m["Link"] = strings.Replace(baseUrl+n.Url, baseUrl+"/", baseUrl, -1)
+func SearchHandler(ctx *golf.Context) {
+	page, _ := strconv.Atoi(ctx.Request.FormValue("page"))
+	q := strings.TrimSpace(ctx.Request.FormValue("q"))
+	data := map[string]interface{}{
+		"Query": q,
+		"Title": "Search",
+	}
+	if q != "" {
+		results, pager, err := model.SearchPosts(q, &model.PostFilter{Status: "published"}, int64(page), 5)
+		if err != nil {
+			data["Error"] = err.Error()
 		} else {
-			m["Link"] = n.Url
+			data["Results"] = results
+			data["Pager"] = pager
+			data["Title"] = "Search: " + q
 		}
-		m["Created"] = now
-		navMap = append(navMap, m)
 	}
-
-	ctx.SetHeader("Content-Type", "application/rss+xml;charset=UTF-8")
-	ctx.Loader("base").Render("sitemap.xml", map[string]interface{}{
-		"Title":      model.GetSettingValue("site_title"),
-		"Link":       baseUrl,
-		"Created":    now,
-		"Articles":   articleMap,
-		"Navigators": navMap,
-	})
+	ctx.Loader("theme").Render("search.html", data)
 }
 
-func RssHandler(ctx *golf.Context) {
-	baseUrl := model.GetSettingValue("site_url")
-	articles, _, _ := model.GetPostList(1, 20, false, true, "published_at DESC")
-	articleMap := make([]map[string]string, len(articles))
-	for i, a := range articles {
-		m := make(map[string]string)
-		m["Title"] = a.Title
-		m["Link"] = a.Url()
-		m["Author"] = a.Author.Name
-		m["Desc"] = a.Excerpt()
-		m["Created"] = a.CreatedAt.Format(time.RFC822)
-		articleMap[i] = m
+func CategoryHandler(ctx *golf.Context) {
+	p := ctx.Param("page")
+	page, _ := strconv.Atoi(p)
+	categorySlug, _ := url.QueryUnescape(ctx.Param("slug"))
+	category, err := model.GetCategoryBySlug(categorySlug)
+	if err != nil {
+		NotFoundHandler(ctx)
+		return
 	}
-
-	ctx.SetHeader("Content-Type", "text/xml; charset=utf-8")
-
-	ctx.Loader("base").Loader("base").Render("rss.xml", map[string]interface{}{
-		"Title":    model.GetSettingValue("site_title"),
-		"Link":     baseUrl,
-		"Desc":     model.GetSettingValue("site_description"),
-		"Created":  utils.Now().Format(time.RFC822),
-		"Articles": articleMap,
-	})
+	posts, pager, err := model.GetPostsByCategory(category.Id, int64(page), postsPerPage, true, "published_at DESC")
+	if err != nil {
+		panic(err)
+	}
+	data := map[string]interface{}{
+		"Articles": posts,
+		"Pager":    pager,
+		"Category": category,
+		"Title":    category.Name,
+	}
+	ctx.Loader("theme").Render("category.html", data)
 }
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+)
+
+// Mailer delivers the mails sent by the handlers, it is set up by the
+// application.
+var Mailer utils.Mailer
+
+// sendMail delivers a mail in the background, so that a slow mail server does
+// not slow down the response. Failures are only logged.
+func sendMail(m *utils.Mail) {
+	mailer := Mailer
+	if mailer == nil {
+		log.Printf("[Error]: No mailer to send %q to %v", m.Subject, m.To)
+		return
+	}
+	go func() {
+		if err := mailer.Send(m); err != nil {
+			log.Printf("[Error]: Can not send %q to %v: %v", m.Subject, m.To, err)
+		}
+	}()
+}
+
+var errNoSiteUrl = errors.New("Mails with links can not be sent until the Site URL is set in the settings.")
+
+// mailUrl returns the url of a path on the site, as set in the settings. The
+// host of the request is not used, since the client can forge it and have the
+// links of the mails point to its own server.
+func mailUrl(path string) (string, error) {
+	base := strings.TrimRight(model.GetSettingValue("site_url"), "/")
+	if base == "" {
+		return "", errNoSiteUrl
+	}
+	return base + path, nil
+}
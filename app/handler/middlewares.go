@@ -3,7 +3,7 @@ package handler
 import (
 	"github.com/dinever/dingo/app/model"
 	"github.com/dinever/golf"
-	"strconv"
+	"strings"
 )
 
 func AuthMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
@@ -23,18 +23,59 @@ func AuthMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
 			ctx.Redirect("/login/")
 			return
 		}
-		tokenUser, err := ctx.Request.Cookie("token-user")
+		// The user is given by the token, the token-user cookie is not trusted
+		user, err := model.GetUserById(token.UserId)
 		if err != nil {
+			panic(err)
+		}
+		if !user.IsActive() {
 			ctx.Redirect("/login/")
 			return
 		}
-		uid, _ := strconv.Atoi(tokenUser.Value)
-		user, err := model.GetUserById(int64(uid))
-		if err != nil {
+		// Users without two-factor authentication can only set it up
+		if model.TwoFactorRequired() && !user.TwoFactorEnabled() && !strings.HasPrefix(ctx.Request.URL.Path, "/admin/profile/") {
+			ctx.Redirect("/admin/profile/")
+			return
+		}
+		if err := token.Touch(ctx.ClientIP()); err != nil {
 			panic(err)
 		}
+		ctx.Session.Set("token", token)
 		ctx.Session.Set("user", user)
 		next(ctx)
 	}
 	return fn
 }
+
+// AdminMiddleware only lets administrators and the owner through. It must be
+// chained after AuthMiddleware.
+func AdminMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
+	return roleMiddleware(next, (*model.User).IsAdmin)
+}
+
+// EditorMiddleware only lets users who can manage the content of others
+// through. It must be chained after AuthMiddleware.
+func EditorMiddleware(next golf.HandlerFunc) golf.HandlerFunc {
+	return roleMiddleware(next, (*model.User).IsEditor)
+}
+
+func roleMiddleware(next golf.HandlerFunc, allowed func(*model.User) bool) golf.HandlerFunc {
+	fn := func(ctx *golf.Context) {
+		userObj, _ := ctx.Session.Get("user")
+		u, ok := userObj.(*model.User)
+		if !ok || !allowed(u) {
+			forbidden(ctx)
+			return
+		}
+		next(ctx)
+	}
+	return fn
+}
+
+func forbidden(ctx *golf.Context) {
+	ctx.SendStatus(403)
+	ctx.JSON(map[string]interface{}{
+		"status": "error",
+		"msg":    "Permission denied",
+	})
+}
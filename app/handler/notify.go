@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	"github.com/dinever/golf"
+)
+
+// commentMail is a mail about comments, with a link which unsubscribes the
+// address it is sent to. Mail clients can unsubscribe in one click from the
+// List-Unsubscribe headers.
+func commentMail(to, subject, body string) *utils.Mail {
+	link := unsubscribeUrl(to)
+	m := utils.NewMail(to, subject, body+fmt.Sprintf(commentMailFooter, model.GetSettingValue("title"), link))
+	m.Headers = map[string]string{
+		"List-Unsubscribe":      "<" + link + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	return m
+}
+
+func unsubscribeUrl(email string) string {
+	link, _ := mailUrl("/comment/unsubscribe/?email=" + url.QueryEscape(email) + "&token=" + model.UnsubscribeToken(email))
+	return link
+}
+
+// commentUrl links to a comment on its post.
+func commentUrl(post *model.Post, c *model.Comment) string {
+	link, _ := mailUrl(post.Url() + "/#comment-" + strconv.FormatInt(c.Id, 10))
+	return link
+}
+
+// commentText is the content of a comment as plain text.
+func commentText(c *model.Comment) string {
+	return html.UnescapeString(utils.Html2Str(strings.Replace(c.Content, "<br/>", "\n", -1)))
+}
+
+// notifyNewComment mails the author of a post about a comment of a visitor,
+// unless the author unsubscribed. The mails about comments are not sent
+// until the Site URL is set, their links are made from it.
+func notifyNewComment(post *model.Post, c *model.Comment) {
+	author := post.Author
+	if author == nil || author.Id == 0 || author.Email == "" || strings.EqualFold(author.Email, c.Email) {
+		return
+	}
+	if unsubscribed, err := model.IsUnsubscribed(author.Email); err != nil || unsubscribed {
+		return
+	}
+	if _, err := mailUrl("/"); err != nil {
+		log.Printf("[Error]: Can not mail about comment %d: %v", c.Id, err)
+		return
+	}
+	next := "It is awaiting moderation."
+	if c.Approved {
+		next = "Read it on the site:\n\n" + commentUrl(post, c)
+	}
+	sendMail(commentMail(author.Email, "New comment on "+post.Title, fmt.Sprintf(newCommentMail,
+		author.Name, c.Author, post.Title, commentText(c), next)))
+}
+
+// notifyReply mails the author of the comment an approved comment replies
+// to, when they asked to be notified of the replies.
+func notifyReply(c *model.Comment) {
+	parent, err := c.NotifiedParent()
+	if err != nil {
+		log.Printf("[Error]: Can not find the parent of comment %d: %v", c.Id, err)
+	}
+	if parent == nil {
+		return
+	}
+	if _, err := mailUrl("/"); err != nil {
+		log.Printf("[Error]: Can not mail about comment %d: %v", c.Id, err)
+		return
+	}
+	post, err := model.GetPostById(c.PostId)
+	if err != nil {
+		log.Printf("[Error]: Can not find the post of comment %d: %v", c.Id, err)
+		return
+	}
+	sendMail(commentMail(parent.Email, c.Author+" replied to your comment on "+post.Title, fmt.Sprintf(replyMail,
+		parent.Author, c.Author, post.Title, commentText(c), commentUrl(post, c))))
+}
+
+// confirmSubscription mails a link which subscribes an address again, when
+// a comment asks to be notified of the replies with an address which
+// unsubscribed. Anybody can comment with any address, so only its owner can
+// undo the unsubscription.
+func confirmSubscription(email string) {
+	if unsubscribed, err := model.IsUnsubscribed(email); err != nil || !unsubscribed {
+		return
+	}
+	link, err := mailUrl("/comment/subscribe/?email=" + url.QueryEscape(email) + "&token=" + model.SubscribeToken(email))
+	if err != nil {
+		log.Printf("[Error]: Can not mail %s to subscribe again: %v", email, err)
+		return
+	}
+	sendMail(utils.NewMail(email, "Confirm the notifications of "+model.GetSettingValue("title"), fmt.Sprintf(subscribeMail,
+		model.GetSettingValue("title"), link)))
+}
+
+// moderateComment moves a comment to a moderation state, and notifies the
+// author of its parent when it gets approved.
+func moderateComment(c *model.Comment, status string) error {
+	approved := c.Approved
+	if err := c.Moderate(status, SpamChecker); err != nil {
+		return err
+	}
+	if !approved && c.Approved {
+		notifyReply(c)
+	}
+	return nil
+}
+
+const newCommentMail = `Hi %s,
+
+%s commented on "%s":
+
+%s
+
+%s
+`
+
+const replyMail = `Hi %s,
+
+%s replied to your comment on "%s":
+
+%s
+
+Read the conversation on the site:
+
+%s
+`
+
+const subscribeMail = `Hi,
+
+A comment on %s asked to be notified of its replies with this address,
+which does not receive mails about comments anymore. To receive them again,
+open the following link:
+
+%s
+
+If you did not ask for it, ignore this mail.
+`
+
+const commentMailFooter = `
+You receive this mail about the comments on %s. To stop these mails, open
+the following link:
+
+%s
+`
+
+// UnsubscribeHandler unsubscribes the address of a signed link sent with the
+// mails about comments. It answers both the link and the one-click POST of
+// the mail clients.
+func UnsubscribeHandler(ctx *golf.Context) {
+	email := ctx.Request.FormValue("email")
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	if !model.CheckUnsubscribeToken(email, ctx.Request.FormValue("token")) {
+		ctx.SendStatus(400)
+		ctx.Send([]byte("This unsubscribe link is not valid.\n"))
+		return
+	}
+	if err := model.Unsubscribe(email); err != nil {
+		panic(err)
+	}
+	ctx.Send([]byte(email + " will not receive mails about comments anymore.\n"))
+}
+
+// SubscribeHandler subscribes again the address of a signed link sent by
+// confirmSubscription. The link opens a form, so that the mail clients
+// which follow links do not subscribe on their own.
+func SubscribeHandler(ctx *golf.Context) {
+	email := ctx.Request.FormValue("email")
+	token := ctx.Request.FormValue("token")
+	if !model.CheckSubscribeToken(email, token) {
+		ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+		ctx.SendStatus(400)
+		ctx.Send([]byte("This subscribe link is not valid.\n"))
+		return
+	}
+	if ctx.Request.Method != "POST" {
+		ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+		ctx.Send([]byte(fmt.Sprintf(subscribeForm, html.EscapeString(email), html.EscapeString(email), html.EscapeString(token))))
+		return
+	}
+	if err := model.Subscribe(email); err != nil {
+		panic(err)
+	}
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	ctx.Send([]byte(email + " will receive mails about comments again.\n"))
+}
+
+const subscribeForm = `<!DOCTYPE html>
+<form method="post">
+  <p>Receive the mails about comments at %s again?</p>
+  <input type="hidden" name="email" value="%s">
+  <input type="hidden" name="token" value="%s">
+  <button type="submit">Subscribe</button>
+</form>
+`
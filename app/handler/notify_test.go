@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stubMailer hands the sent mails over to the test.
+type stubMailer struct {
+	sent chan *utils.Mail
+}
+
+func (s *stubMailer) Send(m *utils.Mail) error {
+	s.sent <- m
+	return nil
+}
+
+// next waits for the next mail, which is sent in the background.
+func (s *stubMailer) next() *utils.Mail {
+	select {
+	case m := <-s.sent:
+		return m
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestCommentNotification(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		mailer := &stubMailer{sent: make(chan *utils.Mail, 10)}
+		Mailer = mailer
+		So(model.NewSetting("site_url", "http://example.com", "blog").Save(), ShouldBeNil)
+		form := url.Values{}
+		form.Add("title", "Hello World")
+		form.Add("slug", "hello-world")
+		form.Add("content", "Sample content")
+		form.Add("comment", "on")
+		form.Add("status", "on")
+		ctx := authenticatedContext(form, "POST", "/admin/editor/post/")
+		app := ctx.App
+		app.ServeHTTP(ctx.Response, ctx.Request)
+
+		form = url.Values{}
+		form.Add("author", "Ken Thompson")
+		form.Add("email", "ken@gmail.com")
+		form.Add("comment", "Nice post!")
+		form.Add("notify", "1")
+		form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+		ctx = mockContext(form, "POST", "/comment/1/")
+		// The links of the mails are not made from the host of the request
+		ctx.Request.Host = "evil.example.org"
+		app.ServeHTTP(ctx.Response, ctx.Request)
+
+		Convey("Notify the author of the post", func() {
+			m := mailer.next()
+			So(m, ShouldNotBeNil)
+			So(m.To, ShouldResemble, []string{email})
+			So(m.Subject, ShouldEqual, "New comment on Hello World")
+			So(m.Body, ShouldContainSubstring, "Nice post!")
+			So(m.Body, ShouldContainSubstring, "awaiting moderation")
+			So(m.Headers["List-Unsubscribe-Post"], ShouldEqual, "List-Unsubscribe=One-Click")
+			So(m.Headers["List-Unsubscribe"], ShouldStartWith, "<http://example.com/comment/unsubscribe/")
+			So(m.Body, ShouldNotContainSubstring, "evil.example.org")
+			c, _ := model.GetCommentById(1)
+			So(c.Notify, ShouldBeTrue)
+
+			Convey("Notify the commenter of a reply", func() {
+				form := url.Values{}
+				form.Add("pid", "1")
+				form.Add("content", "Thanks Ken!")
+				ctx := authenticatedContext(form, "POST", "/admin/comments/")
+				app.ServeHTTP(ctx.Response, ctx.Request)
+
+				m := mailer.next()
+				So(m, ShouldNotBeNil)
+				So(m.To, ShouldResemble, []string{"ken@gmail.com"})
+				So(m.Subject, ShouldEqual, name+" replied to your comment on Hello World")
+				So(m.Body, ShouldContainSubstring, "Thanks Ken!")
+				So(m.Body, ShouldContainSubstring, "http://example.com/hello-world/#comment-2")
+
+				Convey("Unsubscribe from the link", func() {
+					raw := strings.Trim(m.Headers["List-Unsubscribe"], "<>")
+					So(m.Body, ShouldContainSubstring, raw)
+					link, err := url.Parse(raw)
+					So(err, ShouldBeNil)
+					So(getFeed(link.Path+"?email=ken@gmail.com&token=forged").Code, ShouldEqual, 400)
+					rec := getFeed(link.RequestURI())
+					So(rec.Code, ShouldEqual, 200)
+					unsubscribed, _ := model.IsUnsubscribed("ken@gmail.com")
+					So(unsubscribed, ShouldBeTrue)
+					c, _ := model.GetCommentById(1)
+					So(c.Notify, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("Notify nobody who did not ask for it", func() {
+			So(mailer.next(), ShouldNotBeNil)
+			So(model.Unsubscribe(email), ShouldBeNil)
+			form := url.Values{}
+			form.Add("id", "1")
+			ctx := authenticatedContext(form, "PUT", "/admin/comments/")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			form = url.Values{}
+			form.Add("author", "Rob Pike")
+			form.Add("email", "rob@gmail.com")
+			form.Add("comment", "Me too")
+			form.Add("pid", "1")
+			form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+			ctx = mockContext(form, "POST", "/comment/1/")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			form = url.Values{}
+			form.Add("id", "2")
+			ctx = authenticatedContext(form, "PUT", "/admin/comments/")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			// Only the commenter who asked is told about the approved reply
+			m := mailer.next()
+			So(m, ShouldNotBeNil)
+			So(m.To, ShouldResemble, []string{"ken@gmail.com"})
+			So(m.Subject, ShouldStartWith, "Rob Pike replied")
+			So(mailer.next(), ShouldBeNil)
+		})
+
+		Convey("Send no mail until the Site URL is set", func() {
+			So(mailer.next(), ShouldNotBeNil)
+			So(model.NewSetting("site_url", "", "blog").Save(), ShouldBeNil)
+			form := url.Values{}
+			form.Add("author", "Rob Pike")
+			form.Add("email", "rob@gmail.com")
+			form.Add("comment", "Me too")
+			form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+			ctx := mockContext(form, "POST", "/comment/1/")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, `"res":true`)
+			So(mailer.next(), ShouldBeNil)
+		})
+
+		Convey("Refuse the replies to a comment which is not approved", func() {
+			So(mailer.next(), ShouldNotBeNil)
+			form := url.Values{}
+			form.Add("author", "Rob Pike")
+			form.Add("email", "rob@gmail.com")
+			form.Add("comment", "Me too")
+			form.Add("pid", "1")
+			form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+			ctx := mockContext(form, "POST", "/comment/1/")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Body.String(), ShouldContainSubstring, "Can not reply to this comment.")
+			_, err := model.GetCommentById(2)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Confirm a subscription by mail", func() {
+			So(mailer.next(), ShouldNotBeNil)
+			So(model.Unsubscribe("ken@gmail.com"), ShouldBeNil)
+			form := url.Values{}
+			form.Add("author", "Ken Thompson")
+			form.Add("email", "ken@gmail.com")
+			form.Add("comment", "Tell me about the replies")
+			form.Add("notify", "1")
+			form.Add("token", commentToken(1, time.Now().Add(-time.Minute)))
+			ctx := mockContext(form, "POST", "/comment/1/")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+
+			// The comment does not subscribe the address on its own
+			unsubscribed, _ := model.IsUnsubscribed("ken@gmail.com")
+			So(unsubscribed, ShouldBeTrue)
+			var m *utils.Mail
+			for m = mailer.next(); m != nil && m.To[0] != "ken@gmail.com"; m = mailer.next() {
+			}
+			So(m, ShouldNotBeNil)
+			So(m.Subject, ShouldStartWith, "Confirm the notifications")
+			raw := m.Body[strings.Index(m.Body, "http://"):]
+			link, err := url.Parse(strings.TrimSpace(raw[:strings.Index(raw, "\n")]))
+			So(err, ShouldBeNil)
+			So(link.Path, ShouldEqual, "/comment/subscribe/")
+
+			So(getFeed(link.Path+"?email=ken@gmail.com&token="+model.UnsubscribeToken("ken@gmail.com")).Code, ShouldEqual, 400)
+			rec := getFeed(link.RequestURI())
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Body.String(), ShouldContainSubstring, "<form")
+			unsubscribed, _ = model.IsUnsubscribed("ken@gmail.com")
+			So(unsubscribed, ShouldBeTrue)
+
+			ctx = mockContext(link.Query(), "POST", link.Path)
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 200)
+			unsubscribed, _ = model.IsUnsubscribed("ken@gmail.com")
+			So(unsubscribed, ShouldBeFalse)
+		})
+
+		Reset(func() {
+			Mailer = nil
+			os.Remove("test.db")
+		})
+	})
+}
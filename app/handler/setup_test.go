@@ -20,6 +20,7 @@ func InitTestApp() *golf.Application {
 	app := golf.New()
 
 	RegisterAdminURLHandlers(app)
+	RegisterAPIHandlers(app)
 	RegisterHomeHandler(app)
 	utils.RegisterFuncMap(app)
 	app.View.FuncMap["Setting"] = model.GetSettingValue
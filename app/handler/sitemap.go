@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// sitemapSize is the number of URLs in a child sitemap, well below the
+// 50,000 allowed by the protocol.
+const sitemapSize = 1000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// defaultRobots is served as robots.txt until the robots_txt setting is set.
+const defaultRobots = "User-agent: *\nDisallow: /admin/"
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapPart is a child sitemap, such as posts-2.xml.
+type sitemapPart struct {
+	Name    string
+	Entries []*model.SitemapEntry
+}
+
+// sitemapParts splits the pages, posts, tags and authors of the site into
+// child sitemaps of sitemapSize entries. The pages start with the home,
+// which changes with the latest post.
+func sitemapParts() ([]*sitemapPart, error) {
+	posts, err := model.GetSitemapPosts(false)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := model.GetSitemapPosts(true)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := model.GetSitemapTags()
+	if err != nil {
+		return nil, err
+	}
+	authors, err := model.GetSitemapAuthors()
+	if err != nil {
+		return nil, err
+	}
+	home := &model.SitemapEntry{Path: "/", UpdatedAt: latestSitemapEntry(posts)}
+	parts := make([]*sitemapPart, 0)
+	for _, section := range []struct {
+		name    string
+		entries []*model.SitemapEntry
+	}{
+		{"pages", append([]*model.SitemapEntry{home}, pages...)},
+		{"posts", posts},
+		{"tags", tags},
+		{"authors", authors},
+	} {
+		for i := 0; i < len(section.entries); i += sitemapSize {
+			end := i + sitemapSize
+			if end > len(section.entries) {
+				end = len(section.entries)
+			}
+			parts = append(parts, &sitemapPart{
+				Name:    section.name + "-" + strconv.Itoa(i/sitemapSize+1) + ".xml",
+				Entries: section.entries[i:end],
+			})
+		}
+	}
+	return parts, nil
+}
+
+func latestSitemapEntry(entries []*model.SitemapEntry) *time.Time {
+	var latest *time.Time
+	for _, e := range entries {
+		if e.UpdatedAt != nil && (latest == nil || e.UpdatedAt.After(*latest)) {
+			latest = e.UpdatedAt
+		}
+	}
+	return latest
+}
+
+func sitemapLastMod(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func writeSitemap(ctx *golf.Context, v interface{}) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	ctx.SetHeader("Content-Type", "application/xml; charset=utf-8")
+	ctx.Send(append([]byte(xml.Header), body...))
+}
+
+// SiteMapHandler serves the sitemap index, which lists the child sitemaps
+// with the last time one of their entries changed.
+func SiteMapHandler(ctx *golf.Context) {
+	parts, err := sitemapParts()
+	if err != nil {
+		panic(err)
+	}
+	base := siteURL(ctx)
+	index := &sitemapIndex{Xmlns: sitemapNamespace}
+	for _, p := range parts {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     base + "/sitemap/" + p.Name,
+			LastMod: sitemapLastMod(latestSitemapEntry(p.Entries)),
+		})
+	}
+	writeSitemap(ctx, index)
+}
+
+// SiteMapPartHandler serves a child sitemap of the index.
+func SiteMapPartHandler(ctx *golf.Context) {
+	parts, err := sitemapParts()
+	if err != nil {
+		panic(err)
+	}
+	base := siteURL(ctx)
+	for _, p := range parts {
+		if p.Name != ctx.Param("name") {
+			continue
+		}
+		set := &sitemapURLSet{Xmlns: sitemapNamespace}
+		for _, e := range p.Entries {
+			set.URLs = append(set.URLs, sitemapURL{Loc: base + e.Path, LastMod: sitemapLastMod(e.UpdatedAt)})
+		}
+		writeSitemap(ctx, set)
+		return
+	}
+	ctx.Abort(404)
+}
+
+// RobotsHandler serves the robots_txt setting, or the default rules, and
+// points the crawlers to the sitemap unless the rules already do.
+func RobotsHandler(ctx *golf.Context) {
+	rules := strings.TrimSpace(model.GetSettingValue("robots_txt"))
+	if rules == "" {
+		rules = defaultRobots
+	}
+	if !strings.Contains(strings.ToLower(rules), "sitemap:") {
+		rules += "\n\nSitemap: " + siteURL(ctx) + "/sitemap.xml"
+	}
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	ctx.Send([]byte(rules + "\n"))
+}
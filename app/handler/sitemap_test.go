@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/xml"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSiteMapHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		So(model.NewSetting("site_url", "http://example.com/", "blog").Save(), ShouldBeNil)
+		u := model.NewUser(email, name)
+		So(u.Create(password), ShouldBeNil)
+		for i := 1; i <= 3; i++ {
+			p := model.NewPost()
+			p.Title = "Post " + strconv.Itoa(i)
+			p.Slug = "post-" + strconv.Itoa(i)
+			p.Markdown = "Hello"
+			p.Html = utils.Markdown2Html(p.Markdown)
+			p.Tags = model.GenerateTagsFromCommaString("Go")
+			p.CreatedBy = u.Id
+			p.IsPage = i == 3
+			p.IsPublished = i > 1
+			So(p.Save(), ShouldBeNil)
+		}
+		post, _ := model.GetPostBySlug("post-2")
+
+		Convey("Get the sitemap index", func() {
+			rec := getFeed("/sitemap.xml")
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/xml")
+			var index sitemapIndex
+			So(xml.Unmarshal(rec.Body.Bytes(), &index), ShouldBeNil)
+			So(index.Sitemaps, ShouldHaveLength, 4)
+			So(index.Sitemaps[0].Loc, ShouldEqual, "http://example.com/sitemap/pages-1.xml")
+			So(index.Sitemaps[1].Loc, ShouldEqual, "http://example.com/sitemap/posts-1.xml")
+			So(index.Sitemaps[1].LastMod, ShouldEqual, sitemapLastMod(post.UpdatedAt))
+			So(index.Sitemaps[2].Loc, ShouldEqual, "http://example.com/sitemap/tags-1.xml")
+			So(index.Sitemaps[3].Loc, ShouldEqual, "http://example.com/sitemap/authors-1.xml")
+		})
+
+		Convey("Get the child sitemaps", func() {
+			locs := func(path string) []string {
+				rec := getFeed(path)
+				So(rec.Code, ShouldEqual, 200)
+				var set sitemapURLSet
+				So(xml.Unmarshal(rec.Body.Bytes(), &set), ShouldBeNil)
+				urls := make([]string, len(set.URLs))
+				for i, u := range set.URLs {
+					So(u.LastMod, ShouldNotBeEmpty)
+					urls[i] = u.Loc
+				}
+				return urls
+			}
+			So(locs("/sitemap/pages-1.xml"), ShouldResemble, []string{"http://example.com/", "http://example.com/post-3/"})
+			So(locs("/sitemap/posts-1.xml"), ShouldResemble, []string{"http://example.com/post-2/"})
+			So(locs("/sitemap/tags-1.xml"), ShouldResemble, []string{"http://example.com/tag/go/"})
+			So(locs("/sitemap/authors-1.xml"), ShouldResemble, []string{"http://example.com/author/" + u.Slug + "/"})
+			So(getFeed("/sitemap/posts-2.xml").Code, ShouldEqual, 404)
+		})
+
+		Convey("Get an author page", func() {
+			So(getFeed("/author/"+u.Slug+"/").Code, ShouldEqual, 200)
+			So(getFeed("/author/nobody/").Code, ShouldEqual, 404)
+		})
+
+		Convey("Get robots.txt", func() {
+			rec := getFeed("/robots.txt")
+			So(rec.Code, ShouldEqual, 200)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(rec.Body.String(), ShouldEqual, "User-agent: *\nDisallow: /admin/\n\nSitemap: http://example.com/sitemap.xml\n")
+
+			So(model.NewSetting("robots_txt", "User-agent: *\nDisallow: /private/\nSitemap: http://cdn.example.com/sitemap.xml", "").Save(), ShouldBeNil)
+			So(getFeed("/robots.txt").Body.String(), ShouldEqual, "User-agent: *\nDisallow: /private/\nSitemap: http://cdn.example.com/sitemap.xml\n")
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
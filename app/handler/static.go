@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	"github.com/dinever/golf"
+)
+
+// The root-relative links of a page, which are made relative to the page.
+var staticLinkPattern = regexp.MustCompile(`((?:href|src|action)\s*=\s*["'])(/(?:[^/"'][^"']*)?)(["'])`)
+
+// StaticExport renders the public pages of the site into plain files, with
+// the templates of the theme, so that it can be served by any file server.
+// The links between the pages are made relative and point to the files, so
+// the site works from any directory and without a server.
+type StaticExport struct {
+	// App serves the pages, its home handlers must be registered.
+	App *golf.Application
+	// Out is the directory the site is written to.
+	Out string
+	// AssetDir holds the assets of the theme, which are copied to Out.
+	AssetDir string
+	// UploadDir holds the uploaded files, which are copied to Out/upload.
+	UploadDir string
+
+	// pages maps the paths of the rendered pages to their files.
+	pages map[string]string
+}
+
+// Run writes the site and returns the paths of the rendered pages.
+func (s *StaticExport) Run() ([]string, error) {
+	paths, err := staticPaths()
+	if err != nil {
+		return nil, err
+	}
+	if err := copyStaticDir(s.AssetDir, s.Out); err != nil {
+		return nil, err
+	}
+	if err := copyStaticDir(s.UploadDir, filepath.Join(s.Out, "upload")); err != nil {
+		return nil, err
+	}
+	s.pages = make(map[string]string)
+	bodies := make(map[string][]byte)
+	for _, p := range paths {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", p, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.App.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return nil, fmt.Errorf("Can not render %s: %d %s", p, rec.Code, http.StatusText(rec.Code))
+		}
+		file := staticFile(p, rec.Header().Get("Content-Type"))
+		s.pages[staticKey(p)] = file
+		bodies[file] = rec.Body.Bytes()
+	}
+	// The links are rewritten once all the pages are known
+	for _, p := range paths {
+		file := s.pages[staticKey(p)]
+		body := bodies[file]
+		if strings.HasSuffix(file, ".html") {
+			body = []byte(s.relink(file, string(body)))
+		}
+		dest := filepath.Join(s.Out, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(dest, body, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// staticPaths lists the published posts and pages, the tag, category,
+// author and home listings with their pages, the feeds, the sitemaps and
+// robots.txt.
+func staticPaths() ([]string, error) {
+	paths := []string{"/"}
+	listing := func(prefix string, total int64) {
+		for page := int64(2); page <= (total+postsPerPage-1)/postsPerPage; page++ {
+			paths = append(paths, prefix+"page/"+strconv.FormatInt(page, 10)+"/")
+		}
+	}
+	posts, err := model.GetAllPostList(false, true, "published_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	listing("/", int64(len(posts)))
+	pages, err := model.GetAllPostList(true, true, "published_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range append(posts, pages...) {
+		paths = append(paths, p.Url()+"/")
+	}
+	tags, err := model.GetAllTags()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		_, pager, err := model.GetPostsByTag(t.Id, 1, postsPerPage, true, "published_at DESC")
+		if err != nil {
+			return nil, err
+		}
+		if pager.Total == 0 {
+			continue
+		}
+		prefix := "/tag/" + url.PathEscape(t.Slug) + "/"
+		paths = append(paths, prefix)
+		listing(prefix, pager.Total)
+	}
+	categories, err := model.GetAllCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range categories {
+		_, pager, err := model.GetPostsByCategory(c.Id, 1, postsPerPage, true, "published_at DESC")
+		if err != nil {
+			return nil, err
+		}
+		if pager.Total == 0 {
+			continue
+		}
+		prefix := "/category/" + url.PathEscape(c.Slug) + "/"
+		paths = append(paths, prefix)
+		listing(prefix, pager.Total)
+	}
+	authors, err := model.GetSitemapAuthors()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range authors {
+		slug, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(a.Path, "/author/"), "/"))
+		u, err := model.GetUserBySlug(slug)
+		if err != nil {
+			return nil, err
+		}
+		_, pager, err := model.GetFilteredPostList(&model.PostFilter{Status: "published", AuthorId: u.Id}, 1, postsPerPage, "published_at DESC")
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, a.Path)
+		listing(a.Path, pager.Total)
+	}
+	paths = append(paths, "/feed/", "/feed/atom/", "/feed/json/", "/sitemap.xml", "/robots.txt")
+	parts, err := sitemapParts()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		paths = append(paths, "/sitemap/"+p.Name)
+	}
+	return paths, nil
+}
+
+// staticKey is how a path is looked up in the rendered pages, whether it
+// is escaped and ends with a slash or not.
+func staticKey(p string) string {
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+	if path.Ext(p) == "" && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+// staticFile is the file a path is written to. A directory gets an index
+// file, which is XML or JSON for the feeds.
+func staticFile(p, contentType string) string {
+	p = strings.TrimPrefix(staticKey(p), "/")
+	if p != "" && !strings.HasSuffix(p, "/") {
+		return p
+	}
+	switch {
+	case strings.Contains(contentType, "xml"):
+		return p + "index.xml"
+	case strings.Contains(contentType, "json"):
+		return p + "index.json"
+	}
+	return p + "index.html"
+}
+
+// relink makes the root-relative links of a page written to file relative
+// to it. Links to other pages point to their index files, the links which
+// are neither a page nor a copied file, such as the search, are kept.
+func (s *StaticExport) relink(file, body string) string {
+	prefix := strings.Repeat("../", strings.Count(file, "/"))
+	return staticLinkPattern.ReplaceAllStringFunc(body, func(m string) string {
+		parts := staticLinkPattern.FindStringSubmatch(m)
+		link, suffix := parts[2], ""
+		if i := strings.IndexAny(link, "?#"); i >= 0 {
+			link, suffix = link[:i], link[i:]
+		}
+		// A query can only be answered by the server
+		target, ok := s.pages[staticKey(link)]
+		if ok && strings.HasPrefix(suffix, "?") {
+			return m
+		}
+		if !ok {
+			if !utils.IsFile(filepath.Join(s.Out, filepath.FromSlash(staticKey(link)))) {
+				return m
+			}
+			target = strings.TrimPrefix(link, "/")
+		}
+		return parts[1] + prefix + target + suffix + parts[3]
+	})
+}
+
+// copyStaticDir copies the files of source into dest, replacing the files
+// which are already there.
+func copyStaticDir(source, dest string) error {
+	if !utils.IsDir(source) {
+		return nil
+	}
+	return filepath.Walk(source, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(source, file)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dest, rel), 0755)
+		}
+		return utils.CopyFile(file, filepath.Join(dest, rel))
+	})
+}
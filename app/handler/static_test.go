@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStaticExport(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		out, _ := ioutil.TempDir("", "dingo-static")
+		assets, _ := ioutil.TempDir("", "dingo-assets")
+		os.MkdirAll(filepath.Join(assets, "css"), 0755)
+		ioutil.WriteFile(filepath.Join(assets, "css", "screen.css"), []byte("body {}"), 0644)
+		author := model.NewUser(email, name)
+		So(author.Create(password), ShouldBeNil)
+		for i := 1; i <= postsPerPage+2; i++ {
+			p := model.NewPost()
+			p.Title = "Post " + strconv.Itoa(i)
+			p.Slug = "post-" + strconv.Itoa(i)
+			p.Markdown = "Hello"
+			p.Html = utils.Markdown2Html(p.Markdown)
+			p.Tags = model.GenerateTagsFromCommaString("Go")
+			p.CreatedBy = author.Id
+			p.IsPublished = i > 1
+			So(p.Save(), ShouldBeNil)
+		}
+		s := &StaticExport{App: InitTestApp(), Out: out, AssetDir: assets, UploadDir: "test-upload"}
+
+		Convey("Export the site", func() {
+			paths, err := s.Run()
+			So(err, ShouldBeNil)
+			So(paths, ShouldContain, "/post-2/")
+			So(paths, ShouldNotContain, "/post-1/")
+			So(paths, ShouldContain, "/tag/go/")
+			So(paths, ShouldContain, "/tag/go/page/2/")
+			So(paths, ShouldContain, "/page/2/")
+			So(paths, ShouldContain, "/author/"+author.Slug+"/")
+			for _, file := range []string{"index.html", "page/2/index.html", "post-2/index.html", "tag/go/index.html", "feed/index.xml", "sitemap.xml", "sitemap/posts-1.xml", "robots.txt", "css/screen.css"} {
+				So(utils.IsFile(filepath.Join(out, file)), ShouldBeTrue)
+			}
+
+			Convey("Make the links relative", func() {
+				html := `<a href="/">Home</a> <a href="/post-2">Post</a> <a href="/tag/go/#top">Go</a>` +
+					`<link href="/css/screen.css" rel="stylesheet"> <form action="/search/"></form> <a href="//example.com/">Out</a>`
+				So(s.relink("tag/go/index.html", html), ShouldEqual,
+					`<a href="../../index.html">Home</a> <a href="../../post-2/index.html">Post</a> <a href="../../tag/go/index.html#top">Go</a>`+
+						`<link href="../../css/screen.css" rel="stylesheet"> <form action="/search/"></form> <a href="//example.com/">Out</a>`)
+				So(s.relink("index.html", `<a href="/feed/">Feed</a>`), ShouldEqual, `<a href="feed/index.xml">Feed</a>`)
+			})
+		})
+
+		Reset(func() {
+			os.RemoveAll(out)
+			os.RemoveAll(assets)
+			os.Remove("test.db")
+		})
+	})
+}
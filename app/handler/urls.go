@@ -4,60 +4,142 @@ import "github.com/dinever/golf"
 
 func RegisterAdminURLHandlers(app *golf.Application) {
 	authChain := golf.NewChain(AuthMiddleware)
+	editorChain := golf.NewChain(AuthMiddleware, EditorMiddleware)
+	adminChain := golf.NewChain(AuthMiddleware, AdminMiddleware)
 	app.Get("/login/", AuthLoginPageHandler)
 	app.Post("/login/", AuthLoginHandler)
+	app.Post("/login/2fa/", TwoFactorLoginHandler)
 
 	app.Get("/signup/", AuthSignUpPageHandler)
 	app.Post("/signup/", AuthSignUpHandler)
 
 	app.Get("/logout/", AuthLogoutHandler)
 
+	app.Get("/forgot/", ForgotPasswordPageHandler)
+	app.Post("/forgot/", ForgotPasswordHandler)
+	app.Get("/reset/:token/", ResetPasswordPageHandler)
+	app.Post("/reset/:token/", ResetPasswordHandler)
+
+	app.Get("/invite/:token/", InvitationPageHandler)
+	app.Post("/invite/:token/", InvitationAcceptHandler)
+
 	app.Get("/admin/", authChain.Final(AdminHandler))
 
 	app.Get("/admin/profile/", authChain.Final(ProfileHandler))
 	app.Post("/admin/profile/", authChain.Final(ProfileChangeHandler))
+	app.Delete("/admin/profile/sessions/", authChain.Final(SessionRemoveHandler))
+	app.Post("/admin/profile/2fa/setup/", authChain.Final(TwoFactorSetupHandler))
+	app.Post("/admin/profile/2fa/", authChain.Final(TwoFactorEnableHandler))
+	app.Post("/admin/profile/2fa/disable/", authChain.Final(TwoFactorDisableHandler))
+	app.Post("/admin/profile/keys/", authChain.Final(ApiKeyCreateHandler))
+	app.Delete("/admin/profile/keys/", authChain.Final(ApiKeyRemoveHandler))
 
 	app.Get("/admin/editor/post/", authChain.Final(PostCreateHandler))
 	app.Post("/admin/editor/post/", authChain.Final(PostSaveHandler))
 
-	app.Get("/admin/editor/page/", authChain.Final(PageCreateHandler))
-	app.Post("/admin/editor/page/", authChain.Final(PageSaveHandler))
+	app.Get("/admin/editor/page/", editorChain.Final(PageCreateHandler))
+	app.Post("/admin/editor/page/", editorChain.Final(PageSaveHandler))
 
+	// Ownership of the post is checked by the handlers
 	app.Get("/admin/posts/", authChain.Final(AdminPostHandler))
 	app.Get("/admin/editor/:id/", authChain.Final(PostEditHandler))
 	app.Post("/admin/editor/:id/", authChain.Final(PostSaveHandler))
 	app.Delete("/admin/editor/:id/", authChain.Final(PostRemoveHandler))
+	app.Get("/admin/editor/:id/revisions/", authChain.Final(PostRevisionsHandler))
+	app.Post("/admin/editor/:id/revisions/:rid/", authChain.Final(PostRevisionRestoreHandler))
+
+	app.Get("/admin/pages/", editorChain.Final(AdminPageHandler))
 
-	app.Get("/admin/pages/", authChain.Final(AdminPageHandler))
+	app.Get("/admin/comments/", editorChain.Final(CommentViewHandler))
+	app.Post("/admin/comments/", editorChain.Final(CommentAddHandler))
+	app.Put("/admin/comments/", editorChain.Final(CommentUpdateHandler))
+	app.Delete("/admin/comments/", editorChain.Final(CommentRemoveHandler))
+	app.Post("/admin/comments/bulk/", editorChain.Final(CommentBulkHandler))
 
-	app.Get("/admin/comments/", authChain.Final(CommentViewHandler))
-	app.Post("/admin/comments/", authChain.Final(CommentAddHandler))
-	app.Put("/admin/comments/", authChain.Final(CommentUpdateHandler))
-	app.Delete("/admin/comments/", authChain.Final(CommentRemoveHandler))
+	app.Get("/admin/categories/", editorChain.Final(CategoryViewHandler))
+	app.Post("/admin/categories/", editorChain.Final(CategorySaveHandler))
+	app.Delete("/admin/categories/", editorChain.Final(CategoryRemoveHandler))
 
-	app.Get("/admin/setting/", authChain.Final(SettingViewHandler))
-	app.Post("/admin/setting/", authChain.Final(SettingUpdateHandler))
-	app.Post("/admin/setting/custom/", authChain.Final(SettingCustomHandler))
-	app.Post("/admin/setting/nav/", authChain.Final(SettingNavHandler))
+	app.Get("/admin/setting/", adminChain.Final(SettingViewHandler))
+	app.Post("/admin/setting/", adminChain.Final(SettingUpdateHandler))
+	app.Post("/admin/setting/custom/", adminChain.Final(SettingCustomHandler))
+	app.Post("/admin/setting/nav/", adminChain.Final(SettingNavHandler))
+	app.Get("/admin/setting/export/", adminChain.Final(ExportHandler))
+	app.Post("/admin/setting/import/", adminChain.Final(ImportHandler))
+	app.Post("/admin/setting/import/markdown/", adminChain.Final(MarkdownImportHandler))
+	app.Post("/admin/setting/backup/", adminChain.Final(BackupHandler))
 	//
-	app.Get("/admin/files/", authChain.Final(FileViewHandler))
-	app.Delete("/admin/files/", authChain.Final(FileRemoveHandler))
-	app.Post("/admin/files/upload/", authChain.Final(FileUploadHandler))
+	app.Get("/admin/files/", adminChain.Final(FileViewHandler))
+	app.Delete("/admin/files/", adminChain.Final(FileRemoveHandler))
+	app.Post("/admin/files/upload/", adminChain.Final(FileUploadHandler))
+
+	app.Get("/admin/users/", adminChain.Final(UserViewHandler))
+	app.Post("/admin/users/", adminChain.Final(UserInviteHandler))
+	app.Put("/admin/users/", adminChain.Final(UserUpdateHandler))
+	app.Delete("/admin/users/", adminChain.Final(UserRemoveHandler))
+	app.Delete("/admin/users/invitations/", adminChain.Final(InvitationRemoveHandler))
 
 	app.Get("/admin/password/", authChain.Final(AdminPasswordPage))
 	app.Post("/admin/password/", authChain.Final(AdminPasswordChange))
 
-	app.Get("/admin/monitor/", authChain.Final(AdminMonitorPage))
+	app.Get("/admin/monitor/", adminChain.Final(AdminMonitorPage))
+}
+
+func RegisterAPIHandlers(app *golf.Application) {
+	apiChain := golf.NewChain(APIAuthMiddleware)
+	apiEditorChain := golf.NewChain(APIAuthMiddleware, EditorMiddleware)
+	apiAdminChain := golf.NewChain(APIAuthMiddleware, AdminMiddleware)
+
+	// Ownership of the post is checked by the handlers
+	app.Get("/api/v1/posts/", apiChain.Final(APIPostListHandler))
+	app.Post("/api/v1/posts/", apiChain.Final(APIPostCreateHandler))
+	app.Get("/api/v1/posts/:id/", apiChain.Final(APIPostHandler))
+	app.Put("/api/v1/posts/:id/", apiChain.Final(APIPostUpdateHandler))
+	app.Delete("/api/v1/posts/:id/", apiChain.Final(APIPostRemoveHandler))
+
+	app.Get("/api/v1/pages/", apiEditorChain.Final(APIPageListHandler))
+	app.Post("/api/v1/pages/", apiEditorChain.Final(APIPageCreateHandler))
+	app.Get("/api/v1/pages/:id/", apiEditorChain.Final(APIPageHandler))
+	app.Put("/api/v1/pages/:id/", apiEditorChain.Final(APIPageUpdateHandler))
+	app.Delete("/api/v1/pages/:id/", apiEditorChain.Final(APIPageRemoveHandler))
+
+	app.Get("/api/v1/tags/", apiChain.Final(APITagListHandler))
+
+	app.Get("/api/v1/comments/", apiEditorChain.Final(APICommentListHandler))
+	app.Get("/api/v1/comments/:id/", apiEditorChain.Final(APICommentHandler))
+	app.Put("/api/v1/comments/:id/", apiEditorChain.Final(APICommentUpdateHandler))
+	app.Delete("/api/v1/comments/:id/", apiEditorChain.Final(APICommentRemoveHandler))
+
+	app.Get("/api/v1/settings/", apiAdminChain.Final(APISettingListHandler))
+	app.Put("/api/v1/settings/", apiAdminChain.Final(APISettingUpdateHandler))
 }
 
 func RegisterHomeHandler(app *golf.Application) {
 	statsChain := golf.NewChain()
 	app.Get("/", statsChain.Final(HomeHandler))
 	app.Get("/page/:page/", HomeHandler)
+	app.Get("/comment/unsubscribe/", UnsubscribeHandler)
+	app.Post("/comment/unsubscribe/", UnsubscribeHandler)
+	app.Get("/comment/subscribe/", SubscribeHandler)
+	app.Post("/comment/subscribe/", SubscribeHandler)
+	app.Get("/comment/:id/", CommentTreeHandler)
 	app.Post("/comment/:id/", CommentHandler)
 	app.Get("/tag/:tag/", TagHandler)
 	app.Get("/tag/:tag/page/:page/", TagHandler)
-	app.Get("/feed/", RssHandler)
+	app.Get("/category/:slug/", CategoryHandler)
+	app.Get("/category/:slug/page/:page/", CategoryHandler)
+	app.Get("/search/", SearchHandler)
+	app.Get("/feed/", FeedHandler)
+	app.Get("/feed/:format/", FeedHandler)
+	app.Get("/tag/:tag/feed/", TagFeedHandler)
+	app.Get("/tag/:tag/feed/:format/", TagFeedHandler)
+	app.Get("/author/:author/", AuthorHandler)
+	app.Get("/author/:author/page/:page/", AuthorHandler)
+	app.Get("/author/:author/feed/", AuthorFeedHandler)
+	app.Get("/author/:author/feed/:format/", AuthorFeedHandler)
 	app.Get("/sitemap.xml", SiteMapHandler)
+	app.Get("/sitemap/:name", SiteMapPartHandler)
+	app.Get("/robots.txt", RobotsHandler)
+	app.Post("/webmention", WebmentionHandler)
 	app.Get("/:slug/", statsChain.Final(ContentHandler))
 }
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/model"
+	"github.com/dinever/golf"
+)
+
+// webmentionLink advertises the webmention endpoint of the site.
+func webmentionLink(ctx *golf.Context) string {
+	return "<" + siteURL(ctx) + "/webmention>; rel=\"webmention\""
+}
+
+func webmentionError(ctx *golf.Context, status int, msg string) {
+	ctx.SendStatus(status)
+	ctx.JSON(map[string]interface{}{
+		"status": "error",
+		"msg":    msg,
+	})
+}
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// webmentionPost returns the published post target is the url of, or nil.
+// The target must be on the host of the Site URL, the host of the request is
+// sent by the client.
+func webmentionPost(target string) *model.Post {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil
+	}
+	site, err := url.Parse(model.GetSettingValue("site_url"))
+	if err != nil || site.Host == "" || !strings.EqualFold(u.Host, site.Host) {
+		return nil
+	}
+	slug := strings.Trim(u.Path, "/")
+	if slug == "" || strings.Contains(slug, "/") {
+		return nil
+	}
+	post, err := model.GetPostBySlug(slug)
+	if err != nil || !post.IsPublished {
+		return nil
+	}
+	return post
+}
+
+// WebmentionHandler receives the webmentions of the posts. The source is
+// fetched right away, and the mention is stored as a comment of type
+// webmention which waits for a moderator like any comment. A source which
+// sends it again updates it, a changed mention goes through the same checks
+// as a new one and waits for a moderator again. It is deleted once its
+// source does not link to the post anymore.
+func WebmentionHandler(ctx *golf.Context) {
+	source := ctx.Request.FormValue("source")
+	target := ctx.Request.FormValue("target")
+	if !isWebURL(source) || !isWebURL(target) || source == target {
+		webmentionError(ctx, 400, "Source and target must be different http or https URLs.")
+		return
+	}
+	post := webmentionPost(target)
+	if post == nil {
+		webmentionError(ctx, 400, "The target is not a post of this site.")
+		return
+	}
+	if !post.CommentsOpen() {
+		webmentionError(ctx, 400, "The post does not take webmentions.")
+		return
+	}
+	ip := clientIp(ctx.Request)
+	if byIp, _, err := model.CountRecentComments(ip, "", time.Now().Add(-commentRateWindow)); err == nil && byIp >= commentRateIp {
+		webmentionError(ctx, 429, "Too many webmentions, please wait a few minutes.")
+		return
+	}
+	existing, err := model.GetWebmention(post.Id, source)
+	if err != nil {
+		panic(err)
+	}
+	c, err := model.FetchWebmention(source, target)
+	if err == model.ErrWebmentionNoLink && existing != nil {
+		if err := model.DeleteComment(existing.Id); err != nil {
+			panic(err)
+		}
+		// A mention is counted unless it was taken for spam
+		if existing.Status != model.CommentSpam {
+			if err := model.DecrementCommentNum(post.Id); err != nil {
+				log.Printf("[Error]: Can not decrease comment count for post %v: %v", post.Id, err)
+			}
+		}
+	}
+	if err != nil {
+		webmentionError(ctx, 400, err.Error())
+		return
+	}
+	c.PostId = post.Id
+	c.Ip = ip
+	c.UserAgent = ctx.Request.UserAgent()
+	if model.IsCommentBlocked(c) {
+		webmentionError(ctx, 400, "Can not mention this post.")
+		return
+	}
+	if existing != nil {
+		if existing.Author == c.Author && existing.Content == c.Content {
+			ctx.JSON(map[string]interface{}{
+				"status": "success",
+			})
+			return
+		}
+		// A changed mention is dated from its change, and waits for a
+		// moderator again
+		existing.Author, existing.Content = c.Author, c.Content
+		existing.Ip, existing.UserAgent, existing.CreatedAt = c.Ip, c.UserAgent, c.CreatedAt
+		existing.SetStatus(model.CommentPending)
+		c = existing
+	}
+	if SpamChecker != nil {
+		spam, err := SpamChecker.IsSpam(c)
+		if err != nil {
+			log.Printf("[Error]: Can not check webmention for spam: %v", err)
+		}
+		if spam {
+			c.SetStatus(model.CommentSpam)
+		}
+	}
+	if err := c.Save(); err != nil {
+		panic(err)
+	}
+	if existing != nil {
+		ctx.JSON(map[string]interface{}{
+			"status": "success",
+		})
+		return
+	}
+	ctx.SendStatus(201)
+	ctx.JSON(map[string]interface{}{
+		"status": "success",
+	})
+	if c.Status == model.CommentSpam {
+		return
+	}
+	if err := model.IncrementCommentNum(post.Id); err != nil {
+		log.Printf("[Error]: Can not increase comment count for post %v: %v", post.Id, err)
+	}
+	notifyNewComment(post, c)
+	if err := model.NewMessage("comment", c).Save(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/dinever/dingo/app/model"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebmentionHandler(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		model.Initialize("test.db", true)
+		So(model.NewSetting("site_url", "http://example.com", "blog").Save(), ShouldBeNil)
+		form := url.Values{}
+		form.Add("title", "Hello World")
+		form.Add("slug", "hello-world")
+		form.Add("content", "Sample content")
+		form.Add("comment", "on")
+		form.Add("status", "on")
+		ctx := authenticatedContext(form, "POST", "/admin/editor/post/")
+		app := ctx.App
+		app.ServeHTTP(ctx.Response, ctx.Request)
+
+		linked := true
+		title := "Reply to Hello"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<title>%s</title><span class="p-author">Rob</span>`, title)
+			if linked {
+				fmt.Fprintf(w, `<a href="http://example.com/hello-world/">Hello</a>`)
+			}
+		}))
+		client := model.WebmentionClient
+		model.WebmentionClient = &http.Client{}
+		mention := func(source, target string) *httptest.ResponseRecorder {
+			form := url.Values{}
+			form.Add("source", source)
+			form.Add("target", target)
+			ctx := mockContext(form, "POST", "/webmention")
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			return ctx.Response.(*httptest.ResponseRecorder)
+		}
+
+		Convey("Receive a webmention", func() {
+			rec := mention(server.URL, "http://example.com/hello-world/")
+			So(rec.Code, ShouldEqual, 201)
+			c, err := model.GetWebmention(1, server.URL)
+			So(err, ShouldBeNil)
+			So(c.Type, ShouldEqual, model.CommentWebmention)
+			So(c.Author, ShouldEqual, "Rob")
+			So(c.Website, ShouldEqual, server.URL)
+			So(c.Content, ShouldEqual, "Reply to Hello")
+			So(c.Status, ShouldEqual, model.CommentPending)
+			post, _ := model.GetPostById(1)
+			So(post.CommentNum, ShouldEqual, 1)
+
+			Convey("Update it when it is sent again", func() {
+				So(mention(server.URL, "http://example.com/hello-world/").Code, ShouldEqual, 200)
+				counts, _ := model.GetCommentCounts()
+				So(counts[model.CommentPending], ShouldEqual, 1)
+				p, _ := model.GetPostById(1)
+				So(p.CommentNum, ShouldEqual, 1)
+				So(p.UpdatedAt.Equal(*post.UpdatedAt), ShouldBeTrue)
+			})
+
+			Convey("Moderate a changed mention again", func() {
+				c.SetStatus(model.CommentApproved)
+				So(c.Save(), ShouldBeNil)
+				So(mention(server.URL, "http://example.com/hello-world/").Code, ShouldEqual, 200)
+				c, _ := model.GetWebmention(1, server.URL)
+				So(c.Status, ShouldEqual, model.CommentApproved)
+
+				title = "Cheap casino"
+				So(model.NewSetting("comment_blocklist", "casino", "").Save(), ShouldBeNil)
+				So(mention(server.URL, "http://example.com/hello-world/").Code, ShouldEqual, 400)
+				c, _ = model.GetWebmention(1, server.URL)
+				So(c.Content, ShouldEqual, "Reply to Hello")
+				So(c.Status, ShouldEqual, model.CommentApproved)
+
+				title = "Reply to Hello, again"
+				So(mention(server.URL, "http://example.com/hello-world/").Code, ShouldEqual, 200)
+				c, _ = model.GetWebmention(1, server.URL)
+				So(c.Content, ShouldEqual, "Reply to Hello, again")
+				So(c.Status, ShouldEqual, model.CommentPending)
+			})
+
+			Convey("Delete it once the source does not link anymore", func() {
+				linked = false
+				rec := mention(server.URL, "http://example.com/hello-world/")
+				So(rec.Code, ShouldEqual, 400)
+				So(rec.Body.String(), ShouldContainSubstring, "does not link")
+				c, err := model.GetWebmention(1, server.URL)
+				So(err, ShouldBeNil)
+				So(c, ShouldBeNil)
+				post, _ := model.GetPostById(1)
+				So(post.CommentNum, ShouldEqual, 0)
+			})
+		})
+
+		Convey("Refuse the webmentions of other targets", func() {
+			So(mention("ftp://example.org/", "http://example.com/hello-world/").Code, ShouldEqual, 400)
+			So(mention(server.URL, "http://example.org/hello-world/").Code, ShouldEqual, 400)
+			So(mention(server.URL, "http://example.com/missing/").Code, ShouldEqual, 400)
+			// The host of the request does not make a target of this site
+			form := url.Values{}
+			form.Add("source", server.URL)
+			form.Add("target", "http://example.org/hello-world/")
+			ctx := mockContext(form, "POST", "/webmention")
+			ctx.Request.Host = "example.org"
+			app.ServeHTTP(ctx.Response, ctx.Request)
+			So(ctx.Response.(*httptest.ResponseRecorder).Code, ShouldEqual, 400)
+			linked = false
+			So(mention(server.URL, "http://example.com/hello-world/").Code, ShouldEqual, 400)
+			counts, _ := model.GetCommentCounts()
+			So(counts[model.CommentPending], ShouldEqual, 0)
+		})
+
+		Convey("Advertise the endpoint", func() {
+			rec := getFeed("/hello-world/")
+			So(rec.Header().Get("Link"), ShouldEqual, `<http://example.com/webmention>; rel="webmention"`)
+		})
+
+		Reset(func() {
+			model.WebmentionClient = client
+			server.Close()
+			os.Remove("test.db")
+		})
+	})
+}
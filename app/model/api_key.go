@@ -0,0 +1,118 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// ApiKey authenticates the requests of a user to the JSON API. Like tokens,
+// only the hash of the key is stored, the key itself is shown once.
+type ApiKey struct {
+	Id         int64
+	Key        string `json:"-"`
+	Prefix     string
+	Name       string
+	UserId     int64
+	CreatedAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// apiKeyPrefixLength is the length of the beginning of the key which is kept
+// to tell keys apart.
+const apiKeyPrefixLength = 8
+
+func NewApiKey(u *User, name string) *ApiKey {
+	key := utils.RandomToken(20)
+	return &ApiKey{
+		Key:       key,
+		Prefix:    key[:apiKeyPrefixLength],
+		Name:      strings.TrimSpace(name),
+		UserId:    u.Id,
+		CreatedAt: utils.Now(),
+	}
+}
+
+func (k *ApiKey) Save() error {
+	if k.Name == "" {
+		return fmt.Errorf("Name can not be empty")
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	k.Id, err = writeDB.Insert(stmtInsertApiKey, utils.Sha256(k.Key), k.Prefix, k.Name, k.UserId, k.CreatedAt, k.LastUsedAt)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// Touch records that the key has just been used. Like tokens, it is written
+// at most once a minute.
+func (k *ApiKey) Touch() error {
+	now := utils.Now()
+	if k.LastUsedAt != nil && now.Sub(*k.LastUsedAt) < lastSeenInterval {
+		return nil
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtUpdateApiKeyLastUsed, now, k.Id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	k.LastUsedAt = now
+	return writeDB.Commit()
+}
+
+func scanApiKey(row Row, k *ApiKey) error {
+	return row.Scan(&k.Id, &k.Prefix, &k.Name, &k.UserId, &k.CreatedAt, &k.LastUsedAt)
+}
+
+func GetApiKeyByKey(key string) (*ApiKey, error) {
+	k := new(ApiKey)
+	row := db.QueryRow(stmtGetApiKeyByHash, utils.Sha256(key))
+	if err := scanApiKey(row, k); err != nil {
+		return nil, err
+	}
+	k.Key = key
+	return k, nil
+}
+
+func GetApiKeysByUserId(userId int64) ([]*ApiKey, error) {
+	rows, err := db.Query(stmtGetApiKeysByUserId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*ApiKey, 0)
+	for rows.Next() {
+		k := new(ApiKey)
+		if err := scanApiKey(rows, k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteApiKeyById revokes a key, the key must belong to the given user.
+func DeleteApiKeyById(id, userId int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeleteApiKeyById, id, userId); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApiKey(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Test API Key", func() {
+			user := mockUser()
+			So(user.Create(password), ShouldBeNil)
+
+			So(NewApiKey(user, " ").Save(), ShouldNotBeNil)
+
+			key := NewApiKey(user, "Deploy script")
+			So(key.Save(), ShouldBeNil)
+			So(key.Key, ShouldHaveLength, 40)
+			So(key.Prefix, ShouldEqual, key.Key[:8])
+
+			Convey("Get API Key", func() {
+				k, err := GetApiKeyByKey(key.Key)
+				So(err, ShouldBeNil)
+				So(k.Id, ShouldEqual, key.Id)
+				So(k.UserId, ShouldEqual, user.Id)
+				So(k.Name, ShouldEqual, "Deploy script")
+
+				_, err = GetApiKeyByKey(key.Prefix)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Touch API Key", func() {
+				So(key.LastUsedAt, ShouldBeNil)
+				So(key.Touch(), ShouldBeNil)
+				k, _ := GetApiKeyByKey(key.Key)
+				So(k.LastUsedAt, ShouldNotBeNil)
+			})
+
+			Convey("Delete API Key", func() {
+				other := NewUser("author@example.com", "Author")
+				So(other.Create(password), ShouldBeNil)
+				// Only the owner of the key can revoke it
+				So(DeleteApiKeyById(key.Id, other.Id), ShouldBeNil)
+				keys, _ := GetApiKeysByUserId(user.Id)
+				So(keys, ShouldHaveLength, 1)
+
+				So(DeleteApiKeyById(key.Id, user.Id), ShouldBeNil)
+				keys, _ = GetApiKeysByUserId(user.Id)
+				So(keys, ShouldHaveLength, 0)
+			})
+
+			Convey("Delete User", func() {
+				other := NewUser("author@example.com", "Author")
+				So(other.Create(password), ShouldBeNil)
+				So(DeleteUserById(user.Id, other.Id), ShouldBeNil)
+				_, err := GetApiKeyByKey(key.Key)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
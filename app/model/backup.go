@@ -0,0 +1,285 @@
+package model
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/model/sql_builder"
+)
+
+const (
+	backupPrefix   = "dingo-"
+	backupSuffix   = ".tar.gz"
+	backupDatabase = "dingo.db"
+	backupUploads  = "upload"
+)
+
+// Backups takes backups of the site into Dir. A backup is a gzipped tar
+// archive of a snapshot of the database, as dingo.db, and of the files in
+// UploadDir, under upload/. Only the Keep newest archives are kept, all of
+// them if Keep is 0.
+type Backups struct {
+	Dir       string
+	UploadDir string
+	Keep      int
+}
+
+// Run takes a backup and prunes the old ones. The outcome is recorded as a
+// backup message.
+func (b *Backups) Run() (string, error) {
+	path, err := b.create()
+	if err == nil {
+		_, err = b.Prune()
+	}
+	if err != nil {
+		NewMessage("backup", "[0]"+err.Error()).Save()
+		return path, err
+	}
+	NewMessage("backup", "[1]"+path).Save()
+	return path, nil
+}
+
+// RunIfOlder takes a backup if the newest one is older than age.
+func (b *Backups) RunIfOlder(age time.Duration) error {
+	files := b.List()
+	if len(files) > 0 && time.Since(*files[0].ModTime) < age {
+		return nil
+	}
+	_, err := b.Run()
+	return err
+}
+
+// List returns the backups, the newest first.
+func (b *Backups) List() []*File {
+	files := make([]*File, 0)
+	for _, f := range GetFileList(b.Dir) {
+		if isBackup(f.Name()) {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() > files[j].Name()
+	})
+	return files
+}
+
+// Prune removes the backups beyond the Keep newest ones and returns their
+// paths.
+func (b *Backups) Prune() ([]string, error) {
+	removed := make([]string, 0)
+	if b.Keep <= 0 {
+		return removed, nil
+	}
+	files := b.List()
+	for i := b.Keep; i < len(files); i++ {
+		if err := os.Remove(files[i].Url); err != nil {
+			return removed, err
+		}
+		removed = append(removed, files[i].Url)
+	}
+	return removed, nil
+}
+
+func isBackup(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix)
+}
+
+func (b *Backups) create() (string, error) {
+	if err := os.MkdirAll(b.Dir, 0700); err != nil {
+		return "", err
+	}
+	snapshot, err := ioutil.TempFile(b.Dir, ".snapshot-")
+	if err != nil {
+		return "", err
+	}
+	snapshot.Close()
+	// VACUUM INTO refuses to overwrite a file
+	os.Remove(snapshot.Name())
+	defer os.Remove(snapshot.Name())
+	if err := SnapshotDatabase(snapshot.Name()); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(b.Dir, backupPrefix+time.Now().Format("20060102-150405")+backupSuffix)
+	tmp, err := ioutil.TempFile(b.Dir, ".archive-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeBackup(tmp, snapshot.Name(), b.UploadDir); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// CanSnapshotDatabase reports whether the database can be backed up.
+func CanSnapshotDatabase() bool {
+	return db.dialect == SQL.SQLite
+}
+
+// SnapshotDatabase writes a consistent copy of the database to path, which
+// must not exist yet. Only SQLite databases can be copied, PostgreSQL and
+// MySQL have their own backup tools.
+func SnapshotDatabase(path string) error {
+	if !CanSnapshotDatabase() {
+		return fmt.Errorf("backups of %s databases are not supported, use the tools of the database", db.dialect.Name)
+	}
+	_, err := db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+func writeBackup(w io.Writer, snapshot, uploadDir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := addToArchive(tw, snapshot, backupDatabase); err != nil {
+		return err
+	}
+	if _, err := os.Stat(uploadDir); err == nil {
+		err = filepath.Walk(uploadDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(uploadDir, path)
+			if err != nil {
+				return err
+			}
+			return addToArchive(tw, path, filepath.ToSlash(filepath.Join(backupUploads, rel)))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addToArchive(tw *tar.Writer, path, name string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+		return tw.WriteHeader(header)
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// RestoreBackup replaces the SQLite database of the data source name and
+// the upload directory with the content of a backup archive. Dingo must
+// not be running while a backup is restored.
+func RestoreBackup(archive, dsn, uploadDir string) error {
+	dialect, dbPath, err := parseDSN(dsn)
+	if err != nil {
+		return err
+	}
+	if dialect != SQL.SQLite {
+		return fmt.Errorf("backups of %s databases are not supported, use the tools of the database", dialect.Name)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	dbTmp := dbPath + ".restore"
+	uploadTmp := filepath.Clean(uploadDir) + ".restore"
+	defer os.Remove(dbTmp)
+	defer os.RemoveAll(uploadTmp)
+	os.RemoveAll(uploadTmp)
+	if err := os.MkdirAll(uploadTmp, os.ModePerm); err != nil {
+		return err
+	}
+
+	foundDatabase := false
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(header.Name, "/")
+		switch {
+		case name == backupDatabase:
+			foundDatabase = true
+			err = extractFile(tr, dbTmp, 0600)
+		case name == backupUploads:
+		case strings.HasPrefix(name, backupUploads+"/"):
+			rel := filepath.FromSlash(strings.TrimPrefix(name, backupUploads+"/"))
+			if rel != filepath.Clean(rel) || filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("invalid file %q in backup", header.Name)
+			}
+			target := filepath.Join(uploadTmp, rel)
+			if header.Typeflag == tar.TypeDir {
+				err = os.MkdirAll(target, os.ModePerm)
+			} else if header.Typeflag == tar.TypeReg {
+				if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err == nil {
+					err = extractFile(tr, target, os.FileMode(header.Mode).Perm())
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !foundDatabase {
+		return errors.New("the backup does not contain a database")
+	}
+
+	if err := os.Rename(dbTmp, dbPath); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(uploadDir); err != nil {
+		return err
+	}
+	return os.Rename(uploadTmp, uploadDir)
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackups(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		p := mockPost()
+		So(p.Save(), ShouldBeNil)
+		os.MkdirAll(filepath.Join("test-upload", "2016", "05"), os.ModePerm)
+		ioutil.WriteFile(filepath.Join("test-upload", "2016", "05", "dingo.png"), []byte("png"), 0644)
+		b := &Backups{Dir: "test-backup", UploadDir: "test-upload", Keep: 2}
+
+		Convey("Take a backup", func() {
+			path, err := b.Run()
+			So(err, ShouldBeNil)
+			So(filepath.Dir(path), ShouldEqual, "test-backup")
+			So(b.List(), ShouldHaveLength, 1)
+			So(GetUnreadMessages()[0].Data, ShouldContainSubstring, path)
+
+			Convey("Recent backups are not taken again", func() {
+				So(b.RunIfOlder(time.Hour), ShouldBeNil)
+				So(b.List(), ShouldHaveLength, 1)
+			})
+
+			Convey("Restore the backup", func() {
+				other := mockPost()
+				other.Slug = "other"
+				So(other.Save(), ShouldBeNil)
+				os.RemoveAll("test-upload")
+				ioutil.WriteFile("test-upload", []byte("not a directory"), 0644)
+				db.Close()
+
+				So(RestoreBackup(path, "test.db", "test-upload"), ShouldBeNil)
+				Initialize("test.db", true)
+				posts, _ := GetAllPostList(false, false, "id")
+				So(posts, ShouldHaveLength, 1)
+				So(posts[0].UUID, ShouldEqual, p.UUID)
+				data, err := ioutil.ReadFile(filepath.Join("test-upload", "2016", "05", "dingo.png"))
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, "png")
+			})
+		})
+
+		Convey("Prune old backups", func() {
+			os.MkdirAll("test-backup", 0700)
+			for _, name := range []string{"dingo-20160501-000000.tar.gz", "dingo-20160502-000000.tar.gz", "dingo-20160503-000000.tar.gz", "notes.txt"} {
+				ioutil.WriteFile(filepath.Join("test-backup", name), nil, 0600)
+			}
+			removed, err := b.Prune()
+			So(err, ShouldBeNil)
+			So(removed, ShouldResemble, []string{"test-backup/dingo-20160501-000000.tar.gz"})
+			So(b.List()[0].Name(), ShouldEqual, "dingo-20160503-000000.tar.gz")
+			_, err = os.Stat(filepath.Join("test-backup", "notes.txt"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Refuse archives without a database", func() {
+			So(RestoreBackup("backup_test.go", "test.db", "test-upload"), ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+			os.RemoveAll("test-upload")
+			os.RemoveAll("test-backup")
+		})
+	})
+}
@@ -0,0 +1,393 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+	"github.com/twinj/uuid"
+)
+
+type Category struct {
+	Id              int64
+	UUID            string
+	Name            string
+	Slug            string
+	Description     string
+	ParentId        int64
+	MetaTitle       string
+	MetaDescription string
+	CreatedAt       *time.Time
+	CreatedBy       int64
+	UpdatedAt       *time.Time
+	UpdatedBy       int64
+	// Depth is the nesting level of the category, it is only set by GetCategoryTree.
+	Depth int
+}
+
+func NewCategory(name, slug string) *Category {
+	return &Category{
+		UUID:      uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen),
+		Name:      name,
+		Slug:      slug,
+		CreatedAt: utils.Now(),
+	}
+}
+
+func (c *Category) Url() string {
+	return "/category/" + c.Slug
+}
+
+// Indent returns the name of the category prefixed according to its depth,
+// which is used to display the category tree in a flat list.
+func (c *Category) Indent() string {
+	return strings.Repeat("— ", c.Depth) + c.Name
+}
+
+func (c *Category) Parent() *Category {
+	if c.ParentId < 1 {
+		return nil
+	}
+	parent, err := GetCategoryById(c.ParentId)
+	if err != nil {
+		return nil
+	}
+	return parent
+}
+
+// Ancestors returns the parents of the category, from the root to the direct parent.
+func (c *Category) Ancestors() []*Category {
+	ancestors := make([]*Category, 0)
+	visited := map[int64]bool{c.Id: true}
+	for parent := c.Parent(); parent != nil && !visited[parent.Id]; parent = parent.Parent() {
+		visited[parent.Id] = true
+		ancestors = append([]*Category{parent}, ancestors...)
+	}
+	return ancestors
+}
+
+func (c *Category) Children() []*Category {
+	categories, err := GetCategoriesByParentId(c.Id)
+	if err != nil {
+		return make([]*Category, 0)
+	}
+	return categories
+}
+
+func (c *Category) PostCount() int64 {
+	count, _ := GetNumberOfPostsByCategory(c.Id, false)
+	return count
+}
+
+func (c *Category) Save() error {
+	c.Slug = strings.Trim(c.Slug, "/")
+	if c.Slug == "" {
+		return fmt.Errorf("Slug can not be empty or root")
+	}
+	if c.Id == 0 {
+		return c.Insert()
+	}
+	if c.ParentId > 0 {
+		ids, err := GetCategoryDescendantIds(c.Id)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if id == c.ParentId {
+				return fmt.Errorf("A category can not be the parent of itself or its ancestors")
+			}
+		}
+	}
+	return c.Update()
+}
+
+func (c *Category) Insert() error {
+	if _, err := GetCategoryBySlug(c.Slug); err == nil {
+		c.Slug = generateUniqueSlug(c.Slug, "categories", 2)
+	}
+	if c.UUID == "" {
+		c.UUID = uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen)
+	}
+	c.UpdatedAt = c.CreatedAt
+	c.UpdatedBy = c.CreatedBy
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	c.Id, err = writeDB.Insert(stmtInsertCategory, c.UUID, c.Name, c.Slug, c.Description, c.ParentId, c.MetaTitle, c.MetaDescription, c.CreatedAt, c.CreatedBy, c.UpdatedAt, c.UpdatedBy)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func (c *Category) Update() error {
+	if old, err := GetCategoryBySlug(c.Slug); err == nil && old.Id != c.Id {
+		c.Slug = generateUniqueSlug(c.Slug, "categories", 2)
+	}
+	c.UpdatedAt = utils.Now()
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtUpdateCategory, c.Name, c.Slug, c.Description, c.ParentId, c.MetaTitle, c.MetaDescription, c.UpdatedAt, c.UpdatedBy, c.Id)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// DeleteCategoryById removes a category. Its children are moved to its parent
+// and its posts become uncategorized.
+func DeleteCategoryById(id int64) error {
+	category, err := GetCategoryById(id)
+	if err != nil {
+		return err
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtUpdateCategoryParent, category.ParentId, id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeletePostCategoriesByCategoryId, id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeleteCategoryById, id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func scanCategory(row Row, c *Category) error {
+	var (
+		nullDescription     sql.NullString
+		nullParentId        sql.NullInt64
+		nullMetaTitle       sql.NullString
+		nullMetaDescription sql.NullString
+		nullUpdatedBy       sql.NullInt64
+	)
+	err := row.Scan(&c.Id, &c.UUID, &c.Name, &c.Slug, &nullDescription, &nullParentId, &nullMetaTitle, &nullMetaDescription, &c.CreatedAt, &c.CreatedBy, &c.UpdatedAt, &nullUpdatedBy)
+	c.Description = nullDescription.String
+	c.ParentId = nullParentId.Int64
+	c.MetaTitle = nullMetaTitle.String
+	c.MetaDescription = nullMetaDescription.String
+	c.UpdatedBy = nullUpdatedBy.Int64
+	return err
+}
+
+func extractCategories(rows *sql.Rows) ([]*Category, error) {
+	categories := make([]*Category, 0)
+	for rows.Next() {
+		c := new(Category)
+		if err := scanCategory(rows, c); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, nil
+}
+
+func GetCategoryById(id int64) (*Category, error) {
+	c := new(Category)
+	row := db.QueryRow(stmtGetCategoryById, id)
+	if err := scanCategory(row, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func GetCategoryBySlug(slug string) (*Category, error) {
+	c := new(Category)
+	row := db.QueryRow(stmtGetCategoryBySlug, slug)
+	if err := scanCategory(row, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func GetCategoryByUUID(uuid string) (*Category, error) {
+	c := new(Category)
+	row := db.QueryRow(stmtGetCategoryByUUID, uuid)
+	if err := scanCategory(row, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func GetCategoryByPostId(postId int64) (*Category, error) {
+	c := new(Category)
+	row := db.QueryRow(stmtGetCategoryByPostId, postId)
+	if err := scanCategory(row, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func GetAllCategories() ([]*Category, error) {
+	rows, err := db.Query(stmtGetAllCategories)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractCategories(rows)
+}
+
+func GetCategoriesByParentId(parentId int64) ([]*Category, error) {
+	rows, err := db.Query(stmtGetCategoriesByParentId, parentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractCategories(rows)
+}
+
+// GetCategoryTree returns all categories ordered depth-first, with Depth set,
+// so that every category directly follows its parent.
+func GetCategoryTree() ([]*Category, error) {
+	categories, err := GetAllCategories()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[int64]bool)
+	for _, c := range categories {
+		exists[c.Id] = true
+	}
+	children := make(map[int64][]*Category)
+	for _, c := range categories {
+		parentId := c.ParentId
+		if !exists[parentId] {
+			parentId = 0
+		}
+		children[parentId] = append(children[parentId], c)
+	}
+	tree := make([]*Category, 0, len(categories))
+	var walk func(parentId int64, depth int)
+	walk = func(parentId int64, depth int) {
+		for _, c := range children[parentId] {
+			c.Depth = depth
+			tree = append(tree, c)
+			walk(c.Id, depth+1)
+		}
+	}
+	walk(0, 0)
+	return tree, nil
+}
+
+// GetCategoryDescendantIds returns the id of the category together with the ids
+// of all categories nested under it.
+func GetCategoryDescendantIds(id int64) ([]int64, error) {
+	ids := []int64{id}
+	visited := map[int64]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		children, err := GetCategoriesByParentId(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if !visited[c.Id] {
+				visited[c.Id] = true
+				ids = append(ids, c.Id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func InsertPostCategory(postId int64, categoryId int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtInsertPostCategory, postId, categoryId)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func DeletePostCategoriesByPostId(postId int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtDeletePostCategoriesByPostId, postId)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func categoryIdsPlaceholder(ids []int64) (string, []interface{}) {
+	marks := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		marks[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(marks, ", "), args
+}
+
+// GetNumberOfPostsByCategory counts the posts of a category, including the
+// posts of its sub categories.
+func GetNumberOfPostsByCategory(categoryId int64, onlyPublished bool) (int64, error) {
+	ids, err := GetCategoryDescendantIds(categoryId)
+	if err != nil {
+		return 0, err
+	}
+	marks, args := categoryIdsPlaceholder(ids)
+	selector := postCountSelector.Copy().From(`posts, posts_categories`).Where(`posts_categories.post_id = posts.id`, `posts_categories.category_id IN (`+marks+`)`)
+	if onlyPublished {
+		selector.Where(`status = 'published'`, `published_at <= ?`)
+		args = append(args, utils.Now())
+	}
+	var count int64
+	err = db.QueryRow(selector.SQL(), args...).Scan(&count)
+	return count, err
+}
+
+// GetPostsByCategory returns the posts of a category and its sub categories.
+func GetPostsByCategory(categoryId, page, size int64, onlyPublished bool, orderBy string) ([]*Post, *utils.Pager, error) {
+	count, err := GetNumberOfPostsByCategory(categoryId, onlyPublished)
+	if err != nil {
+		return nil, nil, err
+	}
+	pager := utils.NewPager(page, size, count)
+	ids, err := GetCategoryDescendantIds(categoryId)
+	if err != nil {
+		return nil, nil, err
+	}
+	marks, args := categoryIdsPlaceholder(ids)
+	selector := postsCategoriesSelector.Copy().Where(`posts_categories.post_id = posts.id`, `posts_categories.category_id IN (`+marks+`)`)
+	if onlyPublished {
+		selector.Where(`status = 'published'`, `published_at <= ?`)
+		args = append(args, utils.Now())
+	}
+	selector.OrderBy(orderBy)
+	args = append(args, size, pager.Begin-1)
+	rows, err := db.Query(selector.Limit(`?`).Offset(`?`).SQL(), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	posts, err := extractPosts(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return posts, pager, nil
+}
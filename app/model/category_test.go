@@ -0,0 +1,122 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func mockCategory(name string, parentId int64) *Category {
+	c := NewCategory(name, GenerateSlug(name, "categories"))
+	c.ParentId = parentId
+	return c
+}
+
+func TestCategory(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Create a category", func() {
+			parent := mockCategory("Programming", 0)
+			err := parent.Save()
+			So(err, ShouldBeNil)
+			So(parent.Id, ShouldEqual, 1)
+			So(parent.Slug, ShouldEqual, "programming")
+
+			child := mockCategory("Go", parent.Id)
+			err = child.Save()
+			So(err, ShouldBeNil)
+
+			Convey("Get category by slug", func() {
+				c, err := GetCategoryBySlug("go")
+				So(err, ShouldBeNil)
+				So(c.Name, ShouldEqual, "Go")
+				So(c.ParentId, ShouldEqual, parent.Id)
+				So(c.Url(), ShouldEqual, "/category/go")
+			})
+
+			Convey("Category with the same slug", func() {
+				c := NewCategory("Go", "go")
+				err := c.Save()
+				So(err, ShouldBeNil)
+				So(c.Slug, ShouldEqual, "go-2")
+			})
+
+			Convey("Get category tree", func() {
+				tree, err := GetCategoryTree()
+				So(err, ShouldBeNil)
+				So(tree, ShouldHaveLength, 2)
+				So(tree[0].Id, ShouldEqual, parent.Id)
+				So(tree[1].Id, ShouldEqual, child.Id)
+				So(tree[1].Depth, ShouldEqual, 1)
+				So(tree[1].Indent(), ShouldEqual, "— Go")
+			})
+
+			Convey("Ancestors and children", func() {
+				So(child.Ancestors(), ShouldHaveLength, 1)
+				So(child.Ancestors()[0].Id, ShouldEqual, parent.Id)
+				So(parent.Children(), ShouldHaveLength, 1)
+			})
+
+			Convey("A category can not be moved under its descendant", func() {
+				parent.ParentId = child.Id
+				err := parent.Save()
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Assign a category to a post", func() {
+				p := mockPost()
+				p.Category = child
+				err := p.Save()
+				So(err, ShouldBeNil)
+
+				Convey("Post should have the category", func() {
+					post, err := GetPostById(p.Id)
+					So(err, ShouldBeNil)
+					So(post.Category, ShouldNotBeNil)
+					So(post.Category.Id, ShouldEqual, child.Id)
+				})
+
+				Convey("Parent category should list the post", func() {
+					posts, pager, err := GetPostsByCategory(parent.Id, 1, 5, true, "published_at DESC")
+					So(err, ShouldBeNil)
+					So(posts, ShouldHaveLength, 1)
+					So(pager.Total, ShouldEqual, 1)
+				})
+
+				Convey("Remove the category from the post", func() {
+					p.Category = nil
+					err := p.Save()
+					So(err, ShouldBeNil)
+					post, _ := GetPostById(p.Id)
+					So(post.Category, ShouldBeNil)
+				})
+
+				Convey("Delete the category", func() {
+					err := DeleteCategoryById(child.Id)
+					So(err, ShouldBeNil)
+
+					_, err = GetCategoryById(child.Id)
+					So(err, ShouldNotBeNil)
+
+					post, _ := GetPostById(p.Id)
+					So(post.Category, ShouldBeNil)
+				})
+			})
+
+			Convey("Delete a parent category", func() {
+				err := DeleteCategoryById(parent.Id)
+				So(err, ShouldBeNil)
+
+				c, err := GetCategoryById(child.Id)
+				So(err, ShouldBeNil)
+				So(c.ParentId, ShouldEqual, 0)
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
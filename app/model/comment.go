@@ -2,12 +2,38 @@ package model
 
 import (
 	"database/sql"
+	"log"
 	"time"
 
 	"github.com/dinever/dingo/app/utils"
 	"github.com/twinj/uuid"
 )
 
+// The moderation states of a comment. Only the approved comments are shown
+// on the site.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+	CommentTrash    = "trash"
+	// CommentDeleted is a comment deleted while it had replies, it is kept
+	// without its content as the parent of the replies.
+	CommentDeleted = "deleted"
+)
+
+// CommentStatuses lists the moderation states in the order of the queue.
+var CommentStatuses = []string{CommentPending, CommentApproved, CommentSpam, CommentTrash}
+
+// IsCommentStatus tells whether status is a moderation state.
+func IsCommentStatus(status string) bool {
+	for _, s := range CommentStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 // Comment struct defines a comment item data.
 type Comment struct {
 	Id        int64
@@ -19,6 +45,9 @@ type Comment struct {
 	CreatedAt *time.Time
 	Content   string
 	Approved  bool
+	Status    string
+	// Notify is set when the author wants to be mailed the replies.
+	Notify    bool
 	PostId    int64
 	Parent    int64
 	Type      string
@@ -33,28 +62,53 @@ func NewComment() *Comment {
 	return c
 }
 
+// SetStatus moves the comment to a moderation state, it is approved only in
+// the approved state.
+func (c *Comment) SetStatus(status string) {
+	c.Status = status
+	c.Approved = status == CommentApproved
+}
+
+// Moderate moves the comment to a moderation state and saves it. Approving
+// a comment or marking it as spam is learned by the checker, if any.
+func (c *Comment) Moderate(status string, checker SpamChecker) error {
+	c.SetStatus(status)
+	if err := c.Save(); err != nil {
+		return err
+	}
+	if checker != nil && (status == CommentApproved || status == CommentSpam) {
+		if err := checker.Learn(c, status == CommentSpam); err != nil {
+			log.Printf("[Error]: Can not learn comment %d: %v", c.Id, err)
+		}
+	}
+	return nil
+}
+
 func (c *Comment) Save() error {
+	// Approved is what the code which only approves comments sets
+	switch {
+	case c.Approved:
+		c.Status = CommentApproved
+	case c.Status == "" || c.Status == CommentApproved:
+		c.Status = CommentPending
+	}
 	writeDB, err := db.Begin()
 	if err != nil {
 		writeDB.Rollback()
 		return err
 	}
-	var result sql.Result
+	if c.UUID == "" {
+		c.UUID = uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen)
+	}
 	if c.Id > 0 {
-		result, err = writeDB.Exec(stmtInsertComment, c.Id, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), c.PostId, c.Author, c.Email, c.Website, c.Ip, c.CreatedAt, c.Content, c.Approved, c.UserAgent, c.Parent, c.UserId)
+		_, err = writeDB.Exec(stmtUpdateComment, c.PostId, c.Author, c.Email, c.Website, c.Ip, c.CreatedAt, c.Content, c.Approved, c.Status, c.Notify, c.Type, c.UserAgent, c.Parent, c.UserId, c.Id)
 	} else {
-		result, err = writeDB.Exec(stmtInsertComment, nil, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), c.PostId, c.Author, c.Email, c.Website, c.Ip, c.CreatedAt, c.Content, c.Approved, c.UserAgent, c.Parent, c.UserId)
-	}
-	if err != nil {
-		writeDB.Rollback()
-		return err
+		c.Id, err = writeDB.Insert(stmtInsertComment, c.UUID, c.PostId, c.Author, c.Email, c.Website, c.Ip, c.CreatedAt, c.Content, c.Approved, c.Status, c.Notify, c.Type, c.UserAgent, c.Parent, c.UserId)
 	}
-	commentId, err := result.LastInsertId()
 	if err != nil {
 		writeDB.Rollback()
 		return err
 	}
-	c.Id = commentId
 	return writeDB.Commit()
 }
 
@@ -69,6 +123,9 @@ func (c *Comment) ToJson() map[string]interface{} {
 	m["create_time"] = c.CreatedAt.Unix()
 	m["pid"] = c.Parent
 	m["approved"] = c.Approved
+	m["status"] = c.Status
+	m["notify"] = c.Notify
+	m["type"] = c.Type
 	m["ip"] = c.Ip
 	m["user_agent"] = c.UserAgent
 	m["parent_content"] = c.ParentContent()
@@ -117,6 +174,72 @@ func GetCommentList(page, size int64) ([]*Comment, *utils.Pager, error) {
 	return comments, pager, nil
 }
 
+// CommentFilter narrows down the comments listed by GetFilteredCommentList,
+// the zero value of a field does not filter.
+type CommentFilter struct {
+	PostId int64
+	Status string // one of CommentStatuses
+}
+
+func (f *CommentFilter) conditions() ([]string, []interface{}) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	if f.PostId > 0 {
+		where = append(where, `post_id = ?`)
+		args = append(args, f.PostId)
+	}
+	if f.Status != "" {
+		where = append(where, `status = ?`)
+		args = append(args, f.Status)
+	}
+	return where, args
+}
+
+// GetCommentCounts returns the number of comments in each moderation state.
+func GetCommentCounts() (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, s := range CommentStatuses {
+		counts[s] = 0
+	}
+	rows, err := db.Query(stmtGetCommentCountsByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			status string
+			count  int64
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+func GetFilteredCommentList(filter *CommentFilter, page, size int64) ([]*Comment, *utils.Pager, error) {
+	var count int64
+	where, args := filter.conditions()
+	row := db.QueryRow(commentCountSelector.Copy().Where(where...).SQL(), args...)
+	if err := row.Scan(&count); err != nil {
+		return nil, nil, err
+	}
+	pager := utils.NewPager(page, size, count)
+	selector := commentSelector.Copy().Where(where...).OrderBy(`created_at DESC`).Limit(`?`).Offset(`?`)
+	rows, err := db.Query(selector.SQL(), append(args, size, pager.Begin-1)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	comments, err := extractComments(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return comments, pager, nil
+}
+
 func extractComments(rows *sql.Rows) ([]*Comment, error) {
 	comments := make([]*Comment, 0)
 	for rows.Next() {
@@ -132,11 +255,13 @@ func extractComments(rows *sql.Rows) ([]*Comment, error) {
 
 func scanComment(rows Row, comment *Comment) error {
 	var (
+		nullType   sql.NullString
 		nullParent sql.NullInt64
 		nullUserId sql.NullInt64
 	)
-	err := rows.Scan(&comment.Id, &comment.UUID, &comment.PostId, &comment.Author, &comment.Email, &comment.Website, &comment.CreatedAt, &comment.Content, &comment.Approved, &comment.UserAgent, &nullParent, &nullUserId)
+	err := rows.Scan(&comment.Id, &comment.UUID, &comment.PostId, &comment.Author, &comment.Email, &comment.Website, &comment.Ip, &comment.CreatedAt, &comment.Content, &comment.Approved, &comment.Status, &comment.Notify, &nullType, &comment.UserAgent, &nullParent, &nullUserId)
 	comment.Avatar = utils.Gravatar(comment.Email, "50")
+	comment.Type = nullType.String
 	comment.Parent = nullParent.Int64
 	comment.UserId = nullUserId.Int64
 	return err
@@ -152,6 +277,26 @@ func GetCommentById(id int64) (*Comment, error) {
 	return comment, nil
 }
 
+func GetCommentByUUID(uuid string) (*Comment, error) {
+	comment := new(Comment)
+	row := db.QueryRow(stmtGetCommentByUUID, uuid)
+	if err := scanComment(row, comment); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// GetAllComments returns the comments of every post, approved or not, in
+// the order they were written.
+func GetAllComments() ([]*Comment, error) {
+	rows, err := db.Query(stmtGetAllComments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractComments(rows)
+}
+
 func GetCommentByPostId(id int64) ([]*Comment, error) {
 	rows, err := db.Query(stmtGetApprovedCommentListByPostId, id)
 	defer rows.Close()
@@ -161,13 +306,48 @@ func GetCommentByPostId(id int64) ([]*Comment, error) {
 	return extractComments(rows)
 }
 
+// DeleteComment deletes a comment. A comment with replies is only emptied
+// and kept as their parent, and a parent which was emptied is deleted with
+// its last reply.
 func DeleteComment(id int64) error {
 	writeDB, err := db.Begin()
 	if err != nil {
 		writeDB.Rollback()
 		return err
 	}
-	_, err = writeDB.Exec(stmtDeleteCommentById, id)
+	for id > 0 {
+		var (
+			replies int64
+			parent  sql.NullInt64
+			status  string
+		)
+		if err = writeDB.QueryRow(stmtGetCommentReplyCount, id).Scan(&replies); err != nil {
+			break
+		}
+		if replies > 0 {
+			_, err = writeDB.Exec(stmtEmptyCommentById, CommentDeleted, id)
+			break
+		}
+		if err = writeDB.QueryRow(stmtGetCommentParentById, id).Scan(&parent, &status); err != nil {
+			if err == sql.ErrNoRows {
+				err = nil
+			}
+			break
+		}
+		if _, err = writeDB.Exec(stmtDeleteCommentById, id); err != nil {
+			break
+		}
+		id = 0
+		if parent.Valid && parent.Int64 > 0 {
+			var grandparent sql.NullInt64
+			err = writeDB.QueryRow(stmtGetCommentParentById, parent.Int64).Scan(&grandparent, &status)
+			if err == sql.ErrNoRows {
+				err = nil
+			} else if err == nil && status == CommentDeleted {
+				id = parent.Int64
+			}
+		}
+	}
 	if err != nil {
 		writeDB.Rollback()
 		return err
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// CountRecentComments returns the number of comments written since a time
+// from an IP address and with an email address. Nothing is counted for an
+// empty IP address.
+func CountRecentComments(ip, email string, since time.Time) (byIp, byEmail int64, err error) {
+	if ip != "" {
+		if err = db.QueryRow(stmtGetCommentCountByIpSince, ip, since).Scan(&byIp); err != nil {
+			return 0, 0, err
+		}
+	}
+	err = db.QueryRow(stmtGetCommentCountByEmailSince, normalizeEmail(email), since).Scan(&byEmail)
+	return byIp, byEmail, err
+}
+
+// IsDuplicateComment tells whether the post of a new comment already has a
+// comment with the same content.
+func IsDuplicateComment(c *Comment) (bool, error) {
+	var count int64
+	err := db.QueryRow(stmtGetDuplicateCommentCount, c.PostId, c.Content).Scan(&count)
+	return count > 0, err
+}
+
+// IsCommentBlocked tells whether a comment matches an entry of the
+// comment_blocklist setting. It has one entry per line: an IP address or
+// network, an email address or a domain written as @example.com, or else a
+// word which must not appear in the name, email, website or content.
+func IsCommentBlocked(c *Comment) bool {
+	ip := net.ParseIP(c.Ip)
+	email := normalizeEmail(c.Email)
+	text := strings.ToLower(strings.Join([]string{c.Author, c.Email, c.Website, c.Content}, "\n"))
+	for _, line := range strings.Split(GetSettingValue("comment_blocklist"), "\n") {
+		entry := strings.ToLower(strings.TrimSpace(line))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "@") {
+			if email == entry || (strings.HasPrefix(entry, "@") && strings.HasSuffix(email, entry)) {
+				return true
+			}
+		} else if blocked := net.ParseIP(entry); blocked != nil {
+			if blocked.Equal(ip) {
+				return true
+			}
+		} else if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if strings.Contains(text, entry) {
+			return true
+		}
+	}
+	return false
+}
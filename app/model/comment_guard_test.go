@@ -0,0 +1,73 @@
+package model
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentGuard(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		c := NewComment()
+		c.Author, c.Email, c.Content = "Ken", "Ken@example.com", "Nice post!"
+		c.Ip = "192.0.2.1"
+		c.PostId = 1
+		So(c.Save(), ShouldBeNil)
+
+		Convey("Count the recent comments", func() {
+			byIp, byEmail, err := CountRecentComments("192.0.2.1", "ken@example.com", time.Now().Add(-time.Minute))
+			So(err, ShouldBeNil)
+			So(byIp, ShouldEqual, 1)
+			So(byEmail, ShouldEqual, 1)
+
+			byIp, byEmail, err = CountRecentComments("", "rob@example.com", time.Now().Add(-time.Minute))
+			So(err, ShouldBeNil)
+			So(byIp, ShouldEqual, 0)
+			So(byEmail, ShouldEqual, 0)
+
+			_, byEmail, _ = CountRecentComments("", "ken@example.com", time.Now().Add(time.Minute))
+			So(byEmail, ShouldEqual, 0)
+		})
+
+		Convey("Find duplicate comments", func() {
+			d := &Comment{PostId: 1, Content: "Nice post!"}
+			duplicate, err := IsDuplicateComment(d)
+			So(err, ShouldBeNil)
+			So(duplicate, ShouldBeTrue)
+
+			d.PostId = 2
+			duplicate, _ = IsDuplicateComment(d)
+			So(duplicate, ShouldBeFalse)
+		})
+
+		Convey("Block comments from the blocklist", func() {
+			So(IsCommentBlocked(c), ShouldBeFalse)
+			for _, entry := range []string{"192.0.2.1", "192.0.2.0/24", "ken@example.com", "@Example.com", "NICE"} {
+				So(NewSetting("comment_blocklist", "\n  other.example.com\n"+entry+"\n", "").Save(), ShouldBeNil)
+				So(IsCommentBlocked(c), ShouldBeTrue)
+			}
+			So(NewSetting("comment_blocklist", "198.51.100.0/24\n@ample.com\nrob@example.com\nspam", "").Save(), ShouldBeNil)
+			So(IsCommentBlocked(c), ShouldBeFalse)
+		})
+
+		Convey("Close the comments of old posts", func() {
+			published := time.Now().Add(-48 * time.Hour)
+			p := &Post{AllowComment: true, IsPublished: true, PublishedAt: &published}
+			So(p.CommentsOpen(), ShouldBeTrue)
+			So(NewSetting("comment_close_days", "3", "").Save(), ShouldBeNil)
+			So(p.CommentsOpen(), ShouldBeTrue)
+			So(NewSetting("comment_close_days", "1", "").Save(), ShouldBeNil)
+			So(p.CommentsOpen(), ShouldBeFalse)
+			So(NewSetting("comment_close_days", "0", "").Save(), ShouldBeNil)
+			p.AllowComment = false
+			So(p.CommentsOpen(), ShouldBeFalse)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -93,6 +93,29 @@ func TestComment(t *testing.T) {
 				So(result, ShouldEqual, "")
 			})
 
+			Convey("Moderate Comments", func() {
+				So(cc.Status, ShouldEqual, CommentApproved)
+				So(cc.Moderate(CommentSpam, nil), ShouldBeNil)
+				result, err := GetCommentById(cc.Id)
+				So(err, ShouldBeNil)
+				So(result.Status, ShouldEqual, CommentSpam)
+				So(result.Approved, ShouldBeFalse)
+
+				// Unapproving a comment sends it back to the queue
+				pc.Approved = false
+				So(pc.Save(), ShouldBeNil)
+				So(pc.Status, ShouldEqual, CommentPending)
+
+				counts, err := GetCommentCounts()
+				So(err, ShouldBeNil)
+				So(counts, ShouldResemble, map[string]int64{CommentPending: 1, CommentApproved: 0, CommentSpam: 1, CommentTrash: 0})
+
+				spam, _, err := GetFilteredCommentList(&CommentFilter{Status: CommentSpam}, 1, 10)
+				So(err, ShouldBeNil)
+				So(spam, ShouldHaveLength, 1)
+				So(spam[0].Id, ShouldEqual, cc.Id)
+			})
+
 			Convey("Delete Comment", func() {
 				err := DeleteComment(cc.Id)
 				So(err, ShouldBeNil)
@@ -0,0 +1,110 @@
+package model
+
+import "sort"
+
+// CommentNode is an approved comment of a post with its replies. A comment
+// which is not shown, because it was deleted or is not approved, is kept as
+// a Deleted placeholder without its content when it has replies.
+type CommentNode struct {
+	*Comment
+	Deleted bool
+	// Depth is the nesting level of the comment, 0 for the comments on the post.
+	Depth   int
+	Replies []*CommentNode
+}
+
+// GetCommentTree returns the comments of a post as a tree, in the order they
+// were written at every level. The replies nested deeper than maxDepth are
+// listed in order at maxDepth, under their ancestor. A maxDepth of 0 does not
+// limit the nesting.
+func GetCommentTree(postId int64, maxDepth int) ([]*CommentNode, error) {
+	rows, err := db.Query(stmtGetCommentsByPostId, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments, err := extractComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	return buildCommentTree(comments, maxDepth), nil
+}
+
+// FlattenCommentTree returns the comments of a tree depth-first, for the
+// themes which render them as a list indented by Depth.
+func FlattenCommentTree(nodes []*CommentNode) []*CommentNode {
+	list := make([]*CommentNode, 0)
+	for _, n := range nodes {
+		list = append(list, n)
+		list = append(list, FlattenCommentTree(n.Replies)...)
+	}
+	return list
+}
+
+func buildCommentTree(comments []*Comment, maxDepth int) []*CommentNode {
+	nodes := make(map[int64]*CommentNode, len(comments))
+	for _, c := range comments {
+		nodes[c.Id] = &CommentNode{Comment: c}
+	}
+	roots := make([]*CommentNode, 0)
+	for _, c := range comments {
+		// The replies to a comment deleted for good are on the post
+		if parent, ok := nodes[c.Parent]; ok && c.Parent != c.Id {
+			parent.Replies = append(parent.Replies, nodes[c.Id])
+		} else {
+			roots = append(roots, nodes[c.Id])
+		}
+	}
+	roots = pruneCommentNodes(roots)
+	limitCommentDepth(roots, 0, maxDepth)
+	return roots
+}
+
+// pruneCommentNodes drops the comments which are not shown and have no reply
+// shown, and hides the content of the others.
+func pruneCommentNodes(nodes []*CommentNode) []*CommentNode {
+	kept := make([]*CommentNode, 0, len(nodes))
+	for _, n := range nodes {
+		n.Replies = pruneCommentNodes(n.Replies)
+		if n.Status == CommentApproved {
+			kept = append(kept, n)
+			continue
+		}
+		if len(n.Replies) > 0 {
+			n.Comment = &Comment{Id: n.Id, PostId: n.PostId, Parent: n.Parent, CreatedAt: n.CreatedAt, Status: CommentDeleted}
+			n.Deleted = true
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func limitCommentDepth(nodes []*CommentNode, depth, maxDepth int) {
+	for _, n := range nodes {
+		n.Depth = depth
+		if maxDepth > 0 && depth+1 >= maxDepth {
+			n.Replies = flattenCommentReplies(n.Replies)
+			for _, r := range n.Replies {
+				r.Depth = depth + 1
+			}
+			continue
+		}
+		limitCommentDepth(n.Replies, depth+1, maxDepth)
+	}
+}
+
+// flattenCommentReplies lists the replies and all their replies in the order
+// they were written, the placeholders are left out.
+func flattenCommentReplies(nodes []*CommentNode) []*CommentNode {
+	list := make([]*CommentNode, 0)
+	for _, n := range FlattenCommentTree(nodes) {
+		if !n.Deleted {
+			list = append(list, n)
+		}
+		n.Replies = nil
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(*list[j].CreatedAt)
+	})
+	return list
+}
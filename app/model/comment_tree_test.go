@@ -0,0 +1,103 @@
+package model
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommentTree(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		start := time.Now().Add(-time.Hour)
+		comments := make(map[string]*Comment)
+		// Each comment is written a minute after the previous one
+		add := func(name, parent, status string) {
+			c := NewComment()
+			created := start.Add(time.Duration(len(comments)) * time.Minute)
+			c.CreatedAt = &created
+			c.Author = name
+			c.Email = "reader@example.com"
+			c.Content = "Comment " + name
+			c.PostId = 10
+			if p, ok := comments[parent]; ok {
+				c.Parent = p.Id
+			}
+			c.SetStatus(status)
+			So(c.Save(), ShouldBeNil)
+			comments[name] = c
+		}
+		add("a", "", CommentApproved)
+		add("b", "", CommentApproved)
+		add("a1", "a", CommentApproved)
+		add("a1x", "a1", CommentApproved)
+		add("a2", "a", CommentApproved)
+		add("p", "", CommentPending)
+		add("p1", "p", CommentApproved)
+		add("s", "", CommentSpam)
+		add("a1xy", "a1x", CommentApproved)
+
+		names := func(nodes []*CommentNode) []string {
+			list := make([]string, len(nodes))
+			for i, n := range nodes {
+				list[i] = n.Author
+				if n.Deleted {
+					list[i] = "-"
+				}
+			}
+			return list
+		}
+
+		Convey("Get the tree", func() {
+			tree, err := GetCommentTree(10, 0)
+			So(err, ShouldBeNil)
+			So(names(tree), ShouldResemble, []string{"a", "b", "-"})
+			So(names(tree[0].Replies), ShouldResemble, []string{"a1", "a2"})
+			So(tree[0].Replies[0].Replies[0].Replies[0].Depth, ShouldEqual, 3)
+
+			// The pending comment is a placeholder for its reply
+			So(tree[2].Content, ShouldBeEmpty)
+			So(tree[2].Status, ShouldEqual, CommentDeleted)
+			So(names(tree[2].Replies), ShouldResemble, []string{"p1"})
+
+			So(names(FlattenCommentTree(tree)), ShouldResemble, []string{"a", "a1", "a1x", "a1xy", "a2", "b", "-", "p1"})
+		})
+
+		Convey("Limit the depth", func() {
+			tree, err := GetCommentTree(10, 1)
+			So(err, ShouldBeNil)
+			So(names(tree[0].Replies), ShouldResemble, []string{"a1", "a1x", "a2", "a1xy"})
+			for _, n := range tree[0].Replies {
+				So(n.Depth, ShouldEqual, 1)
+				So(n.Replies, ShouldBeEmpty)
+			}
+		})
+
+		Convey("Delete a comment with replies", func() {
+			So(DeleteComment(comments["a1"].Id), ShouldBeNil)
+			c, err := GetCommentById(comments["a1"].Id)
+			So(err, ShouldBeNil)
+			So(c.Status, ShouldEqual, CommentDeleted)
+			So(c.Content, ShouldBeEmpty)
+
+			tree, _ := GetCommentTree(10, 0)
+			So(names(tree[0].Replies), ShouldResemble, []string{"-", "a2"})
+			So(names(tree[0].Replies[0].Replies), ShouldResemble, []string{"a1x"})
+
+			Convey("Delete its replies", func() {
+				So(DeleteComment(comments["a1xy"].Id), ShouldBeNil)
+				So(DeleteComment(comments["a1x"].Id), ShouldBeNil)
+				_, err := GetCommentById(comments["a1"].Id)
+				So(err, ShouldNotBeNil)
+				tree, _ := GetCommentTree(10, 0)
+				So(names(tree[0].Replies), ShouldResemble, []string{"a2"})
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -0,0 +1,544 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// ExportVersion is the version of the export format written by this version
+// of Dingo. Imports of older versions are supported, newer ones are refused.
+const ExportVersion = 1
+
+// Export is a JSON document holding the whole content of a site. Every item
+// keeps its id, which the references between items use, e.g. the author_id
+// of a post is the id of one of the users. The ids change on import.
+type Export struct {
+	Version    int               `json:"version"`
+	ExportedAt *time.Time        `json:"exported_at"`
+	Users      []*ExportUser     `json:"users"`
+	Categories []*ExportCategory `json:"categories"`
+	Tags       []*ExportTag      `json:"tags"`
+	Posts      []*ExportPost     `json:"posts"`
+	Pages      []*ExportPost     `json:"pages"`
+	Comments   []*ExportComment  `json:"comments"`
+	Settings   []*ExportSetting  `json:"settings"`
+	Navigation []*Navigator      `json:"navigation"`
+}
+
+type ExportUser struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Email    string `json:"email"`
+	Image    string `json:"image"`
+	Cover    string `json:"cover"`
+	Bio      string `json:"bio"`
+	Website  string `json:"website"`
+	Location string `json:"location"`
+	Role     int    `json:"role"`
+	Status   string `json:"status"`
+	// Password is the bcrypt hash of the password, it is only exported on
+	// request.
+	Password string `json:"password,omitempty"`
+}
+
+type ExportCategory struct {
+	Id              int64      `json:"id"`
+	UUID            string     `json:"uuid"`
+	Name            string     `json:"name"`
+	Slug            string     `json:"slug"`
+	Description     string     `json:"description"`
+	ParentId        int64      `json:"parent_id"`
+	MetaTitle       string     `json:"meta_title"`
+	MetaDescription string     `json:"meta_description"`
+	CreatedAt       *time.Time `json:"created_at"`
+}
+
+type ExportTag struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type ExportPost struct {
+	Id              int64      `json:"id"`
+	UUID            string     `json:"uuid"`
+	Title           string     `json:"title"`
+	Slug            string     `json:"slug"`
+	Markdown        string     `json:"markdown"`
+	Html            string     `json:"html"`
+	Image           string     `json:"image"`
+	Featured        bool       `json:"featured"`
+	AllowComment    bool       `json:"allow_comment"`
+	Status          string     `json:"status"`
+	AuthorId        int64      `json:"author_id"`
+	CategoryId      int64      `json:"category_id"`
+	Tags            []int64    `json:"tags"`
+	MetaTitle       string     `json:"meta_title"`
+	MetaDescription string     `json:"meta_description"`
+	CreatedAt       *time.Time `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+	PublishedAt     *time.Time `json:"published_at"`
+	UnpublishAt     *time.Time `json:"unpublish_at"`
+}
+
+type ExportComment struct {
+	Id        int64      `json:"id"`
+	UUID      string     `json:"uuid"`
+	PostId    int64      `json:"post_id"`
+	ParentId  int64      `json:"parent_id"`
+	UserId    int64      `json:"user_id"`
+	Author    string     `json:"author"`
+	Email     string     `json:"email"`
+	Website   string     `json:"website"`
+	Content   string     `json:"content"`
+	Approved  bool       `json:"approved"`
+	Status    string     `json:"status,omitempty"`
+	Notify    bool       `json:"notify,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+type ExportSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// ImportResult counts the items created by an import. The items which
+// already exist are skipped, so that a site can be imported again.
+type ImportResult struct {
+	Users      int `json:"users"`
+	Categories int `json:"categories"`
+	Posts      int `json:"posts"`
+	Pages      int `json:"pages"`
+	Comments   int `json:"comments"`
+	Settings   int `json:"settings"`
+	Skipped    int `json:"skipped"`
+}
+
+// ExportSite exports the content of the site. The password hashes of the
+// users are only included with withPasswords.
+func ExportSite(withPasswords bool) (*Export, error) {
+	e := &Export{
+		Version:    ExportVersion,
+		ExportedAt: utils.Now(),
+		Users:      []*ExportUser{},
+		Categories: []*ExportCategory{},
+		Tags:       []*ExportTag{},
+		Comments:   []*ExportComment{},
+		Settings:   []*ExportSetting{},
+	}
+
+	users, err := GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		eu := &ExportUser{Id: u.Id, Name: u.Name, Slug: u.Slug, Email: u.Email, Image: u.Image, Cover: u.Cover, Bio: u.Bio, Website: u.Website, Location: u.Location, Role: u.Role, Status: u.Status}
+		if withPasswords {
+			hash, err := GetHashedPasswordForUser(u.Email)
+			if err != nil {
+				return nil, err
+			}
+			eu.Password = string(hash)
+		}
+		e.Users = append(e.Users, eu)
+	}
+
+	categories, err := GetAllCategories()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range categories {
+		e.Categories = append(e.Categories, &ExportCategory{Id: c.Id, UUID: c.UUID, Name: c.Name, Slug: c.Slug, Description: c.Description, ParentId: c.ParentId, MetaTitle: c.MetaTitle, MetaDescription: c.MetaDescription, CreatedAt: c.CreatedAt})
+	}
+
+	tags, err := GetAllTags()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		e.Tags = append(e.Tags, &ExportTag{Id: t.Id, Name: t.Name, Slug: t.Slug})
+	}
+
+	if e.Posts, err = exportPosts(false); err != nil {
+		return nil, err
+	}
+	if e.Pages, err = exportPosts(true); err != nil {
+		return nil, err
+	}
+
+	comments, err := GetAllComments()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range comments {
+		e.Comments = append(e.Comments, &ExportComment{Id: c.Id, UUID: c.UUID, PostId: c.PostId, ParentId: c.Parent, UserId: c.UserId, Author: c.Author, Email: c.Email, Website: c.Website, Content: c.Content, Approved: c.Approved, Status: c.Status, Notify: c.Notify, Type: c.Type, UserAgent: c.UserAgent, CreatedAt: c.CreatedAt})
+	}
+
+	for _, s := range GetAllSettings() {
+		if s.Key == "navigation" {
+			continue
+		}
+		e.Settings = append(e.Settings, &ExportSetting{Key: s.Key, Value: s.Value, Type: s.Type})
+	}
+	e.Navigation = GetNavigators()
+	return e, nil
+}
+
+func exportPosts(isPage bool) ([]*ExportPost, error) {
+	posts, err := GetAllPostList(isPage, false, "id")
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]*ExportPost, 0, len(posts))
+	for _, p := range posts {
+		ep := &ExportPost{Id: p.Id, UUID: p.UUID, Title: p.Title, Slug: p.Slug, Markdown: p.Markdown, Html: p.Html, Image: p.Image, Featured: p.IsFeatured, AllowComment: p.AllowComment, Status: p.Status(), AuthorId: p.userId, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt, PublishedAt: p.PublishedAt, UnpublishAt: p.UnpublishAt, MetaTitle: p.MetaTitle, MetaDescription: p.MetaDescription}
+		if p.Category != nil {
+			ep.CategoryId = p.Category.Id
+		}
+		ep.Tags = make([]int64, 0, len(p.Tags))
+		for _, t := range p.Tags {
+			ep.Tags = append(ep.Tags, t.Id)
+		}
+		exported = append(exported, ep)
+	}
+	return exported, nil
+}
+
+// ParseExport reads an export, and checks that its version is supported.
+func ParseExport(data []byte) (*Export, error) {
+	e := new(Export)
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	if e.Version < 1 {
+		return nil, fmt.Errorf("Not a Dingo export")
+	}
+	if e.Version > ExportVersion {
+		return nil, fmt.Errorf("The export version %d is newer than the supported version %d, please upgrade Dingo", e.Version, ExportVersion)
+	}
+	return e, nil
+}
+
+// PreviewImport counts the items which ImportSite would create and skip,
+// without changing the site.
+func PreviewImport(e *Export) *ImportResult {
+	r := new(ImportResult)
+	for _, eu := range e.Users {
+		if _, err := GetUserByEmail(eu.Email); err == nil {
+			r.Skipped++
+		} else {
+			r.Users++
+		}
+	}
+	for _, ec := range e.Categories {
+		if _, err := GetCategoryByUUID(ec.UUID); err == nil && ec.UUID != "" {
+			r.Skipped++
+		} else {
+			r.Categories++
+		}
+	}
+	posts := make(map[int64]bool)
+	for _, ep := range e.Posts {
+		posts[ep.Id] = true
+		if _, err := GetPostByUUID(ep.UUID); err == nil && ep.UUID != "" {
+			r.Skipped++
+		} else {
+			r.Posts++
+		}
+	}
+	for _, ep := range e.Pages {
+		posts[ep.Id] = true
+		if _, err := GetPostByUUID(ep.UUID); err == nil && ep.UUID != "" {
+			r.Skipped++
+		} else {
+			r.Pages++
+		}
+	}
+	for _, ec := range e.Comments {
+		if _, err := GetCommentByUUID(ec.UUID); (err == nil && ec.UUID != "") || !posts[ec.PostId] {
+			r.Skipped++
+		} else {
+			r.Comments++
+		}
+	}
+	r.Settings = len(e.Settings)
+	return r
+}
+
+// importIds maps the ids of an export to the ids of the imported items.
+type importIds struct {
+	users, categories, posts, comments map[int64]int64
+	tags                               map[int64]*ExportTag
+	// owner writes the posts whose author is not in the export
+	owner int64
+}
+
+// ImportSite imports an export into the site. The items which already exist
+// are kept: posts, pages, comments and categories are recognized by their
+// UUID, users by their email. The imported items get new ids, and new slugs
+// when theirs are taken. Settings are overwritten.
+func ImportSite(e *Export) (*ImportResult, error) {
+	r := new(ImportResult)
+	ids := &importIds{
+		users:      make(map[int64]int64),
+		categories: make(map[int64]int64),
+		posts:      make(map[int64]int64),
+		comments:   make(map[int64]int64),
+		tags:       make(map[int64]*ExportTag),
+	}
+	if err := importUsers(e.Users, ids, r); err != nil {
+		return r, err
+	}
+	if err := importCategories(e.Categories, ids, r); err != nil {
+		return r, err
+	}
+	for _, t := range e.Tags {
+		ids.tags[t.Id] = t
+	}
+	for _, ep := range e.Posts {
+		if err := importPost(ep, false, ids, r); err != nil {
+			return r, err
+		}
+	}
+	for _, ep := range e.Pages {
+		if err := importPost(ep, true, ids, r); err != nil {
+			return r, err
+		}
+	}
+	for _, ec := range e.Comments {
+		if err := importComment(ec, ids, r); err != nil {
+			return r, err
+		}
+	}
+	for _, s := range e.Settings {
+		// Older exports carried the signing keys of their site
+		if s.Key == "mail_secret" || s.Key == "site_secret" {
+			continue
+		}
+		if err := NewSetting(s.Key, s.Value, s.Type).Save(); err != nil {
+			return r, err
+		}
+		r.Settings++
+	}
+	if e.Navigation != nil {
+		navs, err := json.Marshal(e.Navigation)
+		if err != nil {
+			return r, err
+		}
+		if err := NewSetting("navigation", string(navs), "navigation").Save(); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+func importUsers(users []*ExportUser, ids *importIds, r *ImportResult) error {
+	// A site has one owner, the imported owners are made administrators
+	hasOwner := false
+	existing, err := GetAllUsers()
+	if err != nil {
+		return err
+	}
+	for _, u := range existing {
+		if u.Role == RoleOwner && !hasOwner {
+			hasOwner = true
+			ids.owner = u.Id
+		}
+	}
+	for _, eu := range users {
+		if u, err := GetUserByEmail(eu.Email); err == nil {
+			ids.users[eu.Id] = u.Id
+			r.Skipped++
+			continue
+		}
+		hash := eu.Password
+		if hash == "" {
+			// The user has to reset the password before logging in
+			if hash, err = EncryptPassword(utils.RandomToken(20)); err != nil {
+				return err
+			}
+		}
+		u := NewUser(eu.Email, eu.Name)
+		if eu.Slug != "" {
+			u.Slug = generateUniqueSlug(eu.Slug, "users", 1)
+		} else {
+			u.Slug = GenerateSlug(eu.Name, "users")
+		}
+		u.Image, u.Cover, u.Bio, u.Website, u.Location = eu.Image, eu.Cover, eu.Bio, eu.Website, eu.Location
+		switch u.Role = eu.Role; {
+		case u.Role == RoleOwner && hasOwner:
+			u.Role = RoleAdministrator
+		case u.Role < RoleAdministrator || u.Role > RoleOwner:
+			u.Role = RoleAuthor
+		}
+		if err := u.Save(hash, 0); err != nil {
+			return err
+		}
+		if u.Role == RoleOwner {
+			hasOwner = true
+			ids.owner = u.Id
+		}
+		if err := u.Update(); err != nil {
+			return err
+		}
+		if eu.Status == UserSuspended {
+			if err := u.SetStatus(UserSuspended, 0); err != nil {
+				return err
+			}
+		}
+		ids.users[eu.Id] = u.Id
+		r.Users++
+	}
+	return nil
+}
+
+func importCategories(categories []*ExportCategory, ids *importIds, r *ImportResult) error {
+	created := make([]*Category, 0)
+	parents := make(map[*Category]int64)
+	for _, ec := range categories {
+		if c, err := GetCategoryByUUID(ec.UUID); err == nil && ec.UUID != "" {
+			ids.categories[ec.Id] = c.Id
+			r.Skipped++
+			continue
+		}
+		c := NewCategory(ec.Name, ec.Slug)
+		if ec.UUID != "" {
+			c.UUID = ec.UUID
+		}
+		if c.Slug == "" {
+			c.Slug = GenerateSlug(ec.Name, "categories")
+		}
+		c.Description, c.MetaTitle, c.MetaDescription = ec.Description, ec.MetaTitle, ec.MetaDescription
+		if ec.CreatedAt != nil {
+			c.CreatedAt = ec.CreatedAt
+		}
+		if err := c.Save(); err != nil {
+			return err
+		}
+		ids.categories[ec.Id] = c.Id
+		created = append(created, c)
+		parents[c] = ec.ParentId
+		r.Categories++
+	}
+	// The parents are set once every category exists
+	for _, c := range created {
+		if parentId := ids.categories[parents[c]]; parentId > 0 {
+			c.ParentId = parentId
+			if err := c.Save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func importPost(ep *ExportPost, isPage bool, ids *importIds, r *ImportResult) error {
+	if p, err := GetPostByUUID(ep.UUID); err == nil && ep.UUID != "" {
+		ids.posts[ep.Id] = p.Id
+		r.Skipped++
+		return nil
+	}
+	p := NewPost()
+	p.skipWebmentions = true
+	if ep.UUID != "" {
+		p.UUID = ep.UUID
+	}
+	p.Title = ep.Title
+	p.Slug = ep.Slug
+	if p.Slug == "" {
+		p.Slug = GenerateSlug(ep.Title, "posts")
+	}
+	p.Markdown = ep.Markdown
+	p.Html = ep.Html
+	if p.Html == "" {
+		p.Html = utils.Markdown2Html(p.Markdown)
+	}
+	p.Image = ep.Image
+	p.IsFeatured = ep.Featured
+	p.MetaTitle, p.MetaDescription = ep.MetaTitle, ep.MetaDescription
+	p.IsPage = isPage
+	p.AllowComment = ep.AllowComment
+	p.IsPublished = ep.Status == "published"
+	p.IsScheduled = ep.Status == "scheduled"
+	p.CreatedBy = ids.users[ep.AuthorId]
+	if p.CreatedBy == 0 {
+		p.CreatedBy = ids.owner
+	}
+	if ep.CreatedAt != nil {
+		p.CreatedAt = ep.CreatedAt
+	}
+	p.PublishedAt = ep.PublishedAt
+	p.UnpublishAt = ep.UnpublishAt
+	if categoryId := ids.categories[ep.CategoryId]; categoryId > 0 {
+		p.Category = &Category{Id: categoryId}
+	}
+	p.Tags = make([]*Tag, 0, len(ep.Tags))
+	for _, id := range ep.Tags {
+		t, ok := ids.tags[id]
+		if !ok {
+			continue
+		}
+		slug := t.Slug
+		if slug == "" {
+			slug = GenerateSlug(t.Name, "tags")
+		}
+		p.Tags = append(p.Tags, NewTag(t.Name, slug))
+	}
+	if err := p.Save(); err != nil {
+		return fmt.Errorf("Can not import %s: %v", ep.Title, err)
+	}
+	ids.posts[ep.Id] = p.Id
+	if isPage {
+		r.Pages++
+	} else {
+		r.Posts++
+	}
+	return nil
+}
+
+func importComment(ec *ExportComment, ids *importIds, r *ImportResult) error {
+	if c, err := GetCommentByUUID(ec.UUID); err == nil && ec.UUID != "" {
+		ids.comments[ec.Id] = c.Id
+		r.Skipped++
+		return nil
+	}
+	postId, ok := ids.posts[ec.PostId]
+	if !ok {
+		// The comment of a post which is not in the export
+		r.Skipped++
+		return nil
+	}
+	c := NewComment()
+	if ec.UUID != "" {
+		c.UUID = ec.UUID
+	}
+	c.PostId = postId
+	c.Parent = ids.comments[ec.ParentId]
+	c.UserId = ids.users[ec.UserId]
+	c.Author, c.Email, c.Website = ec.Author, ec.Email, ec.Website
+	c.Avatar = utils.Gravatar(c.Email, "50")
+	c.Content = ec.Content
+	c.Approved = ec.Approved
+	if IsCommentStatus(ec.Status) {
+		c.SetStatus(ec.Status)
+	}
+	c.Notify = ec.Notify
+	c.Type = ec.Type
+	c.UserAgent = ec.UserAgent
+	if ec.CreatedAt != nil {
+		c.CreatedAt = ec.CreatedAt
+	}
+	if err := c.Save(); err != nil {
+		return err
+	}
+	ids.comments[ec.Id] = c.Id
+	r.Comments++
+	return nil
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExport(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		owner := NewUser("owner@example.com", "Owner")
+		owner.Slug = "owner"
+		So(owner.Create("password"), ShouldBeNil)
+		author := NewUser("author@example.com", "Author")
+		author.Slug = "author"
+		So(author.Create("password"), ShouldBeNil)
+
+		parent := NewCategory("Programming", "programming")
+		So(parent.Save(), ShouldBeNil)
+		child := NewCategory("Go", "go")
+		child.ParentId = parent.Id
+		So(child.Save(), ShouldBeNil)
+
+		p := mockPost()
+		p.CreatedBy = author.Id
+		p.Category = child
+		p.MetaTitle = "Welcome"
+		p.MetaDescription = "The first post."
+		So(p.Save(), ShouldBeNil)
+		page := mockPost()
+		page.Title = "About"
+		page.Slug = "about"
+		page.IsPage = true
+		page.Tags = nil
+		So(page.Save(), ShouldBeNil)
+
+		c := NewComment()
+		c.Author, c.Email, c.Content, c.PostId, c.Approved = "Reader", "reader@example.com", "First", p.Id, true
+		So(c.Save(), ShouldBeNil)
+		reply := NewComment()
+		reply.Author, reply.Email, reply.Content, reply.PostId, reply.Parent = "Writer", "author@example.com", "Reply", p.Id, c.Id
+		So(reply.Save(), ShouldBeNil)
+
+		So(NewSetting("title", "Exported Blog", "blog").Save(), ShouldBeNil)
+		So(SetNavigators([]string{"Home"}, []string{"/"}), ShouldBeNil)
+
+		Convey("Export the site", func() {
+			e, err := ExportSite(false)
+			So(err, ShouldBeNil)
+			So(e.Version, ShouldEqual, ExportVersion)
+			So(e.Users, ShouldHaveLength, 2)
+			So(e.Users[0].Password, ShouldEqual, "")
+			So(e.Categories, ShouldHaveLength, 2)
+			So(e.Tags, ShouldHaveLength, 2)
+			So(e.Posts, ShouldHaveLength, 1)
+			So(e.Posts[0].UUID, ShouldEqual, p.UUID)
+			So(e.Posts[0].Tags, ShouldHaveLength, 2)
+			So(e.Posts[0].CategoryId, ShouldEqual, child.Id)
+			So(e.Posts[0].AuthorId, ShouldEqual, author.Id)
+			So(e.Posts[0].MetaTitle, ShouldEqual, "Welcome")
+			So(e.Posts[0].MetaDescription, ShouldEqual, "The first post.")
+			So(e.Pages, ShouldHaveLength, 1)
+			So(e.Comments, ShouldHaveLength, 2)
+			So(e.Navigation, ShouldHaveLength, 1)
+			for _, s := range e.Settings {
+				So(s.Key, ShouldNotEqual, "navigation")
+			}
+
+			withPasswords, _ := ExportSite(true)
+			So(withPasswords.Users[0].Password, ShouldStartWith, "$2a$")
+
+			data, err := json.Marshal(withPasswords)
+			So(err, ShouldBeNil)
+
+			Convey("Import into a new site", func() {
+				os.Remove("test.db")
+				Initialize("test.db", true)
+				imported, err := ParseExport(data)
+				So(err, ShouldBeNil)
+
+				result, err := ImportSite(imported)
+				So(err, ShouldBeNil)
+				So(*result, ShouldResemble, ImportResult{Users: 2, Categories: 2, Posts: 1, Pages: 1, Comments: 2, Settings: len(e.Settings)})
+
+				newPost, err := GetPostByUUID(p.UUID)
+				So(err, ShouldBeNil)
+				So(newPost.Slug, ShouldEqual, p.Slug)
+				So(newPost.Tags, ShouldHaveLength, 2)
+				So(newPost.Category.Slug, ShouldEqual, "go")
+				So(newPost.Category.Parent().Slug, ShouldEqual, "programming")
+				So(newPost.Author.Email, ShouldEqual, "author@example.com")
+				So(newPost.MetaTitle, ShouldEqual, "Welcome")
+				So(newPost.MetaDescription, ShouldEqual, "The first post.")
+
+				newOwner, _ := GetUserByEmail("owner@example.com")
+				So(newOwner.Role, ShouldEqual, RoleOwner)
+				So(newOwner.CheckPassword("password"), ShouldBeTrue)
+
+				comments, _ := GetAllComments()
+				So(comments, ShouldHaveLength, 2)
+				So(comments[1].Parent, ShouldEqual, comments[0].Id)
+				So(GetSettingValue("title"), ShouldEqual, "Exported Blog")
+				So(GetNavigators(), ShouldHaveLength, 1)
+
+				Convey("Import again", func() {
+					result, err := ImportSite(imported)
+					So(err, ShouldBeNil)
+					So(result.Posts+result.Pages+result.Users+result.Comments+result.Categories, ShouldEqual, 0)
+					So(result.Skipped, ShouldEqual, 8)
+
+					posts, _ := GetAllPostList(false, false, "id")
+					So(posts, ShouldHaveLength, 1)
+					comments, _ := GetAllComments()
+					So(comments, ShouldHaveLength, 2)
+				})
+			})
+
+			Convey("Import into a site with the same slugs", func() {
+				for _, ep := range e.Posts {
+					ep.UUID = "other-" + ep.UUID
+				}
+				for _, ec := range e.Categories {
+					ec.UUID = "other-" + ec.UUID
+				}
+				for _, ec := range e.Comments {
+					ec.UUID = "other-" + ec.UUID
+				}
+				e.Users[0].Role = RoleOwner
+				e.Users[0].Email = "other@example.com"
+
+				result, err := ImportSite(e)
+				So(err, ShouldBeNil)
+				So(result.Posts, ShouldEqual, 1)
+				So(result.Users, ShouldEqual, 1)
+
+				newPost, err := GetPostByUUID("other-" + p.UUID)
+				So(err, ShouldBeNil)
+				So(newPost.Slug, ShouldEqual, p.Slug+"-1")
+				So(newPost.Category.Slug, ShouldEqual, "go-2")
+				So(newPost.Author.Id, ShouldEqual, author.Id)
+
+				other, _ := GetUserByEmail("other@example.com")
+				So(other.Role, ShouldEqual, RoleAdministrator)
+				So(other.Slug, ShouldEqual, "owner-2")
+				So(other.CheckPassword("password"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Refuse unknown exports", func() {
+			_, err := ParseExport([]byte(`{"posts": []}`))
+			So(err, ShouldNotBeNil)
+			_, err = ParseExport([]byte(`{"version": 99}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
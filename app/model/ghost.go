@@ -0,0 +1,567 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// GhostExport is the JSON document which Ghost exports and imports. Older
+// versions of Ghost write the meta and data at the top level, newer ones
+// wrap them in a db list.
+type GhostExport struct {
+	DB []*GhostDB `json:"db"`
+}
+
+type GhostDB struct {
+	Meta struct {
+		ExportedOn int64  `json:"exported_on"`
+		Version    string `json:"version"`
+	} `json:"meta"`
+	Data struct {
+		Posts        []*ghostPost       `json:"posts"`
+		Tags         []*ghostTag        `json:"tags"`
+		PostsTags    []*ghostPostTag    `json:"posts_tags"`
+		PostsAuthors []*ghostPostAuthor `json:"posts_authors,omitempty"`
+		PostsMeta    []*ghostPostMeta   `json:"posts_meta,omitempty"`
+		Users        []*ghostStaff      `json:"users"`
+		Roles        []*ghostRole       `json:"roles"`
+		RolesUsers   []*ghostRoleUser   `json:"roles_users"`
+		Settings     []*ghostSetting    `json:"settings"`
+	} `json:"data"`
+}
+
+// ghostId is an id of Ghost, a number before Ghost 1.0 and an ObjectId
+// string since.
+type ghostId string
+
+func (id *ghostId) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = ghostId(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = ghostId(n.String())
+	return nil
+}
+
+// ghostBool is a boolean of Ghost, which Ghost 0.x may write as 0 or 1.
+type ghostBool bool
+
+func (b *ghostBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null":
+		*b = false
+	default:
+		return fmt.Errorf("Invalid boolean %s", data)
+	}
+	return nil
+}
+
+// ghostTime is a date of Ghost, a number of milliseconds before Ghost 1.0
+// and an ISO 8601 string since.
+type ghostTime struct {
+	*time.Time
+}
+
+func (t *ghostTime) UnmarshalJSON(data []byte) error {
+	var ms int64
+	if err := json.Unmarshal(data, &ms); err == nil {
+		parsed := time.Unix(0, ms*int64(time.Millisecond)).UTC()
+		t.Time = &parsed
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = &parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid date %q", s)
+}
+
+func (t ghostTime) MarshalJSON() ([]byte, error) {
+	if t.Time == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Time.UTC().Format("2006-01-02T15:04:05.000Z"))
+}
+
+type ghostPost struct {
+	Id              ghostId   `json:"id"`
+	UUID            string    `json:"uuid"`
+	Title           string    `json:"title"`
+	Slug            string    `json:"slug"`
+	Markdown        string    `json:"markdown,omitempty"`
+	Mobiledoc       string    `json:"mobiledoc,omitempty"`
+	Lexical         string    `json:"lexical,omitempty"`
+	Html            string    `json:"html"`
+	Image           string    `json:"image,omitempty"`
+	FeatureImage    string    `json:"feature_image"`
+	Featured        ghostBool `json:"featured"`
+	Page            ghostBool `json:"page"`
+	Type            string    `json:"type"`
+	Status          string    `json:"status"`
+	MetaTitle       string    `json:"meta_title"`
+	MetaDescription string    `json:"meta_description"`
+	AuthorId        ghostId   `json:"author_id"`
+	CreatedAt       ghostTime `json:"created_at"`
+	UpdatedAt       ghostTime `json:"updated_at"`
+	PublishedAt     ghostTime `json:"published_at"`
+}
+
+// ghostPostMeta holds the meta fields of a post since Ghost 4.0, they were
+// fields of the post before.
+type ghostPostMeta struct {
+	PostId          ghostId `json:"post_id"`
+	MetaTitle       string  `json:"meta_title"`
+	MetaDescription string  `json:"meta_description"`
+}
+
+type ghostTag struct {
+	Id          ghostId `json:"id"`
+	Name        string  `json:"name"`
+	Slug        string  `json:"slug"`
+	Description string  `json:"description"`
+}
+
+type ghostPostTag struct {
+	PostId    ghostId `json:"post_id"`
+	TagId     ghostId `json:"tag_id"`
+	SortOrder int     `json:"sort_order"`
+}
+
+type ghostPostAuthor struct {
+	PostId    ghostId `json:"post_id"`
+	AuthorId  ghostId `json:"author_id"`
+	SortOrder int     `json:"sort_order"`
+}
+
+// ghostStaff is a user of Ghost, which calls them staff.
+type ghostStaff struct {
+	Id           ghostId `json:"id"`
+	Name         string  `json:"name"`
+	Slug         string  `json:"slug"`
+	Email        string  `json:"email"`
+	Image        string  `json:"image,omitempty"`
+	ProfileImage string  `json:"profile_image"`
+	Cover        string  `json:"cover,omitempty"`
+	CoverImage   string  `json:"cover_image"`
+	Bio          string  `json:"bio"`
+	Website      string  `json:"website"`
+	Location     string  `json:"location"`
+	Status       string  `json:"status"`
+}
+
+type ghostRole struct {
+	Id   ghostId `json:"id"`
+	Name string  `json:"name"`
+}
+
+type ghostRoleUser struct {
+	RoleId ghostId `json:"role_id"`
+	UserId ghostId `json:"user_id"`
+}
+
+type ghostSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// isGhostSetting tells whether Ghost and Dingo both have the setting.
+func isGhostSetting(key string) bool {
+	return key == "title" || key == "description"
+}
+
+// ReadExport reads an export of Dingo or of Ghost.
+func ReadExport(data []byte) (*Export, error) {
+	if IsGhostExport(data) {
+		return ParseGhostExport(data)
+	}
+	return ParseExport(data)
+}
+
+// IsGhostExport tells whether data looks like an export of Ghost.
+func IsGhostExport(data []byte) bool {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	_, hasDB := doc["db"]
+	_, hasData := doc["data"]
+	return hasDB || hasData
+}
+
+// ParseGhostExport reads an export of Ghost, and converts it to an export of
+// Dingo which ImportSite imports. The posts keep the UUIDs of Ghost, so
+// that they are recognized when imported again. Ghost has no categories and
+// no comments, and the images of Ghost are expected in the upload
+// directory.
+func ParseGhostExport(data []byte) (*Export, error) {
+	g := new(GhostExport)
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, err
+	}
+	if len(g.DB) == 0 {
+		// Before Ghost 1.0
+		legacy := new(GhostDB)
+		if err := json.Unmarshal(data, legacy); err != nil {
+			return nil, err
+		}
+		g.DB = []*GhostDB{legacy}
+	}
+	gd := g.DB[0]
+	if gd.Meta.Version == "" {
+		return nil, fmt.Errorf("Not a Ghost export")
+	}
+
+	e := &Export{
+		Version:    ExportVersion,
+		ExportedAt: utils.Now(),
+		Users:      []*ExportUser{},
+		Categories: []*ExportCategory{},
+		Tags:       []*ExportTag{},
+		Posts:      []*ExportPost{},
+		Pages:      []*ExportPost{},
+		Comments:   []*ExportComment{},
+		Settings:   []*ExportSetting{},
+	}
+	ids := make(map[ghostId]int64)
+	id := func(gid ghostId) int64 {
+		if _, ok := ids[gid]; !ok {
+			ids[gid] = int64(len(ids) + 1)
+		}
+		return ids[gid]
+	}
+
+	roleNames := make(map[ghostId]string)
+	for _, r := range gd.Data.Roles {
+		roleNames[r.Id] = r.Name
+	}
+	userRoles := make(map[ghostId]int)
+	for _, ru := range gd.Data.RolesUsers {
+		switch roleNames[ru.RoleId] {
+		case "Owner":
+			userRoles[ru.UserId] = RoleOwner
+		case "Administrator":
+			userRoles[ru.UserId] = RoleAdministrator
+		case "Editor":
+			userRoles[ru.UserId] = RoleEditor
+		}
+	}
+	for _, u := range gd.Data.Users {
+		eu := &ExportUser{Id: id("user" + u.Id), Name: u.Name, Slug: u.Slug, Email: u.Email, Bio: u.Bio, Website: u.Website, Location: u.Location, Role: RoleAuthor, Status: UserActive}
+		eu.Image = ghostURL(firstNonEmpty(u.ProfileImage, u.Image))
+		eu.Cover = ghostURL(firstNonEmpty(u.CoverImage, u.Cover))
+		if role, ok := userRoles[u.Id]; ok {
+			eu.Role = role
+		}
+		if u.Status == "inactive" || u.Status == "locked" {
+			eu.Status = UserSuspended
+		}
+		e.Users = append(e.Users, eu)
+	}
+
+	for _, t := range gd.Data.Tags {
+		e.Tags = append(e.Tags, &ExportTag{Id: id("tag" + t.Id), Name: t.Name, Slug: t.Slug})
+	}
+	postTags := make(map[ghostId][]*ghostPostTag)
+	for _, pt := range gd.Data.PostsTags {
+		postTags[pt.PostId] = append(postTags[pt.PostId], pt)
+	}
+	postAuthors := make(map[ghostId]ghostId)
+	sort.SliceStable(gd.Data.PostsAuthors, func(i, j int) bool {
+		return gd.Data.PostsAuthors[i].SortOrder < gd.Data.PostsAuthors[j].SortOrder
+	})
+	for _, pa := range gd.Data.PostsAuthors {
+		if _, ok := postAuthors[pa.PostId]; !ok {
+			postAuthors[pa.PostId] = pa.AuthorId
+		}
+	}
+
+	postMeta := make(map[ghostId]*ghostPostMeta)
+	for _, pm := range gd.Data.PostsMeta {
+		postMeta[pm.PostId] = pm
+	}
+
+	for _, p := range gd.Data.Posts {
+		ep := &ExportPost{
+			Id:              id("post" + p.Id),
+			UUID:            p.UUID,
+			Title:           p.Title,
+			Slug:            p.Slug,
+			Markdown:        ghostMarkdown(p),
+			Image:           ghostURL(firstNonEmpty(p.FeatureImage, p.Image)),
+			Featured:        bool(p.Featured),
+			AllowComment:    true,
+			Status:          p.Status,
+			MetaTitle:       p.MetaTitle,
+			MetaDescription: p.MetaDescription,
+			CreatedAt:       p.CreatedAt.Time,
+			UpdatedAt:       p.UpdatedAt.Time,
+			PublishedAt:     p.PublishedAt.Time,
+			Tags:            []int64{},
+		}
+		if pm, ok := postMeta[p.Id]; ok {
+			ep.MetaTitle = firstNonEmpty(pm.MetaTitle, ep.MetaTitle)
+			ep.MetaDescription = firstNonEmpty(pm.MetaDescription, ep.MetaDescription)
+		}
+		author := p.AuthorId
+		if a, ok := postAuthors[p.Id]; ok {
+			author = a
+		}
+		if author != "" {
+			ep.AuthorId = id("user" + author)
+		}
+		tags := postTags[p.Id]
+		sort.SliceStable(tags, func(i, j int) bool {
+			return tags[i].SortOrder < tags[j].SortOrder
+		})
+		for _, pt := range tags {
+			ep.Tags = append(ep.Tags, id("tag"+pt.TagId))
+		}
+		if p.Page || p.Type == "page" {
+			e.Pages = append(e.Pages, ep)
+		} else {
+			e.Posts = append(e.Posts, ep)
+		}
+	}
+
+	for _, s := range gd.Data.Settings {
+		if isGhostSetting(s.Key) {
+			e.Settings = append(e.Settings, &ExportSetting{Key: s.Key, Value: s.Value, Type: "blog"})
+		}
+		if s.Key == "navigation" {
+			json.Unmarshal([]byte(s.Value), &e.Navigation)
+		}
+	}
+	return e, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ghostURL points the images of Ghost to the upload directory of Dingo.
+func ghostURL(s string) string {
+	s = strings.Replace(s, "__GHOST_URL__/content/images/", "/upload/", -1)
+	s = strings.Replace(s, "\"/content/images/", "\"/upload/", -1)
+	s = strings.Replace(s, "(/content/images/", "(/upload/", -1)
+	if strings.HasPrefix(s, "/content/images/") {
+		s = "/upload/" + strings.TrimPrefix(s, "/content/images/")
+	}
+	return strings.Replace(s, "__GHOST_URL__", "", -1)
+}
+
+// ghostMarkdown finds the Markdown of a post. Ghost 0.x stores Markdown,
+// later versions store Markdown cards in mobiledoc or lexical documents,
+// and otherwise the HTML is converted.
+func ghostMarkdown(p *ghostPost) string {
+	if p.Markdown != "" {
+		return ghostURL(p.Markdown)
+	}
+	if p.Mobiledoc != "" {
+		var doc struct {
+			Cards    [][]json.RawMessage `json:"cards"`
+			Sections [][]json.RawMessage `json:"sections"`
+		}
+		if json.Unmarshal([]byte(p.Mobiledoc), &doc) == nil && len(doc.Cards) > 0 && len(doc.Cards) == len(doc.Sections) {
+			parts := make([]string, 0, len(doc.Cards))
+			for _, card := range doc.Cards {
+				var name string
+				var payload struct {
+					Markdown string `json:"markdown"`
+				}
+				if len(card) != 2 || json.Unmarshal(card[0], &name) != nil || name != "markdown" || json.Unmarshal(card[1], &payload) != nil {
+					parts = nil
+					break
+				}
+				parts = append(parts, payload.Markdown)
+			}
+			if parts != nil {
+				return ghostURL(strings.Join(parts, "\n\n"))
+			}
+		}
+	}
+	if p.Lexical != "" {
+		var doc struct {
+			Root struct {
+				Children []struct {
+					Type     string `json:"type"`
+					Markdown string `json:"markdown"`
+				} `json:"children"`
+			} `json:"root"`
+		}
+		if json.Unmarshal([]byte(p.Lexical), &doc) == nil && len(doc.Root.Children) > 0 {
+			parts := make([]string, 0, len(doc.Root.Children))
+			for _, c := range doc.Root.Children {
+				if c.Type != "markdown" {
+					parts = nil
+					break
+				}
+				parts = append(parts, c.Markdown)
+			}
+			if parts != nil {
+				return ghostURL(strings.Join(parts, "\n\n"))
+			}
+		}
+	}
+	md, _ := utils.Html2Markdown(p.Html)
+	return ghostURL(md)
+}
+
+// ExportGhost exports the posts, pages, tags, users and settings of the site
+// in the format which Ghost imports. The posts are written as Markdown cards,
+// and their categories become their first tags. Comments are not exported,
+// as Ghost has none.
+func ExportGhost() (*GhostExport, error) {
+	e, err := ExportSite(false)
+	if err != nil {
+		return nil, err
+	}
+	gd := new(GhostDB)
+	gd.Meta.ExportedOn = e.ExportedAt.UnixNano() / int64(time.Millisecond)
+	gd.Meta.Version = "2.0.0"
+	d := &gd.Data
+	d.Posts = []*ghostPost{}
+	d.Tags = []*ghostTag{}
+	d.PostsTags = []*ghostPostTag{}
+	d.PostsAuthors = []*ghostPostAuthor{}
+	d.Users = []*ghostStaff{}
+	d.Roles = []*ghostRole{}
+	d.RolesUsers = []*ghostRoleUser{}
+	d.Settings = []*ghostSetting{}
+
+	roles, err := GetAllRoles()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		d.Roles = append(d.Roles, &ghostRole{Id: ghostObjectId(ghostRoleKind, int64(r.Id)), Name: r.Name})
+	}
+	for _, u := range e.Users {
+		status := "active"
+		if u.Status == UserSuspended {
+			status = "inactive"
+		}
+		uid := ghostObjectId(ghostUserKind, u.Id)
+		d.Users = append(d.Users, &ghostStaff{Id: uid, Name: u.Name, Slug: u.Slug, Email: u.Email, ProfileImage: dingoURL(u.Image), CoverImage: dingoURL(u.Cover), Bio: u.Bio, Website: u.Website, Location: u.Location, Status: status})
+		d.RolesUsers = append(d.RolesUsers, &ghostRoleUser{RoleId: ghostObjectId(ghostRoleKind, int64(u.Role)), UserId: uid})
+	}
+	for _, t := range e.Tags {
+		d.Tags = append(d.Tags, &ghostTag{Id: ghostObjectId(ghostTagKind, t.Id), Name: t.Name, Slug: t.Slug})
+	}
+	categories := make(map[int64]bool)
+	for _, c := range e.Categories {
+		d.Tags = append(d.Tags, &ghostTag{Id: ghostObjectId(ghostCategoryKind, c.Id), Name: c.Name, Slug: c.Slug, Description: c.Description})
+		categories[c.Id] = true
+	}
+
+	pages := make(map[*ExportPost]bool)
+	for _, p := range e.Pages {
+		pages[p] = true
+	}
+	for _, p := range append(e.Posts, e.Pages...) {
+		pid := ghostObjectId(ghostPostKind, p.Id)
+		mobiledoc, err := json.Marshal(map[string]interface{}{
+			"version":  "0.3.1",
+			"markups":  []interface{}{},
+			"atoms":    []interface{}{},
+			"cards":    [][]interface{}{{"markdown", map[string]string{"cardName": "markdown", "markdown": dingoURL(p.Markdown)}}},
+			"sections": [][]int{{10, 0}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		gp := &ghostPost{
+			Id:              pid,
+			UUID:            p.UUID,
+			Title:           p.Title,
+			Slug:            p.Slug,
+			Mobiledoc:       string(mobiledoc),
+			Html:            dingoURL(p.Html),
+			FeatureImage:    dingoURL(p.Image),
+			Featured:        ghostBool(p.Featured),
+			Page:            ghostBool(pages[p]),
+			Type:            "post",
+			Status:          p.Status,
+			MetaTitle:       p.MetaTitle,
+			MetaDescription: p.MetaDescription,
+			AuthorId:        ghostObjectId(ghostUserKind, p.AuthorId),
+			CreatedAt:       ghostTime{p.CreatedAt},
+			UpdatedAt:       ghostTime{p.UpdatedAt},
+			PublishedAt:     ghostTime{p.PublishedAt},
+		}
+		if gp.Page {
+			gp.Type = "page"
+		}
+		d.Posts = append(d.Posts, gp)
+		d.PostsAuthors = append(d.PostsAuthors, &ghostPostAuthor{PostId: pid, AuthorId: gp.AuthorId})
+		order := 0
+		if categories[p.CategoryId] {
+			d.PostsTags = append(d.PostsTags, &ghostPostTag{PostId: pid, TagId: ghostObjectId(ghostCategoryKind, p.CategoryId)})
+			order++
+		}
+		for _, t := range p.Tags {
+			d.PostsTags = append(d.PostsTags, &ghostPostTag{PostId: pid, TagId: ghostObjectId(ghostTagKind, t), SortOrder: order})
+			order++
+		}
+	}
+
+	for _, s := range e.Settings {
+		if isGhostSetting(s.Key) {
+			d.Settings = append(d.Settings, &ghostSetting{Key: s.Key, Value: s.Value})
+		}
+	}
+	if e.Navigation != nil {
+		navs, err := json.Marshal(e.Navigation)
+		if err != nil {
+			return nil, err
+		}
+		d.Settings = append(d.Settings, &ghostSetting{Key: "navigation", Value: string(navs)})
+	}
+	return &GhostExport{DB: []*GhostDB{gd}}, nil
+}
+
+const (
+	ghostPostKind = iota + 1
+	ghostTagKind
+	ghostCategoryKind
+	ghostUserKind
+	ghostRoleKind
+)
+
+// ghostObjectId makes an ObjectId, which Ghost uses as ids, out of the kind
+// and the id of an item.
+func ghostObjectId(kind int, id int64) ghostId {
+	return ghostId(fmt.Sprintf("%08x%016x", kind, id))
+}
+
+// dingoURL points the uploaded files of Dingo to the images of Ghost.
+func dingoURL(s string) string {
+	s = strings.Replace(s, "\"/upload/", "\"/content/images/", -1)
+	s = strings.Replace(s, "(/upload/", "(/content/images/", -1)
+	if strings.HasPrefix(s, "/upload/") {
+		s = "/content/images/" + strings.TrimPrefix(s, "/upload/")
+	}
+	return s
+}
@@ -0,0 +1,199 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const ghostFixture = `{"db": [{
+	"meta": {"exported_on": 1462089600000, "version": "2.25.0"},
+	"data": {
+		"posts": [
+			{"id": "5a0000000000000000000001", "uuid": "0c2f0e5a-0000-4000-8000-000000000001", "title": "From Ghost", "slug": "from-ghost",
+			 "mobiledoc": "{\"version\":\"0.3.1\",\"markups\":[],\"atoms\":[],\"cards\":[[\"markdown\",{\"cardName\":\"markdown\",\"markdown\":\"Hello **Ghost**\\n\\n![](__GHOST_URL__/content/images/2016/05/ghost.png)\"}]],\"sections\":[[10,0]]}",
+			 "html": "<p>Hello <strong>Ghost</strong></p>", "feature_image": "/content/images/2016/05/cover.png", "featured": true, "page": false,
+			 "status": "published", "created_at": "2016-05-01T08:00:00.000Z", "updated_at": "2016-05-02T08:00:00.000Z", "published_at": "2016-05-01T09:00:00.000Z"},
+			{"id": "5a0000000000000000000002", "uuid": "0c2f0e5a-0000-4000-8000-000000000002", "title": "Rich Post", "slug": "rich-post",
+			 "mobiledoc": "{\"version\":\"0.3.1\",\"cards\":[[\"image\",{\"src\":\"x.png\"}]],\"sections\":[[1,\"p\",[]],[10,0]]}",
+			 "html": "<h2>Rich</h2><p>Converted</p>", "status": "draft", "meta_title": "Rich", "meta_description": "A rich post.", "created_at": "2016-05-03T08:00:00.000Z"},
+			{"id": "5a0000000000000000000003", "uuid": "0c2f0e5a-0000-4000-8000-000000000003", "title": "Contact", "slug": "contact",
+			 "html": "<p>Mail me</p>", "page": true, "status": "published", "published_at": "2016-04-01T08:00:00.000Z"}
+		],
+		"tags": [
+			{"id": "5b0000000000000000000001", "name": "News", "slug": "news"},
+			{"id": "5b0000000000000000000002", "name": "Go", "slug": "go"}
+		],
+		"posts_tags": [
+			{"post_id": "5a0000000000000000000001", "tag_id": "5b0000000000000000000002", "sort_order": 1},
+			{"post_id": "5a0000000000000000000001", "tag_id": "5b0000000000000000000001", "sort_order": 0}
+		],
+		"posts_meta": [
+			{"post_id": "5a0000000000000000000001", "meta_title": "Hello Ghost", "meta_description": "Moved from Ghost."}
+		],
+		"posts_authors": [
+			{"post_id": "5a0000000000000000000001", "author_id": "5c0000000000000000000002", "sort_order": 0}
+		],
+		"users": [
+			{"id": "5c0000000000000000000001", "name": "Ghost Owner", "slug": "ghost-owner", "email": "owner@ghost.example", "status": "active"},
+			{"id": "5c0000000000000000000002", "name": "Ghost Editor", "slug": "ghost-editor", "email": "editor@ghost.example", "profile_image": "/content/images/me.png", "status": "inactive"}
+		],
+		"roles": [
+			{"id": "5d0000000000000000000001", "name": "Owner"},
+			{"id": "5d0000000000000000000002", "name": "Editor"}
+		],
+		"roles_users": [
+			{"role_id": "5d0000000000000000000001", "user_id": "5c0000000000000000000001"},
+			{"role_id": "5d0000000000000000000002", "user_id": "5c0000000000000000000002"}
+		],
+		"settings": [
+			{"key": "title", "value": "Ghost Blog"},
+			{"key": "navigation", "value": "[{\"label\":\"Home\",\"url\":\"/\"}]"},
+			{"key": "active_theme", "value": "casper"}
+		]
+	}
+}]}`
+
+const legacyGhostFixture = `{
+	"meta": {"exported_on": 1462089600000, "version": "004"},
+	"data": {
+		"posts": [
+			{"id": 1, "uuid": "0c2f0e5a-0000-4000-8000-000000000010", "title": "Old Ghost", "slug": "old-ghost", "markdown": "Old *markdown*",
+			 "html": "<p>Old <em>markdown</em></p>", "image": "/content/images/old.png", "page": 0, "status": "published", "author_id": 1,
+			 "created_at": 1462089600000, "published_at": "2016-05-01 08:00:00"}
+		],
+		"users": [{"id": 1, "name": "Old Author", "slug": "old-author", "email": "old@ghost.example", "status": "active"}],
+		"roles": [{"id": 3, "name": "Author"}],
+		"roles_users": [{"role_id": 3, "user_id": 1}]
+	}
+}`
+
+func TestGhost(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Import an export of Ghost", func() {
+			So(IsGhostExport([]byte(ghostFixture)), ShouldBeTrue)
+			e, err := ReadExport([]byte(ghostFixture))
+			So(err, ShouldBeNil)
+			result, err := ImportSite(e)
+			So(err, ShouldBeNil)
+			So(*result, ShouldResemble, ImportResult{Users: 2, Posts: 2, Pages: 1, Settings: 1})
+
+			p, err := GetPostByUUID("0c2f0e5a-0000-4000-8000-000000000001")
+			So(err, ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "Hello **Ghost**\n\n![](/upload/2016/05/ghost.png)")
+			So(p.Image, ShouldEqual, "/upload/2016/05/cover.png")
+			So(p.IsFeatured, ShouldBeTrue)
+			So(p.Status(), ShouldEqual, "published")
+			So(p.Author.Email, ShouldEqual, "editor@ghost.example")
+			So(p.Tags, ShouldHaveLength, 2)
+			So(p.Tags[0].Slug, ShouldEqual, "news")
+			So(p.MetaTitle, ShouldEqual, "Hello Ghost")
+			So(p.MetaDescription, ShouldEqual, "Moved from Ghost.")
+
+			rich, _ := GetPostBySlug("rich-post")
+			So(rich.Markdown, ShouldEqual, "## Rich\n\nConverted")
+			So(rich.Status(), ShouldEqual, "draft")
+			So(rich.MetaTitle, ShouldEqual, "Rich")
+			So(rich.MetaDescription, ShouldEqual, "A rich post.")
+
+			page, _ := GetPostBySlug("contact")
+			So(page.IsPage, ShouldBeTrue)
+
+			owner, _ := GetUserByEmail("owner@ghost.example")
+			So(owner.Role, ShouldEqual, RoleOwner)
+			editor, _ := GetUserByEmail("editor@ghost.example")
+			So(editor.Role, ShouldEqual, RoleEditor)
+			So(editor.Status, ShouldEqual, UserSuspended)
+			So(editor.Image, ShouldEqual, "/upload/me.png")
+
+			So(GetSettingValue("title"), ShouldEqual, "Ghost Blog")
+			So(GetNavigators()[0].Label, ShouldEqual, "Home")
+
+			Convey("Import it again", func() {
+				result, err := ImportSite(e)
+				So(err, ShouldBeNil)
+				So(result.Posts+result.Pages+result.Users, ShouldEqual, 0)
+			})
+		})
+
+		Convey("Import an export of Ghost 0.x", func() {
+			e, err := ReadExport([]byte(legacyGhostFixture))
+			So(err, ShouldBeNil)
+			_, err = ImportSite(e)
+			So(err, ShouldBeNil)
+
+			p, err := GetPostBySlug("old-ghost")
+			So(err, ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "Old *markdown*")
+			So(p.Image, ShouldEqual, "/upload/old.png")
+			So(p.Author.Email, ShouldEqual, "old@ghost.example")
+			So(p.CreatedAt.Unix(), ShouldEqual, 1462089600)
+		})
+
+		Convey("Export for Ghost", func() {
+			u := NewUser("author@example.com", "Author")
+			So(u.Create("password"), ShouldBeNil)
+			c := NewCategory("Programming", "programming")
+			So(c.Save(), ShouldBeNil)
+			p := mockPost()
+			p.CreatedBy = u.Id
+			p.Category = c
+			p.Markdown = "![](/upload/dingo.png)"
+			p.MetaTitle = "Dingo"
+			p.MetaDescription = "Written with Dingo."
+			So(p.Save(), ShouldBeNil)
+			So(SetNavigators([]string{"Home"}, []string{"/"}), ShouldBeNil)
+
+			g, err := ExportGhost()
+			So(err, ShouldBeNil)
+			gd := g.DB[0]
+			So(gd.Data.Posts, ShouldHaveLength, 1)
+			So(gd.Data.Posts[0].UUID, ShouldEqual, p.UUID)
+			So(gd.Data.Posts[0].Mobiledoc, ShouldContainSubstring, `"markdown":"![](/content/images/dingo.png)"`)
+			So(gd.Data.Posts[0].Id, ShouldHaveLength, 24)
+			So(gd.Data.Posts[0].MetaTitle, ShouldEqual, "Dingo")
+			So(gd.Data.Posts[0].MetaDescription, ShouldEqual, "Written with Dingo.")
+			So(gd.Data.Tags, ShouldHaveLength, 3)
+			So(gd.Data.PostsTags[0].TagId, ShouldEqual, ghostObjectId(ghostCategoryKind, c.Id))
+			So(gd.Data.Users, ShouldHaveLength, 1)
+			So(gd.Data.RolesUsers[0].RoleId, ShouldEqual, ghostObjectId(ghostRoleKind, RoleOwner))
+
+			data, err := json.Marshal(g)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"created_at":"20`)
+
+			Convey("Import it into another site", func() {
+				os.Remove("test.db")
+				Initialize("test.db", true)
+				e, err := ReadExport(data)
+				So(err, ShouldBeNil)
+				_, err = ImportSite(e)
+				So(err, ShouldBeNil)
+
+				imported, err := GetPostByUUID(p.UUID)
+				So(err, ShouldBeNil)
+				So(imported.Markdown, ShouldEqual, p.Markdown)
+				So(imported.Tags, ShouldHaveLength, 3)
+				So(imported.Tags[0].Slug, ShouldEqual, "programming")
+				So(imported.Author.Email, ShouldEqual, "author@example.com")
+				So(imported.MetaTitle, ShouldEqual, "Dingo")
+				So(imported.MetaDescription, ShouldEqual, "Written with Dingo.")
+				So(GetNavigators(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("Refuse other documents", func() {
+			So(IsGhostExport([]byte(`{"version": 1, "posts": []}`)), ShouldBeFalse)
+			_, err := ParseGhostExport([]byte(`{"data": {}}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -1,13 +1,15 @@
 package model
 
 import (
-	"database/sql"
+	"fmt"
+	"os"
+	"strings"
 
+	"github.com/dinever/dingo/app/model/sql_builder"
 	"github.com/dinever/dingo/app/utils"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
+var db *store
 
 const samplePostContent = `
 Welcome to Dingo! This is your first post. You can find it in the [admin panel](/admin/).
@@ -79,10 +81,21 @@ type Row interface {
 	Scan(dest ...interface{}) error
 }
 
-func Initialize(dbPath string, dbExists bool) error {
-	if err := initConnection(dbPath); err != nil {
+// Initialize connects to the database, see openStore for the data source
+// names, and brings it up to date. The welcome data is created when the
+// database is new: dbExists tells whether the SQLite database file existed,
+// other databases are new when they have not been migrated yet.
+func Initialize(dsn string, dbExists bool) error {
+	if err := initConnection(dsn); err != nil {
 		return err
 	}
+	if db.dialect != SQL.SQLite {
+		version, err := SchemaVersion()
+		if err != nil {
+			return err
+		}
+		dbExists = version > 0
+	}
 
 	if err := createTableIfNotExist(); err != nil {
 		return err
@@ -97,9 +110,34 @@ func Initialize(dbPath string, dbExists bool) error {
 	return nil
 }
 
-func initConnection(dbPath string) error {
+// OpenExisting opens the database of an existing site and applies the
+// pending migrations. Unlike Initialize, it never creates the welcome data,
+// and fails if the database does not exist or is empty.
+func OpenExisting(dsn string) error {
+	dialect, source, err := parseDSN(dsn)
+	if err != nil {
+		return err
+	}
+	// Opening a SQLite database which does not exist would create it
+	if dialect == SQL.SQLite {
+		if _, err := os.Stat(strings.SplitN(source, "?", 2)[0]); err != nil {
+			return fmt.Errorf("No database at %s", RedactDSN(dsn))
+		}
+	}
+	if err := initConnection(dsn); err != nil {
+		return err
+	}
+	if exist, err := tableExists(db, "settings"); err != nil {
+		return err
+	} else if !exist {
+		return fmt.Errorf("The database %s has no site", RedactDSN(dsn))
+	}
+	return createTableIfNotExist()
+}
+
+func initConnection(dsn string) error {
 	var err error
-	db, err = sql.Open("sqlite3", dbPath)
+	db, err = openStore(dsn)
 	if err != nil {
 		return err
 	}
@@ -107,7 +145,13 @@ func initConnection(dbPath string) error {
 }
 
 func createTableIfNotExist() error {
-	if _, err := db.Exec(schema); err != nil {
+	if _, err := Migrate(false); err != nil {
+		return err
+	}
+	if err := checkRoles(); err != nil {
+		return err
+	}
+	if err := initSearchIndex(); err != nil {
 		return err
 	}
 
@@ -115,6 +159,28 @@ func createTableIfNotExist() error {
 	return nil
 }
 
+// addColumnIfNotExist adds a column that was introduced after the table was
+// created, so that databases created by older versions keep working.
+func addColumnIfNotExist(s Store, table, column, definition string) error {
+	exist, err := columnExists(s, table, column)
+	if err != nil || exist {
+		return err
+	}
+	return schemaExec(s, "ALTER TABLE "+table+" ADD COLUMN "+column+" "+definition)
+}
+
+func columnExists(s Store, table, column string) (bool, error) {
+	var count int
+	err := s.QueryRow(db.dialect.ColumnExists, table, column).Scan(&count)
+	return count > 0, err
+}
+
+func tableExists(s Store, table string) (bool, error) {
+	var count int
+	err := s.QueryRow(db.dialect.TableExists, table).Scan(&count)
+	return count > 0, err
+}
+
 func checkBlogSettings() {
 	SetSettingIfNotExists("theme", "default", "blog")
 	SetSettingIfNotExists("title", "My Blog", "blog")
@@ -130,7 +196,7 @@ func createWelcomeData() error {
 	p.Html = utils.Markdown2Html(p.Markdown)
 	p.Tags = GenerateTagsFromCommaString("Welcome, Dingo")
 	p.AllowComment = true
-	p.Category = ""
+	p.Category = nil
 	p.CreatedBy = 0
 	p.UpdatedBy = 0
 	p.IsPublished = true
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+	"github.com/twinj/uuid"
+)
+
+// InvitationExpiration is how long an invitation can be accepted for.
+const InvitationExpiration = 7 * 24 * time.Hour
+
+// Invitation allows someone to create an account with a preassigned role.
+// Only the hash of the token is stored, the token itself is only known by
+// the invitation link.
+type Invitation struct {
+	Id         int64
+	Token      string
+	Email      string
+	Role       int
+	CreatedAt  *time.Time
+	CreatedBy  int64
+	ExpiredAt  *time.Time
+	AcceptedAt *time.Time
+}
+
+func NewInvitation(email string, role int, createdBy int64) *Invitation {
+	i := &Invitation{
+		Token:     utils.RandomToken(20),
+		Email:     email,
+		Role:      role,
+		CreatedAt: utils.Now(),
+		CreatedBy: createdBy,
+	}
+	expiredAt := i.CreatedAt.Add(InvitationExpiration)
+	i.ExpiredAt = &expiredAt
+	return i
+}
+
+func (i *Invitation) Url() string {
+	return "/invite/" + i.Token + "/"
+}
+
+func (i *Invitation) RoleName() string {
+	return (&User{Role: i.Role}).RoleName()
+}
+
+func (i *Invitation) IsValid() bool {
+	return i.AcceptedAt == nil && i.ExpiredAt.After(*utils.Now())
+}
+
+func (i *Invitation) Save() error {
+	if i.Role < RoleAdministrator || i.Role > RoleAuthor {
+		return fmt.Errorf("Invalid role")
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	i.Id, err = writeDB.Insert(stmtInsertInvitation, utils.Sha256(i.Token), i.Email, i.Role, i.CreatedAt, i.CreatedBy, i.ExpiredAt, nil)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// AcceptAs creates the user of the invitation, with the role it gives, and
+// marks it as used. Both are done at once so that an invitation is not used
+// up when the user can not be created.
+func (i *Invitation) AcceptAs(u *User, password string) error {
+	hashedPassword, err := EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+	if u.Slug == "" {
+		u.Slug = GenerateSlug(u.Name, "users")
+	}
+	u.Role = i.Role
+	now := utils.Now()
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	result, err := writeDB.Exec(stmtUpdateInvitationAccepted, now, i.Id)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeDB.Rollback()
+		return fmt.Errorf("Invitation has already been accepted")
+	}
+	u.Id, err = writeDB.Insert(stmtInsertUser, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), u.Name, u.Slug, hashedPassword, u.Email, u.Image, u.Cover, now, i.CreatedBy, now, i.CreatedBy)
+	if err == nil {
+		_, err = writeDB.Exec(stmtInsertRoleUser, u.Role, u.Id)
+	}
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	i.AcceptedAt = now
+	return writeDB.Commit()
+}
+
+func scanInvitation(row Row, i *Invitation) error {
+	return row.Scan(&i.Id, &i.Token, &i.Email, &i.Role, &i.CreatedAt, &i.CreatedBy, &i.ExpiredAt, &i.AcceptedAt)
+}
+
+func GetInvitationById(id int64) (*Invitation, error) {
+	i := new(Invitation)
+	if err := scanInvitation(db.QueryRow(stmtGetInvitationById, id), i); err != nil {
+		return nil, err
+	}
+	i.Token = ""
+	return i, nil
+}
+
+// GetInvitationByToken finds the invitation of a token, it returns an error
+// if the invitation has expired or was already accepted.
+func GetInvitationByToken(token string) (*Invitation, error) {
+	i := new(Invitation)
+	if err := scanInvitation(db.QueryRow(stmtGetInvitationByToken, utils.Sha256(token)), i); err != nil {
+		return nil, err
+	}
+	i.Token = token
+	if !i.IsValid() {
+		return nil, fmt.Errorf("Invitation has expired")
+	}
+	return i, nil
+}
+
+func GetPendingInvitations() ([]*Invitation, error) {
+	rows, err := db.Query(stmtGetPendingInvitations, utils.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invitations := make([]*Invitation, 0)
+	for rows.Next() {
+		i := new(Invitation)
+		if err := scanInvitation(rows, i); err != nil {
+			return nil, err
+		}
+		i.Token = ""
+		invitations = append(invitations, i)
+	}
+	return invitations, nil
+}
+
+func DeleteInvitationById(id int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtDeleteInvitationById, id)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
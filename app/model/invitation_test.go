@@ -0,0 +1,94 @@
+package model
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInvitation(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Create an invitation", func() {
+			i := NewInvitation("editor@example.com", RoleEditor, 1)
+			err := i.Save()
+			So(err, ShouldBeNil)
+			So(i.Token, ShouldHaveLength, 40)
+
+			Convey("Get the invitation by token", func() {
+				invitation, err := GetInvitationByToken(i.Token)
+				So(err, ShouldBeNil)
+				So(invitation.Email, ShouldEqual, "editor@example.com")
+				So(invitation.Role, ShouldEqual, RoleEditor)
+			})
+
+			Convey("The token is not stored", func() {
+				invitation, err := GetInvitationById(i.Id)
+				So(err, ShouldBeNil)
+				_, err = GetInvitationByToken(invitation.Token)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("List pending invitations", func() {
+				invitations, err := GetPendingInvitations()
+				So(err, ShouldBeNil)
+				So(invitations, ShouldHaveLength, 1)
+			})
+
+			Convey("An invitation can only be accepted once", func() {
+				So(i.AcceptAs(NewUser("editor@example.com", "Editor"), "password"), ShouldBeNil)
+				So(i.AcceptAs(NewUser("other@example.com", "Other"), "password"), ShouldNotBeNil)
+				_, err := GetInvitationByToken(i.Token)
+				So(err, ShouldNotBeNil)
+				invitations, _ := GetPendingInvitations()
+				So(invitations, ShouldBeEmpty)
+			})
+
+			Convey("Accept the invitation as a new user", func() {
+				u := NewUser("editor@example.com", "Editor")
+				So(i.AcceptAs(u, "password"), ShouldBeNil)
+				user, err := GetUserByEmail("editor@example.com")
+				So(err, ShouldBeNil)
+				So(user.Role, ShouldEqual, RoleEditor)
+				So(user.CheckPassword("password"), ShouldBeTrue)
+				So(i.AcceptAs(NewUser("other@example.com", "Other"), "password"), ShouldNotBeNil)
+			})
+
+			Convey("The invitation is kept when the user can not be created", func() {
+				_, err := db.Exec(`DROP TABLE roles_users`)
+				So(err, ShouldBeNil)
+				So(i.AcceptAs(NewUser("editor@example.com", "Editor"), "password"), ShouldNotBeNil)
+				So(UserEmailExist("editor@example.com"), ShouldBeFalse)
+				_, err = GetInvitationByToken(i.Token)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Delete the invitation", func() {
+				So(DeleteInvitationById(i.Id), ShouldBeNil)
+				_, err := GetInvitationByToken(i.Token)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Expired invitation", func() {
+			i := NewInvitation("author@example.com", RoleAuthor, 1)
+			expiredAt := time.Now().Add(-time.Hour)
+			i.ExpiredAt = &expiredAt
+			So(i.Save(), ShouldBeNil)
+			_, err := GetInvitationByToken(i.Token)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The owner role can not be given", func() {
+			i := NewInvitation("owner@example.com", RoleOwner, 1)
+			So(i.Save(), ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// The layouts of the dates in the front matter, such as the ones of Jekyll
+// and of Hugo.
+var frontMatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// markdownFileMaxSize is the largest file of a zip archive which is
+// imported, the size given by the archive is not trusted.
+const markdownFileMaxSize = 1 << 20
+
+// The date at the start of the name of a file of Jekyll, such as
+// 2016-05-01-hello-world.md.
+var markdownFileDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-`)
+
+// MarkdownImport imports posts from Markdown files with a YAML or TOML
+// front matter, which may give the title, slug, tags, date, draft,
+// description and uuid of the post. A post is matched to an existing one
+// by its uuid, or else by its slug, and is updated when the file changed.
+type MarkdownImport struct {
+	// Author writes the new posts.
+	Author *User
+}
+
+// MarkdownReport tells which files a Markdown import created, updated or
+// skipped.
+type MarkdownReport struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	// Skipped lists the files which are not imported, with the reason.
+	Skipped []string `json:"skipped"`
+}
+
+func (r *MarkdownReport) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Created: %d\n", len(r.Created))
+	for _, name := range r.Created {
+		fmt.Fprintf(&b, "  %s\n", name)
+	}
+	fmt.Fprintf(&b, "Updated: %d\n", len(r.Updated))
+	for _, name := range r.Updated {
+		fmt.Fprintf(&b, "  %s\n", name)
+	}
+	fmt.Fprintf(&b, "Skipped: %d\n", len(r.Skipped))
+	for _, name := range r.Skipped {
+		fmt.Fprintf(&b, "  %s\n", name)
+	}
+	return b.String()
+}
+
+func isMarkdownFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// ImportDir imports the Markdown files found in dir and its
+// subdirectories.
+func (m *MarkdownImport) ImportDir(dir string) (*MarkdownReport, error) {
+	report := newMarkdownReport()
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isMarkdownFile(file) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, file)
+		return m.importFile(filepath.ToSlash(name), data, report)
+	})
+	return report, err
+}
+
+// ImportZip imports the Markdown files of a zip archive.
+func (m *MarkdownImport) ImportZip(r io.ReaderAt, size int64) (*MarkdownReport, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("Not a zip archive: %v", err)
+	}
+	files := make([]*zip.File, 0, len(z.File))
+	for _, f := range z.File {
+		// Skip the metadata of macOS which is added to the archives
+		if f.FileInfo().IsDir() || !isMarkdownFile(f.Name) || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	report := newMarkdownReport()
+	for _, f := range files {
+		tooLarge := f.Name + ": larger than 1 MB"
+		if f.UncompressedSize64 > markdownFileMaxSize {
+			report.Skipped = append(report.Skipped, tooLarge)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return report, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(rc, markdownFileMaxSize+1))
+		rc.Close()
+		if err != nil {
+			return report, err
+		}
+		if len(data) > markdownFileMaxSize {
+			report.Skipped = append(report.Skipped, tooLarge)
+			continue
+		}
+		if err := m.importFile(f.Name, data, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func newMarkdownReport() *MarkdownReport {
+	return &MarkdownReport{Created: make([]string, 0), Updated: make([]string, 0), Skipped: make([]string, 0)}
+}
+
+// importFile imports one file. A file which can not be read as a post is
+// skipped, an error is only returned when the post can not be saved.
+func (m *MarkdownImport) importFile(name string, data []byte, report *MarkdownReport) error {
+	skip := func(reason string) error {
+		report.Skipped = append(report.Skipped, name+": "+reason)
+		return nil
+	}
+	fm, body, err := utils.ParseFrontMatter(string(data))
+	if err != nil {
+		return skip(err.Error())
+	}
+	title := strings.TrimSpace(fm.String("title"))
+	if title == "" {
+		return skip("no title")
+	}
+	slug := strings.Trim(fm.String("slug"), "/")
+	if slug == "" {
+		base := path.Base(name)
+		base = strings.TrimSuffix(base, path.Ext(base))
+		// Like the slug of a tag, it is not made unique, as the post is
+		// matched on it
+		slug = GenerateSlug(markdownFileDatePattern.ReplaceAllString(base, ""), "tags")
+	}
+	if slug == "" {
+		return skip("no slug")
+	}
+	var date *time.Time
+	if value := fm.String("date"); value != "" {
+		if date = parseFrontMatterDate(value); date == nil {
+			return skip(fmt.Sprintf("the date %q can not be read", value))
+		}
+	}
+	draft := fm.Bool("draft")
+	body = strings.TrimSpace(body)
+
+	var p *Post
+	if id := fm.String("uuid"); id != "" {
+		p, _ = GetPostByUUID(id)
+	}
+	if p == nil {
+		p, _ = GetPostBySlug(slug)
+	}
+	if p == nil {
+		p = NewPost()
+		if id := fm.String("uuid"); id != "" {
+			p.UUID = id
+		}
+		if date != nil {
+			p.CreatedAt = date
+		}
+		p.CreatedBy = m.Author.Id
+		p.userId = m.Author.Id
+		p.AllowComment = true
+	} else {
+		if p.IsPage {
+			return skip(fmt.Sprintf("the slug %q is used by a page", slug))
+		}
+		if !markdownPostChanged(p, title, slug, body, fm, date, draft) {
+			return skip("unchanged")
+		}
+	}
+	p.Title = title
+	p.Slug = slug
+	p.Markdown = body
+	p.Html = utils.Markdown2Html(body)
+	p.Tags = GenerateTagsFromCommaString(strings.Join(fm.List("tags"), ","))
+	p.MetaDescription = fm.String("description")
+	p.IsPublished = !draft
+	p.IsScheduled = false
+	if date != nil {
+		p.PublishedAt = date
+	}
+	created := p.Id == 0
+	if err := p.Save(); err != nil {
+		return fmt.Errorf("Can not save %s: %v", name, err)
+	}
+	if created {
+		report.Created = append(report.Created, name)
+	} else {
+		report.Updated = append(report.Updated, name)
+	}
+	return nil
+}
+
+// markdownPostChanged tells whether the file changes anything in the post.
+func markdownPostChanged(p *Post, title, slug, body string, fm utils.FrontMatter, date *time.Time, draft bool) bool {
+	if p.Title != title || p.Slug != slug || p.Markdown != body || p.MetaDescription != fm.String("description") {
+		return true
+	}
+	if draft != (p.Status() == "draft") {
+		return true
+	}
+	if date != nil && (p.PublishedAt == nil || !p.PublishedAt.Equal(*date)) {
+		return true
+	}
+	tags := GenerateTagsFromCommaString(strings.Join(fm.List("tags"), ","))
+	if len(tags) != len(p.Tags) {
+		return true
+	}
+	slugs := make(map[string]bool)
+	for _, t := range p.Tags {
+		slugs[t.Slug] = true
+	}
+	for _, t := range tags {
+		if !slugs[t.Slug] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFrontMatterDate(value string) *time.Time {
+	for _, layout := range frontMatterDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var markdownFixture = map[string]string{
+	"2016-05-01-hello-yaml.md": `---
+title: "Hello: YAML"
+date: 2016-05-01 10:00:00
+tags:
+  - Go
+  - Web
+description: >
+  A post written
+  in YAML.
+---
+
+Hello **YAML**.
+`,
+	"toml/hello-toml.markdown": `+++
+title = "Hello TOML"
+slug = "toml-post"
+uuid = "6b0b0e5a-0000-4000-8000-000000000001"
+tags = ["Go", 'Blog']
+date = 2016-05-02T10:00:00Z
+draft = true
++++
+Hello *TOML*.
+`,
+	"no-title.md": "---\ndraft: true\n---\nNo title.\n",
+	"broken.md":   "---\ntitle: Broken\n",
+	"notes.txt":   "Not Markdown.",
+}
+
+func writeMarkdownFixture(dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		ioutil.WriteFile(file, []byte(content), 0644)
+	}
+}
+
+func TestMarkdownImport(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		u := NewUser("author@example.com", "Author")
+		So(u.Create("password"), ShouldBeNil)
+		dir, _ := ioutil.TempDir("", "dingo-markdown")
+		writeMarkdownFixture(dir, markdownFixture)
+		m := &MarkdownImport{Author: u}
+
+		Convey("Import a directory", func() {
+			report, err := m.ImportDir(dir)
+			So(err, ShouldBeNil)
+			So(report.Created, ShouldResemble, []string{"2016-05-01-hello-yaml.md", "toml/hello-toml.markdown"})
+			So(report.Updated, ShouldBeEmpty)
+			So(report.Skipped, ShouldHaveLength, 2)
+			So(report.Skipped[0], ShouldStartWith, "broken.md: ")
+			So(report.Skipped[1], ShouldEqual, "no-title.md: no title")
+
+			p, err := GetPostBySlug("hello-yaml")
+			So(err, ShouldBeNil)
+			So(p.Title, ShouldEqual, "Hello: YAML")
+			So(p.Markdown, ShouldEqual, "Hello **YAML**.")
+			So(p.MetaDescription, ShouldEqual, "A post written in YAML.")
+			So(p.Status(), ShouldEqual, "published")
+			So(p.PublishedAt.Format("2006-01-02 15:04"), ShouldEqual, "2016-05-01 10:00")
+			So(p.Author.Email, ShouldEqual, "author@example.com")
+			So(p.TagString(), ShouldEqual, "Go, Web")
+
+			draft, err := GetPostByUUID("6b0b0e5a-0000-4000-8000-000000000001")
+			So(err, ShouldBeNil)
+			So(draft.Slug, ShouldEqual, "toml-post")
+			So(draft.Status(), ShouldEqual, "draft")
+			So(draft.Tags, ShouldHaveLength, 2)
+
+			Convey("Import it again", func() {
+				report, err := m.ImportDir(dir)
+				So(err, ShouldBeNil)
+				So(report.Created, ShouldBeEmpty)
+				So(report.Updated, ShouldBeEmpty)
+				So(report.Skipped, ShouldContain, "2016-05-01-hello-yaml.md: unchanged")
+				So(report.Skipped, ShouldContain, "toml/hello-toml.markdown: unchanged")
+			})
+
+			Convey("Import changed files", func() {
+				writeMarkdownFixture(dir, map[string]string{
+					"2016-05-01-hello-yaml.md": "---\ntitle: Hello again\ntags: [Go]\n---\nChanged.\n",
+					// The post is found by its uuid although its slug changed
+					"toml/hello-toml.markdown": "+++\ntitle = \"Hello TOML\"\nslug = \"new-slug\"\nuuid = \"6b0b0e5a-0000-4000-8000-000000000001\"\n+++\nPublished.\n",
+				})
+				report, err := m.ImportDir(dir)
+				So(err, ShouldBeNil)
+				So(report.Updated, ShouldResemble, []string{"2016-05-01-hello-yaml.md", "toml/hello-toml.markdown"})
+
+				p, _ := GetPostBySlug("hello-yaml")
+				So(p.Title, ShouldEqual, "Hello again")
+				So(p.TagString(), ShouldEqual, "Go")
+				So(p.PublishedAt.Format("2006-01-02"), ShouldEqual, "2016-05-01")
+
+				moved, err := GetPostByUUID("6b0b0e5a-0000-4000-8000-000000000001")
+				So(err, ShouldBeNil)
+				So(moved.Slug, ShouldEqual, "new-slug")
+				So(moved.Status(), ShouldEqual, "published")
+			})
+		})
+
+		Convey("Import a zip archive", func() {
+			var buf bytes.Buffer
+			z := zip.NewWriter(&buf)
+			for _, name := range []string{"posts/2016-05-01-hello-yaml.md", "__MACOSX/posts/._hello.md"} {
+				w, _ := z.Create(name)
+				w.Write([]byte(markdownFixture["2016-05-01-hello-yaml.md"]))
+			}
+			So(z.Close(), ShouldBeNil)
+
+			report, err := m.ImportZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			So(report.Created, ShouldResemble, []string{"posts/2016-05-01-hello-yaml.md"})
+			So(report.Skipped, ShouldBeEmpty)
+			So(report.String(), ShouldStartWith, "Created: 1\n  posts/2016-05-01-hello-yaml.md\nUpdated: 0\nSkipped: 0\n")
+
+			_, err = m.ImportZip(bytes.NewReader([]byte("not a zip")), 9)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Skip the large files of a zip archive", func() {
+			var buf bytes.Buffer
+			z := zip.NewWriter(&buf)
+			w, _ := z.Create("large.md")
+			w.Write([]byte("---\ntitle: Large\n---\n"))
+			w.Write(bytes.Repeat([]byte("a"), markdownFileMaxSize))
+			So(z.Close(), ShouldBeNil)
+
+			report, err := m.ImportZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			So(report.Created, ShouldBeEmpty)
+			So(report.Skipped, ShouldResemble, []string{"large.md: larger than 1 MB"})
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+			os.Remove("test.db")
+		})
+	})
+}
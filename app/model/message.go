@@ -44,7 +44,7 @@ func (m *Message) Save() error {
 	if err != nil {
 		writeDB.Rollback()
 	}
-	_, err = writeDB.Exec(stmtInsertMessage, nil, m.Type, m.Data, m.IsRead, m.CreatedAt)
+	_, err = writeDB.Exec(stmtInsertMessage, m.Type, m.Data, m.IsRead, m.CreatedAt)
 	if err != nil {
 		writeDB.Rollback()
 	}
@@ -85,7 +85,9 @@ func generateCommentMessage(co interface{}) string {
 		panic(err)
 	}
 	var s string
-	if c.Parent < 1 {
+	if c.Type == CommentWebmention {
+		s = "<p>" + c.Author + " mentioned post <i>" + string(post.Title) + "</i> on " + c.Website + "</p>"
+	} else if c.Parent < 1 {
 		s = "<p>" + c.Author + " commented on post <i>" + string(post.Title) + "</i>: </p><p>"
 		s += utils.Html2Str(c.Content) + "</p>"
 	} else {
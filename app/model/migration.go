@@ -0,0 +1,320 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/model/sql_builder"
+)
+
+// Migration is a versioned change of the database schema. It is either a SQL
+// script, whose column types are translated to the dialect of the database,
+// or a Go function, and runs inside a transaction. A migration with a Dialect
+// is only run on that database, and only recorded on the others.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+	Func    func(tx Store) error
+	Dialect *SQL.Dialect
+}
+
+// migrations must be kept in order of version. A released migration must not
+// be changed, changes of the schema go into a new migration.
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Func:    migrateInitialSchema,
+	},
+	{
+		Version: 2,
+		Name:    "widen settings value",
+		// The tables of the other databases are created with the wide value
+		Dialect: SQL.SQLite,
+		SQL: `
+CREATE TABLE settings_new (
+  id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  uuid        varchar(36) NOT NULL,
+  key         varchar(150) NOT NULL,
+  value       text NOT NULL,
+  type        varchar(150) NOT NULL DEFAULT 'core',
+  created_at  datetime NOT NULL,
+  created_by  integer NOT NULL,
+  updated_at  datetime,
+  updated_by  integer
+);
+INSERT INTO settings_new SELECT id, uuid, key, value, type, created_at, created_by, updated_at, updated_by FROM settings;
+DROP TABLE settings;
+ALTER TABLE settings_new RENAME TO settings;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "unique setting keys",
+		SQL:     `CREATE UNIQUE INDEX settings_key ON settings ("key");`,
+	},
+	{
+		Version: 4,
+		Name:    "comment moderation",
+		Func:    migrateCommentModeration,
+	},
+	{
+		Version: 5,
+		Name:    "comment notifications",
+		Func:    migrateCommentNotifications,
+	},
+	{
+		Version: 6,
+		Name:    "webmentions",
+		SQL:     webmentionSchema,
+	},
+	{
+		Version: 7,
+		Name:    "utc times",
+		Func:    migrateUTCTimes,
+	},
+	{
+		Version: 8,
+		Name:    "signing keys",
+		Func:    migrateSecrets,
+	},
+}
+
+// migrateInitialSchema creates the tables of a new database. The databases
+// created before migrations existed are brought up to the same schema, which
+// is why it only adds what is missing.
+func migrateInitialSchema(tx Store) error {
+	// Tokens used to be stored in plain text, with one token per user. They
+	// can not be migrated, so the users are simply logged out.
+	if exist, err := columnExists(tx, "tokens", "value"); err != nil {
+		return err
+	} else if exist {
+		if _, err := tx.Exec("DROP TABLE tokens"); err != nil {
+			return err
+		}
+	}
+	if err := schemaExec(tx, schema); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExist(tx, "posts", "unpublish_at", "datetime"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExist(tx, "users", "totp_secret", "varchar(32)"); err != nil {
+		return err
+	}
+	return addColumnIfNotExist(tx, "users", "totp_last_step", "integer")
+}
+
+// migrateCommentModeration moves the comments to moderation states, the
+// approved ones are approved and the others wait for a moderator. It adds
+// the tables the spam classifier learns in.
+func migrateCommentModeration(tx Store) error {
+	if err := addColumnIfNotExist(tx, "comments", "status", "varchar(20) NOT NULL DEFAULT 'pending'"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE comments SET status = 'approved' WHERE approved = 1`); err != nil {
+		return err
+	}
+	return schemaExec(tx, spamSchema)
+}
+
+// migrateCommentNotifications lets the commenters ask to be notified of the
+// replies, and adds the table of the addresses which unsubscribed.
+func migrateCommentNotifications(tx Store) error {
+	if err := addColumnIfNotExist(tx, "comments", "notify", "tinyint NOT NULL DEFAULT '0'"); err != nil {
+		return err
+	}
+	return schemaExec(tx, unsubscribeSchema)
+}
+
+// migrateSecrets moves the signing keys out of the settings. The key of the
+// unsubscribe links used to be the mail_secret setting, and the key of the
+// comment forms the site_secret setting.
+func migrateSecrets(tx Store) error {
+	if err := schemaExec(tx, secretSchema); err != nil {
+		return err
+	}
+	keys := map[string]string{"mail_secret": secretUnsubscribe, "site_secret": secretComment}
+	for setting, name := range keys {
+		var value string
+		err := tx.QueryRow(`SELECT value FROM settings WHERE "key" = ?`, setting).Scan(&value)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stmtInsertSecret, name, value); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM settings WHERE "key" = ?`, setting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timeColumns are the time columns of the tables written before the times
+// were stored in UTC.
+var timeColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"posts", []string{"created_at", "updated_at", "published_at", "unpublish_at"}},
+	{"tokens", []string{"created_at", "last_seen_at", "expired_at"}},
+	{"users", []string{"last_login", "created_at", "updated_at"}},
+	{"categories", []string{"created_at", "updated_at"}},
+	{"tags", []string{"created_at", "updated_at"}},
+	{"comments", []string{"created_at"}},
+	{"post_revisions", []string{"created_at"}},
+	{"settings", []string{"created_at", "updated_at"}},
+	{"roles", []string{"created_at", "updated_at"}},
+	{"invitations", []string{"created_at", "expired_at", "accepted_at"}},
+	{"password_resets", []string{"created_at", "expired_at", "used_at"}},
+	{"login_challenges", []string{"expired_at"}},
+	{"api_keys", []string{"created_at", "last_used_at"}},
+	{"messages", []string{"created_at"}},
+	{"unsubscribes", []string{"created_at"}},
+	{"outgoing_webmentions", []string{"next_attempt_at", "created_at"}},
+}
+
+// migrateUTCTimes rewrites the times in UTC. SQLite kept the offset of the
+// local time they were written in, PostgreSQL dropped it so they are taken
+// as local times. MySQL already had them in UTC.
+func migrateUTCTimes(tx Store) error {
+	if db.dialect == SQL.MySQL {
+		return nil
+	}
+	for _, t := range timeColumns {
+		if err := rewriteUTCTimes(tx, t.table, t.columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rewriteUTCTimes(tx Store, table string, columns []string) error {
+	rows, err := tx.Query(`SELECT id, ` + strings.Join(columns, ", ") + ` FROM ` + table)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		id    int64
+		times []*time.Time
+	}
+	all := make([]*row, 0)
+	for rows.Next() {
+		r := &row{times: make([]*time.Time, len(columns))}
+		dest := []interface{}{&r.id}
+		for i := range r.times {
+			dest = append(dest, &r.times[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	stmt := `UPDATE ` + table + ` SET ` + strings.Join(columns, " = ?, ") + ` = ? WHERE id = ?`
+	for _, r := range all {
+		args := make([]interface{}, 0, len(columns)+1)
+		for _, t := range r.times {
+			if t != nil && db.dialect == SQL.Postgres {
+				local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+				t = &local
+			}
+			args = append(args, t)
+		}
+		if _, err := tx.Exec(stmt, append(args, r.id)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LatestSchemaVersion is the version of the database schema this binary
+// works with.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// database, 0 if none was.
+func SchemaVersion() (int, error) {
+	var version int
+	if exist, err := tableExists(db, "schema_migrations"); err != nil || !exist {
+		return 0, err
+	}
+	err := db.QueryRow(stmtGetSchemaVersion).Scan(&version)
+	return version, err
+}
+
+// Migrate applies the migrations the database has not seen yet, each in its
+// own transaction, and returns them. With dryRun, the pending migrations are
+// only returned. It refuses to touch a database which has been migrated by a
+// newer version of Dingo.
+func Migrate(dryRun bool) ([]*Migration, error) {
+	version, err := SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > LatestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than the supported version %d, please upgrade Dingo", version, LatestSchemaVersion())
+	}
+	pending := make([]*Migration, 0)
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	if dryRun || len(pending) == 0 {
+		return pending, nil
+	}
+	if _, err := db.Exec(stmtCreateSchemaMigrations); err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		if err := m.apply(); err != nil {
+			return nil, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migration) apply() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	switch {
+	case m.Dialect != nil && m.Dialect != db.dialect:
+	case m.Func != nil:
+		err = m.Func(tx)
+	default:
+		err = schemaExec(tx, m.SQL)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(stmtInsertSchemaMigration, m.Version, m.Name, time.Now()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// OpenAndMigrate opens the database and applies the pending migrations, the
+// rest of the application is not initialized.
+func OpenAndMigrate(dsn string, dryRun bool) ([]*Migration, error) {
+	if err := initConnection(dsn); err != nil {
+		return nil, err
+	}
+	return Migrate(dryRun)
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dinever/dingo/app/model/sql_builder"
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// legacySchema is a part of the schema of the databases created before
+// migrations existed.
+const legacySchema = `
+CREATE TABLE posts (id integer NOT NULL PRIMARY KEY AUTOINCREMENT, uuid varchar(36) NOT NULL, title varchar(150) NOT NULL, slug varchar(150) NOT NULL, markdown text, html text, image text, featured tinyint NOT NULL DEFAULT '0', page tinyint NOT NULL DEFAULT '0', allow_comment tinyint NOT NULL DEFAULT '0', comment_num integer NOT NULL DEFAULT '0', status varchar(150) NOT NULL DEFAULT 'draft', language varchar(6) NOT NULL DEFAULT 'en_US', meta_title varchar(150), meta_description varchar(200), author_id integer NOT NULL, created_at datetime NOT NULL, created_by integer NOT NULL, updated_at datetime, updated_by integer, published_at datetime, published_by integer);
+CREATE TABLE tokens (value varchar(40) NOT NULL, user_id integer NOT NULL, created_at datetime NOT NULL, expired_at datetime NOT NULL);
+CREATE TABLE settings (id integer NOT NULL PRIMARY KEY AUTOINCREMENT, uuid varchar(36) NOT NULL, key varchar(150) NOT NULL, value varchar(20) NOT NULL, type varchar(150) NOT NULL DEFAULT 'core', created_at datetime NOT NULL, created_by integer NOT NULL, updated_at datetime, updated_by integer);
+INSERT INTO settings (uuid, key, value, type, created_at, created_by) VALUES ('uuid', 'title', 'Legacy Blog', 'blog', '2016-01-01 00:00:00', 1);
+`
+
+func columnType(table, column string) string {
+	rows, _ := db.Query("PRAGMA table_info(" + table + ")")
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, tp         string
+			defaultValue     interface{}
+		)
+		rows.Scan(&cid, &name, &tp, &notNull, &defaultValue, &pk)
+		if name == column {
+			return strings.ToLower(tp)
+		}
+	}
+	return ""
+}
+
+func TestMigration(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Reset(func() {
+			os.Remove("test.db")
+		})
+
+		Convey("Migrate a new database", func() {
+			So(Initialize("test.db", false), ShouldBeNil)
+			version, err := SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, LatestSchemaVersion())
+			So(columnType("settings", "value"), ShouldEqual, "text")
+
+			Convey("Nothing is pending", func() {
+				pending, err := Migrate(true)
+				So(err, ShouldBeNil)
+				So(pending, ShouldHaveLength, 0)
+			})
+
+			Convey("Refuse a newer database", func() {
+				_, err := db.Exec(stmtInsertSchemaMigration, LatestSchemaVersion()+1, "future", utils.Now())
+				So(err, ShouldBeNil)
+				_, err = Migrate(false)
+				So(err, ShouldNotBeNil)
+				So(Initialize("test.db", true), ShouldNotBeNil)
+			})
+
+			Convey("Only record the migrations of other databases", func() {
+				migrations = append(migrations, &Migration{
+					Version: LatestSchemaVersion() + 1,
+					Name:    "other database",
+					Dialect: SQL.Postgres,
+					SQL:     "CREATE TABLE skipped (id integer);",
+				})
+				defer func() {
+					migrations = migrations[:len(migrations)-1]
+				}()
+				_, err := Migrate(false)
+				So(err, ShouldBeNil)
+				version, _ := SchemaVersion()
+				So(version, ShouldEqual, LatestSchemaVersion())
+				exist, _ := tableExists(db, "skipped")
+				So(exist, ShouldBeFalse)
+			})
+
+			Convey("Roll back a failed migration", func() {
+				migrations = append(migrations, &Migration{
+					Version: LatestSchemaVersion() + 1,
+					Name:    "broken",
+					SQL:     "CREATE TABLE broken (id integer); INSERT INTO missing VALUES (1);",
+				})
+				defer func() {
+					migrations = migrations[:len(migrations)-1]
+				}()
+				_, err := Migrate(false)
+				So(err, ShouldNotBeNil)
+				version, _ := SchemaVersion()
+				So(version, ShouldEqual, LatestSchemaVersion()-1)
+				exist, _ := columnExists(db, "broken", "id")
+				So(exist, ShouldBeFalse)
+			})
+		})
+
+		Convey("Migrate a database created before migrations", func() {
+			So(initConnection("test.db"), ShouldBeNil)
+			_, err := db.Exec(legacySchema)
+			So(err, ShouldBeNil)
+
+			pending, err := Migrate(true)
+			So(err, ShouldBeNil)
+			So(pending, ShouldHaveLength, LatestSchemaVersion())
+			version, _ := SchemaVersion()
+			So(version, ShouldEqual, 0)
+
+			applied, err := Migrate(false)
+			So(err, ShouldBeNil)
+			So(applied, ShouldHaveLength, LatestSchemaVersion())
+			version, _ = SchemaVersion()
+			So(version, ShouldEqual, LatestSchemaVersion())
+
+			exist, _ := columnExists(db, "posts", "unpublish_at")
+			So(exist, ShouldBeTrue)
+			exist, _ = columnExists(db, "tokens", "value")
+			So(exist, ShouldBeFalse)
+			So(columnType("settings", "value"), ShouldEqual, "text")
+			So(GetSettingValue("title"), ShouldEqual, "Legacy Blog")
+		})
+	})
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// The mails about comments carry a link which unsubscribes the address they
+// are sent to. The link is signed, so it works without logging in and can
+// not be made up for another address.
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UnsubscribeToken returns the signature of an email address which allows
+// to unsubscribe it.
+func UnsubscribeToken(email string) string {
+	return sign(secretUnsubscribe, normalizeEmail(email))
+}
+
+// CheckUnsubscribeToken tells whether token is the signature of email.
+func CheckUnsubscribeToken(email, token string) bool {
+	return email != "" && checkSignature(secretUnsubscribe, normalizeEmail(email), token)
+}
+
+// Unsubscribe stops the mails about comments sent to an email address: the
+// replies to its comments, and the new comments on the posts of its user.
+func Unsubscribe(email string) error {
+	email = normalizeEmail(email)
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	var count int64
+	err = writeDB.QueryRow(stmtGetUnsubscribeCount, email).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = writeDB.Exec(stmtInsertUnsubscribe, email, utils.Now())
+	}
+	if err == nil {
+		_, err = writeDB.Exec(stmtClearCommentNotifyByEmail, email)
+	}
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// SubscribeToken returns the signature of an email address which allows to
+// subscribe it again. Like the unsubscribe link, it is only mailed to the
+// address, so that nobody else can undo its unsubscription.
+func SubscribeToken(email string) string {
+	return sign(secretSubscribe, normalizeEmail(email))
+}
+
+// CheckSubscribeToken tells whether token is the subscribe signature of
+// email.
+func CheckSubscribeToken(email, token string) bool {
+	return email != "" && checkSignature(secretSubscribe, normalizeEmail(email), token)
+}
+
+// Subscribe takes an email address out of the unsubscribed ones, which is
+// done when it confirms that it wants to be notified again.
+func Subscribe(email string) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeleteUnsubscribe, normalizeEmail(email)); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// IsUnsubscribed tells whether an email address unsubscribed.
+func IsUnsubscribed(email string) (bool, error) {
+	var count int64
+	err := db.QueryRow(stmtGetUnsubscribeCount, normalizeEmail(email)).Scan(&count)
+	return count > 0, err
+}
+
+// NotifiedParent returns the comment c replies to, when its author asked to
+// be notified of the replies and did not unsubscribe since. Only an approved
+// comment of the same post is a parent. Nobody is notified of their own
+// replies.
+func (c *Comment) NotifiedParent() (*Comment, error) {
+	parent, err := c.ParentComment()
+	if parent == nil || err != nil {
+		return nil, err
+	}
+	if !parent.Notify || parent.Email == "" || normalizeEmail(parent.Email) == normalizeEmail(c.Email) {
+		return nil, nil
+	}
+	if unsubscribed, err := IsUnsubscribed(parent.Email); err != nil || unsubscribed {
+		return nil, err
+	}
+	return parent, nil
+}
+
+// ParentComment returns the comment c replies to, if it is an approved
+// comment of the same post.
+func (c *Comment) ParentComment() (*Comment, error) {
+	if c.Parent < 1 {
+		return nil, nil
+	}
+	parent, err := GetCommentById(c.Parent)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if parent.PostId != c.PostId || parent.Status != CommentApproved {
+		return nil, nil
+	}
+	return parent, nil
+}
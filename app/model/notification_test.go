@@ -0,0 +1,88 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotification(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		parent := NewComment()
+		parent.Author, parent.Email, parent.Content = "Ken", "Ken@example.com", "Hello"
+		parent.PostId = 1
+		parent.Notify = true
+		parent.Approved = true
+		So(parent.Save(), ShouldBeNil)
+		reply := NewComment()
+		reply.Author, reply.Email, reply.Content = "Dennis", "dmr@example.com", "Hi"
+		reply.PostId = 1
+		reply.Parent = parent.Id
+		So(reply.Save(), ShouldBeNil)
+
+		Convey("Sign the unsubscribe links", func() {
+			token := UnsubscribeToken("ken@example.com")
+			So(token, ShouldEqual, UnsubscribeToken(" Ken@Example.com"))
+			So(CheckUnsubscribeToken("ken@example.com", token), ShouldBeTrue)
+			So(CheckUnsubscribeToken("dmr@example.com", token), ShouldBeFalse)
+			So(CheckUnsubscribeToken("", ""), ShouldBeFalse)
+		})
+
+		Convey("Find the parent to notify", func() {
+			p, err := reply.NotifiedParent()
+			So(err, ShouldBeNil)
+			So(p.Id, ShouldEqual, parent.Id)
+			So(p.Notify, ShouldBeTrue)
+
+			// Nobody is notified of their own replies
+			reply.Email = "ken@example.com"
+			p, err = reply.NotifiedParent()
+			So(err, ShouldBeNil)
+			So(p, ShouldBeNil)
+		})
+
+		Convey("Only notify an approved comment of the same post", func() {
+			parent.SetStatus(CommentPending)
+			So(parent.Save(), ShouldBeNil)
+			p, err := reply.NotifiedParent()
+			So(err, ShouldBeNil)
+			So(p, ShouldBeNil)
+
+			parent.SetStatus(CommentApproved)
+			So(parent.Save(), ShouldBeNil)
+			reply.PostId = 2
+			p, err = reply.NotifiedParent()
+			So(err, ShouldBeNil)
+			So(p, ShouldBeNil)
+		})
+
+		Convey("Sign the subscribe links apart", func() {
+			token := SubscribeToken("ken@example.com")
+			So(CheckSubscribeToken("Ken@example.com", token), ShouldBeTrue)
+			So(CheckSubscribeToken("dmr@example.com", token), ShouldBeFalse)
+			So(token, ShouldNotEqual, UnsubscribeToken("ken@example.com"))
+			So(CheckSubscribeToken("ken@example.com", UnsubscribeToken("ken@example.com")), ShouldBeFalse)
+		})
+
+		Convey("Unsubscribe and subscribe again", func() {
+			So(Unsubscribe("KEN@example.com"), ShouldBeNil)
+			So(Unsubscribe("ken@example.com"), ShouldBeNil)
+			unsubscribed, err := IsUnsubscribed("ken@example.com")
+			So(err, ShouldBeNil)
+			So(unsubscribed, ShouldBeTrue)
+			p, err := reply.NotifiedParent()
+			So(err, ShouldBeNil)
+			So(p, ShouldBeNil)
+
+			So(Subscribe("Ken@example.com"), ShouldBeNil)
+			unsubscribed, _ = IsUnsubscribed("ken@example.com")
+			So(unsubscribed, ShouldBeFalse)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
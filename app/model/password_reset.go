@@ -0,0 +1,127 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// PasswordResetExpiration is how long a password reset link can be used for.
+const PasswordResetExpiration = time.Hour
+
+// PasswordReset allows a user who forgot the password to set a new one. Like
+// invitations, only the hash of the token is stored.
+type PasswordReset struct {
+	Id        int64
+	Token     string
+	UserId    int64
+	CreatedAt *time.Time
+	ExpiredAt *time.Time
+	UsedAt    *time.Time
+}
+
+func NewPasswordReset(u *User) *PasswordReset {
+	r := &PasswordReset{
+		Token:     utils.RandomToken(20),
+		UserId:    u.Id,
+		CreatedAt: utils.Now(),
+	}
+	expiredAt := r.CreatedAt.Add(PasswordResetExpiration)
+	r.ExpiredAt = &expiredAt
+	return r
+}
+
+func (r *PasswordReset) Url() string {
+	return "/reset/" + r.Token + "/"
+}
+
+func (r *PasswordReset) IsValid() bool {
+	return r.UsedAt == nil && r.ExpiredAt.After(*utils.Now())
+}
+
+func (r *PasswordReset) Save() error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	r.Id, err = writeDB.Insert(stmtInsertPasswordReset, utils.Sha256(r.Token), r.UserId, r.CreatedAt, r.ExpiredAt, nil)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// Use changes the password of the user and logs the user out everywhere. A
+// password reset can only be used once, it is only used up along with the
+// change of the password.
+func (r *PasswordReset) Use(password string) error {
+	user, err := GetUserById(r.UserId)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+	now := utils.Now()
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	result, err := writeDB.Exec(stmtUpdatePasswordResetUsed, now, r.Id)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeDB.Rollback()
+		return fmt.Errorf("Password reset link has already been used")
+	}
+	_, err = writeDB.Exec(stmtUpdateUserPassword, hashedPassword, now, user.Id, user.Id)
+	if err == nil {
+		_, err = writeDB.Exec(stmtDeleteOtherTokensByUserId, user.Id, 0)
+	}
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if err = writeDB.Commit(); err != nil {
+		return err
+	}
+	r.UsedAt = now
+	return nil
+}
+
+// GetPasswordResetByToken finds the password reset of a token, it returns an
+// error if the link has expired or was already used.
+func GetPasswordResetByToken(token string) (*PasswordReset, error) {
+	r := new(PasswordReset)
+	row := db.QueryRow(stmtGetPasswordResetByToken, utils.Sha256(token))
+	if err := row.Scan(&r.Id, &r.UserId, &r.CreatedAt, &r.ExpiredAt, &r.UsedAt); err != nil {
+		return nil, err
+	}
+	r.Token = token
+	if !r.IsValid() {
+		return nil, fmt.Errorf("Password reset link has expired")
+	}
+	return r, nil
+}
+
+// DeleteExpiredPasswordResets removes the password resets that have expired.
+func DeleteExpiredPasswordResets() error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtDeleteExpiredPasswordResets, utils.Now())
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPasswordReset(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		user := mockUser()
+		So(user.Create(password), ShouldBeNil)
+
+		Convey("Create a password reset", func() {
+			r := NewPasswordReset(user)
+			So(r.Save(), ShouldBeNil)
+
+			Convey("Get the password reset by token", func() {
+				reset, err := GetPasswordResetByToken(r.Token)
+				So(err, ShouldBeNil)
+				So(reset.UserId, ShouldEqual, user.Id)
+			})
+
+			Convey("Use the password reset", func() {
+				ctx := mockSimpleContext()
+				token := NewToken(user, ctx, 100)
+				So(token.Save(), ShouldBeNil)
+
+				So(r.Use("newpassword"), ShouldBeNil)
+				So(user.CheckPassword("newpassword"), ShouldBeTrue)
+
+				Convey("The user is logged out", func() {
+					_, err := GetTokenByValue(token.Value)
+					So(err, ShouldNotBeNil)
+				})
+
+				Convey("A password reset can only be used once", func() {
+					So(r.Use("otherpassword"), ShouldNotBeNil)
+					_, err := GetPasswordResetByToken(r.Token)
+					So(err, ShouldNotBeNil)
+					So(user.CheckPassword("newpassword"), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("The link is kept when the password can not be changed", func() {
+			r := NewPasswordReset(user)
+			So(r.Save(), ShouldBeNil)
+			_, err := db.Exec(`DROP TABLE tokens`)
+			So(err, ShouldBeNil)
+			So(r.Use("newpassword"), ShouldNotBeNil)
+			So(user.CheckPassword(password), ShouldBeTrue)
+			_, err = GetPasswordResetByToken(r.Token)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Expired password reset", func() {
+			r := NewPasswordReset(user)
+			expiredAt := time.Now().Add(-time.Minute)
+			r.ExpiredAt = &expiredAt
+			So(r.Save(), ShouldBeNil)
+			_, err := GetPasswordResetByToken(r.Token)
+			So(err, ShouldNotBeNil)
+
+			So(DeleteExpiredPasswordResets(), ShouldBeNil)
+			var count int
+			db.QueryRow("SELECT count(*) FROM password_resets").Scan(&count)
+			So(count, ShouldEqual, 0)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
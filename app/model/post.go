@@ -23,10 +23,11 @@ type Post struct {
 	Comments        []*Comment
 	IsFeatured      bool
 	IsPublished     bool
+	IsScheduled     bool
 	status          string
 	IsPage          bool
 	AllowComment    bool
-	Category        string
+	Category        *Category
 	Hits            int64
 	Language        string
 	MetaTitle       string
@@ -39,7 +40,12 @@ type Post struct {
 	UpdatedBy       int64
 	PublishedAt     *time.Time
 	PublishedBy     int64
+	UnpublishAt     *time.Time
 	Tags            []*Tag
+
+	// skipWebmentions is set on the imported posts, whose links were
+	// mentioned where they come from.
+	skipWebmentions bool
 }
 
 func NewPost() *Post {
@@ -61,6 +67,20 @@ func (p *Post) TagString() string {
 	return tagString
 }
 
+// CommentsOpen tells whether visitors can comment on the post. Comments must
+// be allowed on a published post, and they close comment_close_days days
+// after it was published when the setting is set.
+func (p *Post) CommentsOpen() bool {
+	if !p.AllowComment || !p.IsPublished {
+		return false
+	}
+	days, _ := strconv.Atoi(GetSettingValue("comment_close_days"))
+	if days > 0 && p.PublishedAt != nil && time.Since(*p.PublishedAt) > time.Duration(days)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
 func (p *Post) Url() string {
 	return "/" + p.Slug
 }
@@ -74,6 +94,38 @@ func (p *Post) Excerpt() string {
 	return utils.Html2Excerpt(p.Html, 255)
 }
 
+// Status returns "draft", "scheduled" or "published".
+func (p *Post) Status() string {
+	if p.status == "" {
+		return "draft"
+	}
+	return p.status
+}
+
+// evaluateStatus decides the status of a post that is about to be saved.
+// A post that should be published but has a publish date in the future is
+// scheduled, and will be published by PublishScheduledPosts.
+func (p *Post) evaluateStatus() {
+	now := utils.Now()
+	if !p.IsPublished && !p.IsScheduled {
+		p.status = "draft"
+		return
+	}
+	if p.PublishedAt == nil {
+		p.PublishedAt = now
+	}
+	if p.PublishedAt.After(*now) {
+		p.status = "scheduled"
+	} else {
+		p.status = "published"
+	}
+	p.IsPublished = p.status == "published"
+	p.IsScheduled = p.status == "scheduled"
+	if p.PublishedBy == 0 {
+		p.PublishedBy = p.CreatedBy
+	}
+}
+
 func (p *Post) Save() error {
 	p.Slug = strings.TrimLeft(p.Slug, "/")
 	p.Slug = strings.TrimRight(p.Slug, "/")
@@ -113,6 +165,24 @@ func (p *Post) Save() error {
 			return err
 		}
 	}
+	// Replace the post-category projection
+	if err := DeletePostCategoriesByPostId(p.Id); err != nil {
+		return err
+	}
+	if p.Category != nil && p.Category.Id > 0 {
+		if err := InsertPostCategory(p.Id, p.Category.Id); err != nil {
+			return err
+		}
+	}
+	if err := p.saveRevision(); err != nil {
+		return err
+	}
+	if err := indexPost(p); err != nil {
+		return err
+	}
+	if err := queueWebmentions(p); err != nil {
+		return err
+	}
 	return DeleteOldTags()
 }
 
@@ -120,35 +190,26 @@ func (p *Post) Insert() error {
 	if !PostChangeSlug(p.Slug) {
 		p.Slug = generateNewSlug(p.Slug, 1)
 	}
-	if p.IsPublished {
-		p.status = "published"
-		p.PublishedAt = p.CreatedAt
-		p.PublishedBy = p.CreatedBy
-	} else {
-		p.status = "draft"
-	}
+	p.evaluateStatus()
 	p.UpdatedAt = p.CreatedAt
 	p.UpdatedBy = p.CreatedBy
+	if p.UUID == "" {
+		p.UUID = uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen)
+	}
 	writeDB, err := db.Begin()
 	if err != nil {
 		writeDB.Rollback()
 		return err
 	}
-	var result sql.Result
-	if p.IsPublished {
-		result, err = writeDB.Exec(stmtInsertPost, nil, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, p.status, p.Image, p.CreatedBy, p.CreatedAt, p.CreatedBy, p.UpdatedAt, p.UpdatedBy, p.PublishedAt, p.PublishedBy)
+	if p.status == "draft" {
+		p.Id, err = writeDB.Insert(stmtInsertPost, p.UUID, p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, p.status, p.Image, p.CreatedBy, p.CreatedAt, p.CreatedBy, p.UpdatedAt, p.UpdatedBy, p.PublishedAt, nil, p.UnpublishAt, p.MetaTitle, p.MetaDescription)
 	} else {
-		result, err = writeDB.Exec(stmtInsertPost, nil, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, p.status, p.Image, p.CreatedBy, p.CreatedAt, p.CreatedBy, p.UpdatedAt, p.UpdatedBy, nil, nil)
+		p.Id, err = writeDB.Insert(stmtInsertPost, p.UUID, p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, p.status, p.Image, p.CreatedBy, p.CreatedAt, p.CreatedBy, p.UpdatedAt, p.UpdatedBy, p.PublishedAt, p.PublishedBy, p.UnpublishAt, p.MetaTitle, p.MetaDescription)
 	}
 	if err != nil {
 		writeDB.Rollback()
 		return err
 	}
-	p.Id, err = result.LastInsertId()
-	if err != nil {
-		writeDB.Rollback()
-		return err
-	}
 	return writeDB.Commit()
 }
 
@@ -158,7 +219,7 @@ func InsertPostTag(post_id int64, tag_id int64) error {
 		writeDB.Rollback()
 		return err
 	}
-	_, err = writeDB.Exec(stmtInsertPostTag, nil, post_id, tag_id)
+	_, err = writeDB.Exec(stmtInsertPostTag, post_id, tag_id)
 	if err != nil {
 		writeDB.Rollback()
 		return err
@@ -174,12 +235,14 @@ func (p *Post) Update() error {
 	if p.Slug != currentPost.Slug && !PostChangeSlug(p.Slug) {
 		p.Slug = generateNewSlug(p.Slug, 1)
 	}
-	status := "draft"
-	if p.IsPublished {
-		status = "published"
-		p.PublishedAt = utils.Now()
-		p.PublishedBy = p.CreatedBy
+	// Keep the original publication date and user unless a new date is given
+	if p.PublishedAt == nil {
+		p.PublishedAt = currentPost.PublishedAt
 	}
+	if currentPost.PublishedBy != 0 {
+		p.PublishedBy = currentPost.PublishedBy
+	}
+	p.evaluateStatus()
 	p.UpdatedAt = utils.Now()
 	p.UpdatedBy = p.CreatedBy
 	writeDB, err := db.Begin()
@@ -187,12 +250,7 @@ func (p *Post) Update() error {
 		writeDB.Rollback()
 		return err
 	}
-	// If the updated post is published for the first time, add publication date and user
-	if p.IsPublished && !currentPost.IsPublished {
-		_, err = writeDB.Exec(stmtUpdatePostPublished, p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, status, p.Image, p.UpdatedAt, p.UpdatedBy, p.PublishedAt, p.PublishedBy, p.Id)
-	} else {
-		_, err = writeDB.Exec(stmtUpdatePost, p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, status, p.Image, p.UpdatedAt, p.UpdatedBy, p.Id)
-	}
+	_, err = writeDB.Exec(stmtUpdatePost, p.Title, p.Slug, p.Markdown, p.Html, p.IsFeatured, p.IsPage, p.AllowComment, p.status, p.Image, p.UpdatedAt, p.UpdatedBy, p.PublishedAt, p.PublishedBy, p.UnpublishAt, p.MetaTitle, p.MetaDescription, p.Id)
 	if err != nil {
 		writeDB.Rollback()
 		return err
@@ -214,6 +272,36 @@ func DeletePostTagsByPostId(post_id int64) error {
 	return writeDB.Commit()
 }
 
+// IncrementCommentNum counts a new comment of a post. It does not save the
+// post, which would change its update time.
+func IncrementCommentNum(postId int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtIncrementPostCommentNum, postId); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// DecrementCommentNum uncounts a comment of a post which is removed. Like
+// IncrementCommentNum, it does not save the post.
+func DecrementCommentNum(postId int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDecrementPostCommentNum, postId); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
 func DeletePostById(id int64) error {
 	writeDB, err := db.Begin()
 	if err != nil {
@@ -233,6 +321,18 @@ func DeletePostById(id int64) error {
 	if err != nil {
 		return err
 	}
+	err = DeletePostCategoriesByPostId(id)
+	if err != nil {
+		return err
+	}
+	err = DeletePostRevisionsByPostId(id)
+	if err != nil {
+		return err
+	}
+	err = unindexPost(id)
+	if err != nil {
+		return err
+	}
 	return DeleteOldTags()
 }
 
@@ -248,19 +348,25 @@ func GetPostBySlug(slug string) (*Post, error) {
 	return extractPost(row)
 }
 
+func GetPostByUUID(uuid string) (*Post, error) {
+	row := db.QueryRow(stmtGetPostByUUID, uuid)
+	return extractPost(row)
+}
+
 func GetPostsByTag(tagId, page, size int64, onlyPublished bool, orderBy string) ([]*Post, *utils.Pager, error) {
 	var (
 		pager *utils.Pager
 		count int64
 	)
-	row := db.QueryRow(stmtGetPostsCountByTag, tagId)
+	now := utils.Now()
+	row := db.QueryRow(stmtGetPostsCountByTag, tagId, now)
 	err := row.Scan(&count)
 	if err != nil {
 		log.Printf("[Error]: ", err.Error())
 		return nil, nil, err
 	}
 	pager = utils.NewPager(page, size, count)
-	rows, err := db.Query(stmtGetPostsByTag, tagId, size, pager.Begin-1)
+	rows, err := db.Query(stmtGetPostsByTag, tagId, now, size, pager.Begin-1)
 	defer rows.Close()
 	if err != nil {
 		log.Printf("[Error]: ", err.Error())
@@ -292,8 +398,10 @@ func GetAllPostsByTag(tagId int64) ([]*Post, error) {
 func GetNumberOfPosts(isPage bool, published bool) (int64, error) {
 	var count int64
 	selector := postCountSelector.Copy()
+	args := make([]interface{}, 0)
 	if published {
-		selector.Where(`status = "published"`)
+		selector.Where(`status = 'published'`, `published_at <= ?`)
+		args = append(args, utils.Now())
 	}
 	if isPage {
 		selector.Where(`page = 1`)
@@ -301,7 +409,7 @@ func GetNumberOfPosts(isPage bool, published bool) (int64, error) {
 		selector.Where(`page = 0`)
 	}
 	var row *sql.Row
-	row = db.QueryRow(selector.SQL())
+	row = db.QueryRow(selector.SQL(), args...)
 	err := row.Scan(&count)
 	if err != nil {
 		return 0, err
@@ -314,8 +422,10 @@ func GetPostList(page, size int64, isPage bool, onlyPublished bool, orderBy stri
 	count, err := GetNumberOfPosts(isPage, onlyPublished)
 	pager = utils.NewPager(page, size, count)
 	selector := postSelector.Copy()
+	args := make([]interface{}, 0)
 	if onlyPublished {
-		selector.Where(`status = "published"`)
+		selector.Where(`status = 'published'`, `published_at <= ?`)
+		args = append(args, utils.Now())
 	}
 	if isPage {
 		selector.Where(`page = 1`)
@@ -323,8 +433,9 @@ func GetPostList(page, size int64, isPage bool, onlyPublished bool, orderBy stri
 		selector.Where(`page = 0`)
 	}
 	selector.OrderBy(orderBy)
+	args = append(args, size, pager.Begin-1)
 	// Get posts
-	rows, err := db.Query(selector.Limit(`?`).Offset(`?`).SQL(), size, pager.Begin-1)
+	rows, err := db.Query(selector.Limit(`?`).Offset(`?`).SQL(), args...)
 	defer rows.Close()
 	if err != nil {
 		log.Printf("[Error]: ", err.Error())
@@ -339,8 +450,10 @@ func GetPostList(page, size int64, isPage bool, onlyPublished bool, orderBy stri
 
 func GetAllPostList(isPage bool, onlyPublished bool, orderBy string) ([]*Post, error) {
 	selector := postSelector.Copy()
+	args := make([]interface{}, 0)
 	if onlyPublished {
-		selector.Where(`status = "published"`)
+		selector.Where(`status = 'published'`, `published_at <= ?`)
+		args = append(args, utils.Now())
 	}
 	if isPage {
 		selector.Where(`page = 1`)
@@ -349,7 +462,7 @@ func GetAllPostList(isPage bool, onlyPublished bool, orderBy string) ([]*Post, e
 	}
 	selector.OrderBy(orderBy)
 	// Get posts
-	rows, err := db.Query(selector.SQL())
+	rows, err := db.Query(selector.SQL(), args...)
 	defer rows.Close()
 	if err != nil {
 		log.Printf("[Error]: ", err.Error())
@@ -362,6 +475,61 @@ func GetAllPostList(isPage bool, onlyPublished bool, orderBy string) ([]*Post, e
 	return posts, nil
 }
 
+// PostFilter narrows down the posts listed by GetFilteredPostList, the zero
+// value of a field does not filter.
+type PostFilter struct {
+	IsPage   bool
+	Status   string // draft, scheduled or published
+	TagId    int64
+	AuthorId int64
+}
+
+func (f *PostFilter) conditions() ([]string, []interface{}) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	if f.IsPage {
+		where = append(where, `page = 1`)
+	} else {
+		where = append(where, `page = 0`)
+	}
+	if f.Status != "" {
+		where = append(where, `status = ?`)
+		args = append(args, f.Status)
+	}
+	if f.TagId > 0 {
+		where = append(where, `id IN (SELECT post_id FROM posts_tags WHERE tag_id = ?)`)
+		args = append(args, f.TagId)
+	}
+	if f.AuthorId > 0 {
+		where = append(where, `author_id = ?`)
+		args = append(args, f.AuthorId)
+	}
+	return where, args
+}
+
+// GetFilteredPostList returns a page of the posts matching the filter, drafts
+// included.
+func GetFilteredPostList(filter *PostFilter, page, size int64, orderBy string) ([]*Post, *utils.Pager, error) {
+	var count int64
+	where, args := filter.conditions()
+	row := db.QueryRow(postCountSelector.Copy().Where(where...).SQL(), args...)
+	if err := row.Scan(&count); err != nil {
+		return nil, nil, err
+	}
+	pager := utils.NewPager(page, size, count)
+	selector := postSelector.Copy().Where(where...).OrderBy(orderBy).Limit(`?`).Offset(`?`)
+	rows, err := db.Query(selector.SQL(), append(args, size, pager.Begin-1)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	posts, err := extractPosts(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return posts, pager, nil
+}
+
 func scanPost(rows Row, post *Post) error {
 	// TODO: CommentNum
 	post.CommentNum = 0
@@ -369,23 +537,24 @@ func scanPost(rows Row, post *Post) error {
 		nullImage       sql.NullString
 		nullUpdatedBy   sql.NullInt64
 		nullPublishedBy sql.NullInt64
+		nullMetaTitle   sql.NullString
+		nullMetaDesc    sql.NullString
 	)
 	err := rows.Scan(&post.Id, &post.UUID, &post.Title, &post.Slug, &post.Markdown,
 		&post.Html, &post.IsFeatured, &post.IsPage, &post.AllowComment, &post.CommentNum, &post.status, &nullImage,
-		&post.userId, &post.CreatedAt, &post.CreatedBy, &post.UpdatedAt, &nullUpdatedBy, &post.PublishedAt, &nullPublishedBy)
+		&post.userId, &post.CreatedAt, &post.CreatedBy, &post.UpdatedAt, &nullUpdatedBy, &post.PublishedAt, &nullPublishedBy, &post.UnpublishAt, &nullMetaTitle, &nullMetaDesc)
 	post.UpdatedBy = nullUpdatedBy.Int64
 	post.PublishedBy = nullUpdatedBy.Int64
 	post.Image = nullImage.String
+	post.MetaTitle = nullMetaTitle.String
+	post.MetaDescription = nullMetaDesc.String
 	return err
 }
 
 func paddingPostData(post *Post) error {
 	// Evaluate status
-	if post.status == "published" {
-		post.IsPublished = true
-	} else {
-		post.IsPublished = false
-	}
+	post.IsPublished = post.status == "published"
+	post.IsScheduled = post.status == "scheduled"
 	var err error
 	// Get user
 	post.Author, err = GetUserById(post.userId)
@@ -397,6 +566,8 @@ func paddingPostData(post *Post) error {
 	if err != nil {
 		return err
 	}
+	// Get category, a post without category is fine
+	post.Category, _ = GetCategoryByPostId(post.Id)
 	// Get comments
 	post.Comments, err = GetCommentByPostId(post.Id)
 	if err != nil {
@@ -446,3 +617,57 @@ func generateNewSlug(slug string, suffix int) string {
 	}
 	return newSlug
 }
+
+// PublishScheduledPosts publishes the scheduled posts whose publication date
+// has come, and takes down the published posts whose unpublish date has
+// passed. It is run periodically by the background scheduler.
+func PublishScheduledPosts() error {
+	now := utils.Now()
+	dueIds, err := queryPostIds(stmtGetDueScheduledPostIds, now)
+	if err != nil {
+		return err
+	}
+	for _, id := range dueIds {
+		p, err := GetPostById(id)
+		if err != nil {
+			return err
+		}
+		p.IsPublished = true
+		if err := p.Save(); err != nil {
+			return err
+		}
+	}
+	expiredIds, err := queryPostIds(stmtGetExpiredPostIds, now)
+	if err != nil {
+		return err
+	}
+	for _, id := range expiredIds {
+		p, err := GetPostById(id)
+		if err != nil {
+			return err
+		}
+		p.IsPublished = false
+		p.UnpublishAt = nil
+		if err := p.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryPostIds(stmt string, args ...interface{}) ([]int64, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
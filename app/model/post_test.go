@@ -5,6 +5,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"os"
 	"testing"
+	"time"
 )
 
 func mockPost() *Post {
@@ -15,7 +16,7 @@ func mockPost() *Post {
 	p.Html = utils.Markdown2Html(p.Markdown)
 	p.Tags = GenerateTagsFromCommaString("Welcome, Dingo")
 	p.AllowComment = true
-	p.Category = ""
+	p.Category = nil
 	p.CreatedBy = 0
 	p.UpdatedBy = 0
 	p.IsPublished = true
@@ -146,6 +147,36 @@ func TestPost(t *testing.T) {
 				So(err, ShouldBeNil)
 			})
 
+			Convey("Get filtered post list", func() {
+				draft := mockPost()
+				draft.Slug = "draft"
+				draft.Tags = GenerateTagsFromCommaString("Draft")
+				draft.IsPublished = false
+				draft.CreatedBy = 2
+				So(draft.Save(), ShouldBeNil)
+
+				posts, pager, err := GetFilteredPostList(&PostFilter{}, 1, 10, "created_at")
+				So(err, ShouldBeNil)
+				So(posts, ShouldHaveLength, 2)
+				So(pager.Total, ShouldEqual, 2)
+
+				posts, _, _ = GetFilteredPostList(&PostFilter{Status: "draft"}, 1, 10, "created_at")
+				So(posts, ShouldHaveLength, 1)
+				So(posts[0].Id, ShouldEqual, draft.Id)
+
+				tag, _ := GetTagBySlug("welcome")
+				posts, _, _ = GetFilteredPostList(&PostFilter{TagId: tag.Id}, 1, 10, "created_at")
+				So(posts, ShouldHaveLength, 1)
+				So(posts[0].Id, ShouldEqual, p.Id)
+
+				posts, _, _ = GetFilteredPostList(&PostFilter{AuthorId: 2}, 1, 10, "created_at")
+				So(posts, ShouldHaveLength, 1)
+				So(posts[0].Id, ShouldEqual, draft.Id)
+
+				posts, _, _ = GetFilteredPostList(&PostFilter{IsPage: true}, 1, 10, "created_at")
+				So(posts, ShouldHaveLength, 0)
+			})
+
 			Convey("Create a post with the same slug", func() {
 				newPost := mockPost()
 				err := newPost.Save()
@@ -165,6 +196,102 @@ func TestPost(t *testing.T) {
 
 		})
 
+		Convey("Create a scheduled post", func() {
+			p := mockPost()
+			publishAt := time.Now().Add(time.Hour)
+			p.PublishedAt = &publishAt
+			err := p.Save()
+
+			So(err, ShouldBeNil)
+			So(p.Status(), ShouldEqual, "scheduled")
+			So(p.IsPublished, ShouldBeFalse)
+			So(p.IsScheduled, ShouldBeTrue)
+
+			Convey("Scheduled post should be hidden", func() {
+				posts, _, err := GetPostList(1, 5, false, true, "published_at DESC")
+
+				So(err, ShouldBeNil)
+				So(posts, ShouldHaveLength, 0)
+			})
+
+			Convey("Scheduled post should not be published before its time", func() {
+				err := PublishScheduledPosts()
+				So(err, ShouldBeNil)
+
+				post, _ := GetPostById(p.Id)
+				So(post.Status(), ShouldEqual, "scheduled")
+			})
+
+			Convey("Publish the post when its time comes", func() {
+				_, err := db.Exec(`UPDATE posts SET published_at = ? WHERE id = ?`, time.Now().Add(-time.Minute), p.Id)
+				So(err, ShouldBeNil)
+
+				err = PublishScheduledPosts()
+				So(err, ShouldBeNil)
+
+				post, _ := GetPostById(p.Id)
+				So(post.Status(), ShouldEqual, "published")
+				So(post.IsPublished, ShouldBeTrue)
+
+				posts, _, err := GetPostList(1, 5, false, true, "published_at DESC")
+				So(posts, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("Create a post with an unpublish date", func() {
+			p := mockPost()
+			unpublishAt := time.Now().Add(-time.Minute)
+			p.UnpublishAt = &unpublishAt
+			err := p.Save()
+
+			So(err, ShouldBeNil)
+			So(p.Status(), ShouldEqual, "published")
+
+			Convey("Unpublish the post", func() {
+				err := PublishScheduledPosts()
+				So(err, ShouldBeNil)
+
+				post, _ := GetPostById(p.Id)
+				So(post.Status(), ShouldEqual, "draft")
+				So(post.UnpublishAt, ShouldBeNil)
+			})
+		})
+
+		Convey("Compare the times of other time zones", func() {
+			local := time.Local
+			time.Local = time.FixedZone("UTC-10", -10*3600)
+			defer func() { time.Local = local }()
+
+			// Imported in UTC, the time of the site is ten hours behind
+			p := mockPost()
+			publishedAt := time.Now().Add(-time.Hour).UTC()
+			p.PublishedAt = &publishedAt
+			So(p.Save(), ShouldBeNil)
+			posts, _, err := GetPostList(1, 5, false, true, "published_at DESC")
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 1)
+
+			// Written in a time zone ten hours ahead of UTC
+			p = mockPost()
+			p.Slug = "scheduled"
+			publishAt := time.Now().Add(time.Hour).In(time.FixedZone("UTC+10", 10*3600))
+			p.PublishedAt = &publishAt
+			So(p.Save(), ShouldBeNil)
+			So(PublishScheduledPosts(), ShouldBeNil)
+			post, _ := GetPostById(p.Id)
+			So(post.Status(), ShouldEqual, "scheduled")
+			So(post.PublishedAt.Equal(publishAt), ShouldBeTrue)
+
+			Convey("Rewrite the times stored before in UTC", func() {
+				_, err := db.Exec(`UPDATE posts SET published_at = ? WHERE id = ?`, publishAt.Format("2006-01-02 15:04:05.999999999-07:00"), p.Id)
+				So(err, ShouldBeNil)
+				So(migrateUTCTimes(db), ShouldBeNil)
+				var stored string
+				db.QueryRow(`SELECT published_at || '' FROM posts WHERE id = ?`, p.Id).Scan(&stored)
+				So(stored, ShouldEqual, publishAt.UTC().Format("2006-01-02 15:04:05.999999999-07:00"))
+			})
+		})
+
 		Convey("Create welcome data", func() {
 			createWelcomeData()
 
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// PostRevision is a snapshot of the content of a post, taken every time the
+// post is saved.
+type PostRevision struct {
+	Id        int64
+	PostId    int64
+	Title     string
+	Markdown  string
+	Html      string
+	CreatedAt *time.Time
+	CreatedBy int64
+}
+
+func NewPostRevision(p *Post) *PostRevision {
+	return &PostRevision{
+		PostId:    p.Id,
+		Title:     p.Title,
+		Markdown:  p.Markdown,
+		Html:      p.Html,
+		CreatedAt: utils.Now(),
+		CreatedBy: p.UpdatedBy,
+	}
+}
+
+func (r *PostRevision) Save() error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	r.Id, err = writeDB.Insert(stmtInsertPostRevision, r.PostId, r.Title, r.Markdown, r.Html, r.CreatedAt, r.CreatedBy)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func (r *PostRevision) Author() *User {
+	user, err := GetUserById(r.CreatedBy)
+	if err != nil {
+		return ghostUser
+	}
+	return user
+}
+
+// Diff returns the line diff of the markdown from the revision r to the
+// revision to.
+func (r *PostRevision) Diff(to *PostRevision) []utils.DiffLine {
+	return utils.DiffLines(r.Markdown, to.Markdown)
+}
+
+// saveRevision records the current content of the post, unless it is the same
+// as the content of the latest revision.
+func (p *Post) saveRevision() error {
+	latest, err := GetLatestPostRevision(p.Id)
+	if err == nil && latest.Title == p.Title && latest.Markdown == p.Markdown {
+		return nil
+	}
+	return NewPostRevision(p).Save()
+}
+
+// RestoreRevision replaces the content of the post with the content of the
+// revision, and saves the post.
+func (p *Post) RestoreRevision(r *PostRevision) error {
+	p.Title = r.Title
+	p.Markdown = r.Markdown
+	p.Html = r.Html
+	return p.Save()
+}
+
+func scanPostRevision(row Row, r *PostRevision) error {
+	var (
+		nullMarkdown sql.NullString
+		nullHtml     sql.NullString
+	)
+	err := row.Scan(&r.Id, &r.PostId, &r.Title, &nullMarkdown, &nullHtml, &r.CreatedAt, &r.CreatedBy)
+	r.Markdown = nullMarkdown.String
+	r.Html = nullHtml.String
+	return err
+}
+
+func GetPostRevisionById(id int64) (*PostRevision, error) {
+	r := new(PostRevision)
+	row := db.QueryRow(stmtGetPostRevisionById, id)
+	if err := scanPostRevision(row, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func GetLatestPostRevision(postId int64) (*PostRevision, error) {
+	r := new(PostRevision)
+	row := db.QueryRow(stmtGetLatestPostRevision, postId)
+	if err := scanPostRevision(row, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func GetPostRevisionsByPostId(postId int64) ([]*PostRevision, error) {
+	rows, err := db.Query(stmtGetPostRevisionsByPostId, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]*PostRevision, 0)
+	for rows.Next() {
+		r := new(PostRevision)
+		if err := scanPostRevision(rows, r); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func DeletePostRevisionsByPostId(postId int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtDeletePostRevisionsByPostId, postId)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostRevision(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Save a post", func() {
+			p := mockPost()
+			p.Markdown = "Hello\nWorld"
+			err := p.Save()
+			So(err, ShouldBeNil)
+
+			Convey("A revision is recorded", func() {
+				revisions, err := GetPostRevisionsByPostId(p.Id)
+				So(err, ShouldBeNil)
+				So(revisions, ShouldHaveLength, 1)
+				So(revisions[0].Markdown, ShouldEqual, "Hello\nWorld")
+			})
+
+			Convey("Saving unchanged content does not record a revision", func() {
+				err := p.Save()
+				So(err, ShouldBeNil)
+				revisions, _ := GetPostRevisionsByPostId(p.Id)
+				So(revisions, ShouldHaveLength, 1)
+			})
+
+			Convey("Update the post", func() {
+				p.Markdown = "Hello\nDingo"
+				err := p.Save()
+				So(err, ShouldBeNil)
+
+				revisions, err := GetPostRevisionsByPostId(p.Id)
+				So(err, ShouldBeNil)
+				So(revisions, ShouldHaveLength, 2)
+				latest, old := revisions[0], revisions[1]
+				So(latest.Markdown, ShouldEqual, "Hello\nDingo")
+
+				Convey("Diff two revisions", func() {
+					diff := old.Diff(latest)
+					So(diff, ShouldHaveLength, 3)
+					So(diff[0].Type, ShouldEqual, "equal")
+					So(diff[1].Type, ShouldEqual, "delete")
+					So(diff[1].Text, ShouldEqual, "World")
+					So(diff[2].Type, ShouldEqual, "insert")
+					So(diff[2].Prefix(), ShouldEqual, "+")
+				})
+
+				Convey("Restore a revision", func() {
+					err := p.RestoreRevision(old)
+					So(err, ShouldBeNil)
+					post, _ := GetPostById(p.Id)
+					So(post.Markdown, ShouldEqual, "Hello\nWorld")
+					revisions, _ := GetPostRevisionsByPostId(p.Id)
+					So(revisions, ShouldHaveLength, 3)
+				})
+			})
+
+			Convey("Deleting the post deletes its revisions", func() {
+				err := DeletePostById(p.Id)
+				So(err, ShouldBeNil)
+				revisions, _ := GetPostRevisionsByPostId(p.Id)
+				So(revisions, ShouldBeEmpty)
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
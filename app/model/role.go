@@ -0,0 +1,110 @@
+package model
+
+import (
+	"time"
+
+	"github.com/twinj/uuid"
+)
+
+const (
+	RoleAdministrator = 1
+	RoleEditor        = 2
+	RoleAuthor        = 3
+	RoleOwner         = 4
+)
+
+type Role struct {
+	Id          int
+	Name        string
+	Description string
+}
+
+var defaultRoles = []*Role{
+	{RoleAdministrator, "Administrator", "Administrators can manage the whole blog, including settings, files and users."},
+	{RoleEditor, "Editor", "Editors can manage all posts, pages, categories and comments."},
+	{RoleAuthor, "Author", "Authors can write posts and manage their own posts."},
+	{RoleOwner, "Owner", "The owner has all the permissions of an administrator."},
+}
+
+func GetRoleById(id int) (*Role, error) {
+	r := new(Role)
+	err := db.QueryRow(stmtGetRoleById, id).Scan(&r.Id, &r.Name, &r.Description)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func GetAllRoles() ([]*Role, error) {
+	rows, err := db.Query(stmtGetAllRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := make([]*Role, 0)
+	for rows.Next() {
+		r := new(Role)
+		if err := rows.Scan(&r.Id, &r.Name, &r.Description); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+// checkRoles creates the default roles, and makes the users created before
+// roles were enforced owners, since only the first user could sign up.
+func checkRoles() error {
+	var count int64
+	if err := db.QueryRow(stmtGetRolesCount).Scan(&count); err != nil {
+		return err
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if count == 0 {
+		now := time.Now()
+		for _, r := range defaultRoles {
+			_, err = writeDB.Exec(stmtInsertRole, r.Id, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), r.Name, r.Description, now, 0, now, 0)
+			if err != nil {
+				writeDB.Rollback()
+				return err
+			}
+		}
+	}
+	if _, err = writeDB.Exec(stmtInsertMissingRoleUsers, RoleOwner); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func (u *User) RoleName() string {
+	for _, r := range defaultRoles {
+		if r.Id == u.Role {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// IsAdmin reports whether the user can manage settings, files and users.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdministrator || u.Role == RoleOwner
+}
+
+// IsEditor reports whether the user can manage the content of other users.
+func (u *User) IsEditor() bool {
+	return u.IsAdmin() || u.Role == RoleEditor
+}
+
+// CanEditPost reports whether the user can edit or delete the post. Authors
+// can only edit their own posts, and pages are left to editors.
+func (u *User) CanEditPost(p *Post) bool {
+	if u.IsEditor() {
+		return true
+	}
+	return !p.IsPage && p.userId == u.Id
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRole(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Default roles are created", func() {
+			roles, err := GetAllRoles()
+			So(err, ShouldBeNil)
+			So(roles, ShouldHaveLength, 4)
+			r, err := GetRoleById(RoleEditor)
+			So(err, ShouldBeNil)
+			So(r.Name, ShouldEqual, "Editor")
+		})
+
+		Convey("Create users", func() {
+			owner := mockUser()
+			err := owner.Create(password)
+			So(err, ShouldBeNil)
+			author := NewUser("author@example.com", "Author")
+			err = author.Create(password)
+			So(err, ShouldBeNil)
+
+			Convey("The first user is the owner", func() {
+				u, _ := GetUserById(owner.Id)
+				So(u.Role, ShouldEqual, RoleOwner)
+				So(u.IsAdmin(), ShouldBeTrue)
+				So(u.IsEditor(), ShouldBeTrue)
+			})
+
+			Convey("Other users are authors", func() {
+				u, _ := GetUserById(author.Id)
+				So(u.Role, ShouldEqual, RoleAuthor)
+				So(u.RoleName(), ShouldEqual, "Author")
+				So(u.IsAdmin(), ShouldBeFalse)
+				So(u.IsEditor(), ShouldBeFalse)
+			})
+
+			Convey("Authors can only edit their own posts", func() {
+				p := mockPost()
+				p.CreatedBy = owner.Id
+				So(p.Save(), ShouldBeNil)
+				own := mockPost()
+				own.Slug = "own-post"
+				own.CreatedBy = author.Id
+				So(own.Save(), ShouldBeNil)
+
+				p, _ = GetPostById(p.Id)
+				own, _ = GetPostById(own.Id)
+				So(author.CanEditPost(p), ShouldBeFalse)
+				So(author.CanEditPost(own), ShouldBeTrue)
+				So(owner.CanEditPost(own), ShouldBeTrue)
+			})
+
+			Convey("Change the role", func() {
+				err := author.SetRole(RoleEditor)
+				So(err, ShouldBeNil)
+				u, _ := GetUserById(author.Id)
+				So(u.Role, ShouldEqual, RoleEditor)
+				So(u.IsEditor(), ShouldBeTrue)
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
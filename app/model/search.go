@@ -0,0 +1,189 @@
+package model
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/dinever/dingo/app/model/sql_builder"
+	"github.com/dinever/dingo/app/utils"
+)
+
+// The markers around the matched terms in the highlighted title and snippet
+// of search results.
+const (
+	highlightOpen  = "\x02"
+	highlightClose = "\x03"
+)
+
+var searchAvailable bool
+
+// SearchResult is a post matching a search, with its title and an extract of
+// its content where the matched terms are highlighted.
+type SearchResult struct {
+	Post    *Post
+	Title   template.HTML
+	Snippet template.HTML
+}
+
+// SearchAvailable reports whether the database is SQLite, and the driver has
+// been built with FTS5, which requires the build tag sqlite_fts5.
+func SearchAvailable() bool {
+	return searchAvailable
+}
+
+// initSearchIndex creates the full-text index of the posts, and fills it when
+// the posts were written by a version without search.
+func initSearchIndex() error {
+	if db.dialect != SQL.SQLite {
+		log.Printf("[Warning]: Search is disabled, it is only supported with SQLite")
+		searchAvailable = false
+		return nil
+	}
+	if _, err := db.Exec(stmtCreateSearchIndex); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			log.Printf("[Warning]: Search is disabled, SQLite is built without FTS5: %v", err)
+			searchAvailable = false
+			return nil
+		}
+		return err
+	}
+	searchAvailable = true
+	var indexed, posts int64
+	if err := db.QueryRow(stmtGetSearchIndexCount).Scan(&indexed); err != nil {
+		return err
+	}
+	if err := db.QueryRow(stmtGetAllPostsCount).Scan(&posts); err != nil {
+		return err
+	}
+	if indexed > 0 || posts == 0 {
+		return nil
+	}
+	ids, err := queryPostIds(stmtGetAllPostIds)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		p, err := GetPostById(id)
+		if err != nil {
+			return err
+		}
+		if err := indexPost(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchText removes the highlight markers from the indexed text.
+func searchText(s string) string {
+	return strings.NewReplacer(highlightOpen, "", highlightClose, "").Replace(s)
+}
+
+// indexPost replaces the entry of the post in the search index.
+func indexPost(p *Post) error {
+	if !searchAvailable {
+		return nil
+	}
+	tags := make([]string, len(p.Tags))
+	for i, t := range p.Tags {
+		tags[i] = t.Name
+	}
+	body := html.UnescapeString(utils.Html2Str(p.Html))
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeleteSearchIndex, p.Id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtInsertSearchIndex, p.Id, searchText(p.Title), searchText(body), searchText(strings.Join(tags, " "))); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func unindexPost(id int64) error {
+	if !searchAvailable {
+		return nil
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeleteSearchIndex, id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// searchQuery turns the words typed by the user into an FTS5 query matching
+// all of them, the last one as a prefix. Quoting the words keeps the FTS5
+// operators and syntax errors out.
+func searchQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = `"` + w + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// SearchPosts returns a page of the posts matching the query and the filter,
+// the best matches first.
+func SearchPosts(q string, filter *PostFilter, page, size int64) ([]*SearchResult, *utils.Pager, error) {
+	if !searchAvailable {
+		return nil, nil, fmt.Errorf("Search is not available")
+	}
+	var count int64
+	query := searchQuery(q)
+	if query == "" {
+		return make([]*SearchResult, 0), utils.NewPager(page, size, 0), nil
+	}
+	where, args := filter.conditions()
+	args = append([]interface{}{query}, args...)
+	row := db.QueryRow(searchCountSelector.Copy().Where(where...).SQL(), args...)
+	if err := row.Scan(&count); err != nil {
+		return nil, nil, err
+	}
+	pager := utils.NewPager(page, size, count)
+	// The title weighs more than the tags, which weigh more than the body
+	selector := searchSelector.Copy().Where(where...).OrderBy(`bm25(posts_search, 10.0, 1.0, 5.0)`).Limit(`?`).Offset(`?`)
+	rows, err := db.Query(selector.SQL(), append(args, size, pager.Begin-1)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	results := make([]*SearchResult, 0)
+	for rows.Next() {
+		var (
+			id             int64
+			title, snippet string
+		)
+		if err := rows.Scan(&id, &title, &snippet); err != nil {
+			return nil, nil, err
+		}
+		p, err := GetPostById(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		results = append(results, &SearchResult{
+			Post:    p,
+			Title:   utils.Highlight(title, highlightOpen, highlightClose),
+			Snippet: utils.Highlight(snippet, highlightOpen, highlightClose),
+		})
+	}
+	return results, pager, rows.Err()
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSearch(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		Reset(func() {
+			os.Remove("test.db")
+		})
+
+		Convey("Search query", func() {
+			So(searchQuery(`go "AND" -sql*`), ShouldEqual, `"go" "AND" "sql"*`)
+			So(searchQuery(`"*`), ShouldEqual, ``)
+		})
+
+		if !SearchAvailable() {
+			// FTS5 needs the build tag sqlite_fts5
+			return
+		}
+
+		Convey("Search posts", func() {
+			p := mockPost()
+			p.Title = "Writing a blog engine"
+			p.Markdown = "Dingo is written in **Go** & uses SQLite <for> storage."
+			p.Html = "<p>Dingo is written in <strong>Go</strong> &amp; uses SQLite &lt;for&gt; storage.</p>"
+			So(p.Save(), ShouldBeNil)
+
+			draft := mockPost()
+			draft.Slug = "draft"
+			draft.Title = "Unfinished engine notes"
+			draft.IsPublished = false
+			So(draft.Save(), ShouldBeNil)
+
+			Convey("Find published posts", func() {
+				results, pager, err := SearchPosts("sqlite stor", &PostFilter{Status: "published"}, 1, 10)
+				So(err, ShouldBeNil)
+				So(pager.Total, ShouldEqual, 1)
+				So(results, ShouldHaveLength, 1)
+				So(results[0].Post.Id, ShouldEqual, p.Id)
+				So(string(results[0].Snippet), ShouldContainSubstring, "<mark>SQLite</mark> &lt;for&gt; <mark>storage</mark>")
+
+				results, _, _ = SearchPosts("engine", &PostFilter{Status: "published"}, 1, 10)
+				So(results, ShouldHaveLength, 1)
+				So(string(results[0].Title), ShouldEqual, "Writing a blog <mark>engine</mark>")
+			})
+
+			Convey("Find drafts", func() {
+				results, _, err := SearchPosts("engine", &PostFilter{}, 1, 10)
+				So(err, ShouldBeNil)
+				So(results, ShouldHaveLength, 2)
+			})
+
+			Convey("Find tags", func() {
+				results, _, _ := SearchPosts("welcome", &PostFilter{}, 1, 10)
+				So(results, ShouldHaveLength, 2)
+			})
+
+			Convey("Update the index", func() {
+				p.Title = "Writing a blog"
+				p.Html = "<p>Nothing to see</p>"
+				So(p.Save(), ShouldBeNil)
+				results, _, _ := SearchPosts("sqlite", &PostFilter{}, 1, 10)
+				So(results, ShouldHaveLength, 0)
+			})
+
+			Convey("Delete from the index", func() {
+				So(DeletePostById(draft.Id), ShouldBeNil)
+				results, _, _ := SearchPosts("engine", &PostFilter{}, 1, 10)
+				So(results, ShouldHaveLength, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"strconv"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// The values signed by the site each have their own key, so that the
+// signature of a value can not be used for another purpose. The keys are
+// kept in their own table, out of the settings which are exported.
+const (
+	secretUnsubscribe = "unsubscribe"
+	secretSubscribe   = "subscribe"
+	secretComment     = "comment"
+)
+
+// secret returns the key of a purpose, it is made up the first time it is
+// needed.
+func secret(name string) (string, error) {
+	var value string
+	err := db.QueryRow(stmtGetSecret, name).Scan(&value)
+	if err != sql.ErrNoRows {
+		return value, err
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return "", err
+	}
+	// Another request may have made it up in the meantime, its key is kept
+	if _, err = writeDB.Exec(stmtInsertSecret, name, utils.RandomToken(32)); err != nil {
+		writeDB.Rollback()
+	} else if err = writeDB.Commit(); err != nil {
+		return "", err
+	}
+	err = db.QueryRow(stmtGetSecret, name).Scan(&value)
+	return value, err
+}
+
+// sign returns the signature of a value with the key of a purpose, or an
+// empty string if the key can not be read.
+func sign(name, value string) string {
+	key, err := secret(name)
+	if err != nil || key == "" {
+		log.Printf("[Error]: Can not read the %s key: %v", name, err)
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkSignature(name, value, signature string) bool {
+	expected := sign(name, value)
+	return expected != "" && hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// CommentFormToken returns the signature of a comment form of a post
+// rendered at a unix time.
+func CommentFormToken(postId int64, ts string) string {
+	return sign(secretComment, strconv.FormatInt(postId, 10)+":"+ts)
+}
+
+// CheckCommentFormToken tells whether token is the signature of a comment
+// form of the post rendered at ts.
+func CheckCommentFormToken(postId int64, ts, token string) bool {
+	return checkSignature(secretComment, strconv.FormatInt(postId, 10)+":"+ts, token)
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSecret(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Sign values with a key of each purpose", func() {
+			token := UnsubscribeToken("reader@example.com")
+			So(token, ShouldHaveLength, 64)
+			So(CheckUnsubscribeToken("Reader@example.com ", token), ShouldBeTrue)
+			So(CheckUnsubscribeToken("other@example.com", token), ShouldBeFalse)
+			So(CommentFormToken(1, "1000"), ShouldNotEqual, sign(secretUnsubscribe, "1:1000"))
+			So(CheckCommentFormToken(1, "1000", CommentFormToken(1, "1000")), ShouldBeTrue)
+			So(CheckCommentFormToken(2, "1000", CommentFormToken(1, "1000")), ShouldBeFalse)
+		})
+
+		Convey("Keep the keys out of the settings", func() {
+			token := UnsubscribeToken("reader@example.com")
+			key, err := secret(secretUnsubscribe)
+			So(err, ShouldBeNil)
+			for _, s := range GetAllSettings() {
+				So(s.Value, ShouldNotEqual, key)
+			}
+			e, err := ExportSite(true)
+			So(err, ShouldBeNil)
+			data, _ := json.Marshal(e)
+			So(string(data), ShouldNotContainSubstring, key)
+
+			e.Settings = append(e.Settings, &ExportSetting{Key: "mail_secret", Value: "forged"}, &ExportSetting{Key: "site_secret", Value: "forged"})
+			_, err = ImportSite(e)
+			So(err, ShouldBeNil)
+			So(CheckUnsubscribeToken("reader@example.com", token), ShouldBeTrue)
+			So(GetSettingValue("mail_secret"), ShouldBeEmpty)
+			So(GetSettingValue("site_secret"), ShouldBeEmpty)
+		})
+
+		Convey("Move the keys of the settings", func() {
+			_, err := db.Exec(`DELETE FROM secrets`)
+			So(err, ShouldBeNil)
+			So(NewSetting("mail_secret", "mail key", "").Save(), ShouldBeNil)
+			So(migrateSecrets(db), ShouldBeNil)
+			key, err := secret(secretUnsubscribe)
+			So(err, ShouldBeNil)
+			So(key, ShouldEqual, "mail key")
+			So(GetSettingValue("mail_secret"), ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
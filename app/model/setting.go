@@ -47,14 +47,14 @@ func SetNavigators(labels, urls []string) error {
 }
 
 func GetSetting(k string) (*Setting, error) {
-	row := db.QueryRow(`SELECT id, uuid, key, value, type, created_at, created_by from settings where key = ?`, k)
+	row := db.QueryRow(stmtGetSettingByKey, k)
 	s := new(Setting)
 	err := scanSetting(row, s)
 	return s, err
 }
 
 func GetSettingValue(k string) string {
-	row := db.QueryRow(`SELECT id, uuid, key, value, type, created_at, created_by from settings where key = ?`, k)
+	row := db.QueryRow(stmtGetSettingByKey, k)
 	s := new(Setting)
 	scanSetting(row, s)
 	return s.Value
@@ -75,8 +75,17 @@ func GetCustomSettings() []*Setting {
 }
 
 func GetSettings(t string) []*Setting {
+	return querySettings(stmtGetSettingsByType, t)
+}
+
+// GetAllSettings returns the settings of every type, ordered by key.
+func GetAllSettings() []*Setting {
+	return querySettings(stmtGetAllSettings)
+}
+
+func querySettings(stmt string, args ...interface{}) []*Setting {
 	settings := make([]*Setting, 0)
-	rows, err := db.Query(`SELECT id, uuid, key, value, type, created_at, created_by from settings where type = ?`, t)
+	rows, err := db.Query(stmt, args...)
 	if err != nil {
 		return settings
 	}
@@ -102,7 +111,7 @@ func (setting *Setting) Save() error {
 		writeDB.Rollback()
 		return err
 	}
-	_, err = db.Exec(`INSERT OR REPLACE INTO settings (id, uuid, key, value, type, created_at, created_by) VALUES ((SELECT id FROM settings WHERE key = ?), ?, ?, ?, ?, ?, ?)`, setting.Key, setting.UUID, setting.Key, setting.Value, setting.Type, setting.CreatedAt, setting.CreatedBy)
+	_, err = writeDB.Exec(settingUpsert.SQL(db.dialect), setting.UUID, setting.Key, setting.Value, setting.Type, setting.CreatedAt, setting.CreatedBy)
 	if err != nil {
 		writeDB.Rollback()
 		return err
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"net/url"
+	"time"
+)
+
+// SitemapEntry is a page of the site in the sitemap, with the last time its
+// content changed.
+type SitemapEntry struct {
+	Path      string
+	UpdatedAt *time.Time
+}
+
+// GetSitemapPosts lists the published posts, or pages, newest first.
+func GetSitemapPosts(isPage bool) ([]*SitemapEntry, error) {
+	return querySitemap("/", stmtGetSitemapPosts, isPage)
+}
+
+// GetSitemapTags lists the tags of the published posts, which are updated
+// with their latest post.
+func GetSitemapTags() ([]*SitemapEntry, error) {
+	return querySitemap("/tag/", stmtGetSitemapTags)
+}
+
+// GetSitemapAuthors lists the authors of the published posts, which are
+// updated with their latest post.
+func GetSitemapAuthors() ([]*SitemapEntry, error) {
+	return querySitemap("/author/", stmtGetSitemapAuthors)
+}
+
+// querySitemap reads the slugs and update times selected by stmt, a slug
+// which is selected several times in a row is listed once with the latest
+// time.
+func querySitemap(prefix, stmt string, args ...interface{}) ([]*SitemapEntry, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*SitemapEntry, 0)
+	var last string
+	for rows.Next() {
+		var slug string
+		var updatedAt *time.Time
+		if err := rows.Scan(&slug, &updatedAt); err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 && slug == last {
+			e := entries[len(entries)-1]
+			if updatedAt != nil && (e.UpdatedAt == nil || updatedAt.After(*e.UpdatedAt)) {
+				e.UpdatedAt = updatedAt
+			}
+			continue
+		}
+		last = slug
+		entries = append(entries, &SitemapEntry{Path: prefix + url.PathEscape(slug) + "/", UpdatedAt: updatedAt})
+	}
+	return entries, rows.Err()
+}
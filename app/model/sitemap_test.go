@@ -0,0 +1,53 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSitemap(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		u := NewUser("author@example.com", "Author")
+		So(u.Create("password"), ShouldBeNil)
+		for _, slug := range []string{"first", "second", "draft"} {
+			p := NewPost()
+			p.Title = slug
+			p.Slug = slug
+			p.Tags = GenerateTagsFromCommaString("Go, Web Dev")
+			p.CreatedBy = u.Id
+			p.IsPublished = slug != "draft"
+			So(p.Save(), ShouldBeNil)
+		}
+		second, _ := GetPostBySlug("second")
+
+		Convey("List the published posts", func() {
+			posts, err := GetSitemapPosts(false)
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 2)
+			pages, err := GetSitemapPosts(true)
+			So(err, ShouldBeNil)
+			So(pages, ShouldBeEmpty)
+		})
+
+		Convey("List the tags and authors with their latest post", func() {
+			tags, err := GetSitemapTags()
+			So(err, ShouldBeNil)
+			So(tags, ShouldHaveLength, 2)
+			So(tags[0].Path, ShouldEqual, "/tag/go/")
+			So(tags[1].Path, ShouldEqual, "/tag/web-dev/")
+			So(tags[0].UpdatedAt.Equal(*second.UpdatedAt), ShouldBeTrue)
+
+			authors, err := GetSitemapAuthors()
+			So(err, ShouldBeNil)
+			So(authors, ShouldHaveLength, 1)
+			So(authors[0].Path, ShouldEqual, "/author/"+u.Slug+"/")
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -31,7 +31,7 @@ func GenerateSlug(input string, table string) string {
 		output = string(runes)
 	}
 	// Don't allow a few specific slugs that are used by the blog
-	if table == "posts" && (output == "rss" || output == "tag" || output == "author" || output == "page" || output == "admin") {
+	if table == "posts" && (output == "rss" || output == "tag" || output == "author" || output == "page" || output == "admin" || output == "category") {
 		output = generateUniqueSlug(output, table, 2)
 	} else if table == "tags" || table == "navigation" { // We want duplicate tag and navigation slugs
 		return output
@@ -52,6 +52,8 @@ func generateUniqueSlug(slug string, table string, suffix int) string {
 		_, err = GetPostBySlug(slugToCheck)
 	} else if table == "users" {
 		_, err = GetUserBySlug(slugToCheck)
+	} else if table == "categories" {
+		_, err = GetCategoryBySlug(slugToCheck)
 	}
 	if err == nil {
 		return generateUniqueSlug(slug, table, suffix+1)
@@ -0,0 +1,219 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// spamTokenChunk is the number of tokens looked up by one query, below the
+// 999 parameters SQLite takes.
+const spamTokenChunk = 500
+
+// SpamChecker classifies the new comments and learns from the moderators,
+// who mark comments as spam or approve them.
+type SpamChecker interface {
+	IsSpam(c *Comment) (bool, error)
+	// Learn is told the decision of a moderator. A comment may be learned
+	// again when the moderator changes their mind.
+	Learn(c *Comment, spam bool) error
+}
+
+// BayesSpamChecker is a naive Bayes classifier trained on the decisions of
+// the moderators. What it learned is kept in the database.
+type BayesSpamChecker struct {
+	// Threshold is the probability above which a comment is spam.
+	Threshold float64
+	// MinLearned is the number of spam and of approved comments it must have
+	// learned before it marks any comment as spam.
+	MinLearned int64
+}
+
+func NewBayesSpamChecker() *BayesSpamChecker {
+	return &BayesSpamChecker{Threshold: 0.9, MinLearned: 5}
+}
+
+// SpamProbability returns the probability that the comment is spam, which
+// is 0.5 when nothing is known about it.
+func (b *BayesSpamChecker) SpamProbability(c *Comment) (float64, error) {
+	spamCount, hamCount, err := b.learnedCounts()
+	if err != nil {
+		return 0, err
+	}
+	return b.probability(c, spamCount, hamCount)
+}
+
+func (b *BayesSpamChecker) probability(c *Comment, spamCount, hamCount int64) (float64, error) {
+	tokens := spamTokens(c)
+	if len(tokens) == 0 || spamCount == 0 || hamCount == 0 {
+		return 0.5, nil
+	}
+	// The log odds of the prior and of each known token, with Laplace
+	// smoothing so that a token seen in one class only does not decide
+	odds := math.Log(float64(spamCount) / float64(hamCount))
+	// The tokens are looked up by chunks, databases limit the number of
+	// parameters of a query
+	for len(tokens) > 0 {
+		n := len(tokens)
+		if n > spamTokenChunk {
+			n = spamTokenChunk
+		}
+		chunkOdds, err := tokenOdds(tokens[:n], spamCount, hamCount)
+		if err != nil {
+			return 0, err
+		}
+		odds += chunkOdds
+		tokens = tokens[n:]
+	}
+	return 1 / (1 + math.Exp(-odds)), nil
+}
+
+func tokenOdds(tokens []string, spamCount, hamCount int64) (float64, error) {
+	args := make([]interface{}, len(tokens))
+	for i, t := range tokens {
+		args[i] = t
+	}
+	rows, err := db.Query(fmt.Sprintf(stmtGetSpamTokens, strings.TrimSuffix(strings.Repeat("?, ", len(tokens)), ", ")), args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var odds float64
+	for rows.Next() {
+		var (
+			token     string
+			spam, ham int64
+		)
+		if err := rows.Scan(&token, &spam, &ham); err != nil {
+			return 0, err
+		}
+		odds += math.Log((float64(spam) + 1) / float64(spamCount+2))
+		odds -= math.Log((float64(ham) + 1) / float64(hamCount+2))
+	}
+	return odds, rows.Err()
+}
+
+func (b *BayesSpamChecker) IsSpam(c *Comment) (bool, error) {
+	spamCount, hamCount, err := b.learnedCounts()
+	if err != nil || spamCount < b.MinLearned || hamCount < b.MinLearned {
+		return false, err
+	}
+	p, err := b.probability(c, spamCount, hamCount)
+	return p > b.Threshold, err
+}
+
+func (b *BayesSpamChecker) Learn(c *Comment, spam bool) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	var learned bool
+	err = writeDB.QueryRow(stmtGetSpamComment, c.Id).Scan(&learned)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = writeDB.Exec(stmtInsertSpamComment, c.Id, spam)
+	case err == nil && learned == spam:
+		return writeDB.Rollback()
+	case err == nil:
+		// The comment is taken out of the other class
+		if err = addSpamTokens(writeDB, c, learned, -1); err == nil {
+			_, err = writeDB.Exec(stmtUpdateSpamComment, spam, c.Id)
+		}
+	}
+	if err == nil {
+		err = addSpamTokens(writeDB, c, spam, 1)
+	}
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// learnedCounts returns the number of spam and approved comments learned.
+func (b *BayesSpamChecker) learnedCounts() (spamCount, hamCount int64, err error) {
+	rows, err := db.Query(stmtGetSpamCommentCounts)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			spam  bool
+			count int64
+		)
+		if err := rows.Scan(&spam, &count); err != nil {
+			return 0, 0, err
+		}
+		if spam {
+			spamCount = count
+		} else {
+			hamCount = count
+		}
+	}
+	return spamCount, hamCount, rows.Err()
+}
+
+func addSpamTokens(s Store, c *Comment, spam bool, delta int64) error {
+	var spamDelta, hamDelta int64
+	if spam {
+		spamDelta = delta
+	} else {
+		hamDelta = delta
+	}
+	for _, t := range spamTokens(c) {
+		res, err := s.Exec(stmtUpdateSpamToken, spamDelta, hamDelta, t)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 && delta > 0 {
+			if _, err := s.Exec(stmtInsertSpamToken, t, spamDelta, hamDelta); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var spamLinkPattern = regexp.MustCompile(`(?i)https?://([^/\s"'<>&]+)`)
+
+// spamTokens returns the distinct words of the author and of the content of
+// a comment, with the domains of the email, of the website and of the links.
+func spamTokens(c *Comment) []string {
+	tokens := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(t string) {
+		if len(t) < 2 || len(t) > 100 || seen[t] {
+			return
+		}
+		seen[t] = true
+		tokens = append(tokens, t)
+	}
+	text := strings.ToLower(utils.Html2Str(c.Author + " " + c.Content))
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		if len(w) <= 40 {
+			add(strings.Trim(w, "'"))
+		}
+	}
+	if i := strings.LastIndex(c.Email, "@"); i >= 0 {
+		add("email:" + strings.ToLower(c.Email[i+1:]))
+	}
+	if u, err := url.Parse(c.Website); err == nil && u.Host != "" {
+		add("site:" + strings.ToLower(u.Host))
+	}
+	for _, m := range spamLinkPattern.FindAllStringSubmatch(c.Content, -1) {
+		add("link:" + strings.ToLower(m[1]))
+	}
+	return tokens
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBayesSpamChecker(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		b := &BayesSpamChecker{Threshold: 0.9, MinLearned: 2}
+		learn := func(content string, spam bool) *Comment {
+			c := NewComment()
+			c.Author = "Someone"
+			c.Email = "someone@example.com"
+			c.Content = content
+			c.PostId = 1
+			So(c.Save(), ShouldBeNil)
+			So(b.Learn(c, spam), ShouldBeNil)
+			return c
+		}
+		spam := NewComment()
+		spam.Content = "Cheap pills, buy now at http://pills.example.com"
+
+		Convey("Do not decide before learning enough", func() {
+			learn("Buy cheap pills now http://pills.example.com", true)
+			learn("Nice post, thanks for the explanation", false)
+			isSpam, err := b.IsSpam(spam)
+			So(err, ShouldBeNil)
+			So(isSpam, ShouldBeFalse)
+		})
+
+		Convey("Learn from the moderators", func() {
+			learn("Buy cheap pills now http://pills.example.com", true)
+			learn("Cheap watches, buy now", true)
+			learn("Nice post, thanks for the explanation", false)
+			ham := learn("I think the second example has a typo", false)
+
+			isSpam, err := b.IsSpam(spam)
+			So(err, ShouldBeNil)
+			So(isSpam, ShouldBeTrue)
+
+			p, err := b.SpamProbability(&Comment{Content: "Thanks for the explanation of the example"})
+			So(err, ShouldBeNil)
+			So(p, ShouldBeLessThan, 0.5)
+
+			Convey("Learn a comment again", func() {
+				So(b.Learn(ham, true), ShouldBeNil)
+				So(b.Learn(ham, true), ShouldBeNil)
+				spamCount, hamCount, err := b.learnedCounts()
+				So(err, ShouldBeNil)
+				So(spamCount, ShouldEqual, 3)
+				So(hamCount, ShouldEqual, 1)
+				var s, h int64
+				So(db.QueryRow(`SELECT spam, ham FROM spam_tokens WHERE token = 'typo'`).Scan(&s, &h), ShouldBeNil)
+				So(s, ShouldEqual, 1)
+				So(h, ShouldEqual, 0)
+			})
+
+			Convey("Check a comment with more tokens than a query takes", func() {
+				words := make([]string, 2*spamTokenChunk+1)
+				for i := range words {
+					words[i] = fmt.Sprintf("word%d", i)
+				}
+				words = append(words, "cheap", "pills")
+				p, err := b.SpamProbability(&Comment{Content: strings.Join(words, " ")})
+				So(err, ShouldBeNil)
+				So(p, ShouldBeGreaterThan, 0.5)
+			})
+		})
+
+		Convey("Tokenize comments", func() {
+			c := &Comment{Author: "Bob", Email: "bob@Mail.example.com", Website: "http://bob.example.com/", Content: "Don't miss it, visit https://Spam.example.com/x now<br/>now"}
+			So(spamTokens(c), ShouldResemble, []string{"bob", "don't", "miss", "it", "visit", "https", "spam", "example", "com", "now", "email:mail.example.com", "site:bob.example.com", "link:spam.example.com"})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -0,0 +1,139 @@
+package SQL
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// A Dialect describes how a database differs from SQLite. The statements are
+// written for SQLite, with ? placeholders and double quoted identifiers, and
+// translated to the dialect of the database they run on.
+type Dialect struct {
+	// Name is the name of the database/sql driver.
+	Name string
+	// Returning is set when the driver can not tell the id of an inserted
+	// row, which is then returned by the INSERT statement.
+	Returning bool
+	// TableExists counts the tables with the given name.
+	TableExists string
+	// ColumnExists counts the columns of the given table with the given name.
+	ColumnExists string
+
+	numbered bool
+	types    *strings.Replacer
+	upsert   func(b *insertBuilder) string
+}
+
+var SQLite = &Dialect{
+	Name:         "sqlite3",
+	TableExists:  `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+	ColumnExists: `SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`,
+	types:        strings.NewReplacer(),
+	upsert:       onConflict,
+}
+
+var Postgres = &Dialect{
+	Name:         "postgres",
+	Returning:    true,
+	TableExists:  `SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`,
+	ColumnExists: `SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`,
+	numbered:     true,
+	// Booleans are stored as numbers, since the statements compare them
+	// with 0 and 1.
+	types: strings.NewReplacer(
+		"integer NOT NULL PRIMARY KEY AUTOINCREMENT", "serial PRIMARY KEY",
+		" datetime", " timestamp",
+		" tinyint", " smallint",
+		" boolean", " smallint",
+	),
+	upsert: onConflict,
+}
+
+// MySQL needs the ANSI_QUOTES SQL mode for the double quoted identifiers.
+var MySQL = &Dialect{
+	Name:         "mysql",
+	TableExists:  `SELECT count(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`,
+	ColumnExists: `SELECT count(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`,
+	types:        strings.NewReplacer("AUTOINCREMENT", "AUTO_INCREMENT"),
+	upsert:       onDuplicateKey,
+}
+
+// Rebind replaces the ? placeholders of the query with the ones of the
+// dialect.
+func (d *Dialect) Rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	buf := new(bytes.Buffer)
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// Schema translates the column types of CREATE TABLE and ALTER TABLE
+// statements.
+func (d *Dialect) Schema(ddl string) string {
+	return d.types.Replace(ddl)
+}
+
+func Insert(into, columns string) *insertBuilder {
+	b := new(insertBuilder)
+	b.into = into
+	b.columns = columns
+	return b
+}
+
+// A simple INSERT statement builder, which can update the existing row when
+// the insert conflicts with a unique key.
+type insertBuilder struct {
+	into     string
+	columns  string
+	conflict string
+	update   []string
+}
+
+// OnConflict updates the given columns of the row which has the same key
+// instead of inserting a new one.
+func (b *insertBuilder) OnConflict(key string, update ...string) *insertBuilder {
+	b.conflict = key
+	b.update = update
+	return b
+}
+
+func (b *insertBuilder) SQL(d *Dialect) string {
+	n := len(strings.Split(b.columns, ","))
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+	stmt := "INSERT INTO " + b.into + " (" + b.columns + ") VALUES (" + placeholders + ")"
+	if b.conflict != "" {
+		stmt += d.upsert(b)
+	}
+	return stmt
+}
+
+func onConflict(b *insertBuilder) string {
+	set := make([]string, len(b.update))
+	for i, c := range b.update {
+		set[i] = c + " = excluded." + c
+	}
+	return " ON CONFLICT (" + b.conflict + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+func onDuplicateKey(b *insertBuilder) string {
+	set := make([]string, len(b.update))
+	for i, c := range b.update {
+		set[i] = c + " = VALUES(" + c + ")"
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
@@ -0,0 +1,47 @@
+package SQL
+
+import (
+	"testing"
+)
+
+func TestDialect(t *testing.T) {
+	upsert := Insert(`settings`, `"key", value, type`).OnConflict(`"key"`, `value`, `type`)
+	cases := []struct {
+		in, out string
+	}{
+		{
+			SQLite.Rebind(`SELECT * FROM posts WHERE id = ? AND slug = ?`),
+			`SELECT * FROM posts WHERE id = ? AND slug = ?`,
+		},
+		{
+			Postgres.Rebind(`SELECT * FROM posts WHERE id = ? AND title = '?' AND slug = ?`),
+			`SELECT * FROM posts WHERE id = $1 AND title = '?' AND slug = $2`,
+		},
+		{
+			Insert(`tags`, `name, slug`).SQL(MySQL),
+			`INSERT INTO tags (name, slug) VALUES (?, ?)`,
+		},
+		{
+			upsert.SQL(SQLite),
+			`INSERT INTO settings ("key", value, type) VALUES (?, ?, ?) ON CONFLICT ("key") DO UPDATE SET value = excluded.value, type = excluded.type`,
+		},
+		{
+			upsert.SQL(MySQL),
+			`INSERT INTO settings ("key", value, type) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value), type = VALUES(type)`,
+		},
+		{
+			Postgres.Schema(`CREATE TABLE tags (id integer NOT NULL PRIMARY KEY AUTOINCREMENT, hidden boolean NOT NULL DEFAULT 0, created_at datetime NOT NULL)`),
+			`CREATE TABLE tags (id serial PRIMARY KEY, hidden smallint NOT NULL DEFAULT 0, created_at timestamp NOT NULL)`,
+		},
+		{
+			MySQL.Schema(`CREATE TABLE tags (id integer NOT NULL PRIMARY KEY AUTOINCREMENT, created_at datetime NOT NULL)`),
+			`CREATE TABLE tags (id integer NOT NULL PRIMARY KEY AUTO_INCREMENT, created_at datetime NOT NULL)`,
+		},
+	}
+
+	for _, c := range cases {
+		if c.in != c.out {
+			t.Errorf("SQL dialect error: \nExpected %v \nGot      %v", c.out, c.in)
+		}
+	}
+}
@@ -12,6 +12,10 @@ func TestSQLBuilder(t *testing.T) {
 			Select(`*`).From(`posts`).Where(`status="published"`).OrderBy("posts.published_at DESC").Limit(`5`).Offset(`0`).SQL(),
 			`SELECT * FROM posts WHERE status="published" ORDER BY posts.published_at DESC LIMIT 5 OFFSET 0`,
 		},
+		{
+			Select(`*`).From(`posts`).Where(`status='published'`).OrderBy("posts.published_at DESC").Limit(`5`).Offset(`0`).SQL(),
+			`SELECT * FROM posts WHERE status='published' ORDER BY posts.published_at DESC LIMIT 5 OFFSET 0`,
+		},
 		{
 			Select(`*`).From(`posts`).SQL(),
 			`SELECT * FROM posts`,
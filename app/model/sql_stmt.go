@@ -4,6 +4,12 @@ import (
 	"github.com/dinever/dingo/app/model/sql_builder"
 )
 
+// schema is the initial schema of the database, applied by the first
+// migration. Later changes go into migrations, see migration.go.
+//
+// The statements are written for SQLite, and translated to the dialect of the
+// database by the store, see store.go. String literals are single quoted,
+// and identifiers which are keywords are double quoted.
 const schema = `
 CREATE TABLE IF NOT EXISTS
 posts (
@@ -28,15 +34,20 @@ posts (
   updated_at         datetime,
   updated_by         integer,
   published_at       datetime,
-  published_by       integer
+  published_by       integer,
+  unpublish_at       datetime
 );
 
 CREATE TABLE IF NOT EXISTS
 tokens (
-  value       varchar(40) NOT NULL PRIMARY KEY,
-  user_id     integer UNIQUE,
-  created_at  datetime,
-  expired_at  datetime
+  id            integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  hash          varchar(64) NOT NULL UNIQUE,
+  user_id       integer NOT NULL,
+  ip            varchar(45),
+  user_agent    text,
+  created_at    datetime NOT NULL,
+  last_seen_at  datetime,
+  expired_at    datetime NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS
@@ -58,6 +69,8 @@ users (
   meta_title       varchar(150),
   meta_description varchar(200),
   last_login       datetime,
+  totp_secret      varchar(32),
+  totp_last_step   integer,
   created_at       datetime NOT NULL,
   created_by       integer NOT NULL,
   updated_at       datetime,
@@ -130,11 +143,22 @@ posts_categories (
   category_id  integer NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS
+post_revisions (
+  id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  post_id     integer NOT NULL,
+  title       varchar(150) NOT NULL,
+  markdown    text,
+  html        text,
+  created_at  datetime NOT NULL,
+  created_by  integer NOT NULL
+);
+
 CREATE TABLE IF NOT EXISTS
 settings (
   id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
   uuid        varchar(36) NOT NULL,
-  key         varchar(150) NOT NULL,
+  "key"       varchar(150) NOT NULL,
   value       varchar(20) NOT NULL,
   type        varchar(150) NOT NULL DEFAULT 'core',
   created_at  datetime NOT NULL,
@@ -155,6 +179,62 @@ roles (
   updated_by   integer
 );
 
+CREATE TABLE IF NOT EXISTS
+roles_users (
+  id       integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  role_id  integer NOT NULL,
+  user_id  integer NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS
+invitations (
+  id           integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  token        varchar(64) NOT NULL UNIQUE,
+  email        varchar(254) NOT NULL,
+  role_id      integer NOT NULL,
+  created_at   datetime NOT NULL,
+  created_by   integer NOT NULL,
+  expired_at   datetime NOT NULL,
+  accepted_at  datetime
+);
+
+CREATE TABLE IF NOT EXISTS
+password_resets (
+  id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  token       varchar(64) NOT NULL UNIQUE,
+  user_id     integer NOT NULL,
+  created_at  datetime NOT NULL,
+  expired_at  datetime NOT NULL,
+  used_at     datetime
+);
+
+CREATE TABLE IF NOT EXISTS
+recovery_codes (
+  id       integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  user_id  integer NOT NULL,
+  code     varchar(64) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS
+login_challenges (
+  id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  token       varchar(64) NOT NULL UNIQUE,
+  user_id     integer NOT NULL,
+  attempts    integer NOT NULL DEFAULT 0,
+  expired_at  datetime NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS
+api_keys (
+  id            integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  hash          varchar(64) NOT NULL UNIQUE,
+  prefix        varchar(8) NOT NULL,
+  name          varchar(150) NOT NULL,
+  user_id       integer NOT NULL,
+  created_at    datetime NOT NULL,
+  last_used_at  datetime
+);
+
 CREATE TABLE IF NOT EXISTS
 messages (
   id           integer NOT NULL PRIMARY KEY AUTOINCREMENT,
@@ -165,27 +245,84 @@ messages (
 );
 `
 
+// spamSchema holds what the spam classifier learned: the number of spam and
+// ham comments each token was seen in, and how each comment was learned.
+const spamSchema = `
+CREATE TABLE IF NOT EXISTS
+spam_tokens (
+  id     integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  token  varchar(100) NOT NULL UNIQUE,
+  spam   integer NOT NULL DEFAULT 0,
+  ham    integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS
+spam_comments (
+  id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  comment_id  integer NOT NULL UNIQUE,
+  spam        boolean NOT NULL
+);
+`
+
+// unsubscribeSchema holds the email addresses which asked for no more mails
+// about comments.
+const unsubscribeSchema = `
+CREATE TABLE IF NOT EXISTS
+unsubscribes (
+  id          integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  email       varchar(150) NOT NULL UNIQUE,
+  created_at  datetime NOT NULL
+);
+`
+
+// secretSchema holds the keys the site signs values with. They are kept out
+// of the settings, which are exported and can be changed from the API.
+const secretSchema = `
+CREATE TABLE IF NOT EXISTS
+secrets (
+  name   varchar(50) NOT NULL PRIMARY KEY,
+  value  text NOT NULL
+);
+`
+
+// webmentionSchema is the queue of the webmentions sent for the links of
+// the published posts.
+const webmentionSchema = `
+CREATE TABLE IF NOT EXISTS
+outgoing_webmentions (
+  id               integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  post_id          integer NOT NULL,
+  target           text NOT NULL,
+  status           varchar(20) NOT NULL DEFAULT 'pending',
+  attempts         integer NOT NULL DEFAULT 0,
+  next_attempt_at  datetime NOT NULL,
+  last_error       text,
+  created_at       datetime NOT NULL
+);
+`
+
 // Posts
 var postCountSelector = SQL.Select(`count(*)`).From(`posts`)
-var stmtGetPublishedPostsCount = postCountSelector.Copy().Where(`status = "published"`).SQL()
+var stmtGetPublishedPostsCount = postCountSelector.Copy().Where(`status = 'published'`).SQL()
 var stmtGetAllPostsCount = postCountSelector.Copy().SQL()
 var stmtGetPostsCountByUser = postCountSelector.Copy().Where(`author_id = ?`).SQL()
-var stmtGetPostsCountByTag = postCountSelector.Copy().From(`posts, posts_tags`).Where(`posts_tags.post_id = posts.id`, `posts_tags.tag_id = ?`, `status = 'published'`).SQL()
+var stmtGetPostsCountByTag = postCountSelector.Copy().From(`posts, posts_tags`).Where(`posts_tags.post_id = posts.id`, `posts_tags.tag_id = ?`, `status = 'published'`, `published_at <= ?`).SQL()
 
-var postSelector = SQL.Select(`id, uuid, title, slug, markdown, html, featured, page, allow_comment, comment_num, status, image, author_id, created_at, created_by, updated_at, updated_by, published_at, published_by`).From(`posts`)
-var stmtGetPublishedPostList = postSelector.Copy().Where(`status = "published"`).OrderBy(`published_at DESC`).Limit(`?`).Offset(`?`).SQL()
+var postSelector = SQL.Select(`id, uuid, title, slug, markdown, html, featured, page, allow_comment, comment_num, status, image, author_id, created_at, created_by, updated_at, updated_by, published_at, published_by, unpublish_at, meta_title, meta_description`).From(`posts`)
+var stmtGetPublishedPostList = postSelector.Copy().Where(`status = 'published'`).OrderBy(`published_at DESC`).Limit(`?`).Offset(`?`).SQL()
 var stmtGetAllPostList = postSelector.Copy().OrderBy(`published_at DESC`).Limit(`?`).Offset(`?`).SQL()
 var stmtGetPostsByUser = postSelector.Copy().Where(`status = 'published'`, `author_id = ?`).OrderBy(`published_at DESC`).Limit(`?`).Offset(`?`).SQL()
 
 var stmtGetPostById = postSelector.Copy().Where(`id = ?`).SQL()
 var stmtGetPostBySlug = postSelector.Copy().Where(`slug = ?`).SQL()
+var stmtGetPostByUUID = postSelector.Copy().Where(`uuid = ?`).SQL()
 
-var postsTagsSelector = SQL.Select(`posts.id, posts.uuid, posts.title, posts.slug, posts.markdown, posts.html, posts.featured, posts.page, posts.allow_comment, posts.comment_num, posts.status, posts.image, posts.author_id, posts.created_at, posts.created_by, posts.updated_at, posts.updated_by, posts.published_at, posts.published_by`).From(`posts, posts_tags`)
-var stmtGetPostsByTag = postsTagsSelector.Copy().Where(`status = 'published'`, `posts_tags.post_id = posts.id`, `posts_tags.tag_id = ?`).OrderBy(`published_at DESC`).Limit(`?`).Offset(`?`).SQL()
+var postsTagsSelector = SQL.Select(`posts.id, posts.uuid, posts.title, posts.slug, posts.markdown, posts.html, posts.featured, posts.page, posts.allow_comment, posts.comment_num, posts.status, posts.image, posts.author_id, posts.created_at, posts.created_by, posts.updated_at, posts.updated_by, posts.published_at, posts.published_by, posts.unpublish_at, posts.meta_title, posts.meta_description`).From(`posts, posts_tags`)
+var stmtGetPostsByTag = postsTagsSelector.Copy().Where(`status = 'published'`, `posts_tags.post_id = posts.id`, `posts_tags.tag_id = ?`, `published_at <= ?`).OrderBy(`published_at DESC`).Limit(`?`).Offset(`?`).SQL()
 var stmtGetAllPostsByTag = postsTagsSelector.Copy().Where(`posts_tags.post_id = posts.id`, `posts_tags.tag_id = ?`).OrderBy(`published_at DESC`).SQL()
 
 var pageCountSelector = SQL.Select(`count(*)`).From(`posts`).Where(`page = 1`)
-var stmtGetPublishedPagesCount = pageCountSelector.Copy().Where(`status = "published"`).SQL()
+var stmtGetPublishedPagesCount = pageCountSelector.Copy().Where(`status = 'published'`).SQL()
 var stmtGetAllPagesCount = pageCountSelector.Copy().SQL()
 var stmtGetPagesCountByUser = pageCountSelector.Copy().Where(`author_id = ?`).SQL()
 var stmtGetPagesCountByTag = pageCountSelector.Copy().Where(`posts_tags.post_id = posts.id`, `posts_tags.tag_id = ?`, `status = 'published'`).SQL()
@@ -196,27 +333,147 @@ var pageSelector = SQL.Select(`id, uuid, title, slug, markdown, html, featured,
 
 var commentCountSelector = SQL.Select(`count(*)`).From(`comments`)
 var stmtGetAllCommentCount = commentCountSelector.SQL()
-var commentSelector = SQL.Select(`id, uuid, post_id, author, author_email, author_url, created_at, content, approved, agent, parent, user_id`).From(`comments`)
+var commentSelector = SQL.Select(`id, uuid, post_id, author, author_email, author_url, author_ip, created_at, content, approved, status, notify, type, agent, parent, user_id`).From(`comments`)
 var stmtGetAllCommentList = commentSelector.Copy().OrderBy(`created_at DESC`).Limit(`?`).Offset(`?`).SQL()
 var stmtGetApprovedCommentList = commentSelector.Copy().Where(`approved = 1`).OrderBy(`created_at DESC`).Limit(`?`).Offset(`?`).SQL()
 var stmtGetCommentById = commentSelector.Copy().Where(`id = ?`).SQL()
+var stmtGetCommentByUUID = commentSelector.Copy().Where(`uuid = ?`).SQL()
+var stmtGetAllComments = commentSelector.Copy().OrderBy(`id`).SQL()
+var stmtGetCommentsByPostId = commentSelector.Copy().Where(`post_id = ?`).OrderBy(`created_at, id`).SQL()
+var stmtGetCommentReplyCount = commentCountSelector.Copy().Where(`parent = ?`).SQL()
 var stmtGetApprovedCommentListByPostId = commentSelector.Copy().Where(`post_id = ?`, `approved = 1`).OrderBy(`created_at DESC`).SQL()
+var stmtGetCommentCountByIpSince = commentCountSelector.Copy().Where(`author_ip = ?`, `created_at > ?`).SQL()
+var stmtGetCommentCountByEmailSince = commentCountSelector.Copy().Where(`lower(author_email) = ?`, `created_at > ?`).SQL()
+var stmtGetDuplicateCommentCount = commentCountSelector.Copy().Where(`post_id = ?`, `content = ?`).SQL()
+var stmtGetWebmentionBySource = commentSelector.Copy().Where(`post_id = ?`, `type = 'webmention'`, `author_url = ?`).SQL()
 
-const stmtInsertComment = `INSERT OR REPLACE INTO comments (id, uuid, post_id, author, author_email, author_url, author_ip, created_at, content, approved, agent, parent, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtGetCommentCountsByStatus = `SELECT status, count(*) FROM comments GROUP BY status`
+const stmtInsertComment = `INSERT INTO comments (uuid, post_id, author, author_email, author_url, author_ip, created_at, content, approved, status, notify, type, agent, parent, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtUpdateComment = `UPDATE comments SET post_id = ?, author = ?, author_email = ?, author_url = ?, author_ip = ?, created_at = ?, content = ?, approved = ?, status = ?, notify = ?, type = ?, agent = ?, parent = ?, user_id = ? WHERE id = ?`
 const stmtDeleteCommentById = `DELETE FROM comments WHERE id = ?`
+const stmtGetCommentParentById = `SELECT parent, status FROM comments WHERE id = ?`
+const stmtEmptyCommentById = `UPDATE comments SET author = '', author_email = '', author_url = '', author_ip = '', content = '', approved = 0, status = ?, notify = 0 WHERE id = ?`
+const stmtClearCommentNotifyByEmail = `UPDATE comments SET notify = 0 WHERE lower(author_email) = ?`
+
+// Spam
+const stmtGetSpamTokens = `SELECT token, spam, ham FROM spam_tokens WHERE token IN (%s)`
+const stmtUpdateSpamToken = `UPDATE spam_tokens SET spam = spam + ?, ham = ham + ? WHERE token = ?`
+const stmtInsertSpamToken = `INSERT INTO spam_tokens (token, spam, ham) VALUES (?, ?, ?)`
+
+const stmtGetSpamComment = `SELECT spam FROM spam_comments WHERE comment_id = ?`
+const stmtInsertSpamComment = `INSERT INTO spam_comments (comment_id, spam) VALUES (?, ?)`
+const stmtUpdateSpamComment = `UPDATE spam_comments SET spam = ? WHERE comment_id = ?`
+const stmtGetSpamCommentCounts = `SELECT spam, count(*) FROM spam_comments GROUP BY spam`
+
+// Unsubscribes
+const stmtGetUnsubscribeCount = `SELECT count(*) FROM unsubscribes WHERE email = ?`
+const stmtInsertUnsubscribe = `INSERT INTO unsubscribes (email, created_at) VALUES (?, ?)`
+const stmtDeleteUnsubscribe = `DELETE FROM unsubscribes WHERE email = ?`
+
+// Secrets
+const stmtGetSecret = `SELECT value FROM secrets WHERE name = ?`
+const stmtInsertSecret = `INSERT INTO secrets (name, value) VALUES (?, ?)`
+
+// Webmentions
+var outgoingWebmentionSelector = SQL.Select(`id, post_id, target, status, attempts, next_attempt_at, last_error`).From(`outgoing_webmentions`)
+var stmtGetOutgoingWebmentionsByPostId = outgoingWebmentionSelector.Copy().Where(`post_id = ?`).OrderBy(`id`).SQL()
+var stmtGetDueOutgoingWebmentions = outgoingWebmentionSelector.Copy().Where(`status = 'pending'`, `next_attempt_at <= ?`).OrderBy(`next_attempt_at`).Limit(`?`).SQL()
+
+const stmtInsertOutgoingWebmention = `INSERT INTO outgoing_webmentions (post_id, target, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+const stmtUpdateOutgoingWebmention = `UPDATE outgoing_webmentions SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`
 
 // Users
-const stmtGetUserById = `SELECT id, name, slug, email, image, cover, bio, website, location FROM users WHERE id = ?`
-const stmtGetUserBySlug = `SELECT id, name, slug, email, image, cover, bio, website, location FROM users WHERE slug = ?`
-const stmtGetUserByName = `SELECT id, name, slug, email, image, cover, bio, website, location FROM users WHERE name = ?`
-const stmtGetUserByEmail = `SELECT id, name, slug, email, image, cover, bio, website, location FROM users WHERE email = ?`
+const stmtGetUserById = `SELECT id, name, slug, email, image, cover, bio, website, location, status, COALESCE((SELECT role_id FROM roles_users WHERE user_id = users.id), 0) FROM users WHERE id = ?`
+const stmtGetUserBySlug = `SELECT id, name, slug, email, image, cover, bio, website, location, status, COALESCE((SELECT role_id FROM roles_users WHERE user_id = users.id), 0) FROM users WHERE slug = ?`
+const stmtGetUserByName = `SELECT id, name, slug, email, image, cover, bio, website, location, status, COALESCE((SELECT role_id FROM roles_users WHERE user_id = users.id), 0) FROM users WHERE name = ?`
+const stmtGetUserByEmail = `SELECT id, name, slug, email, image, cover, bio, website, location, status, COALESCE((SELECT role_id FROM roles_users WHERE user_id = users.id), 0) FROM users WHERE email = ?`
 const stmtGetHashedPasswordByEmail = `SELECT password FROM users WHERE email = ?`
 const stmtGetUsersCount = `SELECT count(*) FROM users`
 const stmtGetUsersCountByEmail = `SELECT count(*) FROM users where email = ?`
+const stmtGetAllUsers = `SELECT id, name, slug, email, image, cover, bio, website, location, status, COALESCE((SELECT role_id FROM roles_users WHERE user_id = users.id), 0) FROM users ORDER BY id`
+const stmtUpdateUserStatus = `UPDATE users SET status = ?, updated_at = ?, updated_by = ? WHERE id = ?`
+const stmtUpdatePostsAuthor = `UPDATE posts SET author_id = ? WHERE author_id = ?`
+const stmtDeleteUserById = `DELETE FROM users WHERE id = ?`
+const stmtDeleteRoleUserByUserId = `DELETE FROM roles_users WHERE user_id = ?`
+const stmtDeleteTokensByUserId = `DELETE FROM tokens WHERE user_id = ?`
+
+// Invitations
+var invitationSelector = SQL.Select(`id, token, email, role_id, created_at, created_by, expired_at, accepted_at`).From(`invitations`)
+var stmtGetInvitationById = invitationSelector.Copy().Where(`id = ?`).SQL()
+var stmtGetInvitationByToken = invitationSelector.Copy().Where(`token = ?`).SQL()
+var stmtGetPendingInvitations = invitationSelector.Copy().Where(`accepted_at IS NULL`, `expired_at > ?`).OrderBy(`created_at DESC`).SQL()
+
+const stmtInsertInvitation = `INSERT INTO invitations (token, email, role_id, created_at, created_by, expired_at, accepted_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+const stmtUpdateInvitationAccepted = `UPDATE invitations SET accepted_at = ? WHERE id = ? AND accepted_at IS NULL`
+const stmtDeleteInvitationById = `DELETE FROM invitations WHERE id = ?`
+
+// Password resets
+var stmtGetPasswordResetByToken = SQL.Select(`id, user_id, created_at, expired_at, used_at`).From(`password_resets`).Where(`token = ?`).SQL()
+
+const stmtInsertPasswordReset = `INSERT INTO password_resets (token, user_id, created_at, expired_at, used_at) VALUES (?, ?, ?, ?, ?)`
+const stmtUpdatePasswordResetUsed = `UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL`
+const stmtDeleteExpiredPasswordResets = `DELETE FROM password_resets WHERE expired_at <= ?`
+
+// Two-factor authentication
+const stmtGetTotpByUserId = `SELECT COALESCE(totp_secret, ''), COALESCE(totp_last_step, 0) FROM users WHERE id = ?`
+const stmtUpdateTotpSecret = `UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?`
+const stmtUpdateTotpLastStep = `UPDATE users SET totp_last_step = ? WHERE id = ? AND COALESCE(totp_last_step, 0) < ?`
+const stmtInsertRecoveryCode = `INSERT INTO recovery_codes (user_id, code) VALUES (?, ?)`
+const stmtDeleteRecoveryCode = `DELETE FROM recovery_codes WHERE user_id = ? AND code = ?`
+const stmtDeleteRecoveryCodesByUserId = `DELETE FROM recovery_codes WHERE user_id = ?`
+const stmtGetRecoveryCodesCount = `SELECT count(*) FROM recovery_codes WHERE user_id = ?`
+const stmtGetLoginChallengeByToken = `SELECT id, user_id, attempts, expired_at FROM login_challenges WHERE token = ?`
+const stmtInsertLoginChallenge = `INSERT INTO login_challenges (token, user_id, attempts, expired_at) VALUES (?, ?, ?, ?)`
+const stmtUpdateLoginChallengeAttempts = `UPDATE login_challenges SET attempts = attempts + 1 WHERE id = ?`
+const stmtDeleteLoginChallengeById = `DELETE FROM login_challenges WHERE id = ?`
+const stmtDeleteExpiredLoginChallenges = `DELETE FROM login_challenges WHERE expired_at <= ?`
+
+// API keys
+var apiKeySelector = SQL.Select(`id, prefix, name, user_id, created_at, last_used_at`).From(`api_keys`)
+var stmtGetApiKeyByHash = apiKeySelector.Copy().Where(`hash = ?`).SQL()
+var stmtGetApiKeysByUserId = apiKeySelector.Copy().Where(`user_id = ?`).OrderBy(`created_at DESC`).SQL()
+
+const stmtInsertApiKey = `INSERT INTO api_keys (hash, prefix, name, user_id, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?)`
+const stmtUpdateApiKeyLastUsed = `UPDATE api_keys SET last_used_at = ? WHERE id = ?`
+const stmtDeleteApiKeyById = `DELETE FROM api_keys WHERE id = ? AND user_id = ?`
+const stmtDeleteApiKeysByUserId = `DELETE FROM api_keys WHERE user_id = ?`
+
+// Migrations
+const stmtCreateSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (version integer NOT NULL PRIMARY KEY, name varchar(150) NOT NULL, applied_at datetime NOT NULL)`
+const stmtGetSchemaVersion = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+const stmtInsertSchemaMigration = `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`
+
+// Search
+// The index is a separate statement since FTS5 may not be compiled in.
+const stmtCreateSearchIndex = `CREATE VIRTUAL TABLE IF NOT EXISTS posts_search USING fts5(title, body, tags)`
+
+var searchCountSelector = SQL.Select(`count(*)`).From(`posts_search JOIN posts ON posts.id = posts_search.rowid`).Where(`posts_search MATCH ?`)
+var searchSelector = SQL.Select(`posts.id, highlight(posts_search, 0, char(2), char(3)), snippet(posts_search, 1, char(2), char(3), '...', 32)`).From(`posts_search JOIN posts ON posts.id = posts_search.rowid`).Where(`posts_search MATCH ?`)
+
+const stmtGetAllPostIds = `SELECT id FROM posts`
+const stmtGetSearchIndexCount = `SELECT count(*) FROM posts_search`
+const stmtInsertSearchIndex = `INSERT INTO posts_search (rowid, title, body, tags) VALUES (?, ?, ?, ?)`
+const stmtDeleteSearchIndex = `DELETE FROM posts_search WHERE rowid = ?`
+
+// Roles
+var roleUserUpsert = SQL.Insert(`roles_users`, `user_id, role_id`).OnConflict(`user_id`, `role_id`)
+
+const stmtGetRoleById = `SELECT id, name, description FROM roles WHERE id = ?`
+const stmtGetAllRoles = `SELECT id, name, description FROM roles ORDER BY id`
+const stmtGetRolesCount = `SELECT count(*) FROM roles`
+const stmtInsertRole = `INSERT INTO roles (id, uuid, name, description, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtInsertMissingRoleUsers = `INSERT INTO roles_users (role_id, user_id) SELECT ?, id FROM users WHERE id NOT IN (SELECT user_id FROM roles_users)`
 
 // Tokens
-const stmtGetTokenByValue = `SELECT value, user_id, created_at, expired_at FROM tokens WHERE value = ?`
-const stmtUpdateToken = `INSERT OR REPLACE INTO tokens (value, user_id, created_at, expired_at) VALUES (?, ?, ?, ?)`
+var tokenSelector = SQL.Select(`id, user_id, ip, user_agent, created_at, last_seen_at, expired_at`).From(`tokens`)
+var stmtGetTokenByHash = tokenSelector.Copy().Where(`hash = ?`).SQL()
+var stmtGetTokensByUserId = tokenSelector.Copy().Where(`user_id = ?`, `expired_at > ?`).OrderBy(`last_seen_at DESC`).SQL()
+
+const stmtInsertToken = `INSERT INTO tokens (hash, user_id, ip, user_agent, created_at, last_seen_at, expired_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+const stmtUpdateTokenLastSeen = `UPDATE tokens SET last_seen_at = ?, ip = ? WHERE id = ?`
+const stmtDeleteTokenById = `DELETE FROM tokens WHERE id = ? AND user_id = ?`
+const stmtDeleteOtherTokensByUserId = `DELETE FROM tokens WHERE user_id = ? AND id != ?`
+const stmtDeleteExpiredTokens = `DELETE FROM tokens WHERE expired_at <= ?`
 
 // Tags
 const stmtGetAllTags = `SELECT id, name, slug FROM tags`
@@ -224,28 +481,66 @@ const stmtGetTags = `SELECT tag_id FROM posts_tags WHERE post_id = ?`
 const stmtGetTagById = `SELECT id, name, slug FROM tags WHERE id = ?`
 const stmtGetTagBySlug = `SELECT id, name, slug, hidden FROM tags WHERE slug = ?`
 
+// Categories
+var categorySelector = SQL.Select(`id, uuid, name, slug, description, parent_id, meta_title, meta_description, created_at, created_by, updated_at, updated_by`).From(`categories`)
+var stmtGetAllCategories = categorySelector.Copy().OrderBy(`name`).SQL()
+var stmtGetCategoryById = categorySelector.Copy().Where(`id = ?`).SQL()
+var stmtGetCategoryBySlug = categorySelector.Copy().Where(`slug = ?`).SQL()
+var stmtGetCategoryByUUID = categorySelector.Copy().Where(`uuid = ?`).SQL()
+var stmtGetCategoriesByParentId = categorySelector.Copy().Where(`parent_id = ?`).OrderBy(`name`).SQL()
+var stmtGetCategoryByPostId = categorySelector.Copy().Where(`id = (SELECT category_id FROM posts_categories WHERE post_id = ?)`).SQL()
+
+var postsCategoriesSelector = SQL.Select(`posts.id, posts.uuid, posts.title, posts.slug, posts.markdown, posts.html, posts.featured, posts.page, posts.allow_comment, posts.comment_num, posts.status, posts.image, posts.author_id, posts.created_at, posts.created_by, posts.updated_at, posts.updated_by, posts.published_at, posts.published_by, posts.unpublish_at, posts.meta_title, posts.meta_description`).From(`posts, posts_categories`)
+
+const stmtInsertCategory = `INSERT INTO categories (uuid, name, slug, description, parent_id, meta_title, meta_description, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtUpdateCategory = `UPDATE categories SET name = ?, slug = ?, description = ?, parent_id = ?, meta_title = ?, meta_description = ?, updated_at = ?, updated_by = ? WHERE id = ?`
+const stmtUpdateCategoryParent = `UPDATE categories SET parent_id = ? WHERE parent_id = ?`
+const stmtDeleteCategoryById = `DELETE FROM categories WHERE id = ?`
+const stmtInsertPostCategory = `INSERT INTO posts_categories (post_id, category_id) VALUES (?, ?)`
+const stmtDeletePostCategoriesByPostId = `DELETE FROM posts_categories WHERE post_id = ?`
+const stmtDeletePostCategoriesByCategoryId = `DELETE FROM posts_categories WHERE category_id = ?`
+
+// Post revisions
+var postRevisionSelector = SQL.Select(`id, post_id, title, markdown, html, created_at, created_by`).From(`post_revisions`)
+var stmtGetPostRevisionById = postRevisionSelector.Copy().Where(`id = ?`).SQL()
+var stmtGetPostRevisionsByPostId = postRevisionSelector.Copy().Where(`post_id = ?`).OrderBy(`id DESC`).SQL()
+var stmtGetLatestPostRevision = postRevisionSelector.Copy().Where(`post_id = ?`).OrderBy(`id DESC`).Limit(`1`).SQL()
+
+const stmtInsertPostRevision = `INSERT INTO post_revisions (post_id, title, markdown, html, created_at, created_by) VALUES (?, ?, ?, ?, ?, ?)`
+const stmtDeletePostRevisionsByPostId = `DELETE FROM post_revisions WHERE post_id = ?`
+
 // Settings
-const stmtGetBlog = `SELECT value FROM settings WHERE key = ?`
+var settingSelector = SQL.Select(`id, uuid, "key", value, type, created_at, created_by`).From(`settings`)
+var stmtGetSettingByKey = settingSelector.Copy().Where(`"key" = ?`).SQL()
+var stmtGetSettingsByType = settingSelector.Copy().Where(`type = ?`).SQL()
+var stmtGetAllSettings = settingSelector.Copy().OrderBy(`"key"`).SQL()
+var settingUpsert = SQL.Insert(`settings`, `uuid, "key", value, type, created_at, created_by`).OnConflict(`"key"`, `value`, `type`)
+
+const stmtGetBlog = `SELECT value FROM settings WHERE "key" = ?`
 const stmtGetPostCreationDateById = `SELECT created_at FROM posts WHERE id = ?`
 
-const stmtInsertPost = `INSERT INTO posts (id, uuid, title, slug, markdown, html, featured, page, allow_comment, status, image, author_id, created_at, created_by, updated_at, updated_by, published_at, published_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-const stmtInsertUser = `INSERT INTO users (id, uuid, name, slug, password, email, image, cover, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-const stmtInsertRoleUser = `INSERT INTO roles_users (id, role_id, user_id) VALUES (?, ?, ?)`
-const stmtInsertTag = `INSERT INTO tags (id, uuid, name, slug, created_at, created_by, updated_at, updated_by, hidden) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-const stmtInsertPostTag = `INSERT INTO posts_tags (id, post_id, tag_id) VALUES (?, ?, ?)`
-const stmtInsertSetting = `INSERT INTO settings (id, uuid, key, value, type, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtInsertPost = `INSERT INTO posts (uuid, title, slug, markdown, html, featured, page, allow_comment, status, image, author_id, created_at, created_by, updated_at, updated_by, published_at, published_by, unpublish_at, meta_title, meta_description) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtInsertUser = `INSERT INTO users (uuid, name, slug, password, email, image, cover, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtInsertRoleUser = `INSERT INTO roles_users (role_id, user_id) VALUES (?, ?)`
+const stmtInsertTag = `INSERT INTO tags (uuid, name, slug, created_at, created_by, updated_at, updated_by, hidden) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+const stmtInsertPostTag = `INSERT INTO posts_tags (post_id, tag_id) VALUES (?, ?)`
+const stmtInsertSetting = `INSERT INTO settings (uuid, "key", value, type, created_at, created_by, updated_at, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-const stmtUpdatePost = `UPDATE posts SET title = ?, slug = ?, markdown = ?, html = ?, featured = ?, page = ?, allow_comment = ?, status = ?, image = ?, updated_at = ?, updated_by = ? WHERE id = ?`
-const stmtUpdatePostPublished = `UPDATE posts SET title = ?, slug = ?, markdown = ?, html = ?, featured = ?, page = ?, allow_comment = ?, status = ?, image = ?, updated_at = ?, updated_by = ?, published_at = ?, published_by = ? WHERE id = ?`
-const stmtUpdateSettings = `UPDATE settings SET value = ?, updated_at = ?, updated_by = ? WHERE key = ?`
+const stmtUpdatePost = `UPDATE posts SET title = ?, slug = ?, markdown = ?, html = ?, featured = ?, page = ?, allow_comment = ?, status = ?, image = ?, updated_at = ?, updated_by = ?, published_at = ?, published_by = ?, unpublish_at = ?, meta_title = ?, meta_description = ? WHERE id = ?`
+const stmtIncrementPostCommentNum = `UPDATE posts SET comment_num = comment_num + 1 WHERE id = ?`
+const stmtDecrementPostCommentNum = `UPDATE posts SET comment_num = comment_num - 1 WHERE id = ? AND comment_num > 0`
+const stmtUpdateSettings = `UPDATE settings SET value = ?, updated_at = ?, updated_by = ? WHERE "key" = ?`
 const stmtUpdateUser = `UPDATE users SET name = ?, slug = ?, email = ?, image = ?, cover = ?, bio = ?, website = ?, location = ?, updated_at = ?, updated_by = ? WHERE id = ?`
 const stmtUpdateLastLogin = `UPDATE users SET last_login = ? WHERE id = ?`
 const stmtUpdateUserPassword = `UPDATE users SET password = ?, updated_at = ?, updated_by = ? WHERE id = ?`
 const stmtUpdateTag = `UPDATE tags SET uuid = ?, name = ?, slug =?, updated_at = ?, updated_by = ?, hidden = ? WHERE id = ?`
 
+const stmtGetDueScheduledPostIds = `SELECT id FROM posts WHERE status = 'scheduled' AND published_at <= ?`
+const stmtGetExpiredPostIds = `SELECT id FROM posts WHERE status = 'published' AND unpublish_at IS NOT NULL AND unpublish_at <= ?`
+
 const stmtDeletePostTagsByPostId = `DELETE FROM posts_tags WHERE post_id = ?`
 const stmtDeletePostById = `DELETE FROM posts WHERE id = ?`
-const stmtDeleteOldTags = `DELETE FROM tags WHERE id IN (SELECT id FROM tags EXCEPT SELECT tag_id FROM posts_tags)`
+const stmtDeleteOldTags = `DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM posts_tags)`
 
 // Messages
 var messageCountSelector = SQL.Select(`count(*)`).From(`messages`)
@@ -256,5 +551,10 @@ var stmtGetAllMessages = messageSelector.Copy().OrderBy(`created_at DESC`).SQL()
 var stmtGetUnreadMessages = messageSelector.Copy().Where(`is_read = 0`).OrderBy(`created_at DESC`).Limit(`?`).Offset(`?`).SQL()
 var stmtGetAllMessagesByPage = messageSelector.Copy().OrderBy(`created_at DESC`).Limit(`?`).Offset(`?`).SQL()
 
-const stmtInsertMessage = `INSERT INTO messages (id, type, data, is_read, created_at) VALUES (?, ?, ?, ?, ?)`
+const stmtInsertMessage = `INSERT INTO messages (type, data, is_read, created_at) VALUES (?, ?, ?, ?)`
 const stmtReadMessage = `UPDATE messages SET is_read = 1 WHERE id = ?`
+
+// Sitemap
+const stmtGetSitemapPosts = `SELECT slug, updated_at FROM posts WHERE status = 'published' AND page = ? ORDER BY published_at DESC`
+const stmtGetSitemapTags = `SELECT tags.slug, posts.updated_at FROM tags, posts_tags, posts WHERE posts_tags.tag_id = tags.id AND posts_tags.post_id = posts.id AND posts.status = 'published' ORDER BY tags.slug`
+const stmtGetSitemapAuthors = `SELECT users.slug, posts.updated_at FROM users, posts WHERE posts.author_id = users.id AND users.slug != '' AND posts.status = 'published' AND posts.page = 0 ORDER BY users.slug`
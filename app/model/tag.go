@@ -82,12 +82,7 @@ func (t *Tag) Insert() error {
 		writeDB.Rollback()
 		return err
 	}
-	result, err := writeDB.Exec(stmtInsertTag, nil, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), t.Name, t.Slug, t.CreatedAt, t.CreatedBy, t.CreatedAt, t.CreatedBy, t.Hidden)
-	if err != nil {
-		writeDB.Rollback()
-		return err
-	}
-	t.Id, err = result.LastInsertId()
+	t.Id, err = writeDB.Insert(stmtInsertTag, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), t.Name, t.Slug, t.CreatedAt, t.CreatedBy, t.CreatedAt, t.CreatedBy, t.Hidden)
 	if err != nil {
 		writeDB.Rollback()
 		return err
@@ -1,26 +1,39 @@
 package model
 
 import (
-	"fmt"
+	"database/sql"
+	"time"
+
 	"github.com/dinever/dingo/app/utils"
 	"github.com/dinever/golf"
-	"time"
 )
 
+// Token is a login session. The value of the token is random and is only
+// known by the client, the database only stores its hash.
 type Token struct {
-	Value     string
-	UserId    int64
-	CreatedAt *time.Time
-	ExpiredAt *time.Time
+	Id         int64
+	Value      string
+	UserId     int64
+	Ip         string
+	UserAgent  string
+	CreatedAt  *time.Time
+	LastSeenAt *time.Time
+	ExpiredAt  *time.Time
 }
 
+// lastSeenInterval limits how often the last seen time of a token is written.
+const lastSeenInterval = time.Minute
+
 func NewToken(u *User, ctx *golf.Context, expire int64) *Token {
 	t := new(Token)
 	t.UserId = u.Id
+	t.Ip = ctx.ClientIP()
+	t.UserAgent = ctx.Request.UserAgent()
 	t.CreatedAt = utils.Now()
+	t.LastSeenAt = t.CreatedAt
 	expiredAt := t.CreatedAt.Add(time.Duration(expire) * time.Second)
 	t.ExpiredAt = &expiredAt
-	t.Value = utils.Sha1(fmt.Sprintf("%s-%s-%d-%d", ctx.ClientIP(), ctx.Request.UserAgent(), t.CreatedAt.Unix(), t.UserId))
+	t.Value = utils.RandomToken(20)
 	return t
 }
 
@@ -30,24 +43,74 @@ func (t *Token) Save() error {
 		writeDB.Rollback()
 		return err
 	}
-	_, err = writeDB.Exec(stmtUpdateToken, t.Value, t.UserId, t.CreatedAt, t.ExpiredAt)
+	t.Id, err = writeDB.Insert(stmtInsertToken, utils.Sha256(t.Value), t.UserId, t.Ip, t.UserAgent, t.CreatedAt, t.LastSeenAt, t.ExpiredAt)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// Touch records that the token has just been used from the given IP.
+func (t *Token) Touch(ip string) error {
+	now := utils.Now()
+	if t.LastSeenAt != nil && now.Sub(*t.LastSeenAt) < lastSeenInterval && t.Ip == ip {
+		return nil
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtUpdateTokenLastSeen, now, ip, t.Id)
 	if err != nil {
 		writeDB.Rollback()
 		return err
 	}
+	t.LastSeenAt = now
+	t.Ip = ip
 	return writeDB.Commit()
 }
 
+func scanToken(row Row, t *Token) error {
+	var (
+		nullIp        sql.NullString
+		nullUserAgent sql.NullString
+	)
+	err := row.Scan(&t.Id, &t.UserId, &nullIp, &nullUserAgent, &t.CreatedAt, &t.LastSeenAt, &t.ExpiredAt)
+	t.Ip = nullIp.String
+	t.UserAgent = nullUserAgent.String
+	return err
+}
+
 func GetTokenByValue(v string) (*Token, error) {
 	t := new(Token)
-	row := db.QueryRow(stmtGetTokenByValue, v)
-	err := row.Scan(&t.Value, &t.UserId, &t.CreatedAt, &t.ExpiredAt)
-	if err != nil {
+	row := db.QueryRow(stmtGetTokenByHash, utils.Sha256(v))
+	if err := scanToken(row, t); err != nil {
 		return nil, err
 	}
+	t.Value = v
 	return t, nil
 }
 
+// GetTokensByUserId returns the sessions of the user that have not expired.
+func GetTokensByUserId(userId int64) ([]*Token, error) {
+	rows, err := db.Query(stmtGetTokensByUserId, userId, utils.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]*Token, 0)
+	for rows.Next() {
+		t := new(Token)
+		if err := scanToken(rows, t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
 func (t *Token) IsValid() bool {
 	user, _ := GetUserById(t.UserId)
 	if user == nil {
@@ -55,3 +118,33 @@ func (t *Token) IsValid() bool {
 	}
 	return t.ExpiredAt.After(*utils.Now())
 }
+
+func deleteTokens(stmt string, args ...interface{}) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmt, args...)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// DeleteTokenById revokes a session of the user.
+func DeleteTokenById(id int64, userId int64) error {
+	return deleteTokens(stmtDeleteTokenById, id, userId)
+}
+
+// DeleteTokensByUserId revokes all the sessions of the user, except the
+// session of the id given in keepId.
+func DeleteTokensByUserId(userId int64, keepId int64) error {
+	return deleteTokens(stmtDeleteOtherTokensByUserId, userId, keepId)
+}
+
+// DeleteExpiredTokens removes the sessions that have expired.
+func DeleteExpiredTokens() error {
+	return deleteTokens(stmtDeleteExpiredTokens, utils.Now())
+}
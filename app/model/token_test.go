@@ -45,6 +45,55 @@ func TestToken(t *testing.T) {
 				So(valid, ShouldEqual, true)
 				So(err, ShouldBeNil)
 			})
+
+			Convey("Token value is not stored", func() {
+				So(token.Value, ShouldHaveLength, 40)
+				var count int
+				db.QueryRow("SELECT count(*) FROM tokens WHERE hash = ?", token.Value).Scan(&count)
+				So(count, ShouldEqual, 0)
+			})
+
+			Convey("Multiple sessions", func() {
+				other := NewToken(user, ctx, 100)
+				So(other.Save(), ShouldBeNil)
+				So(other.Value, ShouldNotEqual, token.Value)
+
+				tokens, err := GetTokensByUserId(user.Id)
+				So(err, ShouldBeNil)
+				So(tokens, ShouldHaveLength, 2)
+
+				Convey("Revoke a session", func() {
+					So(DeleteTokenById(other.Id, user.Id), ShouldBeNil)
+					_, err := GetTokenByValue(other.Value)
+					So(err, ShouldNotBeNil)
+					_, err = GetTokenByValue(token.Value)
+					So(err, ShouldBeNil)
+				})
+
+				Convey("A session can only be revoked by its user", func() {
+					So(DeleteTokenById(other.Id, user.Id+1), ShouldBeNil)
+					_, err := GetTokenByValue(other.Value)
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Revoke the other sessions", func() {
+					So(DeleteTokensByUserId(user.Id, token.Id), ShouldBeNil)
+					tokens, _ := GetTokensByUserId(user.Id)
+					So(tokens, ShouldHaveLength, 1)
+					So(tokens[0].Id, ShouldEqual, token.Id)
+				})
+			})
+
+			Convey("Delete expired tokens", func() {
+				expired := NewToken(user, ctx, -100)
+				So(expired.Save(), ShouldBeNil)
+				So(expired.IsValid(), ShouldBeFalse)
+				So(DeleteExpiredTokens(), ShouldBeNil)
+				_, err := GetTokenByValue(expired.Value)
+				So(err, ShouldNotBeNil)
+				_, err = GetTokenByValue(token.Value)
+				So(err, ShouldBeNil)
+			})
 		})
 		Reset(func() {
 			os.Remove("test.db")
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+const (
+	// RecoveryCodeCount is the number of recovery codes given when two-factor
+	// authentication is enabled.
+	RecoveryCodeCount = 10
+	// LoginChallengeExpiration is how long a user has to enter the code after
+	// entering the password.
+	LoginChallengeExpiration = 5 * time.Minute
+	// LoginChallengeAttempts is how many wrong codes can be entered before the
+	// user has to enter the password again.
+	LoginChallengeAttempts = 5
+)
+
+// TwoFactorRequired reports whether all users must enable two-factor
+// authentication.
+func TwoFactorRequired() bool {
+	return GetSettingValue("require_2fa") == "true"
+}
+
+func (u *User) totp() (secret string, lastStep int64, err error) {
+	err = db.QueryRow(stmtGetTotpByUserId, u.Id).Scan(&secret, &lastStep)
+	return secret, lastStep, err
+}
+
+func (u *User) TwoFactorEnabled() bool {
+	secret, _, err := u.totp()
+	return err == nil && secret != ""
+}
+
+// EnableTwoFactor saves the TOTP secret of the user once the user proved to
+// have it by giving a valid code. It returns new recovery codes, which are
+// only stored hashed and can not be shown again.
+func (u *User) EnableTwoFactor(secret, code string) ([]string, error) {
+	if _, ok := utils.ValidateTOTP(secret, code, *utils.Now()); !ok {
+		return nil, fmt.Errorf("Invalid code")
+	}
+	codes := make([]string, RecoveryCodeCount)
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return nil, err
+	}
+	if _, err = writeDB.Exec(stmtUpdateTotpSecret, secret, u.Id); err != nil {
+		writeDB.Rollback()
+		return nil, err
+	}
+	if _, err = writeDB.Exec(stmtDeleteRecoveryCodesByUserId, u.Id); err != nil {
+		writeDB.Rollback()
+		return nil, err
+	}
+	for i := range codes {
+		codes[i] = utils.RandomToken(5)
+		if _, err = writeDB.Exec(stmtInsertRecoveryCode, u.Id, utils.Sha256(codes[i])); err != nil {
+			writeDB.Rollback()
+			return nil, err
+		}
+	}
+	return codes, writeDB.Commit()
+}
+
+func (u *User) DisableTwoFactor() error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtUpdateTotpSecret, nil, u.Id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtDeleteRecoveryCodesByUserId, u.Id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes.
+func (u *User) RecoveryCodesLeft() int {
+	var count int
+	db.QueryRow(stmtGetRecoveryCodesCount, u.Id).Scan(&count)
+	return count
+}
+
+// CheckTwoFactor checks a TOTP code or a recovery code. A TOTP code can not be
+// used twice, and a recovery code is deleted once used.
+func (u *User) CheckTwoFactor(code string) bool {
+	secret, lastStep, err := u.totp()
+	if err != nil || secret == "" {
+		return false
+	}
+	if step, ok := utils.ValidateTOTP(secret, code, *utils.Now()); ok && step > lastStep {
+		result, err := db.Exec(stmtUpdateTotpLastStep, step, u.Id, step)
+		if err != nil {
+			return false
+		}
+		n, _ := result.RowsAffected()
+		return n == 1
+	}
+	result, err := db.Exec(stmtDeleteRecoveryCode, u.Id, utils.Sha256(code))
+	if err != nil {
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n == 1
+}
+
+// LoginChallenge is the second step of the login of users with two-factor
+// authentication. It is given once the password has been checked.
+type LoginChallenge struct {
+	Id        int64
+	Token     string
+	UserId    int64
+	Attempts  int
+	ExpiredAt *time.Time
+}
+
+func NewLoginChallenge(u *User) *LoginChallenge {
+	expiredAt := utils.Now().Add(LoginChallengeExpiration)
+	return &LoginChallenge{
+		Token:     utils.RandomToken(20),
+		UserId:    u.Id,
+		ExpiredAt: &expiredAt,
+	}
+}
+
+func (c *LoginChallenge) Save() error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	c.Id, err = writeDB.Insert(stmtInsertLoginChallenge, utils.Sha256(c.Token), c.UserId, c.Attempts, c.ExpiredAt)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// Verify checks the code given for the challenge. The challenge is deleted
+// once it succeeded or after too many attempts.
+func (c *LoginChallenge) Verify(code string) (*User, error) {
+	user, err := GetUserById(c.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user.CheckTwoFactor(code) {
+		return user, deleteLoginChallenge(stmtDeleteLoginChallengeById, c.Id)
+	}
+	c.Attempts++
+	if c.Attempts >= LoginChallengeAttempts {
+		deleteLoginChallenge(stmtDeleteLoginChallengeById, c.Id)
+		return nil, fmt.Errorf("Too many attempts, please log in again")
+	}
+	if _, err := db.Exec(stmtUpdateLoginChallengeAttempts, c.Id); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("Invalid code")
+}
+
+// GetLoginChallengeByToken finds the challenge of a token, it returns an error
+// if the challenge has expired.
+func GetLoginChallengeByToken(token string) (*LoginChallenge, error) {
+	c := new(LoginChallenge)
+	row := db.QueryRow(stmtGetLoginChallengeByToken, utils.Sha256(token))
+	if err := row.Scan(&c.Id, &c.UserId, &c.Attempts, &c.ExpiredAt); err != nil {
+		return nil, err
+	}
+	c.Token = token
+	if !c.ExpiredAt.After(*utils.Now()) {
+		return nil, fmt.Errorf("Login has expired, please log in again")
+	}
+	return c, nil
+}
+
+func deleteLoginChallenge(stmt string, args ...interface{}) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmt, args...); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// DeleteExpiredLoginChallenges removes the login challenges that have expired.
+func DeleteExpiredLoginChallenges() error {
+	return deleteLoginChallenge(stmtDeleteExpiredLoginChallenges, utils.Now())
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTwoFactor(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+
+		Convey("Test TOTP", func() {
+			// Test vector of RFC 6238, truncated to 6 digits.
+			secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+			code, err := utils.TOTPCode(secret, 1)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, "287082")
+
+			step, ok := utils.ValidateTOTP(secret, "287082", time.Unix(59, 0))
+			So(ok, ShouldBeTrue)
+			So(step, ShouldEqual, 1)
+			_, ok = utils.ValidateTOTP(secret, "287082", time.Unix(200, 0))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Test Two-Factor Authentication", func() {
+			user := mockUser()
+			So(user.Create(password), ShouldBeNil)
+			So(user.TwoFactorEnabled(), ShouldBeFalse)
+
+			secret := utils.NewTOTPSecret()
+			_, err := user.EnableTwoFactor(secret, "000000x")
+			So(err, ShouldNotBeNil)
+			So(user.TwoFactorEnabled(), ShouldBeFalse)
+
+			code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+			codes, err := user.EnableTwoFactor(secret, code)
+			So(err, ShouldBeNil)
+			So(codes, ShouldHaveLength, RecoveryCodeCount)
+			So(user.TwoFactorEnabled(), ShouldBeTrue)
+			So(user.RecoveryCodesLeft(), ShouldEqual, RecoveryCodeCount)
+
+			Convey("Check Code", func() {
+				So(user.CheckTwoFactor(code), ShouldBeTrue)
+				// A code can not be replayed
+				So(user.CheckTwoFactor(code), ShouldBeFalse)
+			})
+
+			Convey("Check Recovery Code", func() {
+				So(user.CheckTwoFactor(codes[0]), ShouldBeTrue)
+				So(user.CheckTwoFactor(codes[0]), ShouldBeFalse)
+				So(user.RecoveryCodesLeft(), ShouldEqual, RecoveryCodeCount-1)
+			})
+
+			Convey("Verify Login Challenge", func() {
+				c := NewLoginChallenge(user)
+				So(c.Save(), ShouldBeNil)
+
+				c, err := GetLoginChallengeByToken(c.Token)
+				So(err, ShouldBeNil)
+				So(c.UserId, ShouldEqual, user.Id)
+
+				u, err := c.Verify(codes[1])
+				So(err, ShouldBeNil)
+				So(u.Id, ShouldEqual, user.Id)
+				_, err = GetLoginChallengeByToken(c.Token)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Limit Login Challenge Attempts", func() {
+				c := NewLoginChallenge(user)
+				So(c.Save(), ShouldBeNil)
+				for i := 0; i < LoginChallengeAttempts; i++ {
+					_, err := c.Verify("wrong")
+					So(err, ShouldNotBeNil)
+				}
+				_, err := GetLoginChallengeByToken(c.Token)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Disable Two-Factor Authentication", func() {
+				So(user.DisableTwoFactor(), ShouldBeNil)
+				So(user.TwoFactorEnabled(), ShouldBeFalse)
+				So(user.RecoveryCodesLeft(), ShouldEqual, 0)
+				So(user.CheckTwoFactor(codes[2]), ShouldBeFalse)
+			})
+		})
+
+		Reset(func() {
+			os.Remove("test.db")
+		})
+	})
+}
@@ -20,14 +20,21 @@ type User struct {
 	Website  string // NULL
 	Location string // NULL
 	Role     int    //1 = Administrator, 2 = Editor, 3 = Author, 4 = Owner
+	Status   string // active or suspended
 }
 
+const (
+	UserActive    = "active"
+	UserSuspended = "suspended"
+)
+
 var ghostUser = &User{Id: 0, Name: "Dingo User", Email: "example@example.com"}
 
 func NewUser(email, name string) *User {
 	user := new(User)
 	user.Email = email
 	user.Name = name
+	user.Status = UserActive
 	return user
 }
 
@@ -40,16 +47,39 @@ func (u *User) Create(password string) error {
 }
 
 func (u *User) Save(hashedPassword string, createdBy int64) error {
+	// The slug names the author page
+	if u.Slug == "" {
+		u.Slug = GenerateSlug(u.Name, "users")
+	}
 	id, err := InsertUser(u.Name, u.Slug, hashedPassword, u.Email, u.Image, u.Cover, time.Now(), createdBy)
 	if err != nil {
 		return err
 	}
 	u.Id = id
-	//	err = InsertRoleUser(u.Role, userId)
-	//	if err != nil {
-	//		return err
-	//	}
-	return nil
+	if u.Role == 0 {
+		// The first user owns the blog
+		u.Role = RoleAuthor
+		if count, err := GetNumberOfUsers(); err == nil && count == 1 {
+			u.Role = RoleOwner
+		}
+	}
+	return InsertRoleUser(u.Role, u.Id)
+}
+
+// SetRole changes the role of the user.
+func (u *User) SetRole(role int) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(roleUserUpsert.SQL(db.dialect), u.Id, role)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	u.Role = role
+	return writeDB.Commit()
 }
 
 func (u *User) Update() error {
@@ -104,7 +134,53 @@ func (u *User) CheckPassword(password string) bool {
 	return true
 }
 
-func scanUser(user *User, row *sql.Row) error {
+func (u *User) IsActive() bool {
+	return u.Status != UserSuspended
+}
+
+// SetStatus activates or suspends the user. Suspended users can not log in.
+func (u *User) SetStatus(status string, updatedBy int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	_, err = writeDB.Exec(stmtUpdateUserStatus, status, time.Now(), updatedBy, u.Id)
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if status == UserSuspended {
+		if _, err = writeDB.Exec(stmtDeleteTokensByUserId, u.Id); err != nil {
+			writeDB.Rollback()
+			return err
+		}
+	}
+	u.Status = status
+	return writeDB.Commit()
+}
+
+// DeleteUserById removes a user, the posts of the user are given to heir.
+func DeleteUserById(id int64, heir int64) error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	for _, stmt := range []string{stmtDeleteUserById, stmtDeleteRoleUserByUserId, stmtDeleteTokensByUserId, stmtDeleteApiKeysByUserId} {
+		if _, err = writeDB.Exec(stmt, id); err != nil {
+			writeDB.Rollback()
+			return err
+		}
+	}
+	if _, err = writeDB.Exec(stmtUpdatePostsAuthor, heir, id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+func scanUser(user *User, row Row) error {
 	var (
 		nullImage    sql.NullString
 		nullCover    sql.NullString
@@ -112,7 +188,7 @@ func scanUser(user *User, row *sql.Row) error {
 		nullWebsite  sql.NullString
 		nullLocation sql.NullString
 	)
-	err := row.Scan(&user.Id, &user.Name, &user.Slug, &user.Email, &nullImage, &nullCover, &nullBio, &nullWebsite, &nullLocation)
+	err := row.Scan(&user.Id, &user.Name, &user.Slug, &user.Email, &nullImage, &nullCover, &nullBio, &nullWebsite, &nullLocation, &user.Status, &user.Role)
 	user.Avatar = utils.Gravatar(user.Email, "150")
 	user.Image = nullImage.String
 	user.Cover = nullCover.String
@@ -176,18 +252,30 @@ func GetUserByEmail(email string) (*User, error) {
 	return user, nil
 }
 
-func InsertUser(name string, slug string, password string, email string, image string, cover string, created_at time.Time, created_by int64) (int64, error) {
-	writeDB, err := db.Begin()
+func GetAllUsers() ([]*User, error) {
+	rows, err := db.Query(stmtGetAllUsers)
 	if err != nil {
-		writeDB.Rollback()
-		return 0, err
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := new(User)
+		if err := scanUser(user, rows); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
 	}
-	result, err := writeDB.Exec(stmtInsertUser, nil, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), name, slug, password, email, image, cover, created_at, created_by, created_at, created_by)
+	return users, nil
+}
+
+func InsertUser(name string, slug string, password string, email string, image string, cover string, created_at time.Time, created_by int64) (int64, error) {
+	writeDB, err := db.Begin()
 	if err != nil {
 		writeDB.Rollback()
 		return 0, err
 	}
-	userId, err := result.LastInsertId()
+	userId, err := writeDB.Insert(stmtInsertUser, uuid.Formatter(uuid.NewV4(), uuid.CleanHyphen), name, slug, password, email, image, cover, created_at, created_by, created_at, created_by)
 	if err != nil {
 		writeDB.Rollback()
 		return 0, err
@@ -201,7 +289,7 @@ func InsertRoleUser(role_id int, user_id int64) error {
 		writeDB.Rollback()
 		return err
 	}
-	_, err = writeDB.Exec(stmtInsertRoleUser, nil, role_id, user_id)
+	_, err = writeDB.Exec(stmtInsertRoleUser, role_id, user_id)
 	if err != nil {
 		writeDB.Rollback()
 		return err
@@ -91,6 +91,33 @@ func TestUser(t *testing.T) {
 				userEqualCheck(u, user)
 			})
 
+			Convey("Suspend User", func() {
+				So(user.IsActive(), ShouldBeTrue)
+				err := user.SetStatus(UserSuspended, user.Id)
+				So(err, ShouldBeNil)
+				u, _ := GetUserById(user.Id)
+				So(u.Status, ShouldEqual, UserSuspended)
+				So(u.IsActive(), ShouldBeFalse)
+			})
+
+			Convey("Delete User", func() {
+				other := NewUser("author@example.com", "Author")
+				So(other.Create(password), ShouldBeNil)
+				p := mockPost()
+				p.CreatedBy = other.Id
+				So(p.Save(), ShouldBeNil)
+
+				err := DeleteUserById(other.Id, user.Id)
+				So(err, ShouldBeNil)
+				_, err = GetUserById(other.Id)
+				So(err, ShouldNotBeNil)
+				users, _ := GetAllUsers()
+				So(users, ShouldHaveLength, 1)
+
+				post, _ := GetPostById(p.Id)
+				So(post.Author.Id, ShouldEqual, user.Id)
+			})
+
 		})
 		Reset(func() {
 			os.Remove("test.db")
@@ -0,0 +1,434 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+)
+
+// CommentWebmention is the type of the comments which are webmentions
+// received from other sites.
+const CommentWebmention = "webmention"
+
+// The states of a webmention to send.
+const (
+	WebmentionPending    = "pending"
+	WebmentionSent       = "sent"
+	WebmentionNoEndpoint = "no_endpoint"
+	WebmentionFailed     = "failed"
+)
+
+const (
+	// WebmentionMaxAttempts is how many times a webmention is sent before
+	// giving up. The delay before the next attempt starts at
+	// webmentionRetryDelay and doubles after each failure.
+	WebmentionMaxAttempts = 5
+	webmentionRetryDelay  = 5 * time.Minute
+	// webmentionBatchSize is the number of webmentions sent in one run.
+	webmentionBatchSize = 50
+	// webmentionMaxBody is the most read of a page.
+	webmentionMaxBody = 1 << 20
+)
+
+var (
+	ErrWebmentionNoLink = errors.New("The source does not link to the target.")
+	errPrivateAddress   = errors.New("Webmentions can not reach private addresses.")
+)
+
+// WebmentionClient fetches the sources of the received webmentions, and
+// discovers and notifies the endpoints of the sent ones. It does not reach
+// private addresses, so that webmentions can not be used to probe the
+// network of the server. Tests replace it to reach a local server.
+var WebmentionClient = newWebmentionClient()
+
+func newWebmentionClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// webmentionRequest sends a request with the client, and reads at most
+// webmentionMaxBody bytes of the response.
+func webmentionRequest(method, u string, form url.Values) (*http.Response, string, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "Dingo Webmention")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := WebmentionClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, webmentionMaxBody))
+	return resp, string(b), err
+}
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?is)<(a|link|meta)\s[^>]*>`)
+	htmlAttrPattern   = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	htmlTitlePattern  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	pAuthorPattern    = regexp.MustCompile(`(?is)<[a-z]+\s[^>]*class\s*=\s*["'][^"']*\bp-author\b[^"']*["'][^>]*>\s*([^<]+?)\s*<`)
+	linkHeaderPattern = regexp.MustCompile(`<([^>]*)>([^<]*)`)
+	linkRelPattern    = regexp.MustCompile(`(?i)\brel\s*=\s*"?([^";]*)`)
+)
+
+// htmlTag is an a, link or meta tag, with its attributes.
+type htmlTag struct {
+	Name  string
+	Attrs map[string]string
+}
+
+func htmlTags(body string) []*htmlTag {
+	tags := make([]*htmlTag, 0)
+	for _, m := range htmlTagPattern.FindAllStringSubmatch(body, -1) {
+		t := &htmlTag{Name: strings.ToLower(m[1]), Attrs: make(map[string]string)}
+		for _, a := range htmlAttrPattern.FindAllStringSubmatch(m[0][len(m[1])+1:], -1) {
+			name := strings.ToLower(a[1])
+			if _, ok := t.Attrs[name]; !ok {
+				t.Attrs[name] = html.UnescapeString(strings.Trim(a[2], `"'`))
+			}
+		}
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+func hasRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == "webmention" {
+			return true
+		}
+	}
+	return false
+}
+
+// sameURL compares two URLs without their fragments, with or without a
+// trailing slash.
+func sameURL(a, b string) bool {
+	a = strings.TrimSuffix(strings.SplitN(a, "#", 2)[0], "/")
+	b = strings.TrimSuffix(strings.SplitN(b, "#", 2)[0], "/")
+	return a == b
+}
+
+// FetchWebmention fetches the source of a webmention and checks that it
+// links to target. It returns the mention as a new comment, written by the
+// author of the source and holding its title. A source which is gone or does
+// not link to target gives ErrWebmentionNoLink.
+func FetchWebmention(source, target string) (*Comment, error) {
+	resp, body, err := webmentionRequest("GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, ErrWebmentionNoLink
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("The source answered %s.", resp.Status)
+	}
+	base := resp.Request.URL
+	linked := false
+	author := ""
+	for _, t := range htmlTags(body) {
+		if href, ok := t.Attrs["href"]; ok && t.Name == "a" {
+			if u, err := base.Parse(href); err == nil && sameURL(u.String(), target) {
+				linked = true
+			}
+		}
+		if t.Name == "meta" && strings.EqualFold(t.Attrs["name"], "author") && author == "" {
+			author = t.Attrs["content"]
+		}
+	}
+	if !linked {
+		return nil, ErrWebmentionNoLink
+	}
+	if m := pAuthorPattern.FindStringSubmatch(body); m != nil {
+		author = html.UnescapeString(m[1])
+	}
+	if author = strings.TrimSpace(author); author == "" {
+		author = base.Host
+	}
+	title := source
+	if m := htmlTitlePattern.FindStringSubmatch(body); m != nil && strings.TrimSpace(m[1]) != "" {
+		title = strings.TrimSpace(html.UnescapeString(utils.Html2Str(m[1])))
+	}
+	c := NewComment()
+	c.Type = CommentWebmention
+	c.Author = author
+	c.Website = source
+	c.Content = template.HTMLEscapeString(title)
+	return c, nil
+}
+
+// GetWebmention returns the webmention of a post received from source, or
+// nil if there is none.
+func GetWebmention(postId int64, source string) (*Comment, error) {
+	comment := new(Comment)
+	err := scanComment(db.QueryRow(stmtGetWebmentionBySource, postId, source), comment)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// DiscoverWebmentionEndpoint returns the webmention endpoint of target, or
+// an empty string if it has none. The endpoint is given by a Link header, or
+// else by the first link or a tag of the page with the webmention relation.
+func DiscoverWebmentionEndpoint(target string) (string, error) {
+	resp, body, err := webmentionRequest("GET", target, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 == 5 {
+		return "", fmt.Errorf("The target answered %s.", resp.Status)
+	}
+	base := resp.Request.URL
+	endpoint, found := "", false
+	for _, h := range resp.Header["Link"] {
+		for _, m := range linkHeaderPattern.FindAllStringSubmatch(h, -1) {
+			if rel := linkRelPattern.FindStringSubmatch(m[2]); rel != nil && hasRel(rel[1]) {
+				endpoint, found = m[1], true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found && strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		for _, t := range htmlTags(body) {
+			href, ok := t.Attrs["href"]
+			if ok && t.Name != "meta" && hasRel(t.Attrs["rel"]) {
+				endpoint, found = href, true
+				break
+			}
+		}
+	}
+	if !found {
+		return "", nil
+	}
+	u, err := base.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// OutgoingWebmention is a webmention to send for a link of a post.
+type OutgoingWebmention struct {
+	Id            int64
+	PostId        int64
+	Target        string
+	Status        string
+	Attempts      int
+	NextAttemptAt *time.Time
+	LastError     string
+}
+
+// outboundLinks returns the distinct http and https links of a post to
+// other sites.
+func outboundLinks(p *Post, site string) []string {
+	links := make([]string, 0)
+	seen := make(map[string]bool)
+	siteHost := ""
+	if u, err := url.Parse(site); err == nil {
+		siteHost = strings.ToLower(u.Host)
+	}
+	for _, t := range htmlTags(p.Html) {
+		if t.Name != "a" {
+			continue
+		}
+		u, err := url.Parse(strings.TrimSpace(t.Attrs["href"]))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ToLower(u.Host) == siteHost {
+			continue
+		}
+		u.Fragment = ""
+		if link := u.String(); !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// queueWebmentions queues a webmention for each link of a published post
+// which was not queued yet, so that saving the post again does not send
+// them again. Nothing is sent until the site_url setting is set, since the
+// receivers must be able to fetch the post.
+func queueWebmentions(p *Post) error {
+	site := strings.TrimRight(GetSettingValue("site_url"), "/")
+	if p.skipWebmentions || !p.IsPublished || site == "" {
+		return nil
+	}
+	links := outboundLinks(p, site)
+	if len(links) == 0 {
+		return nil
+	}
+	queued, err := GetOutgoingWebmentions(p.Id)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, w := range queued {
+		seen[w.Target] = true
+	}
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	now := utils.Now()
+	for _, link := range links {
+		if seen[link] {
+			continue
+		}
+		if _, err = writeDB.Exec(stmtInsertOutgoingWebmention, p.Id, link, WebmentionPending, 0, now, now); err != nil {
+			writeDB.Rollback()
+			return err
+		}
+	}
+	return writeDB.Commit()
+}
+
+// GetOutgoingWebmentions returns the webmentions queued for the links of a
+// post.
+func GetOutgoingWebmentions(postId int64) ([]*OutgoingWebmention, error) {
+	rows, err := db.Query(stmtGetOutgoingWebmentionsByPostId, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return extractOutgoingWebmentions(rows)
+}
+
+func extractOutgoingWebmentions(rows *sql.Rows) ([]*OutgoingWebmention, error) {
+	list := make([]*OutgoingWebmention, 0)
+	for rows.Next() {
+		w := new(OutgoingWebmention)
+		var lastError sql.NullString
+		if err := rows.Scan(&w.Id, &w.PostId, &w.Target, &w.Status, &w.Attempts, &w.NextAttemptAt, &lastError); err != nil {
+			return nil, err
+		}
+		w.LastError = lastError.String
+		list = append(list, w)
+	}
+	return list, rows.Err()
+}
+
+func (w *OutgoingWebmention) save() error {
+	writeDB, err := db.Begin()
+	if err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	if _, err = writeDB.Exec(stmtUpdateOutgoingWebmention, w.Status, w.Attempts, w.NextAttemptAt, w.LastError, w.Id); err != nil {
+		writeDB.Rollback()
+		return err
+	}
+	return writeDB.Commit()
+}
+
+// send discovers the endpoint of the target and notifies it that source
+// links to it. It returns the new status of the webmention.
+func (w *OutgoingWebmention) send(source string) (string, error) {
+	endpoint, err := DiscoverWebmentionEndpoint(w.Target)
+	if err != nil {
+		return "", err
+	}
+	if endpoint == "" {
+		return WebmentionNoEndpoint, nil
+	}
+	resp, _, err := webmentionRequest("POST", endpoint, url.Values{"source": {source}, "target": {w.Target}})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("The endpoint answered %s.", resp.Status)
+	}
+	return WebmentionSent, nil
+}
+
+// SendWebmentions sends the queued webmentions which are due. A webmention
+// which fails is tried again later, until WebmentionMaxAttempts. It is run
+// periodically by the background scheduler.
+func SendWebmentions() error {
+	site := strings.TrimRight(GetSettingValue("site_url"), "/")
+	if site == "" {
+		return nil
+	}
+	rows, err := db.Query(stmtGetDueOutgoingWebmentions, utils.Now(), webmentionBatchSize)
+	if err != nil {
+		return err
+	}
+	due, err := extractOutgoingWebmentions(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, w := range due {
+		var status string
+		post, err := GetPostById(w.PostId)
+		if err == nil && !post.IsPublished {
+			err = errors.New("The post is not published.")
+		}
+		if err == nil {
+			status, err = w.send(site + post.Url() + "/")
+		}
+		w.Attempts++
+		if err != nil {
+			w.LastError = err.Error()
+			status = WebmentionPending
+			if w.Attempts >= WebmentionMaxAttempts {
+				status = WebmentionFailed
+			}
+			next := time.Now().Add(webmentionRetryDelay << uint(w.Attempts-1))
+			w.NextAttemptAt = &next
+		} else {
+			w.LastError = ""
+		}
+		w.Status = status
+		if err := w.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebmention(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		received := make([]string, 0)
+		failures := 0
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<html><head><title>Gophers &amp; Dingos</title></head><body>
+<a class="p-author h-card" href="/about">  Rob Pike </a>
+<a href='http://blog.example.com/hello-world/#comments'>a post</a></body></html>`)
+		})
+		mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGone)
+		})
+		mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Link", `<http://other.example.com/>; rel="alternate", </endpoint?from=header>; rel="somethingelse webmention"`)
+		})
+		mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="/nothing">x</a><link rel="webmention" href="endpoint?from=html">`)
+		})
+		mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "no endpoint")
+		})
+		mux.HandleFunc("/endpoint", func(w http.ResponseWriter, r *http.Request) {
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			received = append(received, r.FormValue("source")+" "+r.FormValue("target"))
+			w.WriteHeader(http.StatusAccepted)
+		})
+		client := WebmentionClient
+		WebmentionClient = &http.Client{}
+
+		Convey("Fetch a received webmention", func() {
+			c, err := FetchWebmention(server.URL+"/source", "http://blog.example.com/hello-world")
+			So(err, ShouldBeNil)
+			So(c.Type, ShouldEqual, CommentWebmention)
+			So(c.Author, ShouldEqual, "Rob Pike")
+			So(c.Website, ShouldEqual, server.URL+"/source")
+			So(c.Content, ShouldEqual, "Gophers &amp; Dingos")
+
+			_, err = FetchWebmention(server.URL+"/source", "http://blog.example.com/other/")
+			So(err, ShouldEqual, ErrWebmentionNoLink)
+			_, err = FetchWebmention(server.URL+"/gone", "http://blog.example.com/hello-world/")
+			So(err, ShouldEqual, ErrWebmentionNoLink)
+		})
+
+		Convey("Discover the webmention endpoints", func() {
+			endpoint, err := DiscoverWebmentionEndpoint(server.URL + "/header")
+			So(err, ShouldBeNil)
+			So(endpoint, ShouldEqual, server.URL+"/endpoint?from=header")
+			endpoint, err = DiscoverWebmentionEndpoint(server.URL + "/html")
+			So(err, ShouldBeNil)
+			So(endpoint, ShouldEqual, server.URL+"/endpoint?from=html")
+			endpoint, err = DiscoverWebmentionEndpoint(server.URL + "/plain")
+			So(err, ShouldBeNil)
+			So(endpoint, ShouldBeEmpty)
+		})
+
+		Convey("Do not reach private addresses by default", func() {
+			_, err := newWebmentionClient().Get(server.URL + "/plain")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, errPrivateAddress.Error())
+		})
+
+		Convey("Send the webmentions of a published post", func() {
+			So(NewSetting("site_url", "http://blog.example.com/", "blog").Save(), ShouldBeNil)
+			p := NewPost()
+			p.Title = "Links"
+			p.Slug = "links"
+			p.Html = `<a href="` + server.URL + `/header#top">a</a> <a href="` + server.URL + `/html">b</a>
+<a href="` + server.URL + `/header">again</a> <a href="http://blog.example.com/other/">self</a> <a href="/relative/">relative</a>`
+			So(p.Save(), ShouldBeNil)
+			queued, err := GetOutgoingWebmentions(p.Id)
+			So(err, ShouldBeNil)
+			So(queued, ShouldBeEmpty)
+
+			p.IsPublished = true
+			So(p.Save(), ShouldBeNil)
+			So(p.Save(), ShouldBeNil)
+			queued, _ = GetOutgoingWebmentions(p.Id)
+			So(queued, ShouldHaveLength, 2)
+			So(queued[0].Target, ShouldEqual, server.URL+"/header")
+			So(queued[0].Status, ShouldEqual, WebmentionPending)
+
+			failures = 1
+			So(SendWebmentions(), ShouldBeNil)
+			So(received, ShouldResemble, []string{"http://blog.example.com/links/ " + server.URL + "/html"})
+			queued, _ = GetOutgoingWebmentions(p.Id)
+			So(queued[0].Status, ShouldEqual, WebmentionPending)
+			So(queued[0].Attempts, ShouldEqual, 1)
+			So(queued[0].LastError, ShouldContainSubstring, "500")
+			So(queued[0].NextAttemptAt.After(time.Now()), ShouldBeTrue)
+			So(queued[1].Status, ShouldEqual, WebmentionSent)
+
+			Convey("Retry the failed webmentions", func() {
+				// Nothing is due yet
+				So(SendWebmentions(), ShouldBeNil)
+				So(received, ShouldHaveLength, 1)
+
+				_, err := db.Exec(`UPDATE outgoing_webmentions SET next_attempt_at = ?`, time.Now().Add(-time.Minute))
+				So(err, ShouldBeNil)
+				So(SendWebmentions(), ShouldBeNil)
+				So(received, ShouldHaveLength, 2)
+				queued, _ := GetOutgoingWebmentions(p.Id)
+				So(queued[0].Status, ShouldEqual, WebmentionSent)
+				So(queued[0].Attempts, ShouldEqual, 2)
+			})
+
+			Convey("Give up after too many attempts", func() {
+				failures = WebmentionMaxAttempts
+				for i := 1; i < WebmentionMaxAttempts; i++ {
+					_, err := db.Exec(`UPDATE outgoing_webmentions SET next_attempt_at = ?`, time.Now().Add(-time.Minute))
+					So(err, ShouldBeNil)
+					So(SendWebmentions(), ShouldBeNil)
+				}
+				queued, _ := GetOutgoingWebmentions(p.Id)
+				So(queued[0].Status, ShouldEqual, WebmentionFailed)
+				So(queued[0].Attempts, ShouldEqual, WebmentionMaxAttempts)
+				So(received, ShouldHaveLength, 1)
+			})
+		})
+
+		Reset(func() {
+			WebmentionClient = client
+			server.Close()
+			os.Remove("test.db")
+		})
+	})
+}
@@ -0,0 +1,494 @@
+package model
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dinever/dingo/app/utils"
+	"github.com/twinj/uuid"
+)
+
+// wxr is a WordPress eXtended RSS export. The elements of the wp namespace
+// are matched by their local name, as the namespace changes with the
+// version of the format.
+type wxr struct {
+	Channel struct {
+		Title       string        `xml:"title"`
+		Link        string        `xml:"link"`
+		BaseSiteURL string        `xml:"base_site_url"`
+		Authors     []wxrAuthor   `xml:"author"`
+		Categories  []wxrCategory `xml:"category"`
+		Tags        []wxrTag      `xml:"tag"`
+		Items       []wxrItem     `xml:"item"`
+	} `xml:"channel"`
+}
+
+type wxrAuthor struct {
+	Id          int64  `xml:"author_id"`
+	Login       string `xml:"author_login"`
+	Email       string `xml:"author_email"`
+	DisplayName string `xml:"author_display_name"`
+}
+
+type wxrCategory struct {
+	Id          int64  `xml:"term_id"`
+	Slug        string `xml:"category_nicename"`
+	Parent      string `xml:"category_parent"`
+	Name        string `xml:"cat_name"`
+	Description string `xml:"category_description"`
+}
+
+type wxrTag struct {
+	Id   int64  `xml:"term_id"`
+	Slug string `xml:"tag_slug"`
+	Name string `xml:"tag_name"`
+}
+
+type wxrItem struct {
+	Title         string        `xml:"title"`
+	Link          string        `xml:"link"`
+	GUID          string        `xml:"guid"`
+	Creator       string        `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Content       string        `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Id            int64         `xml:"post_id"`
+	Date          string        `xml:"post_date"`
+	DateGMT       string        `xml:"post_date_gmt"`
+	CommentStatus string        `xml:"comment_status"`
+	Slug          string        `xml:"post_name"`
+	Status        string        `xml:"status"`
+	Parent        int64         `xml:"post_parent"`
+	Type          string        `xml:"post_type"`
+	Sticky        int           `xml:"is_sticky"`
+	AttachmentURL string        `xml:"attachment_url"`
+	Terms         []wxrTerm     `xml:"category"`
+	Meta          []wxrMeta     `xml:"postmeta"`
+	Comments      []*wxrComment `xml:"comment"`
+}
+
+type wxrTerm struct {
+	Domain string `xml:"domain,attr"`
+	Slug   string `xml:"nicename,attr"`
+	Name   string `xml:",chardata"`
+}
+
+type wxrMeta struct {
+	Key   string `xml:"meta_key"`
+	Value string `xml:"meta_value"`
+}
+
+type wxrComment struct {
+	Id          int64  `xml:"comment_id"`
+	Author      string `xml:"comment_author"`
+	AuthorEmail string `xml:"comment_author_email"`
+	AuthorURL   string `xml:"comment_author_url"`
+	Date        string `xml:"comment_date"`
+	DateGMT     string `xml:"comment_date_gmt"`
+	Content     string `xml:"comment_content"`
+	Approved    string `xml:"comment_approved"`
+	Type        string `xml:"comment_type"`
+	Parent      int64  `xml:"comment_parent"`
+	UserId      int64  `xml:"comment_user_id"`
+}
+
+const wxrDateLayout = "2006-01-02 15:04:05"
+
+// WordPressImport imports the posts, pages, tags, categories, comments and
+// authors of a WordPress export (WXR). The content is converted to Markdown
+// when possible, and the attachments of the site are copied to UploadDir.
+// Like ImportSite, importing the same export again changes nothing.
+type WordPressImport struct {
+	// UploadDir is where the attachments are copied to, under their path
+	// in wp-content/uploads.
+	UploadDir string
+	// UploadsSource is a copy of the wp-content/uploads directory of the
+	// WordPress site. The attachments are downloaded from the site when it
+	// is empty.
+	UploadsSource string
+	// DryRun only reports what would be imported.
+	DryRun bool
+	Client *http.Client
+}
+
+// WordPressReport tells what a WordPress import did, or would do.
+type WordPressReport struct {
+	Result *ImportResult
+	Tags   int
+	// Html lists the posts and pages whose content can not be converted to
+	// Markdown, their HTML is kept as it is.
+	Html []string
+	// Attachments lists the copied attachments.
+	Attachments []string
+	// Failed lists the attachments which could not be copied.
+	Failed []string
+	// Ignored counts the items which are not imported by their kind.
+	Ignored map[string]int
+}
+
+func (r *WordPressReport) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Users: %d\nCategories: %d\nTags: %d\nPosts: %d\nPages: %d\nComments: %d\nAlready imported: %d\n",
+		r.Result.Users, r.Result.Categories, r.Tags, r.Result.Posts, r.Result.Pages, r.Result.Comments, r.Result.Skipped)
+	fmt.Fprintf(&b, "Attachments: %d\n", len(r.Attachments))
+	for _, a := range r.Attachments {
+		fmt.Fprintf(&b, "  %s\n", a)
+	}
+	if len(r.Failed) > 0 {
+		fmt.Fprintf(&b, "Attachments which could not be copied: %d\n", len(r.Failed))
+		for _, f := range r.Failed {
+			fmt.Fprintf(&b, "  %s\n", f)
+		}
+	}
+	if len(r.Html) > 0 {
+		fmt.Fprintf(&b, "Kept as HTML: %d\n", len(r.Html))
+		for _, title := range r.Html {
+			fmt.Fprintf(&b, "  %s\n", title)
+		}
+	}
+	kinds := make([]string, 0, len(r.Ignored))
+	for kind := range r.Ignored {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "Ignored %s: %d\n", kind, r.Ignored[kind])
+	}
+	return b.String()
+}
+
+// Run imports the WordPress export read from r.
+func (w *WordPressImport) Run(r io.Reader) (*WordPressReport, error) {
+	doc := new(wxr)
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	if err := d.Decode(doc); err != nil {
+		return nil, fmt.Errorf("Not a WordPress export: %v", err)
+	}
+	if doc.Channel.BaseSiteURL == "" && doc.Channel.Link == "" {
+		return nil, fmt.Errorf("Not a WordPress export")
+	}
+	report := &WordPressReport{Ignored: make(map[string]int)}
+	e := convertWordPress(doc, report)
+	report.Tags = len(e.Tags)
+	attachments := w.rewriteAttachments(doc, e)
+
+	if w.DryRun {
+		for _, a := range attachments {
+			report.Attachments = append(report.Attachments, a.path)
+		}
+		report.Result = PreviewImport(e)
+		return report, nil
+	}
+	for _, a := range attachments {
+		if err := w.copyAttachment(a); err != nil {
+			report.Failed = append(report.Failed, a.source+": "+err.Error())
+			continue
+		}
+		report.Attachments = append(report.Attachments, a.path)
+	}
+	result, err := ImportSite(e)
+	report.Result = result
+	return report, err
+}
+
+func wordPressUUID(name string) string {
+	return uuid.Formatter(uuid.NewV5(uuid.NamespaceURL, uuid.Name(name)), uuid.CleanHyphen)
+}
+
+func parseWordPressDate(gmt, local string) *time.Time {
+	if t, err := time.Parse(wxrDateLayout, gmt); err == nil && t.Year() > 1 {
+		return &t
+	}
+	if t, err := time.ParseInLocation(wxrDateLayout, local, time.Local); err == nil && t.Year() > 1 {
+		return &t
+	}
+	return nil
+}
+
+func unescapeSlug(slug string) string {
+	if s, err := url.PathUnescape(slug); err == nil {
+		return s
+	}
+	return slug
+}
+
+// convertWordPress turns a WordPress export into an export of Dingo. The
+// items keep their WordPress ids, and get UUIDs derived from the site, so
+// that they are recognized when imported again.
+func convertWordPress(doc *wxr, report *WordPressReport) *Export {
+	site := strings.TrimSuffix(doc.Channel.BaseSiteURL, "/")
+	if site == "" {
+		site = strings.TrimSuffix(doc.Channel.Link, "/")
+	}
+	e := &Export{
+		Version:    ExportVersion,
+		ExportedAt: utils.Now(),
+		Users:      []*ExportUser{},
+		Categories: []*ExportCategory{},
+		Tags:       []*ExportTag{},
+		Posts:      []*ExportPost{},
+		Pages:      []*ExportPost{},
+		Comments:   []*ExportComment{},
+	}
+
+	authors := make(map[string]int64)
+	for i, a := range doc.Channel.Authors {
+		if a.Email == "" {
+			report.Ignored["authors without an email"]++
+			continue
+		}
+		id := a.Id
+		if id == 0 {
+			id = int64(i + 1)
+		}
+		name := a.DisplayName
+		if name == "" {
+			name = a.Login
+		}
+		authors[a.Login] = id
+		e.Users = append(e.Users, &ExportUser{Id: id, Name: name, Slug: a.Login, Email: a.Email, Role: RoleAuthor})
+	}
+
+	categories := make(map[string]*ExportCategory)
+	for i, c := range doc.Channel.Categories {
+		ec := &ExportCategory{Id: c.Id, UUID: wordPressUUID(site + "/category/" + c.Slug), Name: c.Name, Slug: unescapeSlug(c.Slug), Description: c.Description}
+		if ec.Id == 0 {
+			ec.Id = int64(i + 1)
+		}
+		categories[c.Slug] = ec
+		e.Categories = append(e.Categories, ec)
+	}
+	for _, c := range doc.Channel.Categories {
+		if parent, ok := categories[c.Parent]; ok {
+			categories[c.Slug].ParentId = parent.Id
+		}
+	}
+
+	tags := make(map[string]*ExportTag)
+	addTag := func(slug, name string) *ExportTag {
+		if t, ok := tags[slug]; ok {
+			return t
+		}
+		t := &ExportTag{Id: int64(len(tags) + 1), Name: name, Slug: unescapeSlug(slug)}
+		tags[slug] = t
+		e.Tags = append(e.Tags, t)
+		return t
+	}
+	for _, t := range doc.Channel.Tags {
+		addTag(t.Slug, t.Name)
+	}
+
+	for _, item := range doc.Channel.Items {
+		if item.Type != "post" && item.Type != "page" {
+			if item.Type != "attachment" {
+				report.Ignored[item.Type+" items"]++
+			}
+			continue
+		}
+		var status string
+		switch item.Status {
+		case "publish":
+			status = "published"
+		case "future":
+			status = "scheduled"
+		case "draft", "pending", "private":
+			status = "draft"
+		default:
+			report.Ignored[item.Status+" "+item.Type+"s"]++
+			continue
+		}
+		ep := &ExportPost{
+			Id:           item.Id,
+			UUID:         wordPressUUID(item.GUID),
+			Title:        item.Title,
+			Slug:         unescapeSlug(item.Slug),
+			Featured:     item.Sticky == 1,
+			AllowComment: item.CommentStatus == "open",
+			Status:       status,
+			AuthorId:     authors[item.Creator],
+			CreatedAt:    parseWordPressDate(item.DateGMT, item.Date),
+			Tags:         []int64{},
+		}
+		if item.GUID == "" {
+			ep.UUID = wordPressUUID(site + "/?p=" + strconv.FormatInt(item.Id, 10))
+		}
+		if status != "draft" {
+			ep.PublishedAt = ep.CreatedAt
+		}
+		var ok bool
+		if ep.Markdown, ok = utils.Html2Markdown(item.Content); !ok {
+			report.Html = append(report.Html, item.Title)
+		}
+		for _, term := range item.Terms {
+			switch term.Domain {
+			case "category":
+				if c, found := categories[term.Slug]; found && ep.CategoryId == 0 {
+					ep.CategoryId = c.Id
+				}
+			case "post_tag":
+				ep.Tags = append(ep.Tags, addTag(term.Slug, term.Name).Id)
+			}
+		}
+		if item.Type == "page" {
+			e.Pages = append(e.Pages, ep)
+		} else {
+			e.Posts = append(e.Posts, ep)
+		}
+
+		sort.Slice(item.Comments, func(i, j int) bool {
+			return item.Comments[i].Id < item.Comments[j].Id
+		})
+		for _, c := range item.Comments {
+			switch {
+			case c.Type == "pingback" || c.Type == "trackback":
+				report.Ignored[c.Type+"s"]++
+				continue
+			case c.Approved == "spam" || c.Approved == "trash":
+				report.Ignored[c.Approved+" comments"]++
+				continue
+			}
+			e.Comments = append(e.Comments, &ExportComment{
+				Id:        c.Id,
+				UUID:      wordPressUUID(site + "/?p=" + strconv.FormatInt(item.Id, 10) + "#comment-" + strconv.FormatInt(c.Id, 10)),
+				PostId:    item.Id,
+				ParentId:  c.Parent,
+				UserId:    c.UserId,
+				Author:    c.Author,
+				Email:     c.AuthorEmail,
+				Website:   c.AuthorURL,
+				Content:   c.Content,
+				Approved:  c.Approved == "1",
+				CreatedAt: parseWordPressDate(c.DateGMT, c.Date),
+			})
+		}
+	}
+	return e
+}
+
+type wordPressAttachment struct {
+	source string
+	// path is the path of the file in wp-content/uploads, and of its copy
+	// in the upload directory
+	path string
+}
+
+var wordPressUploadPattern = regexp.MustCompile(`https?://[^\s"'()<>\[\]]+/wp-content/uploads/[^\s"'()<>\[\]?#]+`)
+
+// rewriteAttachments finds the attachments of the site, and points the
+// posts to their copies in the upload directory, which is served at
+// /upload/.
+// The featured images of the posts become their images.
+func (w *WordPressImport) rewriteAttachments(doc *wxr, e *Export) []*wordPressAttachment {
+	hosts := make(map[string]bool)
+	for _, link := range []string{doc.Channel.BaseSiteURL, doc.Channel.Link} {
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			hosts[u.Host] = true
+		}
+	}
+	attachments := make(map[string]*wordPressAttachment)
+	urls := make(map[string]string)
+	find := func(src string) string {
+		if a, ok := attachments[src]; ok {
+			return "/upload/" + a.path
+		}
+		u, err := url.Parse(src)
+		if err != nil || !hosts[u.Host] {
+			return ""
+		}
+		i := strings.Index(u.Path, "/wp-content/uploads/")
+		if i < 0 {
+			return ""
+		}
+		p := path.Clean(strings.TrimPrefix(u.Path[i:], "/wp-content/uploads/"))
+		if strings.HasPrefix(p, "..") {
+			return ""
+		}
+		attachments[src] = &wordPressAttachment{source: src, path: p}
+		return "/upload/" + p
+	}
+
+	for _, item := range doc.Channel.Items {
+		if item.Type == "attachment" && item.AttachmentURL != "" {
+			if target := find(item.AttachmentURL); target != "" {
+				urls[strconv.FormatInt(item.Id, 10)] = target
+			}
+		}
+	}
+	thumbnails := make(map[int64]string)
+	for _, item := range doc.Channel.Items {
+		for _, m := range item.Meta {
+			if m.Key == "_thumbnail_id" {
+				thumbnails[item.Id] = urls[m.Value]
+			}
+		}
+	}
+
+	for _, posts := range [][]*ExportPost{e.Posts, e.Pages} {
+		for _, ep := range posts {
+			ep.Markdown = wordPressUploadPattern.ReplaceAllStringFunc(ep.Markdown, func(src string) string {
+				if target := find(src); target != "" {
+					return target
+				}
+				return src
+			})
+			ep.Image = thumbnails[ep.Id]
+		}
+	}
+
+	list := make([]*wordPressAttachment, 0, len(attachments))
+	for _, a := range attachments {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].path < list[j].path
+	})
+	return list
+}
+
+// copyAttachment copies an attachment from UploadsSource, or downloads it.
+// Attachments which are already copied are kept.
+func (w *WordPressImport) copyAttachment(a *wordPressAttachment) error {
+	target := filepath.Join(w.UploadDir, filepath.FromSlash(a.path))
+	if utils.IsFile(target) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if w.UploadsSource != "" {
+		return utils.CopyFile(filepath.Join(w.UploadsSource, filepath.FromSlash(a.path)), target)
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	resp, err := client.Get(a.source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(target)
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,266 @@
+package model
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dinever/dingo/app/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const wxrFixture = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"
+	xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:wfw="http://wellformedweb.org/CommentAPI/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<title>Legacy Blog</title>
+	<link>SITE</link>
+	<wp:wxr_version>1.2</wp:wxr_version>
+	<wp:base_site_url>SITE</wp:base_site_url>
+	<wp:base_blog_url>SITE</wp:base_blog_url>
+	<wp:author><wp:author_id>2</wp:author_id><wp:author_login><![CDATA[jane]]></wp:author_login><wp:author_email><![CDATA[jane@example.com]]></wp:author_email><wp:author_display_name><![CDATA[Jane Doe]]></wp:author_display_name></wp:author>
+	<wp:category><wp:term_id>3</wp:term_id><wp:category_nicename><![CDATA[programming]]></wp:category_nicename><wp:category_parent><![CDATA[]]></wp:category_parent><wp:cat_name><![CDATA[Programming]]></wp:cat_name></wp:category>
+	<wp:category><wp:term_id>4</wp:term_id><wp:category_nicename><![CDATA[golang]]></wp:category_nicename><wp:category_parent><![CDATA[programming]]></wp:category_parent><wp:cat_name><![CDATA[Go]]></wp:cat_name></wp:category>
+	<wp:tag><wp:term_id>5</wp:term_id><wp:tag_slug><![CDATA[tips]]></wp:tag_slug><wp:tag_name><![CDATA[Tips]]></wp:tag_name></wp:tag>
+	<item>
+		<title>Hello Gopher</title>
+		<link>SITE/2016/05/hello-gopher/</link>
+		<dc:creator><![CDATA[jane]]></dc:creator>
+		<guid isPermaLink="false">SITE/?p=10</guid>
+		<content:encoded><![CDATA[Welcome to <strong>my</strong> blog.
+
+<img class="alignnone size-full wp-image-11" src="SITE/wp-content/uploads/2016/05/gopher.png" alt="Gopher" width="100" height="100" />
+<ul>
+	<li>One</li>
+	<li>Two</li>
+</ul>
+<!--more-->
+Read the <a href="https://golang.org/">docs</a>.]]></content:encoded>
+		<wp:post_id>10</wp:post_id>
+		<wp:post_date><![CDATA[2016-05-01 10:00:00]]></wp:post_date>
+		<wp:post_date_gmt><![CDATA[2016-05-01 08:00:00]]></wp:post_date_gmt>
+		<wp:comment_status><![CDATA[open]]></wp:comment_status>
+		<wp:post_name><![CDATA[hello-gopher]]></wp:post_name>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_parent>0</wp:post_parent>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+		<wp:is_sticky>1</wp:is_sticky>
+		<category domain="category" nicename="golang"><![CDATA[Go]]></category>
+		<category domain="post_tag" nicename="tips"><![CDATA[Tips]]></category>
+		<category domain="post_tag" nicename="news"><![CDATA[News]]></category>
+		<wp:postmeta><wp:meta_key><![CDATA[_thumbnail_id]]></wp:meta_key><wp:meta_value><![CDATA[11]]></wp:meta_value></wp:postmeta>
+		<wp:comment>
+			<wp:comment_id>2</wp:comment_id>
+			<wp:comment_author><![CDATA[Jane Doe]]></wp:comment_author>
+			<wp:comment_author_email><![CDATA[jane@example.com]]></wp:comment_author_email>
+			<wp:comment_date_gmt><![CDATA[2016-05-02 08:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Thanks!]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+			<wp:comment_type><![CDATA[]]></wp:comment_type>
+			<wp:comment_parent>1</wp:comment_parent>
+			<wp:comment_user_id>2</wp:comment_user_id>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>1</wp:comment_id>
+			<wp:comment_author><![CDATA[Reader]]></wp:comment_author>
+			<wp:comment_author_email><![CDATA[reader@example.com]]></wp:comment_author_email>
+			<wp:comment_date_gmt><![CDATA[2016-05-01 09:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Nice post]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+			<wp:comment_type><![CDATA[]]></wp:comment_type>
+			<wp:comment_parent>0</wp:comment_parent>
+			<wp:comment_user_id>0</wp:comment_user_id>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>3</wp:comment_id>
+			<wp:comment_author><![CDATA[Waiting]]></wp:comment_author>
+			<wp:comment_date_gmt><![CDATA[2016-05-03 08:00:00]]></wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Pending]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[0]]></wp:comment_approved>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>4</wp:comment_id>
+			<wp:comment_author><![CDATA[Spammer]]></wp:comment_author>
+			<wp:comment_content><![CDATA[Buy now]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[spam]]></wp:comment_approved>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>5</wp:comment_id>
+			<wp:comment_author><![CDATA[Other Blog]]></wp:comment_author>
+			<wp:comment_content><![CDATA[Linked]]></wp:comment_content>
+			<wp:comment_approved><![CDATA[1]]></wp:comment_approved>
+			<wp:comment_type><![CDATA[pingback]]></wp:comment_type>
+		</wp:comment>
+	</item>
+	<item>
+		<title>gopher.png</title>
+		<guid isPermaLink="false">SITE/wp-content/uploads/2016/05/gopher.png</guid>
+		<wp:post_id>11</wp:post_id>
+		<wp:post_parent>10</wp:post_parent>
+		<wp:status><![CDATA[inherit]]></wp:status>
+		<wp:post_type><![CDATA[attachment]]></wp:post_type>
+		<wp:attachment_url><![CDATA[SITE/wp-content/uploads/2016/05/gopher.png]]></wp:attachment_url>
+	</item>
+	<item>
+		<title>About</title>
+		<dc:creator><![CDATA[jane]]></dc:creator>
+		<guid isPermaLink="false">SITE/?page_id=12</guid>
+		<content:encoded><![CDATA[<table><tr><td>Jane</td></tr></table>]]></content:encoded>
+		<wp:post_id>12</wp:post_id>
+		<wp:post_date_gmt><![CDATA[2016-04-01 08:00:00]]></wp:post_date_gmt>
+		<wp:comment_status><![CDATA[closed]]></wp:comment_status>
+		<wp:post_name><![CDATA[about]]></wp:post_name>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_type><![CDATA[page]]></wp:post_type>
+	</item>
+	<item>
+		<title>Unfinished</title>
+		<dc:creator><![CDATA[jane]]></dc:creator>
+		<guid isPermaLink="false">SITE/?p=13</guid>
+		<content:encoded><![CDATA[Soon]]></content:encoded>
+		<wp:post_id>13</wp:post_id>
+		<wp:post_date><![CDATA[2016-05-05 10:00:00]]></wp:post_date>
+		<wp:post_date_gmt><![CDATA[0000-00-00 00:00:00]]></wp:post_date_gmt>
+		<wp:post_name><![CDATA[]]></wp:post_name>
+		<wp:status><![CDATA[draft]]></wp:status>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+	</item>
+	<item>
+		<title>Old</title>
+		<guid isPermaLink="false">SITE/?p=14</guid>
+		<wp:post_id>14</wp:post_id>
+		<wp:status><![CDATA[trash]]></wp:status>
+		<wp:post_type><![CDATA[post]]></wp:post_type>
+	</item>
+	<item>
+		<title>Home</title>
+		<wp:post_id>15</wp:post_id>
+		<wp:status><![CDATA[publish]]></wp:status>
+		<wp:post_type><![CDATA[nav_menu_item]]></wp:post_type>
+	</item>
+</channel>
+</rss>`
+
+func TestWordPressImport(t *testing.T) {
+	Convey("Initialize database", t, func() {
+		Initialize("test.db", true)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/wp-content/uploads/2016/05/gopher.png" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte("png"))
+		}))
+		fixture := strings.Replace(wxrFixture, "SITE", server.URL, -1)
+		w := &WordPressImport{UploadDir: "test-upload"}
+
+		Convey("Report what would be imported", func() {
+			w.DryRun = true
+			report, err := w.Run(strings.NewReader(fixture))
+			So(err, ShouldBeNil)
+			So(*report.Result, ShouldResemble, ImportResult{Users: 1, Categories: 2, Posts: 2, Pages: 1, Comments: 3})
+			So(report.Tags, ShouldEqual, 2)
+			So(report.Attachments, ShouldResemble, []string{"2016/05/gopher.png"})
+			So(report.Html, ShouldResemble, []string{"About"})
+			So(report.Ignored, ShouldResemble, map[string]int{"pingbacks": 1, "spam comments": 1, "trash posts": 1, "nav_menu_item items": 1})
+			So(report.String(), ShouldContainSubstring, "Posts: 2")
+
+			posts, _ := GetAllPostList(false, false, "id")
+			So(posts, ShouldHaveLength, 0)
+			_, err = os.Stat("test-upload")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Import the export", func() {
+			report, err := w.Run(strings.NewReader(fixture))
+			So(err, ShouldBeNil)
+			So(report.Failed, ShouldBeEmpty)
+			So(*report.Result, ShouldResemble, ImportResult{Users: 1, Categories: 2, Posts: 2, Pages: 1, Comments: 3})
+
+			data, err := ioutil.ReadFile(filepath.Join("test-upload", "2016", "05", "gopher.png"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "png")
+
+			p, err := GetPostBySlug("hello-gopher")
+			So(err, ShouldBeNil)
+			So(p.Markdown, ShouldEqual, "Welcome to **my** blog.\n\n![Gopher](/upload/2016/05/gopher.png)\n\n- One\n- Two\n\nRead the [docs](https://golang.org/).")
+			So(p.Image, ShouldEqual, "/upload/2016/05/gopher.png")
+			So(p.IsFeatured, ShouldBeTrue)
+			So(p.AllowComment, ShouldBeTrue)
+			So(p.Status(), ShouldEqual, "published")
+			So(p.PublishedAt.UTC().Format(wxrDateLayout), ShouldEqual, "2016-05-01 08:00:00")
+			So(p.Category.Slug, ShouldEqual, "golang")
+			So(p.Category.Parent().Slug, ShouldEqual, "programming")
+			So(p.Tags, ShouldHaveLength, 2)
+			So(p.Author.Email, ShouldEqual, "jane@example.com")
+
+			page, err := GetPostBySlug("about")
+			So(err, ShouldBeNil)
+			So(page.IsPage, ShouldBeTrue)
+			So(page.Markdown, ShouldEqual, "<table><tr><td>Jane</td></tr></table>")
+
+			drafts, _ := GetAllPostList(false, false, "id")
+			So(drafts, ShouldHaveLength, 2)
+			So(drafts[1].Title, ShouldEqual, "Unfinished")
+			So(drafts[1].Status(), ShouldEqual, "draft")
+
+			comments, _ := GetAllComments()
+			So(comments, ShouldHaveLength, 3)
+			So(comments[0].Author, ShouldEqual, "Reader")
+			So(comments[1].Parent, ShouldEqual, comments[0].Id)
+			So(comments[1].UserId, ShouldEqual, p.Author.Id)
+			So(comments[2].Approved, ShouldBeFalse)
+
+			Convey("Import it again", func() {
+				report, err := w.Run(strings.NewReader(fixture))
+				So(err, ShouldBeNil)
+				So(report.Result.Skipped, ShouldEqual, 9)
+				posts, _ := GetAllPostList(false, false, "id")
+				So(posts, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("Report the attachments which can not be copied", func() {
+			w.UploadsSource = "test-uploads-source"
+			report, err := w.Run(strings.NewReader(fixture))
+			So(err, ShouldBeNil)
+			So(report.Attachments, ShouldBeEmpty)
+			So(report.Failed, ShouldHaveLength, 1)
+		})
+
+		Convey("Refuse other documents", func() {
+			_, err := w.Run(strings.NewReader(`{"version": 1}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			server.Close()
+			os.Remove("test.db")
+			os.RemoveAll("test-upload")
+		})
+	})
+}
+
+func TestHtml2Markdown(t *testing.T) {
+	Convey("Convert HTML to Markdown", t, func() {
+		md, ok := utils.Html2Markdown(`<h2>Title</h2><p>Some <em>text</em><br/>and a <a href="/x" title="X">link</a></p><blockquote><p>Quote</p></blockquote><pre><code>a &lt; b</code></pre><ol><li>One</li><li>Two</li></ol>`)
+		So(ok, ShouldBeTrue)
+		So(md, ShouldEqual, "## Title\n\nSome *text*  \nand a [link](/x \"X\")\n\n> Quote\n\n```\na < b\n```\n\n1. One\n2. Two")
+
+		Convey("Keep the HTML which has no Markdown equivalent", func() {
+			for _, src := range []string{`<div class="gallery"><img src="a.png"/></div>`, `<p style="color: red">Red</p>`, `<p>Broken</span>`} {
+				md, ok := utils.Html2Markdown(src)
+				So(ok, ShouldBeFalse)
+				So(md, ShouldEqual, src)
+			}
+		})
+	})
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 )
@@ -11,3 +14,19 @@ func Sha1(raw string) string {
 	io.WriteString(t, raw)
 	return fmt.Sprintf("%x", t.Sum(nil))
 }
+
+func Sha256(raw string) string {
+	t := sha256.New()
+	io.WriteString(t, raw)
+	return fmt.Sprintf("%x", t.Sum(nil))
+}
+
+// RandomToken returns a hex encoded string made of n cryptographically
+// secure random bytes.
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
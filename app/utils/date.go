@@ -37,7 +37,11 @@ var conversion = map[rune]string{
 	/* nonStdMilli		 */ 'L': ".000",
 }
 
+// DateFormat formats a time in the local time zone, the times are read from
+// the database in UTC.
 func DateFormat(t *time.Time, format string) string {
+	local := t.Local()
+	t = &local
 	retval := make([]byte, 0, len(format))
 	for i, ni := 0, 0; i < len(format); i = ni + 2 {
 		ni = strings.IndexByte(format[i:], '%')
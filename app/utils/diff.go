@@ -0,0 +1,94 @@
+package utils
+
+import "strings"
+
+// DiffLine is a line of a line based diff.
+type DiffLine struct {
+	Type string // equal, insert, delete
+	Text string
+}
+
+func (l DiffLine) Prefix() string {
+	switch l.Type {
+	case "insert":
+		return "+"
+	case "delete":
+		return "-"
+	}
+	return " "
+}
+
+// DiffLines compares two texts line by line, using the longest common
+// subsequence of their lines.
+func DiffLines(a, b string) []DiffLine {
+	x := splitLines(a)
+	y := splitLines(b)
+	// Common prefix and suffix do not need to go through the LCS table
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	diff := make([]DiffLine, 0, len(x)+len(y))
+	for _, line := range x[:prefix] {
+		diff = append(diff, DiffLine{"equal", line})
+	}
+	diff = append(diff, diffLCS(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, line := range x[len(x)-suffix:] {
+		diff = append(diff, DiffLine{"equal", line})
+	}
+	return diff
+}
+
+func diffLCS(x, y []string) []DiffLine {
+	n, m := len(x), len(y)
+	// lcs[i][j] is the length of the LCS of x[i:] and y[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := make([]DiffLine, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		if x[i] == y[j] {
+			diff = append(diff, DiffLine{"equal", x[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			diff = append(diff, DiffLine{"delete", x[i]})
+			i++
+		} else {
+			diff = append(diff, DiffLine{"insert", y[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		diff = append(diff, DiffLine{"delete", x[i]})
+	}
+	for ; j < m; j++ {
+		diff = append(diff, DiffLine{"insert", y[j]})
+	}
+	return diff
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
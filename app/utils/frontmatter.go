@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FrontMatter is the metadata at the top of a Markdown file. A value is a
+// string, a bool or a []string.
+type FrontMatter map[string]interface{}
+
+// String returns the value of key as a string, lists are joined with commas.
+func (fm FrontMatter) String(key string) string {
+	switch v := fm[key].(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		return strings.Join(v, ", ")
+	}
+	return ""
+}
+
+// Bool returns the value of key as a bool.
+func (fm FrontMatter) Bool(key string) bool {
+	switch v := fm[key].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// List returns the value of key as a list, a string is split at the
+// commas.
+func (fm FrontMatter) List(key string) []string {
+	switch v := fm[key].(type) {
+	case []string:
+		return v
+	case string:
+		list := make([]string, 0)
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ParseFrontMatter splits a Markdown file into its front matter and its
+// body. The front matter is YAML between "---" lines or TOML between "+++"
+// lines, of which only the flat keys with scalars and lists of scalars are
+// read, which is what the posts are described with. A file without front
+// matter has an empty one.
+func ParseFrontMatter(src string) (FrontMatter, string, error) {
+	src = strings.TrimPrefix(src, "\ufeff")
+	src = strings.Replace(src, "\r\n", "\n", -1)
+	fm := make(FrontMatter)
+	var delimiter string
+	switch {
+	case strings.HasPrefix(src, "---\n"):
+		delimiter = "---"
+	case strings.HasPrefix(src, "+++\n"):
+		delimiter = "+++"
+	default:
+		return fm, src, nil
+	}
+	rest := src[len(delimiter)+1:]
+	var head, body string
+	if strings.HasPrefix(rest, delimiter+"\n") || rest == delimiter {
+		head, body = "", strings.TrimPrefix(rest, delimiter)
+	} else if end := strings.Index(rest, "\n"+delimiter+"\n"); end >= 0 {
+		head, body = rest[:end], rest[end+len(delimiter)+2:]
+	} else if strings.HasSuffix(rest, "\n"+delimiter) {
+		head, body = rest[:len(rest)-len(delimiter)-1], ""
+	} else {
+		return nil, "", fmt.Errorf("The front matter is not closed with %q", delimiter)
+	}
+	var err error
+	if delimiter == "---" {
+		err = parseYamlFrontMatter(head, fm)
+	} else {
+		err = parseTomlFrontMatter(head, fm)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return fm, strings.TrimLeft(body, "\n"), nil
+}
+
+func parseYamlFrontMatter(head string, fm FrontMatter) error {
+	lines := strings.Split(head, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// The content of a nested map, which is not read
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return fmt.Errorf("Line %d of the front matter is not a key and a value", i+1)
+		}
+		key := strings.ToLower(strings.TrimSpace(unquoteFrontMatter(line[:colon])))
+		value := strings.TrimSpace(line[colon+1:])
+		switch {
+		case value == "" || strings.HasPrefix(value, "#"):
+			// A list of "- item" lines, or a nested map
+			list := make([]string, 0)
+			for i+1 < len(lines) {
+				item := strings.TrimSpace(lines[i+1])
+				if item != "" && lines[i+1][0] != ' ' && lines[i+1][0] != '\t' && !strings.HasPrefix(item, "- ") && item != "-" {
+					break
+				}
+				i++
+				if strings.HasPrefix(item, "- ") {
+					list = append(list, yamlScalar(strings.TrimSpace(item[2:])))
+				}
+			}
+			if len(list) > 0 {
+				fm[key] = list
+			}
+		case value == "|" || value == ">" || value == "|-" || value == ">-":
+			// A block of indented lines
+			block := make([]string, 0)
+			for i+1 < len(lines) && (strings.TrimSpace(lines[i+1]) == "" || lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+				i++
+				block = append(block, strings.TrimSpace(lines[i]))
+			}
+			separator := "\n"
+			if value[0] == '>' {
+				separator = " "
+			}
+			fm[key] = strings.TrimSpace(strings.Join(block, separator))
+		case value[0] == '[':
+			list, err := parseFrontMatterList(value, yamlScalar)
+			if err != nil {
+				return fmt.Errorf("Line %d of the front matter: %v", i+1, err)
+			}
+			fm[key] = list
+		default:
+			fm[key] = yamlValue(value)
+		}
+	}
+	return nil
+}
+
+func yamlValue(value string) interface{} {
+	if value[0] != '"' && value[0] != '\'' {
+		switch strings.ToLower(stripFrontMatterComment(value)) {
+		case "true", "yes", "on":
+			return true
+		case "false", "no", "off":
+			return false
+		}
+	}
+	return yamlScalar(value)
+}
+
+func yamlScalar(value string) string {
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		return unquoteFrontMatter(value)
+	}
+	return stripFrontMatterComment(value)
+}
+
+func parseTomlFrontMatter(head string, fm FrontMatter) error {
+	scanner := bufio.NewScanner(strings.NewReader(head))
+	inTable := false
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			// The keys of a table, which are not read
+			inTable = true
+			continue
+		}
+		equal := strings.Index(line, "=")
+		if equal <= 0 {
+			return fmt.Errorf("Line %d of the front matter is not a key and a value", n)
+		}
+		if inTable {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(unquoteFrontMatter(line[:equal])))
+		value := strings.TrimSpace(line[equal+1:])
+		if value == "" {
+			return fmt.Errorf("Line %d of the front matter has no value", n)
+		}
+		switch {
+		case value[0] == '[':
+			list, err := parseFrontMatterList(value, tomlScalar)
+			if err != nil {
+				return fmt.Errorf("Line %d of the front matter: %v", n, err)
+			}
+			fm[key] = list
+		case value[0] == '"' || value[0] == '\'':
+			fm[key] = unquoteFrontMatter(value)
+		default:
+			value = stripFrontMatterComment(value)
+			if b, err := strconv.ParseBool(value); err == nil {
+				fm[key] = b
+			} else {
+				fm[key] = value
+			}
+		}
+	}
+	return nil
+}
+
+func tomlScalar(value string) string {
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		return unquoteFrontMatter(value)
+	}
+	return value
+}
+
+// parseFrontMatterList parses a list written on one line, such as
+// [go, "web, blog"].
+func parseFrontMatterList(value string, scalar func(string) string) ([]string, error) {
+	list := make([]string, 0)
+	var item strings.Builder
+	var quote byte
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote != 0:
+			item.WriteByte(c)
+			if c == '\\' && quote == '"' && i+1 < len(value) {
+				i++
+				item.WriteByte(value[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			item.WriteByte(c)
+		case c == ',' || c == ']':
+			if s := strings.TrimSpace(item.String()); s != "" {
+				list = append(list, scalar(s))
+			}
+			item.Reset()
+			if c == ']' {
+				return list, nil
+			}
+		default:
+			item.WriteByte(c)
+		}
+	}
+	return nil, fmt.Errorf("The list is not closed with \"]\"")
+}
+
+func unquoteFrontMatter(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return value
+	}
+	switch value[0] {
+	case '"':
+		if end := strings.LastIndex(value, `"`); end > 0 {
+			if s, err := strconv.Unquote(value[:end+1]); err == nil {
+				return s
+			}
+			return value[1:end]
+		}
+	case '\'':
+		if end := strings.LastIndex(value, `'`); end > 0 {
+			return strings.Replace(value[1:end], "''", "'", -1)
+		}
+	}
+	return value
+}
+
+// stripFrontMatterComment removes a comment at the end of a value which is
+// not quoted.
+func stripFrontMatterComment(value string) string {
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"github.com/russross/blackfriday"
+	"html"
 	"html/template"
 	"regexp"
 	"strings"
@@ -53,6 +54,16 @@ func Html2Excerpt(html string, length int) string {
 	return SubString(Html2Str(html), 0, length)
 }
 
+// Highlight escapes the text and wraps the parts between the open and close
+// markers in <mark> elements. The markers must not contain HTML special
+// characters.
+func Highlight(text, open, close string) template.HTML {
+	text = html.EscapeString(text)
+	text = strings.Replace(text, open, "<mark>", -1)
+	text = strings.Replace(text, close, "</mark>", -1)
+	return template.HTML(text)
+}
+
 func Markdown2Html(text string) string {
 	return string(blackfriday.MarkdownCommon([]byte(text)))
 }
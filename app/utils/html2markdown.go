@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	htmlTagPattern     = regexp.MustCompile(`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:[^>"']|"[^"]*"|'[^']*')*?)(/?)>`)
+	htmlAttrPattern    = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+	htmlSpacePattern   = regexp.MustCompile(`\s+`)
+	markdownNewlines   = regexp.MustCompile(`\n{3,}`)
+	markdownLineSpaces = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// markdownLineBreak marks the line breaks until the trailing spaces are
+// removed, as a line break is written with two of them.
+const markdownLineBreak = "\x00br"
+
+// The elements which Html2Markdown converts, with the attributes they may
+// have. The other elements can not be written in Markdown.
+var markdownElements = map[string][]string{
+	"p": nil, "br": nil, "hr": nil, "span": nil,
+	"strong": nil, "b": nil, "em": nil, "i": nil, "code": nil, "pre": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"ul": nil, "ol": nil, "li": nil, "blockquote": nil,
+	"a":   {"href", "title", "target", "rel"},
+	"img": {"src", "alt", "title", "class", "width", "height"},
+}
+
+type htmlNode struct {
+	tag      string
+	attrs    map[string]string
+	text     string
+	children []*htmlNode
+}
+
+// Html2Markdown converts HTML to Markdown. Only the elements which have a
+// Markdown equivalent are converted, ok is false if the HTML has other ones,
+// in which case it is returned as it is.
+func Html2Markdown(src string) (md string, ok bool) {
+	root, ok := parseHtml(src)
+	if !ok {
+		return src, false
+	}
+	md = renderMarkdown(root, true)
+	md = markdownLineSpaces.ReplaceAllString(md, "\n")
+	md = markdownNewlines.ReplaceAllString(md, "\n\n")
+	md = strings.Replace(md, markdownLineBreak, "  ", -1)
+	return strings.TrimSpace(md), true
+}
+
+func parseHtml(src string) (*htmlNode, bool) {
+	root := &htmlNode{}
+	stack := []*htmlNode{root}
+	last := 0
+	for _, m := range htmlTagPattern.FindAllStringSubmatchIndex(src, -1) {
+		top := stack[len(stack)-1]
+		if m[0] > last {
+			top.children = append(top.children, &htmlNode{text: src[last:m[0]]})
+		}
+		last = m[1]
+		if m[2] < 0 {
+			// A comment, such as <!--more-->
+			continue
+		}
+		tag := strings.ToLower(src[m[4]:m[5]])
+		allowed, known := markdownElements[tag]
+		if !known {
+			return nil, false
+		}
+		if src[m[2]:m[3]] == "/" {
+			i := len(stack) - 1
+			for i > 0 && stack[i].tag != tag {
+				i--
+			}
+			if i == 0 {
+				return nil, false
+			}
+			stack = stack[:i]
+			continue
+		}
+		node := &htmlNode{tag: tag, attrs: make(map[string]string)}
+		for _, a := range htmlAttrPattern.FindAllStringSubmatch(src[m[6]:m[7]], -1) {
+			name := strings.ToLower(a[1])
+			if !containsString(allowed, name) {
+				return nil, false
+			}
+			node.attrs[name] = html.UnescapeString(a[2] + a[3] + a[4])
+		}
+		top.children = append(top.children, node)
+		if tag != "br" && tag != "hr" && tag != "img" && src[m[8]:m[9]] != "/" {
+			stack = append(stack, node)
+		}
+	}
+	if last < len(src) {
+		top := stack[len(stack)-1]
+		top.children = append(top.children, &htmlNode{text: src[last:]})
+	}
+	return root, true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// renderMarkdown renders the children of n. The text outside of elements
+// keeps its line breaks, as WordPress turns them into paragraphs.
+func renderMarkdown(n *htmlNode, topLevel bool) string {
+	var b strings.Builder
+	for _, c := range n.children {
+		if c.tag == "" {
+			text := html.UnescapeString(c.text)
+			if !topLevel {
+				text = htmlSpacePattern.ReplaceAllString(text, " ")
+			}
+			b.WriteString(text)
+			continue
+		}
+		inner := func() string { return renderMarkdown(c, false) }
+		switch c.tag {
+		case "p":
+			b.WriteString("\n\n" + strings.TrimSpace(inner()) + "\n\n")
+		case "br":
+			b.WriteString(markdownLineBreak + "\n")
+		case "hr":
+			b.WriteString("\n\n---\n\n")
+		case "span":
+			b.WriteString(inner())
+		case "strong", "b":
+			b.WriteString(wrapInline(inner(), "**"))
+		case "em", "i":
+			b.WriteString(wrapInline(inner(), "*"))
+		case "code":
+			b.WriteString("`" + html.UnescapeString(textContent(c)) + "`")
+		case "pre":
+			b.WriteString("\n\n```\n" + strings.Trim(html.UnescapeString(textContent(c)), "\n") + "\n```\n\n")
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			level, _ := strconv.Atoi(c.tag[1:])
+			b.WriteString("\n\n" + strings.Repeat("#", level) + " " + strings.TrimSpace(inner()) + "\n\n")
+		case "ul", "ol":
+			b.WriteString("\n\n" + renderList(c) + "\n\n")
+		case "li":
+			// A list item outside of a list
+			b.WriteString("\n- " + strings.TrimSpace(inner()) + "\n")
+		case "blockquote":
+			quote := strings.TrimSpace(markdownNewlines.ReplaceAllString(inner(), "\n\n"))
+			b.WriteString("\n\n> " + strings.Replace(quote, "\n", "\n> ", -1) + "\n\n")
+		case "a":
+			text := strings.TrimSpace(inner())
+			href := c.attrs["href"]
+			if href == "" {
+				b.WriteString(text)
+			} else if title := c.attrs["title"]; title != "" {
+				b.WriteString("[" + text + "](" + href + ` "` + strings.Replace(title, `"`, `\"`, -1) + `")`)
+			} else {
+				b.WriteString("[" + text + "](" + href + ")")
+			}
+		case "img":
+			img := "![" + c.attrs["alt"] + "](" + c.attrs["src"]
+			if title := c.attrs["title"]; title != "" {
+				img += ` "` + strings.Replace(title, `"`, `\"`, -1) + `"`
+			}
+			b.WriteString(img + ")")
+		}
+	}
+	return b.String()
+}
+
+func renderList(list *htmlNode) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range list.children {
+		if c.tag != "li" {
+			continue
+		}
+		n++
+		marker := "- "
+		if list.tag == "ol" {
+			marker = strconv.Itoa(n) + ". "
+		}
+		item := strings.TrimSpace(markdownNewlines.ReplaceAllString(renderMarkdown(c, false), "\n\n"))
+		indent := strings.Repeat(" ", len(marker))
+		b.WriteString(marker + strings.Replace(item, "\n", "\n"+indent, -1) + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// wrapInline wraps text in the markers, keeping the surrounding spaces
+// outside of them.
+func wrapInline(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(text, trimmed)
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+func textContent(n *htmlNode) string {
+	if n.tag == "" {
+		return n.text
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		if c.tag == "br" {
+			b.WriteString("\n")
+		}
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mail is a plain text email.
+type Mail struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	// Headers are added to the standard headers of the mail.
+	Headers map[string]string
+}
+
+func NewMail(to, subject, body string) *Mail {
+	return &Mail{
+		To:      []string{to},
+		Subject: subject,
+		Body:    body,
+	}
+}
+
+// Bytes formats the mail as an RFC 5322 message.
+func (m *Mail) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@dingo>\r\n", RandomToken(16))
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, m.Headers[k])
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
+
+// Mailer delivers mails. If the sender of a mail is empty, the default sender
+// of the mailer is used.
+type Mailer interface {
+	Send(m *Mail) error
+}
+
+// SMTPMailer sends mails through an SMTP server.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+		From:     from,
+	}
+}
+
+func (s *SMTPMailer) Send(m *Mail) error {
+	if m.From == "" {
+		m.From = s.From
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, m.From, m.To, m.Bytes())
+}
+
+// MaildirMailer stores mails in a maildir instead of sending them, which is
+// useful for development and tests.
+type MaildirMailer struct {
+	Dir  string
+	From string
+}
+
+func NewMaildirMailer(dir, from string) *MaildirMailer {
+	return &MaildirMailer{
+		Dir:  dir,
+		From: from,
+	}
+}
+
+func (md *MaildirMailer) Send(m *Mail) error {
+	if m.From == "" {
+		m.From = md.From
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(md.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	// Mails are written to tmp first, so that readers of new never see a
+	// partially written mail.
+	name := fmt.Sprintf("%d.%s.dingo", time.Now().UnixNano(), RandomToken(8))
+	tmp := filepath.Join(md.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, m.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a task that the Scheduler runs periodically in the background.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// Scheduler runs jobs in the background, each job in its own goroutine.
+type Scheduler struct {
+	jobs    []*Job
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	running bool
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		jobs: make([]*Job, 0),
+	}
+}
+
+// Every registers a job that runs once when the scheduler starts, and then
+// again after every interval.
+func (s *Scheduler) Every(interval time.Duration, name string, fn func() error) {
+	s.jobs = append(s.jobs, &Job{Name: name, Interval: interval, Run: fn})
+}
+
+func (s *Scheduler) Start() {
+	if s.running {
+		return
+	}
+	s.running = true
+	s.stop = make(chan struct{})
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// Stop stops all jobs and waits for the running ones to finish.
+func (s *Scheduler) Stop() {
+	if !s.running {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.running = false
+}
+
+func (s *Scheduler) loop(job *Job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		runJob(job)
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func runJob(job *Job) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("[Error]: Job %s panicked: %v", job.Name, err)
+		}
+	}()
+	if err := job.Run(); err != nil {
+		log.Printf("[Error]: Job %s failed: %v", job.Name, err)
+	}
+}
@@ -104,16 +104,32 @@ function initComment() {
             if (json.comment.status == "approved") {
                 tpl.find(".comment-check").remove();
             }
-            $list.append(tpl);
+            var parent = $('#comment-' + json.comment.pid);
+            if (json.comment.pid && parent.length) {
+                // The reply goes after the last reply to the parent
+                var depth = parent.data("depth");
+                var last = parent;
+                parent.nextAll().each(function () {
+                    if ($(this).data("depth") <= depth) {
+                        return false;
+                    }
+                    last = $(this);
+                });
+                tpl.addClass("depth-" + (depth + 1)).attr("data-depth", depth + 1);
+                last.after(tpl);
+            } else {
+                $list.append(tpl);
+            }
             $('.cancel-reply').trigger("click");
             $('#comment-content').val("");
         } else {
-            alert("Can not submit comment!");
+            alert(json.msg || "Can not submit comment!");
         }
     });
     $list.on("click", ".comment-reply", function () {
         var id = $(this).attr("rel");
         var pc = $('#comment-' + id);
+        $('#comment-parent').val(id);
         var md = "> @" + pc.find(".comment-name").text() + "\n\n";
         md += "> " + pc.find(".comment-content").html() + "\n";
         $('#comment-reply').html(marked(md));